@@ -11,7 +11,12 @@ type Sever struct {
 }
 
 type Databse struct {
+	// "sqlite" or "postgres"
+	Driver string
+	// Used only by sqlite
 	Path string
+	// Used only by postgres
+	DSN string
 }
 
 type Log struct {
@@ -27,11 +32,44 @@ type Users struct {
 	First_User FirstUser
 }
 
+type Trash struct {
+	// Deleted todos are permanently removed after this number of days, 0
+	// means never
+	Retention_Days int
+}
+
+type SMTP struct {
+	// Reminders are sent only if the host is set
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	// Use TLS from the start of the connection (usually on port 465) instead
+	// of STARTTLS
+	Tls bool
+	// How long before the expiration of a todo its reminders are sent, as Go
+	// durations like "24h"
+	Reminders []string
+	// Hour of the day the digest of the expired todos is sent, -1 never
+	Digest_Hour int
+}
+
+type Webhooks struct {
+	// Webhooks can be sent to loopback, private and link-local addresses.
+	// Any user could then use them to reach the internal network of the
+	// server.
+	Allow_Private bool
+}
+
 type Config struct {
-	Server  Sever
-	Databse Databse
-	Log     Log
-	Users   Users
+	Server   Sever
+	Databse  Databse
+	Log      Log
+	Users    Users
+	Trash    Trash
+	SMTP     SMTP
+	Webhooks Webhooks
 }
 
 var config Config = Config{
@@ -39,7 +77,8 @@ var config Config = Config{
 		Listen: "0.0.0.0:8080",
 	},
 	Databse: Databse{
-		Path: "./doit.db",
+		Driver: "sqlite",
+		Path:   "./doit.db",
 	},
 	Log: Log{
 		Log_level: "info",
@@ -50,6 +89,14 @@ var config Config = Config{
 			Email:    "admin@mail.com",
 		},
 	},
+	Trash: Trash{
+		Retention_Days: 30,
+	},
+	SMTP: SMTP{
+		Port:        587,
+		Reminders:   []string{"24h", "1h"},
+		Digest_Hour: 8,
+	},
 }
 
 func ParseConfig(path string) error {
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"strings"
+	"time"
+)
+
+// Filter used to list the audit log. The zero value matches all the events.
+type AuditFilter struct {
+	// Events where the user is the actor or the target, 0 means any user
+	UserID int64
+	// Empty matches all the types
+	Types []string
+	// If not zero, only events in the time range
+	After  time.Time
+	Before time.Time
+	// 0 means no limit
+	Limit  int
+	Offset int
+}
+
+// Return the WHERE clause, with ? placeholders, and its arguments
+func (f *AuditFilter) where() (string, []any) {
+	conds := []string{"1 = 1"}
+	var args []any
+
+	if f.UserID != 0 {
+		conds = append(conds, "(actorID = ? OR targetID = ?)")
+		args = append(args, f.UserID, f.UserID)
+	}
+	if len(f.Types) > 0 {
+		p := make([]string, len(f.Types))
+		for i := range f.Types {
+			p[i] = "?"
+			args = append(args, f.Types[i])
+		}
+		conds = append(conds, "type IN ("+strings.Join(p, ", ")+")")
+	}
+	if !f.After.IsZero() {
+		conds = append(conds, "time >= ?")
+		args = append(args, f.After.Unix())
+	}
+	if !f.Before.IsZero() {
+		conds = append(conds, "time < ?")
+		args = append(args, f.Before.Unix())
+	}
+
+	return strings.Join(conds, " AND "), args
+}
@@ -0,0 +1,44 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func setCalDAVObject(db *sql.DB, bind func(string) string, o doit.CalDAVObject) error {
+	_, err := db.Exec(bind("INSERT INTO caldav_objects(todoID, uid, name) values(?, ?, ?) ON CONFLICT(todoID) DO UPDATE SET uid = excluded.uid, name = excluded.name"),
+		o.TodoID, o.UID, o.Name)
+	return err
+}
+
+func calDAVObjects(db *sql.DB, bind func(string) string, todoIDs []int64) ([]doit.CalDAVObject, error) {
+	if len(todoIDs) == 0 {
+		return nil, nil
+	}
+	in, args := inClause("todoID", todoIDs, nil)
+	return queryCalDAVObjects(db, bind("SELECT todoID, uid, name FROM caldav_objects WHERE "+in), args...)
+}
+
+func calDAVObjectsByName(db *sql.DB, bind func(string) string, name string) ([]doit.CalDAVObject, error) {
+	return queryCalDAVObjects(db, bind("SELECT todoID, uid, name FROM caldav_objects WHERE name = ?"), name)
+}
+
+func queryCalDAVObjects(db *sql.DB, query string, args ...any) ([]doit.CalDAVObject, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.CalDAVObject
+	for rows.Next() {
+		var o doit.CalDAVObject
+		if err := rows.Scan(&o.TodoID, &o.UID, &o.Name); err != nil {
+			return nil, err
+		}
+		all = append(all, o)
+	}
+
+	return all, rows.Err()
+}
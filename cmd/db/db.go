@@ -3,117 +3,345 @@ package db
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"log/slog"
 	"math/rand"
+	"os"
+	"time"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/samuelemusiani/doit/cmd/config"
 	"github.com/samuelemusiani/doit/cmd/doit"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var global_db *SQLiteRepository = nil
+// Repository is the storage layer of DOIT. Handlers only talk to the database
+// through this interface, so a different backend (or a fake one in tests) can
+// be used without touching them.
+type Repository interface {
+	CreateTodo(todo doit.Todo) (*doit.Todo, error)
+	// Create all the todos in a single transaction: if one fails none is
+	// created
+	CreateTodos(todos []doit.Todo) ([]doit.Todo, error)
+	AllTodos(userID int64) ([]doit.Todo, error)
+	// Return the todos visible to the user (his own and the ones shared with
+	// him) that match the filter, and the total number of matching todos
+	// ignoring limit and offset
+	FilterTodos(userID int64, f TodoFilter) ([]doit.Todo, int64, error)
+	// Full-text search in the todos visible to the user. Results are ordered
+	// by rank, limit 0 means no limit.
+	SearchTodos(userID int64, query string, limit int) ([]doit.TodoSearchResult, error)
+	GetTodoByID(id int64) (*doit.Todo, error)
+	// Delete todo with id todoID only if userID match
+	DeleteTodoByID(todoID int64, userID int64) error
+	DeleteTodosByUserID(userID int64) error
+	// Move the todo with id todoID to the trash only if userID match
+	TrashTodo(todoID int64, userID int64, at time.Time) error
+	// Move the todo with id todoID out of the trash only if userID match
+	RestoreTodo(todoID int64, userID int64) error
+	// Return the todos of the user in the trash, from the last deleted
+	TrashedTodos(userID int64) ([]doit.Todo, error)
+	// Permanently delete the todos moved to the trash before the time, and
+	// return how many were deleted
+	PurgeTrash(before time.Time) (int64, error)
+	UpdateTodo(id int64, todo doit.Todo, userID int64) (*doit.Todo, error)
+
+	// Add the item at the end of the checklist of its todo
+	CreateTodoItem(item doit.TodoItem) (*doit.TodoItem, error)
+	AllTodoItems(todoID int64) ([]doit.TodoItem, error)
+	// Get item with id itemID only if todoID match
+	GetTodoItemByID(itemID int64, todoID int64) (*doit.TodoItem, error)
+	// Update item with id itemID only if todoID match. If the position changes
+	// the other items are shifted, the position is clamped to the checklist.
+	UpdateTodoItem(itemID int64, todoID int64, item doit.TodoItem) (*doit.TodoItem, error)
+	// Delete item with id itemID only if todoID match. The following items are
+	// shifted back.
+	DeleteTodoItemByID(itemID int64, todoID int64) error
+
+	CreateProject(p doit.Project) (*doit.Project, error)
+	// Return the projects of the user and the ones shared with him
+	AllProjects(userID int64) ([]doit.Project, error)
+	GetProjectByID(id int64) (*doit.Project, error)
+	// Update project with id projectID only if userID match
+	UpdateProject(projectID int64, userID int64, p doit.Project) (*doit.Project, error)
+	// Delete project with id projectID only if userID match. Its todos are
+	// moved to the inbox.
+	DeleteProjectByID(projectID int64, userID int64) error
+
+	// Return the permission granted to userID on the todo, directly or
+	// through its project, or "" if the todo is not shared with him
+	TodoSharePermission(todoID int64, userID int64) (string, error)
+	// Return the permission granted to userID on the project, or "" if the
+	// project is not shared with him
+	ProjectSharePermission(projectID int64, userID int64) (string, error)
+	// Share the todo with userID, or change the permission if already shared
+	ShareTodo(todoID int64, userID int64, permission string) error
+	UnshareTodo(todoID int64, userID int64) error
+	TodoShares(todoID int64) ([]doit.Share, error)
+	// Share the project with userID, or change the permission if already
+	// shared
+	ShareProject(projectID int64, userID int64, permission string) error
+	UnshareProject(projectID int64, userID int64) error
+	ProjectShares(projectID int64) ([]doit.Share, error)
+
+	// Add the event to the history of its todo
+	CreateTodoEvent(e doit.TodoEvent) (*doit.TodoEvent, error)
+	// Return the history of the todo, from the oldest event
+	TodoEvents(todoID int64) ([]doit.TodoEvent, error)
+	// Get event with id eventID only if todoID match
+	GetTodoEventByID(eventID int64, todoID int64) (*doit.TodoEvent, error)
+
+	// Save the CalDAV object of its todo, replacing the old one
+	SetCalDAVObject(o doit.CalDAVObject) error
+	// Return the CalDAV objects of the todos that have one
+	CalDAVObjects(todoIDs []int64) ([]doit.CalDAVObject, error)
+	// Return the CalDAV objects with the name, of any user
+	CalDAVObjectsByName(name string) ([]doit.CalDAVObject, error)
+
+	// Return the todos of all the users that expire in [from, to), the done
+	// ones and the ones in the trash excluded. The first expiring is first.
+	ExpiringTodos(from time.Time, to time.Time) ([]doit.Todo, error)
+	// Record the reminder of the todo for the due date before sending it.
+	// Return false if it was already recorded, by this or another server, so
+	// it must not be sent.
+	ClaimReminder(reminder doit.Reminder) (bool, error)
+	// Forget a reminder that could not be sent, so it's tried again
+	DeleteReminder(reminder doit.Reminder) error
+	// Return when the last digest was sent to the user, zero if never
+	LastDigest(userID int64) (time.Time, error)
+	// Record that the digest is sent to the user at the time, if the last one
+	// was sent before since. Return false if it was not, so it must not be
+	// sent.
+	ClaimDigest(userID int64, at time.Time, since time.Time) (bool, error)
+	SetLastDigest(userID int64, at time.Time) error
+
+	CreateWebhook(w doit.Webhook) (*doit.Webhook, error)
+	GetWebhookByID(id int64) (*doit.Webhook, error)
+	AllWebhooks(userID int64) ([]doit.Webhook, error)
+	// Return the active webhooks that receive the events of the user: his own
+	// and the global ones. With userID 0 only the global ones. Webhooks of
+	// users not active, and global ones of users not admin, are skipped.
+	ActiveWebhooks(userID int64) ([]doit.Webhook, error)
+	// Update the webhook with the ID of w only if the userID of w match
+	UpdateWebhook(w doit.Webhook) (*doit.Webhook, error)
+	// Delete webhook with id only if userID match
+	DeleteWebhookByID(id int64, userID int64) error
+	// Add a delivery to the log of its webhook, deleting the oldest above
+	// WEBHOOK_DELIVERIES_KEPT
+	CreateWebhookDelivery(d doit.WebhookDelivery) (*doit.WebhookDelivery, error)
+	// Return the deliveries of the webhook, from the newest
+	WebhookDeliveries(webhookID int64) ([]doit.WebhookDelivery, error)
+
+	CreateAuditEvent(e doit.AuditEvent) (*doit.AuditEvent, error)
+	// Return the events of the audit log that match the filter, from the
+	// newest
+	AuditEvents(f AuditFilter) ([]doit.AuditEvent, error)
+
+	CreateComment(c doit.Comment) (*doit.Comment, error)
+	// Return the comments of the todo, from the oldest
+	AllComments(todoID int64) ([]doit.Comment, error)
+	// Get comment with id commentID only if todoID match
+	GetCommentByID(commentID int64, todoID int64) (*doit.Comment, error)
+	// Update the body and the edited time of the comment with id commentID
+	// only if todoID match
+	UpdateComment(commentID int64, todoID int64, c doit.Comment) (*doit.Comment, error)
+	// Delete comment with id commentID only if todoID match
+	DeleteCommentByID(commentID int64, todoID int64) error
+
+	CreateTag(tag doit.Tag) (*doit.Tag, error)
+	AllTags(userID int64) ([]doit.Tag, error)
+	// Get tag with id tagID only if userID match
+	GetTagByID(tagID int64, userID int64) (*doit.Tag, error)
+	// Update tag with id tagID only if userID match
+	UpdateTag(tagID int64, userID int64, tag doit.Tag) (*doit.Tag, error)
+	// Delete tag with id tagID only if userID match. The tag is removed from
+	// all the todos.
+	DeleteTagByID(tagID int64, userID int64) error
+
+	CreateUser(user doit.User) (*doit.User, error)
+	AllUsers() ([]doit.User, error)
+	GetUserByID(id int64) (*doit.User, error)
+	GetUserByUsername(username string) (*doit.User, error)
+	GetUserByEmail(email string) (*doit.User, error)
+	DeleteUserByID(id int64) error
+	UpdateUser(id int64, user doit.User) (*doit.User, error)
+
+	// The following are used to fill the lookup tables on startup. The IDs
+	// found or generated in the DB are written back in the slices.
+	InsertTodoStates(s []*doit.TodoState) error
+	InsertTodoPriorities(s []*doit.TodoPriority) error
+	InsertTodoColors(s []*doit.Color) error
+	// Like the Insert ones, but only read the IDs. ErrNotExists if a value is
+	// not in the DB.
+	LoadTodoStates(s []*doit.TodoState) error
+	LoadTodoPriorities(s []*doit.TodoPriority) error
+	LoadTodoColors(s []*doit.Color) error
+
+	CreateSession(s doit.Session) (*doit.Session, error)
+	GetSessionByTokenHash(hash string) (*doit.Session, error)
+	AllSessions(userID int64) ([]doit.Session, error)
+	UpdateSessionLastSeen(id int64, lastSeen time.Time) error
+	// Delete session with id sessionID only if userID match
+	DeleteSessionByID(sessionID int64, userID int64) error
+	DeleteSessionByTokenHash(hash string) error
+	DeleteExpiredSessions(now time.Time) (int64, error)
+	// Delete all the sessions of the user but exceptID, 0 to delete them all.
+	// Return the number of sessions deleted.
+	DeleteUserSessions(userID int64, exceptID int64) (int64, error)
+
+	CreateAPIToken(t doit.APIToken) (*doit.APIToken, error)
+	GetAPITokenByTokenHash(hash string) (*doit.APIToken, error)
+	AllAPITokens(userID int64) ([]doit.APIToken, error)
+	UpdateAPITokenLastUsed(id int64, lastUsed time.Time) error
+	// Delete token with id tokenID only if userID match
+	DeleteAPITokenByID(tokenID int64, userID int64) error
+
+	GetInternal(key string) ([]byte, error)
+	AddInternal(key string, data []byte) error
+
+	Close() error
+}
+
+// A Repository whose schema is managed by versioned migrations
+type migratable interface {
+	Repository
+	migrator() *migrator
+}
 
-func Init() error {
+// Open the database specified in the config, apply the pending migrations and
+// fill it with the defaults values.
+func Init() (Repository, error) {
 	slog.Debug("Init db connection")
 
 	config := config.GetConfig()
 
-	rawDB, err := sql.Open("sqlite3", config.Databse.Path+"?_foreign_keys=on")
+	r, err := open(config.Databse)
 	if err != nil {
-		return errors.Join(err, errors.New("Can't open db"))
+		return nil, err
 	}
 
-	global_db = newSQLiteRepository(rawDB)
-	err = global_db.migrate()
+	_, err = r.migrator().up()
 	if err != nil {
-		return errors.Join(err, errors.New("Can't generate tables on DB"))
+		r.Close()
+		return nil, errors.Join(err, errors.New("Can't migrate DB"))
 	}
 
-	err = fillDB()
+	err = fillDB(r)
 	if err != nil {
-		return errors.Join(err, errors.New("Can't fill DB with defaults tables"))
+		return nil, errors.Join(err, errors.New("Can't fill DB with defaults tables"))
 	}
 
-	err = generateDefaultAdmin(config.Users.First_User)
+	err = generateDefaultAdmin(r, config.Users.First_User)
 	if err != nil {
-		return errors.Join(err, errors.New("Can't create default user"))
+		return nil, errors.Join(err, errors.New("Can't create default user"))
 	}
 
-	return nil
-}
-
-func Close() error {
-	slog.Debug("Closing DB connection")
-	err := global_db.db.Close()
-	return err
+	return r, nil
 }
 
-func CreateTodo(note doit.Todo) (*doit.Todo, error) {
-	return global_db.createTodo(note)
-}
-
-func AllTodos(userID int64) ([]doit.Todo, error) {
-	return global_db.allTodos(userID)
-}
+// Open the database specified in the config as it is: the schema must be
+// already at the latest version and nothing is added to it. For the commands
+// that only work on the data.
+func Open() (Repository, error) {
+	conf := config.GetConfig().Databse
 
-func GetTodoByID(id int64) (*doit.Todo, error) {
-	return global_db.getTodoByID(id)
-}
+	// SQLite would create an empty database
+	if conf.Driver == "" || conf.Driver == "sqlite" {
+		if _, err := os.Stat(conf.Path); err != nil {
+			return nil, errors.Join(err, errors.New("Can't open db"))
+		}
+	}
 
-// Delte note with id noteID only if userID match
-func DeleteTodoByID(noteID int64, userID int64) error {
-	return global_db.deleteTodoByID(noteID, userID)
-}
+	r, err := open(conf)
+	if err != nil {
+		return nil, err
+	}
 
-func DeleteTodosByUserID(userID int64) error {
-	return global_db.deleteTodosByUserID(userID)
-}
+	err = r.migrator().check()
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
 
-func UpdateTodo(noteID int64, note doit.Todo, userID int64) (*doit.Todo, error) {
-	return global_db.updateTodo(noteID, note, userID)
+	err = loadDB(r)
+	if err != nil {
+		r.Close()
+		return nil, errors.Join(err, errors.New("Can't load the defaults tables, start the server once to fill them"))
+	}
+	return r, nil
 }
 
-func CreateUser(user doit.User) (*doit.User, error) {
-	return global_db.createUser(user)
+// Open the connection with the driver selected in the config
+func open(conf config.Databse) (migratable, error) {
+	switch conf.Driver {
+	case "", "sqlite":
+		if !sqliteFTS5 {
+			return nil, errors.New("SQLite needs DOIT built with the sqlite_fts5 tag")
+		}
+		rawDB, err := sql.Open("sqlite3", conf.Path+"?_foreign_keys=on")
+		if err != nil {
+			return nil, errors.Join(err, errors.New("Can't open db"))
+		}
+		return NewSQLiteRepository(rawDB), nil
+	case "postgres":
+		rawDB, err := sql.Open("postgres", conf.DSN)
+		if err != nil {
+			return nil, errors.Join(err, errors.New("Can't open db"))
+		}
+		return NewPostgresRepository(rawDB), nil
+	default:
+		return nil, fmt.Errorf("Unknown database driver %q", conf.Driver)
+	}
 }
 
-func AllUsers() ([]doit.User, error) {
-	return global_db.allUsers()
-}
+// Return the status of every migration known for the database in the config
+func MigrationsStatus() ([]MigrationStatus, error) {
+	r, err := open(config.GetConfig().Databse)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
 
-func GetUserByID(id int64) (*doit.User, error) {
-	return global_db.getUserByID(id)
+	return r.migrator().status()
 }
 
-func GetUserByUsername(username string) (*doit.User, error) {
-	return global_db.getUserByUsername(username)
-}
+// Apply all the pending migrations on the database in the config. Return the
+// number of migrations applied.
+func MigrateUp() (int, error) {
+	r, err := open(config.GetConfig().Databse)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
 
-func GetUserByEmail(email string) (*doit.User, error) {
-	return global_db.getUserByEmail(email)
+	return r.migrator().up()
 }
 
-func DeleteUserByID(id int64) error {
-	return global_db.deleteUserByID(id)
-}
+// Revert the last migration applied on the database in the config. Return the
+// version reverted.
+func MigrateDown() (int, error) {
+	r, err := open(config.GetConfig().Databse)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
 
-func UpdateUser(id int64, user doit.User) (*doit.User, error) {
-	return global_db.updateUser(id, user)
+	return r.migrator().down()
 }
 
-func fillDB() error {
-	err := global_db.insertTodoStates(doit.States)
+func fillDB(r Repository) error {
+	err := r.InsertTodoStates(doit.States)
 	if err != nil {
 		return errors.Join(err, errors.New("Inserting states into db"))
 	}
 
-	err = global_db.insertTodoPriorities(doit.Priorities)
+	err = r.InsertTodoPriorities(doit.Priorities)
 	if err != nil {
 		return errors.Join(err, errors.New("Inserting states into db"))
 	}
 
-	err = global_db.insertTodoColors(doit.Colors)
+	err = r.InsertTodoColors(doit.Colors)
 	if err != nil {
 		return errors.Join(err, errors.New("Inserting states into db"))
 	}
@@ -121,8 +349,28 @@ func fillDB() error {
 	return nil
 }
 
-func generateDefaultAdmin(user config.FirstUser) error {
-	u, err := global_db.getInternal("first_user")
+// Same as fillDB, without writing to the DB
+func loadDB(r Repository) error {
+	err := r.LoadTodoStates(doit.States)
+	if err != nil {
+		return errors.Join(err, errors.New("Loading states from db"))
+	}
+
+	err = r.LoadTodoPriorities(doit.Priorities)
+	if err != nil {
+		return errors.Join(err, errors.New("Loading priorities from db"))
+	}
+
+	err = r.LoadTodoColors(doit.Colors)
+	if err != nil {
+		return errors.Join(err, errors.New("Loading colors from db"))
+	}
+
+	return nil
+}
+
+func generateDefaultAdmin(r Repository, user config.FirstUser) error {
+	u, err := r.GetInternal("first_user")
 	// User found
 	if err == nil {
 		// We check if config has changed, just to notify :)
@@ -152,12 +400,12 @@ func generateDefaultAdmin(user config.FirstUser) error {
 		Active:   true,
 	}
 
-	_, err = global_db.createUser(new_user)
+	_, err = r.CreateUser(new_user)
 	if err != nil {
 		return err
 	}
 
-	err = global_db.addInternal("first_user", []byte(user.Username))
+	err = r.AddInternal("first_user", []byte(user.Username))
 	if err != nil {
 		return err
 	}
@@ -3,6 +3,7 @@ package db
 import (
 	"math/rand"
 	"slices"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,14 +15,27 @@ import (
 
 // Utils functions
 
-func setup() error {
+// Run the test against an empty database of every supported backend
+func eachBackend(t *testing.T, test func(*testing.T, Repository)) {
+	t.Run("sqlite", func(t *testing.T) {
+		test(t, setupSQLite(t))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		test(t, setupPostgres(t))
+	})
+}
+
+func setupSQLite(t *testing.T) Repository {
 	conf := config.GetConfig()
+	conf.Databse.Driver = "sqlite"
 	conf.Databse.Path = ":memory:"
-	return Init()
-}
 
-func cleanup() error {
-	return Close()
+	r, err := Init()
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, r.Close())
+	})
+	return r
 }
 
 func randBool() bool {
@@ -67,75 +81,73 @@ func newUser() (doit.User, error) {
 	}, err
 }
 
-func createAndInsertUser() (*doit.User, error) {
+func createAndInsertUser(r Repository) (*doit.User, error) {
 	user, err := newUser()
 	if err != nil {
 		return &doit.User{}, err
 	}
 
-	return CreateUser(user)
+	return r.CreateUser(user)
 }
 
-func createAndInsertTodo(userID int64) (*doit.Todo, error) {
+func createAndInsertTodo(r Repository, userID int64) (*doit.Todo, error) {
 	todo := newTodo()
 	todo.UserID = userID
-	return CreateTodo(todo)
+	return r.CreateTodo(todo)
 }
 
 // Acctual testing
 
-func TestInit(t *testing.T) {
-	err := setup()
-	assert.NilError(t, err)
-	err = cleanup()
+func TestInit(t *testing.T) { eachBackend(t, testInit) }
+
+func testInit(t *testing.T, r Repository) {
+	// Created by Init for the default admin
+	_, err := r.GetInternal("first_user")
 	assert.NilError(t, err)
+
+	err = r.AddInternal("first_user", []byte("someone"))
+	assert.ErrorIs(t, err, ErrDuplicate)
+
+	_, err = r.GetInternal("not_present")
+	assert.ErrorIs(t, err, ErrNotExists)
 }
 
-func TestCreateUser(t *testing.T) {
-	err := setup()
-	assert.NilError(t, err)
+func TestCreateUser(t *testing.T) { eachBackend(t, testCreateUser) }
 
+func testCreateUser(t *testing.T, r Repository) {
 	user, err := newUser()
 	assert.NilError(t, err)
 
-	newUser, err := CreateUser(user)
+	newUser, err := r.CreateUser(user)
 	user.ID = newUser.ID
 	assert.NilError(t, err)
 	assert.DeepEqual(t, &user, newUser)
-
-	err = cleanup()
-	assert.NilError(t, err)
 }
 
-func TestCreateUserDuplicate(t *testing.T) {
-	err := setup()
-	assert.NilError(t, err)
+func TestCreateUserDuplicate(t *testing.T) { eachBackend(t, testCreateUserDuplicate) }
 
-	user, err := createAndInsertUser()
+func testCreateUserDuplicate(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
 	assert.NilError(t, err)
 
-	_, err = CreateUser(*user)
+	_, err = r.CreateUser(*user)
 	assert.ErrorIs(t, err, ErrDuplicate)
-
-	err = cleanup()
-	assert.NilError(t, err)
 }
 
-func TestAllUsers(t *testing.T) {
-	err := setup()
-	assert.NilError(t, err)
+func TestAllUsers(t *testing.T) { eachBackend(t, testAllUsers) }
 
+func testAllUsers(t *testing.T, r Repository) {
 	n := rand.Intn(10) + 10
 	users := make([]doit.User, n)
 
 	for i := range n {
-		user, err := createAndInsertUser()
+		user, err := createAndInsertUser(r)
 		assert.NilError(t, err)
 
 		users[i] = *user
 	}
 
-	dbUsers, err := AllUsers()
+	dbUsers, err := r.AllUsers()
 	assert.NilError(t, err)
 
 	sortFunc := func(a, b doit.User) int { return int(a.ID - b.ID) }
@@ -144,67 +156,51 @@ func TestAllUsers(t *testing.T) {
 
 	// Skip the first user as not generated here, it's the default admin
 	assert.DeepEqual(t, users, dbUsers[1:])
-
-	err = cleanup()
-	assert.NilError(t, err)
 }
 
-func TestGetUserByID(t *testing.T) {
-	err := setup()
-	assert.NilError(t, err)
+func TestGetUserByID(t *testing.T) { eachBackend(t, testGetUserByID) }
 
-	user, err := createAndInsertUser()
+func testGetUserByID(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
 	assert.NilError(t, err)
 
-	getUser, err := GetUserByID(user.ID)
+	getUser, err := r.GetUserByID(user.ID)
 	assert.NilError(t, err)
 	assert.DeepEqual(t, user, getUser)
-
-	err = cleanup()
-	assert.NilError(t, err)
 }
 
-func TestGetUserByUsername(t *testing.T) {
-	err := setup()
-	assert.NilError(t, err)
+func TestGetUserByUsername(t *testing.T) { eachBackend(t, testGetUserByUsername) }
 
-	user, err := createAndInsertUser()
+func testGetUserByUsername(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
 	assert.NilError(t, err)
 
-	getUser, err := GetUserByUsername(user.Username)
+	getUser, err := r.GetUserByUsername(user.Username)
 	assert.NilError(t, err)
 	assert.DeepEqual(t, user, getUser)
-
-	err = cleanup()
-	assert.NilError(t, err)
 }
 
-func TestGetUserByEmail(t *testing.T) {
-	err := setup()
-	assert.NilError(t, err)
+func TestGetUserByEmail(t *testing.T) { eachBackend(t, testGetUserByEmail) }
 
-	user, err := createAndInsertUser()
+func testGetUserByEmail(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
 	assert.NilError(t, err)
 
-	getUser, err := GetUserByEmail(user.Email)
+	getUser, err := r.GetUserByEmail(user.Email)
 	assert.NilError(t, err)
 	assert.DeepEqual(t, user, getUser)
-
-	err = cleanup()
-	assert.NilError(t, err)
 }
 
-func TestDeleteUserByID(t *testing.T) {
-	err := setup()
-	assert.NilError(t, err)
+func TestDeleteUserByID(t *testing.T) { eachBackend(t, testDeleteUserByID) }
 
-	user, err := createAndInsertUser()
+func testDeleteUserByID(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
 	assert.NilError(t, err)
 
-	err = DeleteUserByID(user.ID)
+	err = r.DeleteUserByID(user.ID)
 	assert.NilError(t, err)
 
-	users, err := AllUsers()
+	users, err := r.AllUsers()
 	assert.NilError(t, err)
 
 	for i := range users {
@@ -212,70 +208,78 @@ func TestDeleteUserByID(t *testing.T) {
 			t.Fatalf("User present in db after deletion")
 		}
 	}
-
-	err = cleanup()
-	assert.NilError(t, err)
 }
 
-func TestUpdateUser(t *testing.T) {
-	err := setup()
-	assert.NilError(t, err)
+func TestUpdateUser(t *testing.T) { eachBackend(t, testUpdateUser) }
 
-	nUser, err := createAndInsertUser()
+func testUpdateUser(t *testing.T, r Repository) {
+	nUser, err := createAndInsertUser(r)
 	assert.NilError(t, err)
 
 	user, err := newUser()
 	assert.NilError(t, err)
 	user.ID = nUser.ID
 
-	uUser, err := UpdateUser(nUser.ID, user)
+	uUser, err := r.UpdateUser(nUser.ID, user)
 	assert.NilError(t, err)
 	assert.DeepEqual(t, &user, uUser)
-
-	err = cleanup()
-	assert.NilError(t, err)
 }
 
-func TestCreateTodoWithoutUser(t *testing.T) {
-	err := setup()
-	assert.NilError(t, err)
+func TestCreateTodoWithoutUser(t *testing.T) { eachBackend(t, testCreateTodoWithoutUser) }
 
+func testCreateTodoWithoutUser(t *testing.T, r Repository) {
 	todo := newTodo()
 
-	_, err = CreateTodo(todo)
-	assert.ErrorContains(t, err, "FOREIGN KEY constraint failed")
-
-	err = cleanup()
-	assert.NilError(t, err)
+	_, err := r.CreateTodo(todo)
+	// sqlite and postgres word the error differently
+	assert.Assert(t, err != nil)
+	assert.Check(t, strings.Contains(strings.ToLower(err.Error()), "foreign key"), err)
 }
 
-func TestCreateTodo(t *testing.T) {
-	err := setup()
-	assert.NilError(t, err)
+func TestCreateTodo(t *testing.T) { eachBackend(t, testCreateTodo) }
 
-	u, err := createAndInsertUser()
+func testCreateTodo(t *testing.T, r Repository) {
+	u, err := createAndInsertUser(r)
 	assert.NilError(t, err)
 
 	n := newTodo()
 	n.UserID = u.ID
-	nn, err := CreateTodo(n)
+	nn, err := r.CreateTodo(n)
 	assert.NilError(t, err)
 
 	n.ID = nn.ID
 	assert.DeepEqual(t, &n, nn)
+}
 
-	err = cleanup()
+func TestCreateTodos(t *testing.T) { eachBackend(t, testCreateTodos) }
+
+func testCreateTodos(t *testing.T, r Repository) {
+	u, err := createAndInsertUser(r)
 	assert.NilError(t, err)
-}
 
-func TestAllTodos(t *testing.T) {
-	err := setup()
+	a, b := newTodo(), newTodo()
+	a.UserID, b.UserID = u.ID, u.ID
+	created, err := r.CreateTodos([]doit.Todo{a, b})
 	assert.NilError(t, err)
+	assert.Equal(t, len(created), 2)
+	assert.Assert(t, created[0].ID != 0 && created[1].ID != created[0].ID)
 
+	// Nothing is created if a todo fails
+	b.TagIDs = []int64{1000}
+	_, err = r.CreateTodos([]doit.Todo{a, b})
+	assert.ErrorIs(t, err, ErrInvalidTag)
+	todos, err := r.AllTodos(u.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(todos), 2)
+}
+
+func TestAllTodos(t *testing.T) { eachBackend(t, testAllTodos) }
+
+func testAllTodos(t *testing.T, r Repository) {
 	numberOfUsers := 3
 	users := make([]doit.User, numberOfUsers)
 	for i := range numberOfUsers {
-		user, err := createAndInsertUser()
+		user, err := createAndInsertUser(r)
 		assert.NilError(t, err)
 
 		users[i] = *user
@@ -284,7 +288,7 @@ func TestAllTodos(t *testing.T) {
 	numberOfTodos := rand.Intn(10) + 10
 	todos := make([]doit.Todo, numberOfTodos)
 	for i := range numberOfTodos {
-		todo, err := createAndInsertTodo(int64(rand.Intn(numberOfUsers) + 1))
+		todo, err := createAndInsertTodo(r, int64(rand.Intn(numberOfUsers)+1))
 		assert.NilError(t, err)
 
 		todos[i] = *todo
@@ -303,70 +307,61 @@ func TestAllTodos(t *testing.T) {
 	sortFunc := func(a, b doit.Todo) int { return int(a.ID - b.ID) }
 
 	for userID := int64(1); userID <= int64(numberOfUsers); userID++ {
-		dbTodos, err := AllTodos(userID)
+		dbTodos, err := r.AllTodos(userID)
 		assert.NilError(t, err)
 
 		todosFiltered := todosFromUserID(todos, userID)
 		slices.SortFunc(todosFiltered, sortFunc)
 		assert.DeepEqual(t, todosFiltered, dbTodos)
 	}
-
-	err = cleanup()
-	assert.NilError(t, err)
 }
 
-func TestGetTodoById(t *testing.T) {
-	err := setup()
-	assert.NilError(t, err)
+func TestGetTodoById(t *testing.T) { eachBackend(t, testGetTodoById) }
 
-	user, err := createAndInsertUser()
+func testGetTodoById(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
 	assert.NilError(t, err)
 
-	todo, err := createAndInsertTodo(user.ID)
+	todo, err := createAndInsertTodo(r, user.ID)
 	assert.NilError(t, err)
 
-	getTodo, err := GetTodoByID(todo.ID)
+	getTodo, err := r.GetTodoByID(todo.ID)
 	assert.NilError(t, err)
 
 	assert.DeepEqual(t, todo, getTodo)
-
-	err = cleanup()
-	assert.NilError(t, err)
 }
 
-func TestUpdateTodoByID(t *testing.T) {
-	err := setup()
-	assert.NilError(t, err)
+func TestUpdateTodoByID(t *testing.T) { eachBackend(t, testUpdateTodoByID) }
 
-	user, err := createAndInsertUser()
+func testUpdateTodoByID(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
 	assert.NilError(t, err)
 
-	todo, err := createAndInsertTodo(user.ID)
+	todo, err := createAndInsertTodo(r, user.ID)
 	assert.NilError(t, err)
 
 	newTodo := newTodo()
 	newTodo.ID = todo.ID
 	newTodo.UserID = todo.UserID
 
-	modTodo, err := UpdateTodo(todo.ID, newTodo, todo.UserID)
+	modTodo, err := r.UpdateTodo(todo.ID, newTodo, todo.UserID)
 	assert.NilError(t, err)
-	assert.Equal(t, *modTodo, newTodo)
+	assert.DeepEqual(t, *modTodo, newTodo)
 }
 
-func TestDeleteTodoByID(t *testing.T) {
-	err := setup()
-	assert.NilError(t, err)
+func TestDeleteTodoByID(t *testing.T) { eachBackend(t, testDeleteTodoByID) }
 
-	user, err := createAndInsertUser()
+func testDeleteTodoByID(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
 	assert.NilError(t, err)
 
-	todo, err := createAndInsertTodo(user.ID)
+	todo, err := createAndInsertTodo(r, user.ID)
 	assert.NilError(t, err)
 
-	err = DeleteTodoByID(todo.ID, user.ID)
+	err = r.DeleteTodoByID(todo.ID, user.ID)
 	assert.NilError(t, err)
 
-	todos, err := AllTodos(user.ID)
+	todos, err := r.AllTodos(user.ID)
 	assert.NilError(t, err)
 
 	for i := range todos {
@@ -374,36 +369,28 @@ func TestDeleteTodoByID(t *testing.T) {
 			t.Fatalf("Todo present in db after deletion")
 		}
 	}
-
-	err = cleanup()
-	assert.NilError(t, err)
 }
 
-func TestDeleteTodoByIDWrongUserID(t *testing.T) {
-	err := setup()
-	assert.NilError(t, err)
+func TestDeleteTodoByIDWrongUserID(t *testing.T) { eachBackend(t, testDeleteTodoByIDWrongUserID) }
 
-	user, err := createAndInsertUser()
+func testDeleteTodoByIDWrongUserID(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
 	assert.NilError(t, err)
 
-	todo, err := createAndInsertTodo(user.ID)
+	todo, err := createAndInsertTodo(r, user.ID)
 	assert.NilError(t, err)
 
-	err = DeleteTodoByID(todo.ID, 123982)
+	err = r.DeleteTodoByID(todo.ID, 123982)
 	assert.ErrorIs(t, err, ErrDeleteFailed)
-
-	err = cleanup()
-	assert.NilError(t, err)
 }
 
-func TestDeleteTodosByUserID(t *testing.T) {
-	err := setup()
-	assert.NilError(t, err)
+func TestDeleteTodosByUserID(t *testing.T) { eachBackend(t, testDeleteTodosByUserID) }
 
+func testDeleteTodosByUserID(t *testing.T, r Repository) {
 	numberOfUsers := 3
 	users := make([]doit.User, numberOfUsers)
 	for i := range numberOfUsers {
-		user, err := createAndInsertUser()
+		user, err := createAndInsertUser(r)
 		assert.NilError(t, err)
 
 		users[i] = *user
@@ -412,28 +399,1023 @@ func TestDeleteTodosByUserID(t *testing.T) {
 	numberOfTodos := rand.Intn(10) + 10
 	todos := make([]doit.Todo, numberOfTodos)
 	for i := range numberOfTodos {
-		todo, err := createAndInsertTodo(int64(rand.Intn(numberOfUsers) + 1))
+		todo, err := createAndInsertTodo(r, int64(rand.Intn(numberOfUsers)+1))
 		assert.NilError(t, err)
 
 		todos[i] = *todo
 	}
 
 	userID := int64(rand.Intn(numberOfUsers) + 1)
-	err = DeleteTodosByUserID(userID)
+	err := r.DeleteTodosByUserID(userID)
 	assert.NilError(t, err)
 
-	dbTodos, err := AllTodos(userID)
+	dbTodos, err := r.AllTodos(userID)
 	assert.NilError(t, err)
 	assert.Check(t, len(dbTodos) == 0)
+}
+
+func TestInsertTodoStates(t *testing.T) { eachBackend(t, testInsertTodoStates) }
+
+func testInsertTodoStates(t *testing.T, r Repository) {
+}
+
+func TestLoadTodoStates(t *testing.T) { eachBackend(t, testLoadTodoStates) }
+
+func testLoadTodoStates(t *testing.T, r Repository) {
+	states := []*doit.TodoState{{State: doit.States[1].State}}
+	err := r.LoadTodoStates(states)
+	assert.NilError(t, err)
+	assert.Equal(t, states[0].ID, doit.States[1].ID)
+
+	err = r.LoadTodoStates([]*doit.TodoState{{State: "Not a state"}})
+	assert.ErrorIs(t, err, ErrNotExists)
+}
+
+func newSession(userID int64) doit.Session {
+	now := time.Now().Round(time.Second)
+	return doit.Session{
+		TokenHash: randString(64),
+		UserID:    userID,
+		Created:   now,
+		Expire:    now.Add(time.Hour),
+		LastSeen:  now,
+		UserAgent: randString(20),
+		IP:        "127.0.0.1",
+	}
+}
+
+func TestCreateAndGetSession(t *testing.T) { eachBackend(t, testCreateAndGetSession) }
+
+func testCreateAndGetSession(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+
+	s := newSession(user.ID)
+	ns, err := r.CreateSession(s)
+	assert.NilError(t, err)
+	s.ID = ns.ID
+	assert.DeepEqual(t, &s, ns)
+
+	gs, err := r.GetSessionByTokenHash(s.TokenHash)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, &s, gs)
+
+	_, err = r.CreateSession(s)
+	assert.ErrorIs(t, err, ErrDuplicate)
+
+	_, err = r.GetSessionByTokenHash("not present")
+	assert.ErrorIs(t, err, ErrNotExists)
+}
+
+func TestDeleteSessionByIDWrongUserID(t *testing.T) { eachBackend(t, testDeleteSessionByIDWrongUserID) }
+
+func testDeleteSessionByIDWrongUserID(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+
+	s, err := r.CreateSession(newSession(user.ID))
+	assert.NilError(t, err)
+
+	err = r.DeleteSessionByID(s.ID, 123982)
+	assert.ErrorIs(t, err, ErrDeleteFailed)
+
+	err = r.DeleteSessionByID(s.ID, user.ID)
+	assert.NilError(t, err)
+
+	sessions, err := r.AllSessions(user.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(sessions), 0)
+}
+
+func TestDeleteExpiredSessions(t *testing.T) { eachBackend(t, testDeleteExpiredSessions) }
+
+func testDeleteExpiredSessions(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+
+	expired := newSession(user.ID)
+	expired.Expire = time.Now().Add(-time.Hour)
+	_, err = r.CreateSession(expired)
+	assert.NilError(t, err)
+
+	valid, err := r.CreateSession(newSession(user.ID))
+	assert.NilError(t, err)
+
+	n, err := r.DeleteExpiredSessions(time.Now())
+	assert.NilError(t, err)
+	assert.Equal(t, n, int64(1))
+
+	sessions, err := r.AllSessions(user.ID)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, sessions, []doit.Session{*valid})
+}
+
+func TestDeleteUserSessions(t *testing.T) { eachBackend(t, testDeleteUserSessions) }
+
+func testDeleteUserSessions(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+	other, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+
+	current, err := r.CreateSession(newSession(user.ID))
+	assert.NilError(t, err)
+	_, err = r.CreateSession(newSession(user.ID))
+	assert.NilError(t, err)
+	otherSession, err := r.CreateSession(newSession(other.ID))
+	assert.NilError(t, err)
+
+	n, err := r.DeleteUserSessions(user.ID, current.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, n, int64(1))
+	sessions, err := r.AllSessions(user.ID)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, sessions, []doit.Session{*current})
+
+	n, err = r.DeleteUserSessions(user.ID, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, n, int64(1))
+	sessions, err = r.AllSessions(user.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(sessions), 0)
+
+	sessions, err = r.AllSessions(other.ID)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, sessions, []doit.Session{*otherSession})
+}
+
+func TestDeleteUserDeletesSessions(t *testing.T) { eachBackend(t, testDeleteUserDeletesSessions) }
+
+func testDeleteUserDeletesSessions(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+
+	s, err := r.CreateSession(newSession(user.ID))
+	assert.NilError(t, err)
+
+	err = r.DeleteUserByID(user.ID)
+	assert.NilError(t, err)
+
+	_, err = r.GetSessionByTokenHash(s.TokenHash)
+	assert.ErrorIs(t, err, ErrNotExists)
+}
+
+func TestCreateAndGetAPIToken(t *testing.T) { eachBackend(t, testCreateAndGetAPIToken) }
+
+func testCreateAndGetAPIToken(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+
+	token := doit.APIToken{
+		UserID:    user.ID,
+		Name:      randString(10),
+		TokenHash: randString(64),
+		Scopes:    []string{doit.ScopeTodosRead, doit.ScopeTodosWrite},
+		Created:   time.Now().Round(time.Second),
+	}
+	nToken, err := r.CreateAPIToken(token)
+	assert.NilError(t, err)
+	token.ID = nToken.ID
+	assert.DeepEqual(t, &token, nToken)
+
+	gToken, err := r.GetAPITokenByTokenHash(token.TokenHash)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, &token, gToken)
+	assert.Check(t, gToken.Expire.IsZero())
+	assert.Check(t, gToken.LastUsed.IsZero())
+
+	lastUsed := time.Now().Round(time.Second)
+	err = r.UpdateAPITokenLastUsed(token.ID, lastUsed)
+	assert.NilError(t, err)
+
+	tokens, err := r.AllAPITokens(user.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(tokens), 1)
+	assert.Equal(t, tokens[0].LastUsed, lastUsed)
+
+	err = r.DeleteAPITokenByID(token.ID, 123982)
+	assert.ErrorIs(t, err, ErrDeleteFailed)
+
+	err = r.DeleteAPITokenByID(token.ID, user.ID)
+	assert.NilError(t, err)
+
+	_, err = r.GetAPITokenByTokenHash(token.TokenHash)
+	assert.ErrorIs(t, err, ErrNotExists)
+}
+
+func TestFilterTodos(t *testing.T) { eachBackend(t, testFilterTodos) }
+
+func testFilterTodos(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+	other, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+
+	now := time.Now().Round(time.Second)
+	todos := []doit.Todo{
+		{Title: "Buy milk", Description: "", StateID: 1, PriorityID: 3, ColorID: 1, Expiration: doit.Expiration{DoesExpire: true, Date: now.Add(time.Hour)}},
+		{Title: "Write report", Description: "50% done", StateID: 2, PriorityID: 5, ColorID: 2, Expiration: doit.Expiration{DoesExpire: true, Date: now.Add(48 * time.Hour)}},
+		{Title: "Call mom", Description: "about the MILK", StateID: 1, PriorityID: 1, ColorID: 1},
+		{Title: "Fix bike", Description: "", StateID: 4, PriorityID: 5, ColorID: 3},
+	}
+	for i := range todos {
+		todos[i].UserID = user.ID
+		todo, err := r.CreateTodo(todos[i])
+		assert.NilError(t, err)
+		todos[i] = *todo
+	}
+	_, err = createAndInsertTodo(r, other.ID)
+	assert.NilError(t, err)
+
+	ids := func(todos []doit.Todo) []int64 {
+		var ids []int64
+		for i := range todos {
+			ids = append(ids, todos[i].ID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		filter TodoFilter
+		want   []int64
+		total  int64
+	}{
+		{TodoFilter{}, ids(todos), 4},
+		{TodoFilter{StateIDs: []int64{1}}, ids([]doit.Todo{todos[0], todos[2]}), 2},
+		{TodoFilter{StateIDs: []int64{2, 4}, ColorIDs: []int64{3}}, ids(todos[3:4]), 1},
+		{TodoFilter{Query: "milk"}, ids([]doit.Todo{todos[0], todos[2]}), 2},
+		{TodoFilter{Query: "%"}, ids(todos[1:2]), 1},
+		{TodoFilter{ExpiresBefore: now.Add(2 * time.Hour)}, ids(todos[0:1]), 1},
+		{TodoFilter{ExpiresAfter: now.Add(2 * time.Hour)}, ids(todos[1:2]), 1},
+		{TodoFilter{Sort: []TodoSort{{Field: "priority", Desc: true}, {Field: "title"}}}, ids([]doit.Todo{todos[3], todos[1], todos[0], todos[2]}), 4},
+		{TodoFilter{Limit: 2, Offset: 1}, ids(todos[1:3]), 4},
+		{TodoFilter{Offset: 3}, ids(todos[3:]), 4},
+	}
+
+	for _, test := range tests {
+		got, total, err := r.FilterTodos(user.ID, test.filter)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, ids(got), test.want)
+		assert.Equal(t, total, test.total)
+	}
+
+	_, _, err = r.FilterTodos(user.ID, TodoFilter{Sort: []TodoSort{{Field: "userID"}}})
+	assert.ErrorIs(t, err, ErrInvalidFilter)
+}
+
+func TestSearchTodos(t *testing.T) { eachBackend(t, testSearchTodos) }
+
+func testSearchTodos(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+	other, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+
+	create := func(userID int64, title string, description string) *doit.Todo {
+		todo := newTodo()
+		todo.UserID = userID
+		todo.Title = title
+		todo.Description = description
+		nTodo, err := r.CreateTodo(todo)
+		assert.NilError(t, err)
+		return nTodo
+	}
+
+	inDescription := create(user.ID, "Groceries", "Remember to buy the milk")
+	inTitle := create(user.ID, "Milk", "From the farm")
+	create(user.ID, "Call mom", "Sunday")
+	create(other.ID, "Milk", "Not mine")
+
+	res, err := r.SearchTodos(user.ID, "milk", 0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(res), 2)
+	// Matches in title rank higher
+	assert.Equal(t, res[0].Todo.ID, inTitle.ID)
+	assert.Equal(t, res[1].Todo.ID, inDescription.ID)
+	assert.Check(t, res[0].Rank > res[1].Rank)
+	assert.Check(t, strings.Contains(res[1].Snippet, "<b>milk</b>"), res[1].Snippet)
+
+	res, err = r.SearchTodos(user.ID, "milk", 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(res), 1)
+
+	// Index is updated with the todo
+	inTitle.Title = "Water"
+	_, err = r.UpdateTodo(inTitle.ID, *inTitle, user.ID)
+	assert.NilError(t, err)
+	err = r.DeleteTodoByID(inDescription.ID, user.ID)
+	assert.NilError(t, err)
+
+	res, err = r.SearchTodos(user.ID, "milk", 0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(res), 0)
+
+	res, err = r.SearchTodos(user.ID, "water farm", 0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(res), 1)
+
+	// FTS syntax is not interpreted
+	_, err = r.SearchTodos(user.ID, `"unbalanced OR`, 0)
+	assert.NilError(t, err)
+
+	// The snippet is HTML, only the matches are in tags
+	create(user.ID, "Tea", `1 < 2 & <i>"green"</i>`)
+	res, err = r.SearchTodos(user.ID, "green", 0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(res), 1)
+	assert.Check(t, strings.Contains(res[0].Snippet, "<b>green</b>"), res[0].Snippet)
+	text := strings.NewReplacer("<b>", "", "</b>", "").Replace(res[0].Snippet)
+	assert.Check(t, !strings.ContainsAny(text, `<>"`), res[0].Snippet)
+}
+
+func TestTodoItems(t *testing.T) { eachBackend(t, testTodoItems) }
+
+func testTodoItems(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+	todo, err := createAndInsertTodo(r, user.ID)
+	assert.NilError(t, err)
+
+	var items []*doit.TodoItem
+	for _, text := range []string{"a", "b", "c", "d"} {
+		item, err := r.CreateTodoItem(doit.TodoItem{TodoID: todo.ID, Text: text})
+		assert.NilError(t, err)
+		items = append(items, item)
+	}
+	assert.Equal(t, items[3].Position, int64(3))
+
+	texts := func() string {
+		all, err := r.AllTodoItems(todo.ID)
+		assert.NilError(t, err)
+		var s string
+		for i := range all {
+			assert.Equal(t, all[i].Position, int64(i))
+			s += all[i].Text
+		}
+		return s
+	}
+
+	// Move d to the top and a after the end
+	d := *items[3]
+	d.Position = 0
+	_, err = r.UpdateTodoItem(d.ID, todo.ID, d)
+	assert.NilError(t, err)
+	assert.Equal(t, texts(), "dabc")
+
+	a := *items[0]
+	a.Position = 10
+	a.Done = true
+	nA, err := r.UpdateTodoItem(a.ID, todo.ID, a)
+	assert.NilError(t, err)
+	assert.Equal(t, nA.Position, int64(3))
+	assert.Equal(t, texts(), "dbca")
+
+	err = r.DeleteTodoItemByID(items[1].ID, todo.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, texts(), "dca")
+
+	// Items can't be reached through another todo
+	other, err := createAndInsertTodo(r, user.ID)
+	assert.NilError(t, err)
+	_, err = r.GetTodoItemByID(a.ID, other.ID)
+	assert.ErrorIs(t, err, ErrNotExists)
+	err = r.DeleteTodoItemByID(a.ID, other.ID)
+	assert.ErrorIs(t, err, ErrDeleteFailed)
+
+	// One of three is done
+	nTodo, err := r.GetTodoByID(todo.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, nTodo.Progress, int64(33))
+	nOther, err := r.GetTodoByID(other.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, nOther.Progress, int64(0))
+
+	err = r.DeleteTodoByID(todo.ID, user.ID)
+	assert.NilError(t, err)
+	all, err := r.AllTodoItems(todo.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(all), 0)
+}
+
+func TestTodoTags(t *testing.T) { eachBackend(t, testTodoTags) }
+
+func testTodoTags(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+	other, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+
+	work, err := r.CreateTag(doit.Tag{UserID: user.ID, Name: "work", Color: "#ff0000"})
+	assert.NilError(t, err)
+	home, err := r.CreateTag(doit.Tag{UserID: user.ID, Name: "home"})
+	assert.NilError(t, err)
+	notMine, err := r.CreateTag(doit.Tag{UserID: other.ID, Name: "work"})
+	assert.NilError(t, err)
+
+	_, err = r.CreateTag(doit.Tag{UserID: user.ID, Name: "work"})
+	assert.ErrorIs(t, err, ErrDuplicate)
+
+	tags, err := r.AllTags(user.ID)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, tags, []doit.Tag{*home, *work})
+
+	todo := newTodo()
+	todo.UserID = user.ID
+	todo.TagIDs = []int64{work.ID, home.ID, work.ID}
+	nTodo, err := r.CreateTodo(todo)
+	assert.NilError(t, err)
+
+	getTodo, err := r.GetTodoByID(nTodo.ID)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, getTodo.TagIDs, []int64{work.ID, home.ID})
+
+	// Tags of other users can't be used
+	todo.TagIDs = []int64{notMine.ID}
+	_, err = r.CreateTodo(todo)
+	assert.ErrorIs(t, err, ErrInvalidTag)
+
+	untagged, err := createAndInsertTodo(r, user.ID)
+	assert.NilError(t, err)
+
+	todos, _, err := r.FilterTodos(user.ID, TodoFilter{TagIDs: []int64{home.ID}})
+	assert.NilError(t, err)
+	assert.Equal(t, len(todos), 1)
+	assert.Equal(t, todos[0].ID, nTodo.ID)
+
+	// nil leaves the tags unchanged
+	getTodo.TagIDs = nil
+	uTodo, err := r.UpdateTodo(getTodo.ID, *getTodo, user.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(uTodo.TagIDs), 2)
+
+	getTodo.TagIDs = []int64{home.ID}
+	uTodo, err = r.UpdateTodo(getTodo.ID, *getTodo, user.ID)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, uTodo.TagIDs, []int64{home.ID})
+
+	// Deleting a tag removes it from the todos
+	err = r.DeleteTagByID(home.ID, user.ID)
+	assert.NilError(t, err)
+	getTodo, err = r.GetTodoByID(nTodo.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(getTodo.TagIDs), 0)
+
+	err = r.DeleteTagByID(work.ID, other.ID)
+	assert.ErrorIs(t, err, ErrDeleteFailed)
+
+	getUntagged, err := r.GetTodoByID(untagged.ID)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, getUntagged, untagged)
+}
+
+func TestProjects(t *testing.T) { eachBackend(t, testProjects) }
+
+func testProjects(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+	other, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+
+	work, err := r.CreateProject(doit.Project{UserID: user.ID, Name: "work"})
+	assert.NilError(t, err)
+	home, err := r.CreateProject(doit.Project{UserID: user.ID, Name: "home"})
+	assert.NilError(t, err)
+	notMine, err := r.CreateProject(doit.Project{UserID: other.ID, Name: "work"})
+	assert.NilError(t, err)
+
+	projects, err := r.AllProjects(user.ID)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, projects, []doit.Project{*home, *work})
+
+	create := func(projectID int64) *doit.Todo {
+		todo := newTodo()
+		todo.UserID = user.ID
+		todo.ProjectID = projectID
+		nTodo, err := r.CreateTodo(todo)
+		assert.NilError(t, err)
+		return nTodo
+	}
+	inWork := create(work.ID)
+	inHome := create(home.ID)
+	inbox := create(0)
+
+	todo := newTodo()
+	todo.UserID = user.ID
+	todo.ProjectID = notMine.ID
+	_, err = r.CreateTodo(todo)
+	assert.ErrorIs(t, err, ErrInvalidProject)
+
+	ids := func(f TodoFilter) []int64 {
+		todos, _, err := r.FilterTodos(user.ID, f)
+		assert.NilError(t, err)
+		var ids []int64
+		for i := range todos {
+			ids = append(ids, todos[i].ID)
+		}
+		return ids
+	}
+	assert.DeepEqual(t, ids(TodoFilter{ProjectIDs: []int64{work.ID}}), []int64{inWork.ID})
+	assert.DeepEqual(t, ids(TodoFilter{ProjectIDs: []int64{home.ID}, Inbox: true}), []int64{inHome.ID, inbox.ID})
+
+	// Todos of archived projects are hidden
+	work.Archived = true
+	_, err = r.UpdateProject(work.ID, user.ID, *work)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, ids(TodoFilter{}), []int64{inHome.ID, inbox.ID})
+	assert.DeepEqual(t, ids(TodoFilter{IncludeArchived: true}), []int64{inWork.ID, inHome.ID, inbox.ID})
+
+	// Move a todo between projects
+	inWork.ProjectID = home.ID
+	moved, err := r.UpdateTodo(inWork.ID, *inWork, user.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, moved.ProjectID, home.ID)
+
+	// Todos go to the inbox
+	err = r.DeleteProjectByID(home.ID, other.ID)
+	assert.ErrorIs(t, err, ErrDeleteFailed)
+	err = r.DeleteProjectByID(home.ID, user.ID)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, ids(TodoFilter{Inbox: true}), []int64{inWork.ID, inHome.ID, inbox.ID})
+}
+
+func TestShares(t *testing.T) { eachBackend(t, testShares) }
+
+func testShares(t *testing.T, r Repository) {
+	owner, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+	other, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+
+	project, err := r.CreateProject(doit.Project{UserID: owner.ID, Name: "work"})
+	assert.NilError(t, err)
+	shared, err := createAndInsertTodo(r, owner.ID)
+	assert.NilError(t, err)
+	inProject := newTodo()
+	inProject.UserID = owner.ID
+	inProject.ProjectID = project.ID
+	nInProject, err := r.CreateTodo(inProject)
+	assert.NilError(t, err)
+	_, err = createAndInsertTodo(r, owner.ID)
+	assert.NilError(t, err)
+
+	ids := func() []int64 {
+		todos, _, err := r.FilterTodos(other.ID, TodoFilter{})
+		assert.NilError(t, err)
+		var ids []int64
+		for i := range todos {
+			ids = append(ids, todos[i].ID)
+		}
+		return ids
+	}
+	assert.Equal(t, len(ids()), 0)
+
+	err = r.ShareTodo(shared.ID, other.ID, "admin")
+	assert.ErrorIs(t, err, ErrInvalidPermission)
+	err = r.ShareTodo(shared.ID, other.ID, doit.PermissionRead)
+	assert.NilError(t, err)
+	err = r.ShareProject(project.ID, other.ID, doit.PermissionEdit)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, ids(), []int64{shared.ID, nInProject.ID})
+
+	projects, err := r.AllProjects(other.ID)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, projects, []doit.Project{*project})
+
+	p, err := r.TodoSharePermission(shared.ID, other.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, p, doit.PermissionRead)
+	p, err = r.TodoSharePermission(nInProject.ID, other.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, p, doit.PermissionEdit)
+	p, err = r.TodoSharePermission(shared.ID, owner.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, p, "")
+
+	// Sharing again changes the permission
+	err = r.ShareTodo(shared.ID, other.ID, doit.PermissionEdit)
+	assert.NilError(t, err)
+	shares, err := r.TodoShares(shared.ID)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, shares, []doit.Share{{UserID: other.ID, Username: other.Username, Permission: doit.PermissionEdit}})
 
-	err = cleanup()
+	err = r.UnshareTodo(shared.ID, other.ID)
 	assert.NilError(t, err)
+	err = r.UnshareTodo(shared.ID, other.ID)
+	assert.ErrorIs(t, err, ErrDeleteFailed)
+	err = r.UnshareProject(project.ID, other.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(ids()), 0)
 }
 
-func TestInsertTodoStates(t *testing.T) {
-	err := setup()
+func TestAssignedTodos(t *testing.T) { eachBackend(t, testAssignedTodos) }
+
+func testAssignedTodos(t *testing.T, r Repository) {
+	owner, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+	assignee, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+
+	todo := newTodo()
+	todo.UserID = owner.ID
+	todo.AssigneeID = assignee.ID
+	assigned, err := r.CreateTodo(todo)
+	assert.NilError(t, err)
+	assert.Equal(t, assigned.AssigneeID, assignee.ID)
+	_, err = createAndInsertTodo(r, owner.ID)
+	assert.NilError(t, err)
+
+	todos, _, err := r.FilterTodos(owner.ID, TodoFilter{AssignedToMe: true})
+	assert.NilError(t, err)
+	assert.Equal(t, len(todos), 0)
+
+	// The assignee sees the todo, even if it's not shared with him
+	todos, _, err = r.FilterTodos(assignee.ID, TodoFilter{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(todos), 1)
+	todos, _, err = r.FilterTodos(assignee.ID, TodoFilter{AssignedToMe: true})
+	assert.NilError(t, err)
+	assert.Equal(t, len(todos), 1)
+	assert.Equal(t, todos[0].ID, assigned.ID)
+
+	// The todo is unassigned when the assignee is deleted
+	err = r.DeleteUserByID(assignee.ID)
+	assert.NilError(t, err)
+	nTodo, err := r.GetTodoByID(assigned.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, nTodo.AssigneeID, int64(0))
+}
+
+func TestComments(t *testing.T) { eachBackend(t, testComments) }
+
+func testComments(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+	todo, err := createAndInsertTodo(r, user.ID)
+	assert.NilError(t, err)
+	other, err := createAndInsertTodo(r, user.ID)
+	assert.NilError(t, err)
+
+	now := time.Now().Round(time.Second)
+	first, err := r.CreateComment(doit.Comment{TodoID: todo.ID, UserID: user.ID, Body: "first", Created: now})
+	assert.NilError(t, err)
+	assert.Equal(t, first.Username, user.Username)
+	assert.Assert(t, first.Edited.IsZero())
+	second, err := r.CreateComment(doit.Comment{TodoID: todo.ID, UserID: user.ID, Body: "second", Created: now.Add(time.Minute)})
+	assert.NilError(t, err)
+
+	comments, err := r.AllComments(todo.ID)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, comments, []doit.Comment{*first, *second})
+
+	_, err = r.GetCommentByID(first.ID, other.ID)
+	assert.ErrorIs(t, err, ErrNotExists)
+
+	first.Body = "changed"
+	first.Edited = now.Add(time.Hour)
+	updated, err := r.UpdateComment(first.ID, todo.ID, *first)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, updated, first)
+	_, err = r.UpdateComment(first.ID, other.ID, *first)
+	assert.ErrorIs(t, err, ErrUpdateFailed)
+
+	err = r.DeleteCommentByID(second.ID, other.ID)
+	assert.ErrorIs(t, err, ErrDeleteFailed)
+	err = r.DeleteCommentByID(second.ID, todo.ID)
+	assert.NilError(t, err)
+
+	// Comments are deleted with the todo
+	err = r.DeleteTodoByID(todo.ID, user.ID)
+	assert.NilError(t, err)
+	_, err = r.GetCommentByID(first.ID, todo.ID)
+	assert.ErrorIs(t, err, ErrNotExists)
+}
+
+func TestTodoEvents(t *testing.T) { eachBackend(t, testTodoEvents) }
+
+func testTodoEvents(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+	todo, err := createAndInsertTodo(r, user.ID)
+	assert.NilError(t, err)
+
+	now := time.Now().Round(time.Second)
+	created, err := r.CreateTodoEvent(doit.TodoEvent{
+		TodoID:  todo.ID,
+		UserID:  user.ID,
+		Action:  doit.TodoEventCreate,
+		Time:    now,
+		Changes: []doit.TodoChange{{Field: "Title", New: todo.Title}},
+		Todo:    *todo,
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, created.Username, user.Username)
+	assert.Equal(t, created.Todo.Title, todo.Title)
+	assert.DeepEqual(t, created.Changes, []doit.TodoChange{{Field: "Title", New: todo.Title}})
+
+	_, err = r.CreateTodoEvent(doit.TodoEvent{TodoID: todo.ID, UserID: user.ID, Action: doit.TodoEventDelete, Time: now.Add(time.Minute), Todo: *todo})
 	assert.NilError(t, err)
 
-	err = cleanup()
+	// The history is kept after the todo and the user are deleted
+	err = r.DeleteTodoByID(todo.ID, user.ID)
+	assert.NilError(t, err)
+	err = r.DeleteUserByID(user.ID)
+	assert.NilError(t, err)
+
+	events, err := r.TodoEvents(todo.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(events), 2)
+	assert.Equal(t, events[0].Action, doit.TodoEventCreate)
+	assert.Equal(t, events[1].Action, doit.TodoEventDelete)
+	assert.Equal(t, events[1].UserID, int64(0))
+
+	_, err = r.GetTodoEventByID(created.ID, todo.ID+1)
+	assert.ErrorIs(t, err, ErrNotExists)
+}
+
+func TestAuditEvents(t *testing.T) { eachBackend(t, testAuditEvents) }
+
+func testAuditEvents(t *testing.T, r Repository) {
+	now := time.Now().Round(time.Second)
+	add := func(eventType string, actorID int64, targetID int64, at time.Time) *doit.AuditEvent {
+		e, err := r.CreateAuditEvent(doit.AuditEvent{Type: eventType, ActorID: actorID, TargetID: targetID, IP: "127.0.0.1", Time: at})
+		assert.NilError(t, err)
+		return e
+	}
+	failed := add(doit.AuditLoginFailed, 0, 1, now.Add(-2*time.Hour))
+	login := add(doit.AuditLogin, 1, 1, now.Add(-time.Hour))
+	grant := add(doit.AuditAdminGrant, 1, 2, now)
+
+	ids := func(f AuditFilter) []int64 {
+		events, err := r.AuditEvents(f)
+		assert.NilError(t, err)
+		var ids []int64
+		for i := range events {
+			ids = append(ids, events[i].ID)
+		}
+		return ids
+	}
+	assert.DeepEqual(t, ids(AuditFilter{}), []int64{grant.ID, login.ID, failed.ID})
+	assert.DeepEqual(t, ids(AuditFilter{UserID: 2}), []int64{grant.ID})
+	assert.DeepEqual(t, ids(AuditFilter{Types: []string{doit.AuditLogin, doit.AuditLoginFailed}}), []int64{login.ID, failed.ID})
+	assert.DeepEqual(t, ids(AuditFilter{After: now.Add(-time.Hour), Before: now}), []int64{login.ID})
+	assert.DeepEqual(t, ids(AuditFilter{Offset: 1}), []int64{login.ID, failed.ID})
+	assert.DeepEqual(t, ids(AuditFilter{Limit: 1, Offset: 1}), []int64{login.ID})
+
+	events, err := r.AuditEvents(AuditFilter{Limit: 1})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, events, []doit.AuditEvent{*grant})
+}
+
+func TestTrash(t *testing.T) { eachBackend(t, testTrash) }
+
+func testTrash(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+	other, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+	old, err := createAndInsertTodo(r, user.ID)
+	assert.NilError(t, err)
+	recent, err := createAndInsertTodo(r, user.ID)
+	assert.NilError(t, err)
+	kept, err := createAndInsertTodo(r, user.ID)
+	assert.NilError(t, err)
+
+	now := time.Now().Round(time.Second)
+	err = r.TrashTodo(old.ID, other.ID, now)
+	assert.ErrorIs(t, err, ErrDeleteFailed)
+	err = r.TrashTodo(old.ID, user.ID, now.Add(-48*time.Hour))
+	assert.NilError(t, err)
+	err = r.TrashTodo(old.ID, user.ID, now)
+	assert.ErrorIs(t, err, ErrDeleteFailed)
+	err = r.TrashTodo(recent.ID, user.ID, now)
+	assert.NilError(t, err)
+
+	// Todos in the trash are not listed
+	todos, n, err := r.FilterTodos(user.ID, TodoFilter{})
+	assert.NilError(t, err)
+	assert.Equal(t, n, int64(1))
+	assert.Equal(t, todos[0].ID, kept.ID)
+
+	trashed, err := r.TrashedTodos(user.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(trashed), 2)
+	assert.Equal(t, trashed[0].ID, recent.ID)
+	assert.Equal(t, trashed[0].DeletedAt, now)
+	assert.Equal(t, trashed[1].ID, old.ID)
+
+	err = r.RestoreTodo(recent.ID, user.ID)
+	assert.NilError(t, err)
+	err = r.RestoreTodo(recent.ID, user.ID)
+	assert.ErrorIs(t, err, ErrUpdateFailed)
+	restored, err := r.GetTodoByID(recent.ID)
+	assert.NilError(t, err)
+	assert.Assert(t, restored.DeletedAt.IsZero())
+
+	n, err = r.PurgeTrash(now.Add(-24 * time.Hour))
+	assert.NilError(t, err)
+	assert.Equal(t, n, int64(1))
+	_, err = r.GetTodoByID(old.ID)
+	assert.ErrorIs(t, err, ErrNotExists)
+}
+
+func TestCalDAVObjects(t *testing.T) { eachBackend(t, testCalDAVObjects) }
+
+func testCalDAVObjects(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+	a, err := createAndInsertTodo(r, user.ID)
+	assert.NilError(t, err)
+	b, err := createAndInsertTodo(r, user.ID)
+	assert.NilError(t, err)
+
+	err = r.SetCalDAVObject(doit.CalDAVObject{TodoID: a.ID, UID: "first", Name: "first.ics"})
+	assert.NilError(t, err)
+	err = r.SetCalDAVObject(doit.CalDAVObject{TodoID: a.ID, UID: "abc", Name: "abc.ics"})
+	assert.NilError(t, err)
+
+	objects, err := r.CalDAVObjects([]int64{a.ID, b.ID})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, objects, []doit.CalDAVObject{{TodoID: a.ID, UID: "abc", Name: "abc.ics"}})
+
+	objects, err = r.CalDAVObjectsByName("abc.ics")
+	assert.NilError(t, err)
+	assert.Equal(t, len(objects), 1)
+	objects, err = r.CalDAVObjectsByName("first.ics")
+	assert.NilError(t, err)
+	assert.Equal(t, len(objects), 0)
+
+	// Objects are deleted with their todo
+	err = r.DeleteTodoByID(a.ID, user.ID)
+	assert.NilError(t, err)
+	objects, err = r.CalDAVObjectsByName("abc.ics")
+	assert.NilError(t, err)
+	assert.Equal(t, len(objects), 0)
+}
+
+func TestReminders(t *testing.T) { eachBackend(t, testReminders) }
+
+func testReminders(t *testing.T, r Repository) {
+	user, err := createAndInsertUser(r)
+	assert.NilError(t, err)
+
+	now := time.Now().Round(time.Second)
+	soon := newTodo()
+	soon.UserID = user.ID
+	soon.Expiration.Date = now.Add(time.Hour)
+	a, err := r.CreateTodo(soon)
+	assert.NilError(t, err)
+	done := soon
+	done.StateID = doit.StateDone.ID
+	_, err = r.CreateTodo(done)
+	assert.NilError(t, err)
+	later := soon
+	later.Expiration.Date = now.Add(48 * time.Hour)
+	_, err = r.CreateTodo(later)
+	assert.NilError(t, err)
+	never := soon
+	never.Expiration.DoesExpire = false
+	_, err = r.CreateTodo(never)
+	assert.NilError(t, err)
+	trashed, err := r.CreateTodo(soon)
+	assert.NilError(t, err)
+	err = r.TrashTodo(trashed.ID, user.ID, now)
+	assert.NilError(t, err)
+
+	todos, err := r.ExpiringTodos(now, now.Add(24*time.Hour))
+	assert.NilError(t, err)
+	assert.Equal(t, len(todos), 1)
+	assert.Equal(t, todos[0].ID, a.ID)
+
+	reminder := doit.Reminder{TodoID: a.ID, Before: time.Hour, Due: a.Expiration.Date, SentAt: now}
+	ok, err := r.ClaimReminder(reminder)
+	assert.NilError(t, err)
+	assert.Assert(t, ok)
+	ok, err = r.ClaimReminder(reminder)
+	assert.NilError(t, err)
+	assert.Assert(t, !ok)
+	// A new due date needs a new reminder
+	reminder.Due = later.Expiration.Date
+	ok, err = r.ClaimReminder(reminder)
+	assert.NilError(t, err)
+	assert.Assert(t, ok)
+	err = r.DeleteReminder(reminder)
+	assert.NilError(t, err)
+	ok, err = r.ClaimReminder(reminder)
+	assert.NilError(t, err)
+	assert.Assert(t, ok)
+
+	last, err := r.LastDigest(user.ID)
+	assert.NilError(t, err)
+	assert.Assert(t, last.IsZero())
+	ok, err = r.ClaimDigest(user.ID, now.Add(-time.Hour), now.Add(-2*time.Hour))
+	assert.NilError(t, err)
+	assert.Assert(t, ok)
+	// Already sent after since
+	ok, err = r.ClaimDigest(user.ID, now, now.Add(-2*time.Hour))
+	assert.NilError(t, err)
+	assert.Assert(t, !ok)
+	ok, err = r.ClaimDigest(user.ID, now, now)
+	assert.NilError(t, err)
+	assert.Assert(t, ok)
+	last, err = r.LastDigest(user.ID)
+	assert.NilError(t, err)
+	assert.Assert(t, last.Equal(now))
+
+	err = r.SetLastDigest(user.ID, now.Add(-time.Hour))
+	assert.NilError(t, err)
+	last, err = r.LastDigest(user.ID)
+	assert.NilError(t, err)
+	assert.Assert(t, last.Equal(now.Add(-time.Hour)))
+}
+
+func TestWebhooks(t *testing.T) { eachBackend(t, testWebhooks) }
+
+func testWebhooks(t *testing.T, r Repository) {
+	u, err := newUser()
+	assert.NilError(t, err)
+	u.Admin, u.Active = false, true
+	alice, err := r.CreateUser(u)
+	assert.NilError(t, err)
+	u, err = newUser()
+	assert.NilError(t, err)
+	u.Admin, u.Active = true, true
+	bob, err := r.CreateUser(u)
+	assert.NilError(t, err)
+
+	now := time.Now().Round(time.Second)
+	w, err := r.CreateWebhook(doit.Webhook{UserID: alice.ID, URL: "https://example.com/hook", Secret: "s3cret", Events: []string{doit.WebhookNoteCreated, doit.WebhookNoteDeleted}, Active: true, Created: now})
+	assert.NilError(t, err)
+	global, err := r.CreateWebhook(doit.Webhook{UserID: bob.ID, URL: "https://example.com/all", Secret: "s", Events: []string{}, Global: true, Active: true, Created: now})
+	assert.NilError(t, err)
+
+	got, err := r.GetWebhookByID(w.ID)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, got, w)
+
+	active, err := r.ActiveWebhooks(alice.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(active), 2)
+	active, err = r.ActiveWebhooks(0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(active), 1)
+	assert.Equal(t, active[0].ID, global.ID)
+
+	// Global webhooks stop when the owner is not an admin anymore, and all
+	// the webhooks when the owner is deactivated
+	bob.Admin = false
+	_, err = r.UpdateUser(bob.ID, *bob)
+	assert.NilError(t, err)
+	active, err = r.ActiveWebhooks(alice.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(active), 1)
+	assert.Equal(t, active[0].ID, w.ID)
+	active, err = r.ActiveWebhooks(bob.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(active), 1)
+	assert.Equal(t, active[0].ID, global.ID)
+
+	alice.Active = false
+	_, err = r.UpdateUser(alice.ID, *alice)
+	assert.NilError(t, err)
+	active, err = r.ActiveWebhooks(alice.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(active), 0)
+	alice.Active = true
+	_, err = r.UpdateUser(alice.ID, *alice)
+	assert.NilError(t, err)
+	bob.Admin = true
+	_, err = r.UpdateUser(bob.ID, *bob)
+	assert.NilError(t, err)
+
+	// Only the owner can update and delete
+	w.Active = false
+	w.UserID = bob.ID
+	_, err = r.UpdateWebhook(*w)
+	assert.ErrorIs(t, err, ErrUpdateFailed)
+	w.UserID = alice.ID
+	got, err = r.UpdateWebhook(*w)
+	assert.NilError(t, err)
+	assert.Assert(t, !got.Active)
+	active, err = r.ActiveWebhooks(alice.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(active), 1)
+
+	for i := range WEBHOOK_DELIVERIES_KEPT + 5 {
+		_, err = r.CreateWebhookDelivery(doit.WebhookDelivery{WebhookID: w.ID, PayloadID: "p", Event: doit.WebhookNoteCreated, Attempt: i + 1, StatusCode: 500, Error: "500 Internal Server Error", Time: now, Payload: "{}"})
+		assert.NilError(t, err)
+	}
+	deliveries, err := r.WebhookDeliveries(w.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(deliveries), WEBHOOK_DELIVERIES_KEPT)
+	assert.Equal(t, deliveries[0].Attempt, WEBHOOK_DELIVERIES_KEPT+5)
+
+	err = r.DeleteWebhookByID(w.ID, bob.ID)
+	assert.ErrorIs(t, err, ErrDeleteFailed)
+	err = r.DeleteWebhookByID(w.ID, alice.ID)
+	assert.NilError(t, err)
+	_, err = r.GetWebhookByID(w.ID)
+	assert.ErrorIs(t, err, ErrNotExists)
+	deliveries, err = r.WebhookDeliveries(w.ID)
 	assert.NilError(t, err)
+	assert.Equal(t, len(deliveries), 0)
 }
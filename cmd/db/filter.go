@@ -0,0 +1,177 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+type TodoSort struct {
+	// One of the keys of todoSortColumns
+	Field string
+	Desc  bool
+}
+
+// Fields that can be used for sorting and the column they refer to
+var todoSortColumns = map[string]string{
+	"id":         "id",
+	"title":      "title",
+	"state":      "stateID",
+	"priority":   "priorityID",
+	"color":      "colorID",
+	"expiration": "expiration_date",
+}
+
+// Filter used to list the todos of a user. The zero value matches all the
+// todos, ordered by ID.
+type TodoFilter struct {
+	// Empty slices match everything
+	StateIDs    []int64
+	PriorityIDs []int64
+	ColorIDs    []int64
+	// Todos with at least one of the tags
+	TagIDs []int64
+	// Todos in one of the projects, or in the inbox if Inbox is set
+	ProjectIDs []int64
+	Inbox      bool
+	// Only the todos assigned to the user
+	AssignedToMe bool
+	// If false, todos in archived projects are not matched
+	IncludeArchived bool
+	// If not zero, only todos that expire are matched
+	ExpiresBefore time.Time
+	ExpiresAfter  time.Time
+	// Matched against title and description, case insensitive
+	Query string
+	Sort  []TodoSort
+	// 0 means no limit
+	Limit  int
+	Offset int
+}
+
+// Escape the LIKE wildcards in s
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
+
+func inClause(column string, ids []int64, args []any) (string, []any) {
+	p := make([]string, len(ids))
+	for i := range ids {
+		p[i] = "?"
+		args = append(args, ids[i])
+	}
+	return fmt.Sprintf("%s IN (%s)", column, strings.Join(p, ", ")), args
+}
+
+// Return the WHERE clause, with ? placeholders, and its arguments
+func (f *TodoFilter) where(userID int64) (string, []any) {
+	visible, args := visibleTodos(userID)
+	conds := []string{visible}
+
+	var c string
+	if len(f.StateIDs) > 0 {
+		c, args = inClause("stateID", f.StateIDs, args)
+		conds = append(conds, c)
+	}
+	if len(f.PriorityIDs) > 0 {
+		c, args = inClause("priorityID", f.PriorityIDs, args)
+		conds = append(conds, c)
+	}
+	if len(f.ColorIDs) > 0 {
+		c, args = inClause("colorID", f.ColorIDs, args)
+		conds = append(conds, c)
+	}
+	if len(f.TagIDs) > 0 {
+		c, args = inClause("tagID", f.TagIDs, args)
+		conds = append(conds, "id IN (SELECT todoID FROM todo_tags WHERE "+c+")")
+	}
+	if f.AssignedToMe {
+		conds = append(conds, "assigneeID = ?")
+		args = append(args, userID)
+	}
+	if len(f.ProjectIDs) > 0 || f.Inbox {
+		var or []string
+		if len(f.ProjectIDs) > 0 {
+			c, args = inClause("projectID", f.ProjectIDs, args)
+			or = append(or, c)
+		}
+		if f.Inbox {
+			or = append(or, "projectID IS NULL")
+		}
+		conds = append(conds, "("+strings.Join(or, " OR ")+")")
+	}
+	if !f.IncludeArchived {
+		conds = append(conds, "(projectID IS NULL OR projectID NOT IN (SELECT id FROM projects WHERE archived))")
+	}
+
+	if !f.ExpiresBefore.IsZero() {
+		conds = append(conds, "does_expire AND expiration_date < ?")
+		args = append(args, f.ExpiresBefore.Unix())
+	}
+	if !f.ExpiresAfter.IsZero() {
+		conds = append(conds, "does_expire AND expiration_date > ?")
+		args = append(args, f.ExpiresAfter.Unix())
+	}
+
+	if f.Query != "" {
+		conds = append(conds, `(LOWER(title) LIKE LOWER(?) ESCAPE '\' OR LOWER(description) LIKE LOWER(?) ESCAPE '\')`)
+		q := "%" + escapeLike(f.Query) + "%"
+		args = append(args, q, q)
+	}
+
+	return "WHERE " + strings.Join(conds, " AND "), args
+}
+
+// Return the ORDER BY, LIMIT and OFFSET clauses
+func (f *TodoFilter) orderAndLimit() (string, error) {
+	var order []string
+	for _, s := range f.Sort {
+		col, ok := todoSortColumns[s.Field]
+		if !ok {
+			return "", fmt.Errorf("%w: can't sort by %q", ErrInvalidFilter, s.Field)
+		}
+		if s.Desc {
+			col += " DESC"
+		}
+		order = append(order, col)
+	}
+	// Always have a stable order, needed for pagination
+	order = append(order, "id")
+
+	if f.Limit < 0 || f.Offset < 0 {
+		return "", fmt.Errorf("%w: limit and offset can't be negative", ErrInvalidFilter)
+	}
+
+	q := "ORDER BY " + strings.Join(order, ", ")
+	if f.Limit > 0 {
+		q += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+	if f.Offset > 0 {
+		if f.Limit == 0 {
+			// SQLite needs a LIMIT before OFFSET
+			q += fmt.Sprintf(" LIMIT %d", math.MaxInt64)
+		}
+		q += fmt.Sprintf(" OFFSET %d", f.Offset)
+	}
+	return q, nil
+}
+
+// Replace the ? placeholders with the $n ones used by postgres
+func rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
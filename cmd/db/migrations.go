@@ -0,0 +1,209 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+var (
+	ErrSchemaTooNew      = errors.New("database schema is newer than this version of DOIT")
+	ErrNothingToMigrate  = errors.New("no migration to apply")
+	ErrPendingMigrations = errors.New("database has pending migrations, run doit migrate up")
+)
+
+// A single step of the schema. Migrations are numbered by their position in
+// the list of the driver, starting from 1, so they must never be reordered or
+// removed: new changes to the schema always go in a new migration at the end.
+type migration struct {
+	name string
+	up   string
+	down string
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Apply and revert the migrations of a driver. The applied versions are stored
+// in the schema_migrations table.
+type migrator struct {
+	db         *sql.DB
+	migrations []migration
+	// Queries with the placeholders of the driver
+	insertVersion string
+	deleteVersion string
+	// Count the schema_migrations tables, to check if it exists
+	tableExists string
+}
+
+func (m *migrator) init() error {
+	_, err := m.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations(
+    version INTEGER PRIMARY KEY,
+    applied_at BIGINT NOT NULL
+  )`)
+	return err
+}
+
+// Return the version of the database, 0 if nothing was ever applied
+func (m *migrator) version() (int, error) {
+	if err := m.init(); err != nil {
+		return 0, err
+	}
+
+	var v int
+	row := m.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations")
+	if err := row.Scan(&v); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+// Return an error if the database is not at the latest version, without
+// changing it
+func (m *migrator) check() error {
+	var n int
+	if err := m.db.QueryRow(m.tableExists).Scan(&n); err != nil {
+		return err
+	}
+
+	current := 0
+	if n > 0 {
+		row := m.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations")
+		if err := row.Scan(&current); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case current > m.latest():
+		return fmt.Errorf("%w: database is at version %d, latest known is %d", ErrSchemaTooNew, current, m.latest())
+	case current < m.latest():
+		return fmt.Errorf("%w: database is at version %d, latest is %d", ErrPendingMigrations, current, m.latest())
+	}
+	return nil
+}
+
+func (m *migrator) latest() int {
+	return len(m.migrations)
+}
+
+func (m *migrator) status() ([]MigrationStatus, error) {
+	if err := m.init(); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.Query("SELECT version, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var v int
+		var t int64
+		if err := rows.Scan(&v, &t); err != nil {
+			return nil, err
+		}
+		applied[v] = time.Unix(t, 0)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(m.migrations))
+	for i := range m.migrations {
+		t, ok := applied[i+1]
+		status[i] = MigrationStatus{
+			Version:   i + 1,
+			Name:      m.migrations[i].name,
+			Applied:   ok,
+			AppliedAt: t,
+		}
+		delete(applied, i+1)
+	}
+
+	for v, t := range applied {
+		status = append(status, MigrationStatus{
+			Version:   v,
+			Name:      "unknown",
+			Applied:   true,
+			AppliedAt: t,
+		})
+	}
+
+	return status, nil
+}
+
+// Apply all the pending migrations, each one in its own transaction. Return
+// the number of migrations applied.
+func (m *migrator) up() (int, error) {
+	current, err := m.version()
+	if err != nil {
+		return 0, err
+	}
+
+	if current > m.latest() {
+		return 0, fmt.Errorf("%w: database is at version %d, latest known is %d", ErrSchemaTooNew, current, m.latest())
+	}
+
+	for v := current + 1; v <= m.latest(); v++ {
+		mig := m.migrations[v-1]
+		slog.With("version", v, "name", mig.name).Info("Applying migration")
+
+		err := m.inTx(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(mig.up); err != nil {
+				return err
+			}
+			_, err := tx.Exec(m.insertVersion, v, time.Now().Unix())
+			return err
+		})
+		if err != nil {
+			return v - current - 1, fmt.Errorf("applying migration %d (%s): %w", v, mig.name, err)
+		}
+	}
+
+	return m.latest() - current, nil
+}
+
+// Revert the last applied migration. Return the version reverted.
+func (m *migrator) down() (int, error) {
+	current, err := m.version()
+	if err != nil {
+		return 0, err
+	}
+
+	if current == 0 {
+		return 0, ErrNothingToMigrate
+	}
+
+	if current > m.latest() {
+		return 0, fmt.Errorf("%w: database is at version %d, latest known is %d", ErrSchemaTooNew, current, m.latest())
+	}
+
+	mig := m.migrations[current-1]
+	slog.With("version", current, "name", mig.name).Info("Reverting migration")
+
+	err = m.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(mig.down); err != nil {
+			return err
+		}
+		_, err := tx.Exec(m.deleteVersion, current)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("reverting migration %d (%s): %w", current, mig.name, err)
+	}
+
+	return current, nil
+}
+
+func (m *migrator) inTx(f func(tx *sql.Tx) error) error {
+	return inTx(m.db, f)
+}
@@ -0,0 +1,340 @@
+package db
+
+// Schema of the PostgreSQL database. Append new migrations at the end, never modify
+// the ones already released.
+var postgresMigrations = []migration{
+	{
+		name: "initial schema",
+		// IF NOT EXISTS is needed for databases created before migrations were
+		// introduced, as they already contain these tables.
+		up: `
+  CREATE TABLE IF NOT EXISTS users(
+    id BIGSERIAL PRIMARY KEY,
+    username TEXT UNIQUE NOT NULL,
+    email TEXT UNIQUE NOT NULL,
+    name TEXT NOT NULL,
+    surname TEXT NOT NULL,
+    admin BOOLEAN NOT NULL,
+    active BOOLEAN NOT NULL,
+    password TEXT NOT NULL
+  );
+  CREATE TABLE IF NOT EXISTS todo_states(
+    id BIGSERIAL PRIMARY KEY,
+    state TEXT NOT NULL
+  );
+  CREATE TABLE IF NOT EXISTS todo_priority(
+    id BIGSERIAL PRIMARY KEY,
+    priority TEXT NOT NULL
+  );
+  CREATE TABLE IF NOT EXISTS todo_colors(
+    id BIGSERIAL PRIMARY KEY,
+    color TEXT NOT NULL
+  );
+  CREATE TABLE IF NOT EXISTS todos(
+    id BIGSERIAL PRIMARY KEY,
+    title TEXT NOT NULL,
+    description TEXT NOT NULL,
+    stateID BIGINT REFERENCES todo_states(id),
+    priorityID BIGINT REFERENCES todo_priority(id),
+    colorID BIGINT REFERENCES todo_colors(id),
+    does_expire BOOLEAN,
+    expiration_date BIGINT,
+    userID BIGINT REFERENCES users(id)
+  );
+  CREATE TABLE IF NOT EXISTS internals(
+    key TEXT NOT NULL PRIMARY KEY,
+    data BYTEA NOT NULL
+  );
+  `,
+		down: `
+  DROP TABLE IF EXISTS internals;
+  DROP TABLE IF EXISTS todos;
+  DROP TABLE IF EXISTS todo_colors;
+  DROP TABLE IF EXISTS todo_priority;
+  DROP TABLE IF EXISTS todo_states;
+  DROP TABLE IF EXISTS users;
+  `,
+	},
+	{
+		name: "sessions",
+		up: `
+  CREATE TABLE sessions(
+    id BIGSERIAL PRIMARY KEY,
+    token_hash TEXT UNIQUE NOT NULL,
+    userID BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created BIGINT NOT NULL,
+    expire BIGINT NOT NULL,
+    last_seen BIGINT NOT NULL,
+    user_agent TEXT NOT NULL,
+    ip TEXT NOT NULL
+  );
+  CREATE INDEX sessions_expire ON sessions(expire);
+  `,
+		down: `
+  DROP TABLE sessions;
+  `,
+	},
+	{
+		name: "api tokens",
+		up: `
+  CREATE TABLE api_tokens(
+    id BIGSERIAL PRIMARY KEY,
+    userID BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    scopes TEXT NOT NULL,
+    created BIGINT NOT NULL,
+    expire BIGINT NOT NULL,
+    last_used BIGINT NOT NULL
+  );
+  `,
+		down: `
+  DROP TABLE api_tokens;
+  `,
+	},
+	{
+		name: "full-text search",
+		up: `
+  CREATE INDEX todos_fts ON todos USING GIN (to_tsvector('simple', title || ' ' || description));
+  `,
+		down: `
+  DROP INDEX todos_fts;
+  `,
+	},
+	{
+		name: "todo items",
+		up: `
+  CREATE TABLE todo_items(
+    id BIGSERIAL PRIMARY KEY,
+    todoID BIGINT NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+    text TEXT NOT NULL,
+    done BOOLEAN NOT NULL,
+    position BIGINT NOT NULL
+  );
+  CREATE INDEX todo_items_todo ON todo_items(todoID, position);
+  `,
+		down: `
+  DROP TABLE todo_items;
+  `,
+	},
+	{
+		name: "tags",
+		up: `
+  CREATE TABLE tags(
+    id BIGSERIAL PRIMARY KEY,
+    userID BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    color TEXT NOT NULL,
+    UNIQUE(userID, name)
+  );
+  CREATE TABLE todo_tags(
+    todoID BIGINT NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+    tagID BIGINT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY(todoID, tagID)
+  );
+  CREATE INDEX todo_tags_tag ON todo_tags(tagID);
+  `,
+		down: `
+  DROP TABLE todo_tags;
+  DROP TABLE tags;
+  `,
+	},
+	{
+		name: "recurrence",
+		up: `
+  ALTER TABLE todos ADD COLUMN recurrence TEXT NOT NULL DEFAULT '';
+  `,
+		down: `
+  ALTER TABLE todos DROP COLUMN recurrence;
+  `,
+	},
+	{
+		name: "projects",
+		up: `
+  CREATE TABLE projects(
+    id BIGSERIAL PRIMARY KEY,
+    userID BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    archived BOOLEAN NOT NULL
+  );
+  ALTER TABLE todos ADD COLUMN projectID BIGINT REFERENCES projects(id) ON DELETE SET NULL;
+  CREATE INDEX todos_project ON todos(projectID);
+  `,
+		down: `
+  DROP INDEX todos_project;
+  ALTER TABLE todos DROP COLUMN projectID;
+  DROP TABLE projects;
+  `,
+	},
+	{
+		name: "sharing",
+		up: `
+  CREATE TABLE todo_shares(
+    todoID BIGINT NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+    userID BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    permission TEXT NOT NULL,
+    PRIMARY KEY(todoID, userID)
+  );
+  CREATE TABLE project_shares(
+    projectID BIGINT NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    userID BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    permission TEXT NOT NULL,
+    PRIMARY KEY(projectID, userID)
+  );
+  CREATE INDEX todo_shares_user ON todo_shares(userID);
+  CREATE INDEX project_shares_user ON project_shares(userID);
+  `,
+		down: `
+  DROP TABLE project_shares;
+  DROP TABLE todo_shares;
+  `,
+	},
+	{
+		name: "assignees",
+		up: `
+  ALTER TABLE todos ADD COLUMN assigneeID BIGINT REFERENCES users(id) ON DELETE SET NULL;
+  CREATE INDEX todos_assignee ON todos(assigneeID);
+  `,
+		down: `
+  DROP INDEX todos_assignee;
+  ALTER TABLE todos DROP COLUMN assigneeID;
+  `,
+	},
+	{
+		name: "comments",
+		up: `
+  CREATE TABLE comments(
+    id BIGSERIAL PRIMARY KEY,
+    todoID BIGINT NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+    userID BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    created BIGINT NOT NULL,
+    edited BIGINT NOT NULL
+  );
+  CREATE INDEX comments_todo ON comments(todoID);
+  `,
+		down: `
+  DROP TABLE comments;
+  `,
+	},
+	{
+		// The events are kept after the todo is deleted, so todoID is not a
+		// foreign key
+		name: "todo history",
+		up: `
+  CREATE TABLE todo_events(
+    id BIGSERIAL PRIMARY KEY,
+    todoID BIGINT NOT NULL,
+    userID BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    action TEXT NOT NULL,
+    time BIGINT NOT NULL,
+    changes TEXT NOT NULL,
+    todo TEXT NOT NULL
+  );
+  CREATE INDEX todo_events_todo ON todo_events(todoID);
+  `,
+		down: `
+  DROP TABLE todo_events;
+  `,
+	},
+	{
+		// The log must survive the users, so there are no foreign keys
+		name: "audit log",
+		up: `
+  CREATE TABLE audit_log(
+    id BIGSERIAL PRIMARY KEY,
+    type TEXT NOT NULL,
+    actorID BIGINT NOT NULL,
+    actorName TEXT NOT NULL,
+    targetID BIGINT NOT NULL,
+    targetName TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    userAgent TEXT NOT NULL,
+    time BIGINT NOT NULL,
+    details TEXT NOT NULL
+  );
+  CREATE INDEX audit_log_time ON audit_log(time);
+  `,
+		down: `
+  DROP TABLE audit_log;
+  `,
+	},
+	{
+		name: "trash",
+		up: `
+  ALTER TABLE todos ADD COLUMN deleted_at BIGINT;
+  CREATE INDEX todos_deleted_at ON todos(deleted_at);
+  `,
+		down: `
+  DROP INDEX todos_deleted_at;
+  ALTER TABLE todos DROP COLUMN deleted_at;
+  `,
+	},
+	{
+		name: "caldav objects",
+		up: `
+  CREATE TABLE caldav_objects(
+    todoID BIGINT PRIMARY KEY REFERENCES todos(id) ON DELETE CASCADE,
+    uid TEXT NOT NULL,
+    name TEXT NOT NULL
+  );
+  CREATE INDEX caldav_objects_name ON caldav_objects(name);
+  `,
+		down: `
+  DROP TABLE caldav_objects;
+  `,
+	},
+	{
+		name: "reminders",
+		up: `
+  CREATE TABLE reminders(
+    todoID BIGINT NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+    before_due BIGINT NOT NULL,
+    due BIGINT NOT NULL,
+    sent_at BIGINT NOT NULL,
+    PRIMARY KEY (todoID, before_due, due)
+  );
+  CREATE TABLE reminder_digests(
+    userID BIGINT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    sent_at BIGINT NOT NULL
+  );
+  CREATE INDEX todos_expiration_date ON todos(expiration_date);
+  `,
+		down: `
+  DROP INDEX todos_expiration_date;
+  DROP TABLE reminder_digests;
+  DROP TABLE reminders;
+  `,
+	},
+	{
+		name: "webhooks",
+		up: `
+  CREATE TABLE webhooks(
+    id BIGSERIAL PRIMARY KEY,
+    userID BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT NOT NULL,
+    global BOOLEAN NOT NULL,
+    active BOOLEAN NOT NULL,
+    created BIGINT NOT NULL
+  );
+  CREATE TABLE webhook_deliveries(
+    id BIGSERIAL PRIMARY KEY,
+    webhookID BIGINT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    payloadID TEXT NOT NULL,
+    event TEXT NOT NULL,
+    attempt INTEGER NOT NULL,
+    status_code INTEGER NOT NULL,
+    error TEXT NOT NULL,
+    time BIGINT NOT NULL,
+    payload TEXT NOT NULL
+  );
+  CREATE INDEX webhook_deliveries_webhookID ON webhook_deliveries(webhookID);
+  `,
+		down: `
+  DROP TABLE webhook_deliveries;
+  DROP TABLE webhooks;
+  `,
+	},
+}
@@ -0,0 +1,372 @@
+package db
+
+// Schema of the SQLite database. Append new migrations at the end, never modify
+// the ones already released.
+var sqliteMigrations = []migration{
+	{
+		name: "initial schema",
+		// IF NOT EXISTS is needed for databases created before migrations were
+		// introduced, as they already contain these tables.
+		up: `
+  CREATE TABLE IF NOT EXISTS users(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TINYTEXT UNIQUE NOT NULL,
+    email TINYTEXT UNIQUE NOT NULL,
+    name TINYTEXT NOT NULL,
+    surname TINYTEXT NOT NULL,
+    admin BOOL NOT NULL,
+    active BOOL NOT NULL,
+    password TINYTEXT NOT NULL
+  );
+  CREATE TABLE IF NOT EXISTS todo_states(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    state TINYTEXT NOT NULL
+  );
+  CREATE TABLE IF NOT EXISTS todo_priority(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    priority INTEGER NOT NULL
+  );
+  CREATE TABLE IF NOT EXISTS todo_colors(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    color TINYTEXT NOT NULL
+  );
+  CREATE TABLE IF NOT EXISTS todos(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    title TEXT NOT NULL,
+    description TEXT NOT NULL,
+    stateID INTEGER,
+    priorityID INTEGER,
+    colorID INTEGER,
+    does_expire BOOL,
+    expiration_date INTEGER,
+    userID INTEGER,
+    FOREIGN KEY(stateID) REFERENCES todo_states(id),
+    FOREIGN KEY(priorityID) REFERENCES todo_priority(id),
+    FOREIGN KEY(colorID) REFERENCES todo_colors(id),
+    FOREIGN KEY(userID) REFERENCES users(id)
+  );
+  CREATE TABLE IF NOT EXISTS internals(
+    key TEXT NOT NULL UNIQUE PRIMARY KEY,
+    data BLOB NOT NULL
+  );
+  `,
+		down: `
+  DROP TABLE IF EXISTS internals;
+  DROP TABLE IF EXISTS todos;
+  DROP TABLE IF EXISTS todo_colors;
+  DROP TABLE IF EXISTS todo_priority;
+  DROP TABLE IF EXISTS todo_states;
+  DROP TABLE IF EXISTS users;
+  `,
+	},
+	{
+		name: "sessions",
+		up: `
+  CREATE TABLE sessions(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token_hash TEXT UNIQUE NOT NULL,
+    userID INTEGER NOT NULL,
+    created INTEGER NOT NULL,
+    expire INTEGER NOT NULL,
+    last_seen INTEGER NOT NULL,
+    user_agent TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    FOREIGN KEY(userID) REFERENCES users(id) ON DELETE CASCADE
+  );
+  CREATE INDEX sessions_expire ON sessions(expire);
+  `,
+		down: `
+  DROP TABLE sessions;
+  `,
+	},
+	{
+		name: "api tokens",
+		up: `
+  CREATE TABLE api_tokens(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    userID INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    scopes TEXT NOT NULL,
+    created INTEGER NOT NULL,
+    expire INTEGER NOT NULL,
+    last_used INTEGER NOT NULL,
+    FOREIGN KEY(userID) REFERENCES users(id) ON DELETE CASCADE
+  );
+  `,
+		down: `
+  DROP TABLE api_tokens;
+  `,
+	},
+	{
+		name: "full-text search",
+		up: `
+  CREATE VIRTUAL TABLE todos_fts USING fts5(title, description, content='todos', content_rowid='id', tokenize='unicode61');
+  INSERT INTO todos_fts(todos_fts) VALUES('rebuild');
+  CREATE TRIGGER todos_fts_after_insert AFTER INSERT ON todos BEGIN
+    INSERT INTO todos_fts(rowid, title, description) VALUES(new.id, new.title, new.description);
+  END;
+  CREATE TRIGGER todos_fts_after_delete AFTER DELETE ON todos BEGIN
+    INSERT INTO todos_fts(todos_fts, rowid, title, description) VALUES('delete', old.id, old.title, old.description);
+  END;
+  CREATE TRIGGER todos_fts_after_update AFTER UPDATE ON todos BEGIN
+    INSERT INTO todos_fts(todos_fts, rowid, title, description) VALUES('delete', old.id, old.title, old.description);
+    INSERT INTO todos_fts(rowid, title, description) VALUES(new.id, new.title, new.description);
+  END;
+  `,
+		down: `
+  DROP TRIGGER IF EXISTS todos_fts_after_insert;
+  DROP TRIGGER IF EXISTS todos_fts_after_delete;
+  DROP TRIGGER IF EXISTS todos_fts_after_update;
+  DROP TABLE todos_fts;
+  `,
+	},
+	{
+		name: "todo items",
+		up: `
+  CREATE TABLE todo_items(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    todoID INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    done BOOL NOT NULL,
+    position INTEGER NOT NULL,
+    FOREIGN KEY(todoID) REFERENCES todos(id) ON DELETE CASCADE
+  );
+  CREATE INDEX todo_items_todo ON todo_items(todoID, position);
+  `,
+		down: `
+  DROP TABLE todo_items;
+  `,
+	},
+	{
+		name: "tags",
+		up: `
+  CREATE TABLE tags(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    userID INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    color TEXT NOT NULL,
+    UNIQUE(userID, name),
+    FOREIGN KEY(userID) REFERENCES users(id) ON DELETE CASCADE
+  );
+  CREATE TABLE todo_tags(
+    todoID INTEGER NOT NULL,
+    tagID INTEGER NOT NULL,
+    PRIMARY KEY(todoID, tagID),
+    FOREIGN KEY(todoID) REFERENCES todos(id) ON DELETE CASCADE,
+    FOREIGN KEY(tagID) REFERENCES tags(id) ON DELETE CASCADE
+  );
+  CREATE INDEX todo_tags_tag ON todo_tags(tagID);
+  `,
+		down: `
+  DROP TABLE todo_tags;
+  DROP TABLE tags;
+  `,
+	},
+	{
+		name: "recurrence",
+		up: `
+  ALTER TABLE todos ADD COLUMN recurrence TEXT NOT NULL DEFAULT '';
+  `,
+		down: `
+  ALTER TABLE todos DROP COLUMN recurrence;
+  `,
+	},
+	{
+		name: "projects",
+		up: `
+  CREATE TABLE projects(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    userID INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    archived BOOL NOT NULL,
+    FOREIGN KEY(userID) REFERENCES users(id) ON DELETE CASCADE
+  );
+  ALTER TABLE todos ADD COLUMN projectID INTEGER REFERENCES projects(id) ON DELETE SET NULL;
+  CREATE INDEX todos_project ON todos(projectID);
+  `,
+		down: `
+  DROP INDEX todos_project;
+  ALTER TABLE todos DROP COLUMN projectID;
+  DROP TABLE projects;
+  `,
+	},
+	{
+		name: "sharing",
+		up: `
+  CREATE TABLE todo_shares(
+    todoID INTEGER NOT NULL,
+    userID INTEGER NOT NULL,
+    permission TEXT NOT NULL,
+    PRIMARY KEY(todoID, userID),
+    FOREIGN KEY(todoID) REFERENCES todos(id) ON DELETE CASCADE,
+    FOREIGN KEY(userID) REFERENCES users(id) ON DELETE CASCADE
+  );
+  CREATE TABLE project_shares(
+    projectID INTEGER NOT NULL,
+    userID INTEGER NOT NULL,
+    permission TEXT NOT NULL,
+    PRIMARY KEY(projectID, userID),
+    FOREIGN KEY(projectID) REFERENCES projects(id) ON DELETE CASCADE,
+    FOREIGN KEY(userID) REFERENCES users(id) ON DELETE CASCADE
+  );
+  CREATE INDEX todo_shares_user ON todo_shares(userID);
+  CREATE INDEX project_shares_user ON project_shares(userID);
+  `,
+		down: `
+  DROP TABLE project_shares;
+  DROP TABLE todo_shares;
+  `,
+	},
+	{
+		name: "assignees",
+		up: `
+  ALTER TABLE todos ADD COLUMN assigneeID INTEGER REFERENCES users(id) ON DELETE SET NULL;
+  CREATE INDEX todos_assignee ON todos(assigneeID);
+  `,
+		down: `
+  DROP INDEX todos_assignee;
+  ALTER TABLE todos DROP COLUMN assigneeID;
+  `,
+	},
+	{
+		name: "comments",
+		up: `
+  CREATE TABLE comments(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    todoID INTEGER NOT NULL,
+    userID INTEGER NOT NULL,
+    body TEXT NOT NULL,
+    created INTEGER NOT NULL,
+    edited INTEGER NOT NULL,
+    FOREIGN KEY(todoID) REFERENCES todos(id) ON DELETE CASCADE,
+    FOREIGN KEY(userID) REFERENCES users(id) ON DELETE CASCADE
+  );
+  CREATE INDEX comments_todo ON comments(todoID);
+  `,
+		down: `
+  DROP TABLE comments;
+  `,
+	},
+	{
+		// The events are kept after the todo is deleted, so todoID is not a
+		// foreign key
+		name: "todo history",
+		up: `
+  CREATE TABLE todo_events(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    todoID INTEGER NOT NULL,
+    userID INTEGER,
+    action TEXT NOT NULL,
+    time INTEGER NOT NULL,
+    changes TEXT NOT NULL,
+    todo TEXT NOT NULL,
+    FOREIGN KEY(userID) REFERENCES users(id) ON DELETE SET NULL
+  );
+  CREATE INDEX todo_events_todo ON todo_events(todoID);
+  `,
+		down: `
+  DROP TABLE todo_events;
+  `,
+	},
+	{
+		// The log must survive the users, so there are no foreign keys
+		name: "audit log",
+		up: `
+  CREATE TABLE audit_log(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    type TEXT NOT NULL,
+    actorID INTEGER NOT NULL,
+    actorName TEXT NOT NULL,
+    targetID INTEGER NOT NULL,
+    targetName TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    userAgent TEXT NOT NULL,
+    time INTEGER NOT NULL,
+    details TEXT NOT NULL
+  );
+  CREATE INDEX audit_log_time ON audit_log(time);
+  `,
+		down: `
+  DROP TABLE audit_log;
+  `,
+	},
+	{
+		name: "trash",
+		up: `
+  ALTER TABLE todos ADD COLUMN deleted_at INTEGER;
+  CREATE INDEX todos_deleted_at ON todos(deleted_at);
+  `,
+		down: `
+  DROP INDEX todos_deleted_at;
+  ALTER TABLE todos DROP COLUMN deleted_at;
+  `,
+	},
+	{
+		name: "caldav objects",
+		up: `
+  CREATE TABLE caldav_objects(
+    todoID INTEGER PRIMARY KEY REFERENCES todos(id) ON DELETE CASCADE,
+    uid TEXT NOT NULL,
+    name TEXT NOT NULL
+  );
+  CREATE INDEX caldav_objects_name ON caldav_objects(name);
+  `,
+		down: `
+  DROP TABLE caldav_objects;
+  `,
+	},
+	{
+		name: "reminders",
+		up: `
+  CREATE TABLE reminders(
+    todoID INTEGER NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+    before_due INTEGER NOT NULL,
+    due INTEGER NOT NULL,
+    sent_at INTEGER NOT NULL,
+    PRIMARY KEY (todoID, before_due, due)
+  );
+  CREATE TABLE reminder_digests(
+    userID INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    sent_at INTEGER NOT NULL
+  );
+  CREATE INDEX todos_expiration_date ON todos(expiration_date);
+  `,
+		down: `
+  DROP INDEX todos_expiration_date;
+  DROP TABLE reminder_digests;
+  DROP TABLE reminders;
+  `,
+	},
+	{
+		name: "webhooks",
+		up: `
+  CREATE TABLE webhooks(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    userID INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT NOT NULL,
+    global BOOL NOT NULL,
+    active BOOL NOT NULL,
+    created INTEGER NOT NULL
+  );
+  CREATE TABLE webhook_deliveries(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhookID INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    payloadID TEXT NOT NULL,
+    event TEXT NOT NULL,
+    attempt INTEGER NOT NULL,
+    status_code INTEGER NOT NULL,
+    error TEXT NOT NULL,
+    time INTEGER NOT NULL,
+    payload TEXT NOT NULL
+  );
+  CREATE INDEX webhook_deliveries_webhookID ON webhook_deliveries(webhookID);
+  `,
+		down: `
+  DROP TABLE webhook_deliveries;
+  DROP TABLE webhooks;
+  `,
+	},
+}
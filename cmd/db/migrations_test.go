@@ -0,0 +1,111 @@
+package db
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/samuelemusiani/doit/cmd/config"
+	"gotest.tools/v3/assert"
+)
+
+func setupFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "doit.db")
+	conf := config.GetConfig()
+	conf.Databse.Path = path
+
+	r, err := Init()
+	assert.NilError(t, err)
+	assert.NilError(t, r.Close())
+	return path
+}
+
+func TestMigrationsStatus(t *testing.T) {
+	setupFile(t)
+
+	status, err := MigrationsStatus()
+	assert.NilError(t, err)
+	assert.Equal(t, len(status), len(sqliteMigrations))
+	for i := range status {
+		assert.Equal(t, status[i].Version, i+1)
+		assert.Check(t, status[i].Applied)
+	}
+}
+
+func TestMigrateDownUp(t *testing.T) {
+	setupFile(t)
+
+	v, err := MigrateDown()
+	assert.NilError(t, err)
+	assert.Equal(t, v, len(sqliteMigrations))
+
+	status, err := MigrationsStatus()
+	assert.NilError(t, err)
+	assert.Check(t, !status[v-1].Applied)
+
+	n, err := MigrateUp()
+	assert.NilError(t, err)
+	assert.Equal(t, n, 1)
+
+	n, err = MigrateUp()
+	assert.NilError(t, err)
+	assert.Equal(t, n, 0)
+}
+
+// Every migration can be reverted and applied again
+func TestMigrateAllDownUp(t *testing.T) {
+	setupFile(t)
+
+	for i := len(sqliteMigrations); i > 0; i-- {
+		v, err := MigrateDown()
+		assert.NilError(t, err)
+		assert.Equal(t, v, i)
+	}
+
+	_, err := MigrateDown()
+	assert.ErrorIs(t, err, ErrNothingToMigrate)
+
+	n, err := MigrateUp()
+	assert.NilError(t, err)
+	assert.Equal(t, n, len(sqliteMigrations))
+}
+
+func TestOpen(t *testing.T) {
+	conf := config.GetConfig()
+	conf.Databse.Path = filepath.Join(t.TempDir(), "doit.db")
+
+	// Nothing is created
+	_, err := Open()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(conf.Databse.Path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	setupFile(t)
+	r, err := Open()
+	assert.NilError(t, err)
+	assert.NilError(t, r.Close())
+
+	_, err = MigrateDown()
+	assert.NilError(t, err)
+	_, err = Open()
+	assert.ErrorIs(t, err, ErrPendingMigrations)
+	status, err := MigrationsStatus()
+	assert.NilError(t, err)
+	assert.Check(t, !status[len(status)-1].Applied)
+}
+
+func TestMigrateSchemaTooNew(t *testing.T) {
+	path := setupFile(t)
+
+	rawDB, err := sql.Open("sqlite3", path)
+	assert.NilError(t, err)
+	_, err = rawDB.Exec("INSERT INTO schema_migrations(version, applied_at) values(?, 0)", len(sqliteMigrations)+1)
+	assert.NilError(t, err)
+	assert.NilError(t, rawDB.Close())
+
+	_, err = Init()
+	assert.ErrorIs(t, err, ErrSchemaTooNew)
+	_, err = Open()
+	assert.ErrorIs(t, err, ErrSchemaTooNew)
+}
@@ -0,0 +1,432 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+
+	"github.com/lib/pq"
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Code returned by postgres when a UNIQUE constraint is violated
+const pqUniqueViolation = "23505"
+
+type PostgresRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresRepository(db *sql.DB) *PostgresRepository {
+	return &PostgresRepository{
+		db: db,
+	}
+}
+
+func (r *PostgresRepository) migrator() *migrator {
+	return &migrator{
+		db:            r.db,
+		migrations:    postgresMigrations,
+		insertVersion: "INSERT INTO schema_migrations(version, applied_at) values($1, $2)",
+		deleteVersion: "DELETE FROM schema_migrations WHERE version = $1",
+		tableExists:   "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'schema_migrations'",
+	}
+}
+
+func (r *PostgresRepository) Close() error {
+	slog.Debug("Closing DB connection")
+	return r.db.Close()
+}
+
+// Map the postgres errors to the errors of this package
+func pqError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		if pqErr.Code == pqUniqueViolation {
+			return ErrDuplicate
+		}
+	}
+	return err
+}
+
+func (r *PostgresRepository) CreateTodo(todo doit.Todo) (*doit.Todo, error) {
+	err := inTx(r.db, func(tx *sql.Tx) error {
+		return postgresInsertTodo(tx, &todo)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &todo, nil
+}
+
+func (r *PostgresRepository) CreateTodos(todos []doit.Todo) ([]doit.Todo, error) {
+	created := make([]doit.Todo, len(todos))
+	copy(created, todos)
+	err := inTx(r.db, func(tx *sql.Tx) error {
+		for i := range created {
+			if err := postgresInsertTodo(tx, &created[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// Insert the todo with its tags and set its ID
+func postgresInsertTodo(tx *sql.Tx, todo *doit.Todo) error {
+	if err := checkTodoProject(tx, rebind, todo.ProjectID, todo.UserID); err != nil {
+		return err
+	}
+
+	row := tx.QueryRow("INSERT INTO todos(title, description, stateID, priorityID, colorID, does_expire, expiration_date, userID, recurrence, projectID, assigneeID) values($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id", todo.Title, todo.Description, todo.StateID, todo.PriorityID, todo.ColorID, todo.Expiration.DoesExpire, todo.Expiration.Date.Unix(), todo.UserID, todo.Recurrence, nullID(todo.ProjectID), nullID(todo.AssigneeID))
+
+	if err := row.Scan(&todo.ID); err != nil {
+		return pqError(err)
+	}
+
+	return setTodoTags(tx, rebind, todo.ID, todo.UserID, todo.TagIDs)
+}
+
+func (r *PostgresRepository) CreateUser(user doit.User) (*doit.User, error) {
+	row := r.db.QueryRow("INSERT INTO users(username, email, name, surname, admin, active, password) values($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		user.Username, user.Email, user.Name, user.Surname,
+		user.Admin, user.Active, user.Password)
+
+	err := row.Scan(&user.ID)
+	if err != nil {
+		return nil, pqError(err)
+	}
+
+	return &user, nil
+}
+
+func (r *PostgresRepository) AllTodos(userId int64) ([]doit.Todo, error) {
+	rows, err := r.db.Query("SELECT "+postgresTodoColumns+" FROM todos WHERE userID = $1 ORDER BY id", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.Todo
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *todo)
+	}
+
+	return all, nil
+}
+
+func (r *PostgresRepository) AllUsers() ([]doit.User, error) {
+	rows, err := r.db.Query("SELECT * FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.User
+	for rows.Next() {
+		var user doit.User
+		err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Name, &user.Surname, &user.Admin, &user.Active, &user.Password)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, user)
+	}
+
+	return all, nil
+}
+
+func (r *PostgresRepository) GetTodoByID(id int64) (*doit.Todo, error) {
+	row := r.db.QueryRow("SELECT "+postgresTodoColumns+" FROM todos WHERE id = $1", id)
+
+	return scanTodo(row)
+}
+
+func (r *PostgresRepository) GetUserByID(id int64) (*doit.User, error) {
+	row := r.db.QueryRow("SELECT * FROM users WHERE id = $1", id)
+	return scanUser(row)
+}
+
+func (r *PostgresRepository) GetUserByUsername(username string) (*doit.User, error) {
+	row := r.db.QueryRow("SELECT * FROM users WHERE username = $1", username)
+	return scanUser(row)
+}
+
+func (r *PostgresRepository) GetUserByEmail(email string) (*doit.User, error) {
+	row := r.db.QueryRow("SELECT * FROM users WHERE email = $1", email)
+	return scanUser(row)
+}
+
+// Delete todo with id todoID only if userID match
+func (r *PostgresRepository) DeleteTodoByID(todoID int64, userID int64) error {
+	res, err := r.db.Exec("DELETE FROM todos WHERE id = $1 AND userID = $2", todoID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
+
+func (r *PostgresRepository) DeleteTodosByUserID(userID int64) error {
+	res, err := r.db.Exec("DELETE FROM todos WHERE userID = $1", userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
+
+func (r *PostgresRepository) DeleteUserByID(id int64) error {
+	res, err := r.db.Exec("DELETE FROM users WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
+
+func (r *PostgresRepository) UpdateTodo(id int64, todo doit.Todo, userID int64) (*doit.Todo, error) {
+	if id == 0 {
+		return nil, errors.New("invalid updated ID")
+	}
+
+	err := inTx(r.db, func(tx *sql.Tx) error {
+		if err := checkTodoProject(tx, rebind, todo.ProjectID, todo.UserID); err != nil {
+			return err
+		}
+
+		res, err := tx.Exec("UPDATE todos SET title = $1, description = $2, stateID = $3, priorityID = $4, colorID = $5, does_expire = $6, expiration_date = $7, userID = $8, recurrence = $9, projectID = $10, assigneeID = $11 WHERE id = $12 AND userID = $13",
+			todo.Title, todo.Description, todo.StateID, todo.PriorityID, todo.ColorID, todo.Expiration.DoesExpire, todo.Expiration.Date.Unix(), todo.UserID, todo.Recurrence, nullID(todo.ProjectID), nullID(todo.AssigneeID), todo.ID, userID)
+
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrUpdateFailed
+		}
+
+		return setTodoTags(tx, rebind, todo.ID, todo.UserID, todo.TagIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetTodoByID(todo.ID)
+}
+
+func (r *PostgresRepository) UpdateUser(id int64, user doit.User) (*doit.User, error) {
+	if id == 0 {
+		return nil, errors.New("invalid updated ID")
+	}
+
+	res, err := r.db.Exec("UPDATE users SET username = $1, email = $2, name = $3, surname = $4, admin = $5, active = $6, password = $7 WHERE id = $8",
+		user.Username, user.Email, user.Name, user.Surname, user.Admin, user.Active, user.Password, user.ID)
+
+	if err != nil {
+		return nil, pqError(err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrUpdateFailed
+	}
+
+	return &user, nil
+}
+
+func (r *PostgresRepository) InsertTodoStates(s []*doit.TodoState) error {
+	for i := range s {
+		row := r.db.QueryRow("SELECT * FROM todo_states WHERE state = $1", s[i].State)
+
+		if err := row.Scan(&s[i].ID, &s[i].State); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				row := r.db.QueryRow("INSERT INTO todo_states(state) values($1) RETURNING id", s[i].State)
+				if err := row.Scan(&s[i].ID); err != nil {
+					return err
+				}
+			} else {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *PostgresRepository) LoadTodoStates(s []*doit.TodoState) error {
+	for i := range s {
+		row := r.db.QueryRow("SELECT id FROM todo_states WHERE state = $1", s[i].State)
+
+		if err := row.Scan(&s[i].ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotExists
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *PostgresRepository) InsertTodoPriorities(s []*doit.TodoPriority) error {
+	for i := range s {
+		row := r.db.QueryRow("SELECT * FROM todo_priority WHERE priority = $1", s[i].Priority)
+
+		if err := row.Scan(&s[i].ID, &s[i].Priority); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				row := r.db.QueryRow("INSERT INTO todo_priority(priority) values($1) RETURNING id", s[i].Priority)
+				if err := row.Scan(&s[i].ID); err != nil {
+					return err
+				}
+			} else {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *PostgresRepository) LoadTodoPriorities(s []*doit.TodoPriority) error {
+	for i := range s {
+		row := r.db.QueryRow("SELECT id FROM todo_priority WHERE priority = $1", s[i].Priority)
+
+		if err := row.Scan(&s[i].ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotExists
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *PostgresRepository) InsertTodoColors(s []*doit.Color) error {
+	for i := range s {
+		row := r.db.QueryRow("SELECT * FROM todo_colors WHERE color = $1", s[i].Hex)
+
+		if err := row.Scan(&s[i].ID, &s[i].Hex); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				row := r.db.QueryRow("INSERT INTO todo_colors(color) values($1) RETURNING id", s[i].Hex)
+				if err := row.Scan(&s[i].ID); err != nil {
+					return err
+				}
+			} else {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *PostgresRepository) LoadTodoColors(s []*doit.Color) error {
+	for i := range s {
+		row := r.db.QueryRow("SELECT id FROM todo_colors WHERE color = $1", s[i].Hex)
+
+		if err := row.Scan(&s[i].ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotExists
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *PostgresRepository) GetInternal(key string) ([]byte, error) {
+	row := r.db.QueryRow("SELECT data FROM internals WHERE key = $1", key)
+
+	var blob []byte
+	if err := row.Scan(&blob); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExists
+		} else {
+			return nil, err
+		}
+	}
+
+	return blob, nil
+}
+
+func (r *PostgresRepository) AddInternal(key string, data []byte) error {
+	_, err := r.db.Exec("INSERT INTO internals(key, data) values($1, $2)", key, data)
+	return pqError(err)
+}
+
+// Return the todos of the user that match the filter, and the total number of
+// matching todos ignoring limit and offset
+func (r *PostgresRepository) FilterTodos(userID int64, f TodoFilter) ([]doit.Todo, int64, error) {
+	where, args := f.where(userID)
+	order, err := f.orderAndLimit()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	row := r.db.QueryRow(rebind("SELECT COUNT(*) FROM todos "+where), args...)
+	if err := row.Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.Query(rebind("SELECT "+postgresTodoColumns+" FROM todos "+where+" "+order), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var all []doit.Todo
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		all = append(all, *todo)
+	}
+
+	return all, total, nil
+}
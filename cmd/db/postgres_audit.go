@@ -0,0 +1,50 @@
+package db
+
+import (
+	"math"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func (r *PostgresRepository) CreateAuditEvent(e doit.AuditEvent) (*doit.AuditEvent, error) {
+	row := r.db.QueryRow("INSERT INTO audit_log(type, actorID, actorName, targetID, targetName, ip, userAgent, time, details) values($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id",
+		e.Type, e.ActorID, e.ActorName, e.TargetID, e.TargetName, e.IP, e.UserAgent, e.Time.Unix(), e.Details)
+	err := row.Scan(&e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// Return the events that match the filter, from the newest
+func (r *PostgresRepository) AuditEvents(f AuditFilter) ([]doit.AuditEvent, error) {
+	where, args := f.where()
+	q := "SELECT id, type, actorID, actorName, targetID, targetName, ip, userAgent, time, details FROM audit_log WHERE " + where + " ORDER BY time DESC, id DESC"
+	if f.Limit > 0 || f.Offset > 0 {
+		limit := int64(f.Limit)
+		if limit == 0 {
+			limit = math.MaxInt64
+		}
+		q += " LIMIT ? OFFSET ?"
+		args = append(args, limit, f.Offset)
+	}
+
+	rows, err := r.db.Query(rebind(q), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.AuditEvent
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *e)
+	}
+
+	return all, rows.Err()
+}
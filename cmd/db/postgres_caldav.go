@@ -0,0 +1,17 @@
+package db
+
+import (
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func (r *PostgresRepository) SetCalDAVObject(o doit.CalDAVObject) error {
+	return setCalDAVObject(r.db, rebind, o)
+}
+
+func (r *PostgresRepository) CalDAVObjects(todoIDs []int64) ([]doit.CalDAVObject, error) {
+	return calDAVObjects(r.db, rebind, todoIDs)
+}
+
+func (r *PostgresRepository) CalDAVObjectsByName(name string) ([]doit.CalDAVObject, error) {
+	return calDAVObjectsByName(r.db, rebind, name)
+}
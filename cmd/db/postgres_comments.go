@@ -0,0 +1,82 @@
+package db
+
+import (
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func (r *PostgresRepository) CreateComment(c doit.Comment) (*doit.Comment, error) {
+	row := r.db.QueryRow("INSERT INTO comments(todoID, userID, body, created, edited) values($1, $2, $3, $4, $5) RETURNING id",
+		c.TodoID, c.UserID, c.Body, c.Created.Unix(), unixOrZero(c.Edited))
+	err := row.Scan(&c.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetCommentByID(c.ID, c.TodoID)
+}
+
+// The comments of the todo, from the oldest
+func (r *PostgresRepository) AllComments(todoID int64) ([]doit.Comment, error) {
+	rows, err := r.db.Query("SELECT "+commentColumns+" WHERE comments.todoID = $1 ORDER BY comments.created, comments.id", todoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.Comment
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *c)
+	}
+
+	return all, rows.Err()
+}
+
+// Get comment with id commentID only if todoID match
+func (r *PostgresRepository) GetCommentByID(commentID int64, todoID int64) (*doit.Comment, error) {
+	row := r.db.QueryRow("SELECT "+commentColumns+" WHERE comments.id = $1 AND comments.todoID = $2", commentID, todoID)
+	return scanComment(row)
+}
+
+// Update the body and the edited time of the comment with id commentID only if
+// todoID match
+func (r *PostgresRepository) UpdateComment(commentID int64, todoID int64, c doit.Comment) (*doit.Comment, error) {
+	res, err := r.db.Exec("UPDATE comments SET body = $1, edited = $2 WHERE id = $3 AND todoID = $4", c.Body, unixOrZero(c.Edited), commentID, todoID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrUpdateFailed
+	}
+
+	return r.GetCommentByID(commentID, todoID)
+}
+
+// Delete comment with id commentID only if todoID match
+func (r *PostgresRepository) DeleteCommentByID(commentID int64, todoID int64) error {
+	res, err := r.db.Exec("DELETE FROM comments WHERE id = $1 AND todoID = $2", commentID, todoID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"encoding/json"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Add the event to the history of its todo
+func (r *PostgresRepository) CreateTodoEvent(e doit.TodoEvent) (*doit.TodoEvent, error) {
+	changes, err := json.Marshal(e.Changes)
+	if err != nil {
+		return nil, err
+	}
+	todo, err := json.Marshal(e.Todo)
+	if err != nil {
+		return nil, err
+	}
+
+	row := r.db.QueryRow("INSERT INTO todo_events(todoID, userID, action, time, changes, todo) values($1, $2, $3, $4, $5, $6) RETURNING id",
+		e.TodoID, nullID(e.UserID), e.Action, e.Time.Unix(), string(changes), string(todo))
+	err = row.Scan(&e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetTodoEventByID(e.ID, e.TodoID)
+}
+
+// The history of the todo, from the oldest event
+func (r *PostgresRepository) TodoEvents(todoID int64) ([]doit.TodoEvent, error) {
+	rows, err := r.db.Query("SELECT "+todoEventColumns+" WHERE todo_events.todoID = $1 ORDER BY todo_events.time, todo_events.id", todoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.TodoEvent
+	for rows.Next() {
+		e, err := scanTodoEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *e)
+	}
+
+	return all, rows.Err()
+}
+
+// Get event with id eventID only if todoID match
+func (r *PostgresRepository) GetTodoEventByID(eventID int64, todoID int64) (*doit.TodoEvent, error) {
+	row := r.db.QueryRow("SELECT "+todoEventColumns+" WHERE todo_events.id = $1 AND todo_events.todoID = $2", eventID, todoID)
+	return scanTodoEvent(row)
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Add the item at the end of the checklist of its todo
+func (r *PostgresRepository) CreateTodoItem(item doit.TodoItem) (*doit.TodoItem, error) {
+	row := r.db.QueryRow("INSERT INTO todo_items(todoID, text, done, position) values($1, $2, $3, (SELECT COUNT(*) FROM todo_items WHERE todoID = $4)) RETURNING id, position",
+		item.TodoID, item.Text, item.Done, item.TodoID)
+
+	err := row.Scan(&item.ID, &item.Position)
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+func (r *PostgresRepository) AllTodoItems(todoID int64) ([]doit.TodoItem, error) {
+	rows, err := r.db.Query("SELECT id, todoID, text, done, position FROM todo_items WHERE todoID = $1 ORDER BY position, id", todoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.TodoItem
+	for rows.Next() {
+		item, err := scanTodoItem(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *item)
+	}
+
+	return all, nil
+}
+
+// Get item with id itemID only if todoID match
+func (r *PostgresRepository) GetTodoItemByID(itemID int64, todoID int64) (*doit.TodoItem, error) {
+	row := r.db.QueryRow("SELECT id, todoID, text, done, position FROM todo_items WHERE id = $1 AND todoID = $2", itemID, todoID)
+	return scanTodoItem(row)
+}
+
+// Update item with id itemID only if todoID match. If the position changes
+// the other items are shifted, the position is clamped to the checklist.
+func (r *PostgresRepository) UpdateTodoItem(itemID int64, todoID int64, item doit.TodoItem) (*doit.TodoItem, error) {
+	err := inTx(r.db, func(tx *sql.Tx) error {
+		var old, count int64
+		row := tx.QueryRow("SELECT position, (SELECT COUNT(*) FROM todo_items WHERE todoID = $1) FROM todo_items WHERE id = $2 AND todoID = $3", todoID, itemID, todoID)
+		if err := row.Scan(&old, &count); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUpdateFailed
+			}
+			return err
+		}
+
+		item.Position = max(0, min(item.Position, count-1))
+
+		var err error
+		if item.Position < old {
+			_, err = tx.Exec("UPDATE todo_items SET position = position + 1 WHERE todoID = $1 AND position >= $2 AND position < $3", todoID, item.Position, old)
+		} else if item.Position > old {
+			_, err = tx.Exec("UPDATE todo_items SET position = position - 1 WHERE todoID = $1 AND position > $2 AND position <= $3", todoID, old, item.Position)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE todo_items SET text = $1, done = $2, position = $3 WHERE id = $4", item.Text, item.Done, item.Position, itemID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	item.ID = itemID
+	item.TodoID = todoID
+	return &item, nil
+}
+
+// Delete item with id itemID only if todoID match. The following items are
+// shifted back.
+func (r *PostgresRepository) DeleteTodoItemByID(itemID int64, todoID int64) error {
+	return inTx(r.db, func(tx *sql.Tx) error {
+		var position int64
+		row := tx.QueryRow("DELETE FROM todo_items WHERE id = $1 AND todoID = $2 RETURNING position", itemID, todoID)
+		if err := row.Scan(&position); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrDeleteFailed
+			}
+			return err
+		}
+
+		_, err := tx.Exec("UPDATE todo_items SET position = position - 1 WHERE todoID = $1 AND position > $2", todoID, position)
+		return err
+	})
+}
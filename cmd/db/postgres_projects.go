@@ -0,0 +1,85 @@
+package db
+
+import (
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func (r *PostgresRepository) CreateProject(p doit.Project) (*doit.Project, error) {
+	row := r.db.QueryRow("INSERT INTO projects(userID, name, archived) values($1, $2, $3) RETURNING id", p.UserID, p.Name, p.Archived)
+
+	err := row.Scan(&p.ID)
+	if err != nil {
+		return nil, pqError(err)
+	}
+
+	return &p, nil
+}
+
+// Return the projects of the user and the ones shared with him
+func (r *PostgresRepository) AllProjects(userID int64) ([]doit.Project, error) {
+	rows, err := r.db.Query(`SELECT id, userID, name, archived FROM projects
+    WHERE userID = $1 OR id IN (SELECT projectID FROM project_shares WHERE userID = $1)
+    ORDER BY name, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.Project
+	for rows.Next() {
+		p, err := scanProject(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *p)
+	}
+
+	return all, nil
+}
+
+func (r *PostgresRepository) GetProjectByID(id int64) (*doit.Project, error) {
+	row := r.db.QueryRow("SELECT id, userID, name, archived FROM projects WHERE id = $1", id)
+	return scanProject(row)
+}
+
+// Update project with id projectID only if userID match
+func (r *PostgresRepository) UpdateProject(projectID int64, userID int64, p doit.Project) (*doit.Project, error) {
+	res, err := r.db.Exec("UPDATE projects SET name = $1, archived = $2 WHERE id = $3 AND userID = $4", p.Name, p.Archived, projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrUpdateFailed
+	}
+
+	p.ID = projectID
+	p.UserID = userID
+	return &p, nil
+}
+
+// Delete project with id projectID only if userID match. Its todos are
+// moved to the inbox.
+func (r *PostgresRepository) DeleteProjectByID(projectID int64, userID int64) error {
+	res, err := r.db.Exec("DELETE FROM projects WHERE id = $1 AND userID = $2", projectID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func (r *PostgresRepository) ExpiringTodos(from time.Time, to time.Time) ([]doit.Todo, error) {
+	return expiringTodos(r.db, rebind, postgresTodoColumns, from, to)
+}
+
+func (r *PostgresRepository) ClaimReminder(reminder doit.Reminder) (bool, error) {
+	return claimReminder(r.db, rebind, reminder)
+}
+
+func (r *PostgresRepository) DeleteReminder(reminder doit.Reminder) error {
+	return deleteReminder(r.db, rebind, reminder)
+}
+
+func (r *PostgresRepository) LastDigest(userID int64) (time.Time, error) {
+	return lastDigest(r.db, rebind, userID)
+}
+
+func (r *PostgresRepository) ClaimDigest(userID int64, at time.Time, since time.Time) (bool, error) {
+	return claimDigest(r.db, rebind, userID, at, since)
+}
+
+func (r *PostgresRepository) SetLastDigest(userID int64, at time.Time) error {
+	return setLastDigest(r.db, rebind, userID, at)
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Full-text search in the todos visible to the user. Results are ordered by
+// rank, limit 0 means no limit.
+func (r *PostgresRepository) SearchTodos(userID int64, query string, limit int) ([]doit.TodoSearchResult, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, nil
+	}
+
+	visible, args := visibleTodos(userID)
+	q := `SELECT ` + postgresTodoColumns + `,
+      ts_rank(to_tsvector('simple', title || ' ' || description), query) AS rank,
+      ts_headline('simple', title || ' ' || description, query, ?)
+    FROM todos, plainto_tsquery('simple', ?) query
+    WHERE to_tsvector('simple', title || ' ' || description) @@ query AND ` + visible + `
+    ORDER BY rank DESC, id`
+	options := "StartSel=" + snippetStart + ", StopSel=" + snippetStop + ", MaxWords=15, MinWords=5"
+	args = append([]any{options, query}, args...)
+	if limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := r.db.Query(rebind(q), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.TodoSearchResult
+	for rows.Next() {
+		var res doit.TodoSearchResult
+		todo, err := scanTodo(rows, &res.Rank, &res.Snippet)
+		if err != nil {
+			return nil, err
+		}
+
+		res.Todo = *todo
+		res.Snippet = htmlSnippet(res.Snippet)
+		all = append(all, res)
+	}
+
+	return all, rows.Err()
+}
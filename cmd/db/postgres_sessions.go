@@ -0,0 +1,121 @@
+package db
+
+import (
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func (r *PostgresRepository) CreateSession(s doit.Session) (*doit.Session, error) {
+	row := r.db.QueryRow("INSERT INTO sessions(token_hash, userID, created, expire, last_seen, user_agent, ip) values($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		s.TokenHash, s.UserID, s.Created.Unix(), s.Expire.Unix(), s.LastSeen.Unix(), s.UserAgent, s.IP)
+
+	err := row.Scan(&s.ID)
+	if err != nil {
+		return nil, pqError(err)
+	}
+
+	return &s, nil
+}
+
+func (r *PostgresRepository) GetSessionByTokenHash(hash string) (*doit.Session, error) {
+	row := r.db.QueryRow("SELECT * FROM sessions WHERE token_hash = $1", hash)
+	return scanSession(row)
+}
+
+func (r *PostgresRepository) AllSessions(userID int64) ([]doit.Session, error) {
+	rows, err := r.db.Query("SELECT * FROM sessions WHERE userID = $1 ORDER BY last_seen DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *s)
+	}
+
+	return all, nil
+}
+
+func (r *PostgresRepository) UpdateSessionLastSeen(id int64, lastSeen time.Time) error {
+	res, err := r.db.Exec("UPDATE sessions SET last_seen = $1 WHERE id = $2", lastSeen.Unix(), id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrUpdateFailed
+	}
+
+	return nil
+}
+
+// Delete session with id sessionID only if userID match
+func (r *PostgresRepository) DeleteSessionByID(sessionID int64, userID int64) error {
+	res, err := r.db.Exec("DELETE FROM sessions WHERE id = $1 AND userID = $2", sessionID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
+
+func (r *PostgresRepository) DeleteSessionByTokenHash(hash string) error {
+	res, err := r.db.Exec("DELETE FROM sessions WHERE token_hash = $1", hash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
+
+// Delete all the sessions expired before now. Return the number of sessions
+// deleted.
+func (r *PostgresRepository) DeleteExpiredSessions(now time.Time) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM sessions WHERE expire < $1", now.Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Delete all the sessions of the user but exceptID, 0 to delete them all.
+// Return the number of sessions deleted.
+func (r *PostgresRepository) DeleteUserSessions(userID int64, exceptID int64) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM sessions WHERE userID = $1 AND id != $2", userID, exceptID)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
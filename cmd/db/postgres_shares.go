@@ -0,0 +1,43 @@
+package db
+
+import (
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Return the permission granted to userID on the todo, directly or through
+// its project, or "" if the todo is not shared with him
+func (r *PostgresRepository) TodoSharePermission(todoID int64, userID int64) (string, error) {
+	return todoSharePermission(r.db, rebind, todoID, userID)
+}
+
+// Return the permission granted to userID on the project, or "" if the
+// project is not shared with him
+func (r *PostgresRepository) ProjectSharePermission(projectID int64, userID int64) (string, error) {
+	return projectSharePermission(r.db, rebind, projectID, userID)
+}
+
+// Share the todo with userID, or change the permission if already shared
+func (r *PostgresRepository) ShareTodo(todoID int64, userID int64, permission string) error {
+	return share(r.db, rebind, "todo_shares", "todoID", todoID, userID, permission)
+}
+
+func (r *PostgresRepository) UnshareTodo(todoID int64, userID int64) error {
+	return unshare(r.db, rebind, "todo_shares", "todoID", todoID, userID)
+}
+
+func (r *PostgresRepository) TodoShares(todoID int64) ([]doit.Share, error) {
+	return allShares(r.db, rebind, "todo_shares", "todoID", todoID)
+}
+
+// Share the project with userID, or change the permission if already shared
+func (r *PostgresRepository) ShareProject(projectID int64, userID int64, permission string) error {
+	return share(r.db, rebind, "project_shares", "projectID", projectID, userID, permission)
+}
+
+func (r *PostgresRepository) UnshareProject(projectID int64, userID int64) error {
+	return unshare(r.db, rebind, "project_shares", "projectID", projectID, userID)
+}
+
+func (r *PostgresRepository) ProjectShares(projectID int64) ([]doit.Share, error) {
+	return allShares(r.db, rebind, "project_shares", "projectID", projectID)
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func (r *PostgresRepository) CreateTag(tag doit.Tag) (*doit.Tag, error) {
+	row := r.db.QueryRow("INSERT INTO tags(userID, name, color) values($1, $2, $3) RETURNING id", tag.UserID, tag.Name, tag.Color)
+
+	err := row.Scan(&tag.ID)
+	if err != nil {
+		return nil, pqError(err)
+	}
+
+	return &tag, nil
+}
+
+func (r *PostgresRepository) AllTags(userID int64) ([]doit.Tag, error) {
+	rows, err := r.db.Query("SELECT id, userID, name, color FROM tags WHERE userID = $1 ORDER BY name", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.Tag
+	for rows.Next() {
+		tag, err := scanTag(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *tag)
+	}
+
+	return all, nil
+}
+
+// Get tag with id tagID only if userID match
+func (r *PostgresRepository) GetTagByID(tagID int64, userID int64) (*doit.Tag, error) {
+	row := r.db.QueryRow("SELECT id, userID, name, color FROM tags WHERE id = $1 AND userID = $2", tagID, userID)
+	return scanTag(row)
+}
+
+// Update tag with id tagID only if userID match
+func (r *PostgresRepository) UpdateTag(tagID int64, userID int64, tag doit.Tag) (*doit.Tag, error) {
+	res, err := r.db.Exec("UPDATE tags SET name = $1, color = $2 WHERE id = $3 AND userID = $4", tag.Name, tag.Color, tagID, userID)
+	if err != nil {
+		return nil, pqError(err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrUpdateFailed
+	}
+
+	tag.ID = tagID
+	tag.UserID = userID
+	return &tag, nil
+}
+
+// Delete tag with id tagID only if userID match. The tag is removed from all
+// the todos.
+func (r *PostgresRepository) DeleteTagByID(tagID int64, userID int64) error {
+	res, err := r.db.Exec("DELETE FROM tags WHERE id = $1 AND userID = $2", tagID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/samuelemusiani/doit/cmd/config"
+	"gotest.tools/v3/assert"
+)
+
+// The postgres used by the tests, started at most once per run
+var testPostgres struct {
+	once sync.Once
+	dsn  string
+	skip string
+	err  error
+	stop func()
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if testPostgres.stop != nil {
+		testPostgres.stop()
+	}
+	os.Exit(code)
+}
+
+// Return the DSN of a postgres that can be used for testing. If
+// DOIT_TEST_POSTGRES_DSN is set it is used, otherwise a new cluster is started
+// in a temporary directory with the postgres binaries found in the PATH. The
+// test is skipped if neither is available.
+func postgresDSN(t *testing.T) string {
+	testPostgres.once.Do(startPostgres)
+	if testPostgres.skip != "" {
+		t.Skip(testPostgres.skip)
+	}
+	assert.NilError(t, testPostgres.err)
+	return testPostgres.dsn
+}
+
+func startPostgres() {
+	if dsn := os.Getenv("DOIT_TEST_POSTGRES_DSN"); dsn != "" {
+		testPostgres.dsn = dsn
+		return
+	}
+
+	initdb, err := exec.LookPath("initdb")
+	if err != nil {
+		testPostgres.skip = "initdb not found and DOIT_TEST_POSTGRES_DSN not set"
+		return
+	}
+	pgctl, err := exec.LookPath("pg_ctl")
+	if err != nil {
+		testPostgres.skip = "pg_ctl not found and DOIT_TEST_POSTGRES_DSN not set"
+		return
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		testPostgres.err = err
+		return
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	dir, err := os.MkdirTemp("", "doit-postgres")
+	if err != nil {
+		testPostgres.err = err
+		return
+	}
+	data := filepath.Join(dir, "data")
+	testPostgres.stop = func() {
+		exec.Command(pgctl, "-D", data, "-m", "immediate", "stop").Run()
+		os.RemoveAll(dir)
+	}
+
+	out, err := exec.Command(initdb, "-D", data, "-U", "doit", "--auth=trust").CombinedOutput()
+	if err != nil {
+		testPostgres.err = fmt.Errorf("initdb: %w: %s", err, out)
+		return
+	}
+
+	opts := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1", port, dir)
+	out, err = exec.Command(pgctl, "-D", data, "-o", opts, "-w", "start").CombinedOutput()
+	if err != nil {
+		testPostgres.err = fmt.Errorf("pg_ctl start: %w: %s", err, out)
+		return
+	}
+
+	testPostgres.dsn = fmt.Sprintf("postgres://doit@127.0.0.1:%d/postgres?sslmode=disable", port)
+}
+
+func setupPostgres(t *testing.T) Repository {
+	dsn := postgresDSN(t)
+
+	// Start every test from an empty database
+	rawDB, err := sql.Open("postgres", dsn)
+	assert.NilError(t, err)
+	_, err = rawDB.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public;")
+	assert.NilError(t, err)
+	assert.NilError(t, rawDB.Close())
+
+	conf := config.GetConfig()
+	conf.Databse.Driver = "postgres"
+	conf.Databse.DSN = dsn
+	t.Cleanup(func() {
+		conf.Databse.Driver = "sqlite"
+	})
+
+	r, err := Init()
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		assert.NilError(t, r.Close())
+	})
+	return r
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func (r *PostgresRepository) CreateAPIToken(t doit.APIToken) (*doit.APIToken, error) {
+	row := r.db.QueryRow("INSERT INTO api_tokens(userID, name, token_hash, scopes, created, expire, last_used) values($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+		t.UserID, t.Name, t.TokenHash, strings.Join(t.Scopes, " "), t.Created.Unix(), unixOrZero(t.Expire), unixOrZero(t.LastUsed))
+
+	err := row.Scan(&t.ID)
+	if err != nil {
+		return nil, pqError(err)
+	}
+
+	return &t, nil
+}
+
+func (r *PostgresRepository) GetAPITokenByTokenHash(hash string) (*doit.APIToken, error) {
+	row := r.db.QueryRow("SELECT * FROM api_tokens WHERE token_hash = $1", hash)
+	return scanAPIToken(row)
+}
+
+func (r *PostgresRepository) AllAPITokens(userID int64) ([]doit.APIToken, error) {
+	rows, err := r.db.Query("SELECT * FROM api_tokens WHERE userID = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *t)
+	}
+
+	return all, nil
+}
+
+func (r *PostgresRepository) UpdateAPITokenLastUsed(id int64, lastUsed time.Time) error {
+	res, err := r.db.Exec("UPDATE api_tokens SET last_used = $1 WHERE id = $2", lastUsed.Unix(), id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrUpdateFailed
+	}
+
+	return nil
+}
+
+// Delete token with id tokenID only if userID match
+func (r *PostgresRepository) DeleteAPITokenByID(tokenID int64, userID int64) error {
+	res, err := r.db.Exec("DELETE FROM api_tokens WHERE id = $1 AND userID = $2", tokenID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
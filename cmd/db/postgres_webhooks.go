@@ -0,0 +1,37 @@
+package db
+
+import (
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func (r *PostgresRepository) CreateWebhook(w doit.Webhook) (*doit.Webhook, error) {
+	return createWebhook(r.db, rebind, w)
+}
+
+func (r *PostgresRepository) GetWebhookByID(id int64) (*doit.Webhook, error) {
+	return getWebhookByID(r.db, rebind, id)
+}
+
+func (r *PostgresRepository) AllWebhooks(userID int64) ([]doit.Webhook, error) {
+	return allWebhooks(r.db, rebind, userID)
+}
+
+func (r *PostgresRepository) ActiveWebhooks(userID int64) ([]doit.Webhook, error) {
+	return activeWebhooks(r.db, rebind, userID)
+}
+
+func (r *PostgresRepository) UpdateWebhook(w doit.Webhook) (*doit.Webhook, error) {
+	return updateWebhook(r.db, rebind, w)
+}
+
+func (r *PostgresRepository) DeleteWebhookByID(id int64, userID int64) error {
+	return deleteWebhookByID(r.db, rebind, id, userID)
+}
+
+func (r *PostgresRepository) CreateWebhookDelivery(d doit.WebhookDelivery) (*doit.WebhookDelivery, error) {
+	return createWebhookDelivery(r.db, rebind, d)
+}
+
+func (r *PostgresRepository) WebhookDeliveries(webhookID int64) ([]doit.WebhookDelivery, error) {
+	return webhookDeliveries(r.db, rebind, webhookID)
+}
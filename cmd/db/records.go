@@ -0,0 +1,132 @@
+package db
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Return the records of the todos owned by the user, the ones in the trash
+// excluded
+func ExportRecords(r Repository, userID int64) ([]doit.TodoRecord, error) {
+	todos, err := r.AllTodos(userID)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(todos, func(a, b doit.Todo) int { return cmp.Compare(a.ID, b.ID) })
+
+	projects, err := r.AllProjects(userID)
+	if err != nil {
+		return nil, err
+	}
+	projectNames := make(map[int64]string)
+	for _, p := range projects {
+		projectNames[p.ID] = p.Name
+	}
+
+	tags, err := r.AllTags(userID)
+	if err != nil {
+		return nil, err
+	}
+	tagNames := make(map[int64]string)
+	for _, t := range tags {
+		tagNames[t.ID] = t.Name
+	}
+
+	records := []doit.TodoRecord{}
+	for i := range todos {
+		if !todos[i].DeletedAt.IsZero() {
+			continue
+		}
+		var names []string
+		for _, id := range todos[i].TagIDs {
+			names = append(names, tagNames[id])
+		}
+		records = append(records, doit.TodoToRecord(&todos[i], projectNames[todos[i].ProjectID], names))
+	}
+	return records, nil
+}
+
+// Create the todos of the records for the user, with the projects and the
+// tags resolved by name among the ones he owns. If a record is not valid
+// nothing is created and the errors of all the records are returned. With
+// dryRun the records are only checked. The created todos are returned too, so
+// the caller can record them in their history.
+func ImportRecords(r Repository, userID int64, records []doit.TodoRecord, dryRun bool) (*doit.ImportResult, []doit.Todo, error) {
+	projects, err := r.AllProjects(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	projectIDs := make(map[string]int64)
+	// Projects are not unique by name, the oldest wins
+	for i := len(projects) - 1; i >= 0; i-- {
+		if projects[i].UserID == userID {
+			projectIDs[projects[i].Name] = projects[i].ID
+		}
+	}
+
+	tags, err := r.AllTags(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	tagIDs := make(map[string]int64)
+	for _, t := range tags {
+		tagIDs[t.Name] = t.ID
+	}
+
+	result := doit.ImportResult{DryRun: dryRun, Errors: []doit.ImportError{}}
+	var todos []doit.Todo
+	for i := range records {
+		todo, err := recordToTodo(&records[i], projectIDs, tagIDs)
+		if err != nil {
+			result.Errors = append(result.Errors, doit.ImportError{Row: i + 1, Error: err.Error()})
+			continue
+		}
+		todo.UserID = userID
+		todos = append(todos, todo)
+	}
+	if len(result.Errors) > 0 || len(todos) == 0 {
+		return &result, nil, nil
+	}
+	if dryRun {
+		result.Imported = len(todos)
+		return &result, nil, nil
+	}
+
+	created, err := r.CreateTodos(todos)
+	if err != nil {
+		return nil, nil, err
+	}
+	result.Imported = len(created)
+
+	return &result, created, nil
+}
+
+func recordToTodo(rec *doit.TodoRecord, projectIDs map[string]int64, tagIDs map[string]int64) (doit.Todo, error) {
+	todo, err := rec.ToTodo()
+	if err != nil {
+		return todo, err
+	}
+
+	if rec.Project != "" {
+		id, ok := projectIDs[rec.Project]
+		if !ok {
+			return todo, fmt.Errorf("Project %q does not exist", rec.Project)
+		}
+		todo.ProjectID = id
+	}
+
+	todo.TagIDs = []int64{}
+	for _, name := range rec.Tags {
+		id, ok := tagIDs[name]
+		if !ok {
+			return todo, fmt.Errorf("Tag %q does not exist", name)
+		}
+		todo.TagIDs = append(todo.TagIDs, id)
+	}
+
+	_, err = todo.NormalizeRecurrence()
+	return todo, err
+}
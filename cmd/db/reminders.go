@@ -0,0 +1,74 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func expiringTodos(db *sql.DB, bind func(string) string, columns string, from time.Time, to time.Time) ([]doit.Todo, error) {
+	rows, err := db.Query(bind("SELECT "+columns+` FROM todos
+  WHERE does_expire AND expiration_date >= ? AND expiration_date < ? AND stateID != ? AND deleted_at IS NULL
+  ORDER BY expiration_date, id`), from.Unix(), to.Unix(), doit.StateDone.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.Todo
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, *todo)
+	}
+
+	return all, rows.Err()
+}
+
+// Return if the query changed a row
+func claimed(res sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func claimReminder(db *sql.DB, bind func(string) string, r doit.Reminder) (bool, error) {
+	return claimed(db.Exec(bind("INSERT INTO reminders(todoID, before_due, due, sent_at) values(?, ?, ?, ?) ON CONFLICT(todoID, before_due, due) DO NOTHING"),
+		r.TodoID, int64(r.Before.Seconds()), r.Due.Unix(), r.SentAt.Unix()))
+}
+
+func deleteReminder(db *sql.DB, bind func(string) string, r doit.Reminder) error {
+	_, err := db.Exec(bind("DELETE FROM reminders WHERE todoID = ? AND before_due = ? AND due = ?"),
+		r.TodoID, int64(r.Before.Seconds()), r.Due.Unix())
+	return err
+}
+
+func lastDigest(db *sql.DB, bind func(string) string, userID int64) (time.Time, error) {
+	var t int64
+	err := db.QueryRow(bind("SELECT sent_at FROM reminder_digests WHERE userID = ?"), userID).Scan(&t)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	return time.Unix(t, 0), nil
+}
+
+func claimDigest(db *sql.DB, bind func(string) string, userID int64, at time.Time, since time.Time) (bool, error) {
+	return claimed(db.Exec(bind(`INSERT INTO reminder_digests(userID, sent_at) values(?, ?)
+  ON CONFLICT(userID) DO UPDATE SET sent_at = excluded.sent_at WHERE reminder_digests.sent_at < ?`),
+		userID, at.Unix(), since.Unix()))
+}
+
+func setLastDigest(db *sql.DB, bind func(string) string, userID int64, at time.Time) error {
+	_, err := db.Exec(bind("INSERT INTO reminder_digests(userID, sent_at) values(?, ?) ON CONFLICT(userID) DO UPDATE SET sent_at = excluded.sent_at"),
+		userID, at.Unix())
+	return err
+}
@@ -0,0 +1,20 @@
+package db
+
+import (
+	"html"
+	"strings"
+)
+
+// Put by the databases around the matches of a snippet, in place of the HTML
+// tags, so the text can be escaped first
+const (
+	snippetStart = "\x02"
+	snippetStop  = "\x03"
+)
+
+// Return the snippet as HTML, with the matches between <b> and </b>
+func htmlSnippet(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, snippetStart, "<b>")
+	return strings.ReplaceAll(s, snippetStop, "</b>")
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+var ErrInvalidPermission = errors.New("invalid permission")
+
+// Condition, with ? placeholders, matching the todos that userID can see: the
+// ones he owns, the ones assigned to him, the ones shared with him and the ones
+// in a project shared with him. Todos in the trash are never matched. This is
+// the only place where the visibility of todos is decided.
+func visibleTodos(userID int64) (string, []any) {
+	return `(todos.deleted_at IS NULL AND (todos.userID = ? OR todos.assigneeID = ?
+    OR todos.id IN (SELECT todoID FROM todo_shares WHERE userID = ?)
+    OR todos.projectID IN (SELECT projectID FROM project_shares WHERE userID = ?)))`,
+		[]any{userID, userID, userID, userID}
+}
+
+// Return the strongest of the permissions, ignoring the empty ones
+func strongestPermission(perms ...sql.NullString) string {
+	best := ""
+	for _, p := range perms {
+		if !p.Valid {
+			continue
+		}
+		if p.String == doit.PermissionEdit || best == "" {
+			best = p.String
+		}
+	}
+	return best
+}
+
+func todoSharePermission(db *sql.DB, bind func(string) string, todoID int64, userID int64) (string, error) {
+	var direct, project sql.NullString
+	row := db.QueryRow(bind(`SELECT
+      (SELECT permission FROM todo_shares WHERE todoID = todos.id AND userID = ?),
+      (SELECT permission FROM project_shares WHERE projectID = todos.projectID AND userID = ?)
+    FROM todos WHERE id = ?`), userID, userID, todoID)
+	if err := row.Scan(&direct, &project); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNotExists
+		}
+		return "", err
+	}
+	return strongestPermission(direct, project), nil
+}
+
+func projectSharePermission(db *sql.DB, bind func(string) string, projectID int64, userID int64) (string, error) {
+	var p sql.NullString
+	row := db.QueryRow(bind("SELECT permission FROM project_shares WHERE projectID = ? AND userID = ?"), projectID, userID)
+	if err := row.Scan(&p); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strongestPermission(p), nil
+}
+
+// Share with userID, or change the permission if already shared. table is
+// todo_shares or project_shares and column the ID they refer to.
+func share(db *sql.DB, bind func(string) string, table string, column string, id int64, userID int64, permission string) error {
+	if permission != doit.PermissionRead && permission != doit.PermissionEdit {
+		return ErrInvalidPermission
+	}
+
+	_, err := db.Exec(bind("INSERT INTO "+table+"("+column+", userID, permission) values(?, ?, ?) ON CONFLICT("+column+", userID) DO UPDATE SET permission = excluded.permission"),
+		id, userID, permission)
+	return err
+}
+
+func unshare(db *sql.DB, bind func(string) string, table string, column string, id int64, userID int64) error {
+	res, err := db.Exec(bind("DELETE FROM "+table+" WHERE "+column+" = ? AND userID = ?"), id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
+
+func allShares(db *sql.DB, bind func(string) string, table string, column string, id int64) ([]doit.Share, error) {
+	rows, err := db.Query(bind("SELECT users.id, users.username, "+table+".permission FROM "+table+" JOIN users ON users.id = "+table+".userID WHERE "+table+"."+column+" = ? ORDER BY users.username"), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.Share
+	for rows.Next() {
+		var s doit.Share
+		if err := rows.Scan(&s.UserID, &s.Username, &s.Permission); err != nil {
+			return nil, err
+		}
+		all = append(all, s)
+	}
+
+	return all, rows.Err()
+}
@@ -3,7 +3,7 @@ package db
 import (
 	"database/sql"
 	"errors"
-	"time"
+	"log/slog"
 
 	"github.com/mattn/go-sqlite3"
 	"github.com/samuelemusiani/doit/cmd/doit"
@@ -20,82 +20,83 @@ type SQLiteRepository struct {
 	db *sql.DB
 }
 
-func newSQLiteRepository(db *sql.DB) *SQLiteRepository {
+func NewSQLiteRepository(db *sql.DB) *SQLiteRepository {
 	return &SQLiteRepository{
 		db: db,
 	}
 }
 
-func (r *SQLiteRepository) migrate() error {
-	query := `
-  CREATE TABLE IF NOT EXISTS users(
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    username TINYTEXT UNIQUE NOT NULL,
-    email TINYTEXT UNIQUE NOT NULL,
-    name TINYTEXT NOT NULL,
-    surname TINYTEXT NOT NULL,
-    admin BOOL NOT NULL,
-    active BOOL NOT NULL,
-    password TINYTEXT NOT NULL
-  );
-  CREATE TABLE IF NOT EXISTS todo_states(
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    state TINYTEXT NOT NULL
-  );
-  CREATE TABLE IF NOT EXISTS todo_priority(
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    priority INTEGER NOT NULL
-  );
-  CREATE TABLE IF NOT EXISTS todo_colors(
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    color TINYTEXT NOT NULL
-  );
-  CREATE TABLE IF NOT EXISTS todos(
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    title TEXT NOT NULL,
-    description TEXT NOT NULL,
-    stateID INTEGER,
-    priorityID INTEGER,
-    colorID INTEGER,
-    does_expire BOOL,
-    expiration_date INTEGER,
-    userID INTEGER,
-    FOREIGN KEY(stateID) REFERENCES todo_states(id),
-    FOREIGN KEY(priorityID) REFERENCES todo_priority(id),
-    FOREIGN KEY(colorID) REFERENCES todo_colors(id),
-    FOREIGN KEY(userID) REFERENCES users(id)
-  );
-  CREATE TABLE IF NOT EXISTS internals(
-    key TEXT NOT NULL UNIQUE PRIMARY KEY,
-    data BLOB NOT NULL
-  );
-  `
-	_, err := r.db.Exec(query)
-	return err
+func (r *SQLiteRepository) migrator() *migrator {
+	return &migrator{
+		db:            r.db,
+		migrations:    sqliteMigrations,
+		insertVersion: "INSERT INTO schema_migrations(version, applied_at) values(?, ?)",
+		deleteVersion: "DELETE FROM schema_migrations WHERE version = ?",
+		tableExists:   "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'",
+	}
+}
+
+func (r *SQLiteRepository) Close() error {
+	slog.Debug("Closing DB connection")
+	return r.db.Close()
 }
 
-func (r *SQLiteRepository) createTodo(todo doit.Todo) (*doit.Todo, error) {
-	res, err := r.db.Exec("INSERT INTO todos(title, description, stateID, priorityID, colorID, does_expire, expiration_date, userID) values(?, ?, ?, ?, ?, ?, ?, ?)", todo.Title, todo.Description, todo.StateID, todo.PriorityID, todo.ColorID, todo.Expiration.DoesExpire, todo.Expiration.Date.Unix(), todo.UserID)
+func (r *SQLiteRepository) CreateTodo(todo doit.Todo) (*doit.Todo, error) {
+	err := inTx(r.db, func(tx *sql.Tx) error {
+		return sqliteInsertTodo(tx, &todo)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &todo, nil
+}
+
+func (r *SQLiteRepository) CreateTodos(todos []doit.Todo) ([]doit.Todo, error) {
+	created := make([]doit.Todo, len(todos))
+	copy(created, todos)
+	err := inTx(r.db, func(tx *sql.Tx) error {
+		for i := range created {
+			if err := sqliteInsertTodo(tx, &created[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// Insert the todo with its tags and set its ID
+func sqliteInsertTodo(tx *sql.Tx, todo *doit.Todo) error {
+	if err := checkTodoProject(tx, noRebind, todo.ProjectID, todo.UserID); err != nil {
+		return err
+	}
+
+	res, err := tx.Exec("INSERT INTO todos(title, description, stateID, priorityID, colorID, does_expire, expiration_date, userID, recurrence, projectID, assigneeID) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", todo.Title, todo.Description, todo.StateID, todo.PriorityID, todo.ColorID, todo.Expiration.DoesExpire, todo.Expiration.Date.Unix(), todo.UserID, todo.Recurrence, nullID(todo.ProjectID), nullID(todo.AssigneeID))
 
 	if err != nil {
 		var sqliteErr sqlite3.Error
 		if errors.As(err, &sqliteErr) {
 			if errors.Is(sqliteErr.ExtendedCode, sqlite3.ErrConstraintUnique) {
-				return nil, ErrDuplicate
+				return ErrDuplicate
 			}
 		}
-		return nil, err
+		return err
 	}
 
-	id, err := res.LastInsertId()
+	todo.ID, err = res.LastInsertId()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	todo.ID = id
-	return &todo, nil
+
+	return setTodoTags(tx, noRebind, todo.ID, todo.UserID, todo.TagIDs)
 }
 
-func (r *SQLiteRepository) createUser(user doit.User) (*doit.User, error) {
+func (r *SQLiteRepository) CreateUser(user doit.User) (*doit.User, error) {
 	res, err := r.db.Exec("INSERT INTO users(username, email, name, surname, admin, active, password) values(?, ?, ?, ?, ?, ?, ?)",
 		user.Username, user.Email, user.Name, user.Surname,
 		user.Admin, user.Active, user.Password)
@@ -119,8 +120,8 @@ func (r *SQLiteRepository) createUser(user doit.User) (*doit.User, error) {
 	return &user, nil
 }
 
-func (r *SQLiteRepository) allTodos(userId int64) ([]doit.Todo, error) {
-	rows, err := r.db.Query("SELECT * FROM todos WHERE userID = ?", userId)
+func (r *SQLiteRepository) AllTodos(userId int64) ([]doit.Todo, error) {
+	rows, err := r.db.Query("SELECT "+sqliteTodoColumns+" FROM todos WHERE userID = ?", userId)
 	if err != nil {
 		return nil, err
 	}
@@ -128,24 +129,18 @@ func (r *SQLiteRepository) allTodos(userId int64) ([]doit.Todo, error) {
 
 	var all []doit.Todo
 	for rows.Next() {
-		var todo doit.Todo
-		var t int64
-		err := rows.Scan(&todo.ID, &todo.Title, &todo.Description, &todo.StateID, &todo.PriorityID, &todo.ColorID, &todo.Expiration.DoesExpire, &t, &todo.UserID)
+		todo, err := scanTodo(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		if todo.Expiration.DoesExpire {
-			todo.Expiration.Date = time.Unix(t, 0)
-		}
-
-		all = append(all, todo)
+		all = append(all, *todo)
 	}
 
 	return all, nil
 }
 
-func (r *SQLiteRepository) allUsers() ([]doit.User, error) {
+func (r *SQLiteRepository) AllUsers() ([]doit.User, error) {
 	rows, err := r.db.Query("SELECT * FROM users")
 	if err != nil {
 		return nil, err
@@ -166,22 +161,10 @@ func (r *SQLiteRepository) allUsers() ([]doit.User, error) {
 	return all, nil
 }
 
-func (r *SQLiteRepository) getTodoByID(id int64) (*doit.Todo, error) {
-	row := r.db.QueryRow("SELECT * FROM todos WHERE id = ?", id)
+func (r *SQLiteRepository) GetTodoByID(id int64) (*doit.Todo, error) {
+	row := r.db.QueryRow("SELECT "+sqliteTodoColumns+" FROM todos WHERE id = ?", id)
 
-	var todo doit.Todo
-	var t int64
-	err := row.Scan(&todo.ID, &todo.Title, &todo.Description, &todo.StateID, &todo.PriorityID, &todo.ColorID, &todo.Expiration.DoesExpire, &t, &todo.UserID)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrNotExists
-		}
-		return nil, err
-	}
-	if todo.Expiration.DoesExpire {
-		todo.Expiration.Date = time.Unix(t, 0)
-	}
-	return &todo, nil
+	return scanTodo(row)
 }
 
 func scanUser(row *sql.Row) (*doit.User, error) {
@@ -197,23 +180,23 @@ func scanUser(row *sql.Row) (*doit.User, error) {
 	return &user, nil
 }
 
-func (r *SQLiteRepository) getUserByID(id int64) (*doit.User, error) {
+func (r *SQLiteRepository) GetUserByID(id int64) (*doit.User, error) {
 	row := r.db.QueryRow("SELECT * FROM users WHERE id = ?", id)
 	return scanUser(row)
 }
 
-func (r *SQLiteRepository) getUserByUsername(username string) (*doit.User, error) {
+func (r *SQLiteRepository) GetUserByUsername(username string) (*doit.User, error) {
 	row := r.db.QueryRow("SELECT * FROM users WHERE username = ?", username)
 	return scanUser(row)
 }
 
-func (r *SQLiteRepository) getUserByEmail(email string) (*doit.User, error) {
+func (r *SQLiteRepository) GetUserByEmail(email string) (*doit.User, error) {
 	row := r.db.QueryRow("SELECT * FROM users WHERE email = ?", email)
 	return scanUser(row)
 }
 
 // Delete todo with id todoID only if userID match
-func (r *SQLiteRepository) deleteTodoByID(todoID int64, userID int64) error {
+func (r *SQLiteRepository) DeleteTodoByID(todoID int64, userID int64) error {
 	res, err := r.db.Exec("DELETE FROM todos WHERE id = ? AND userID = ?", todoID, userID)
 	if err != nil {
 		return err
@@ -231,7 +214,7 @@ func (r *SQLiteRepository) deleteTodoByID(todoID int64, userID int64) error {
 	return nil
 }
 
-func (r *SQLiteRepository) deleteTodosByUserID(userID int64) error {
+func (r *SQLiteRepository) DeleteTodosByUserID(userID int64) error {
 	res, err := r.db.Exec("DELETE FROM todos WHERE userID = ?", userID)
 	if err != nil {
 		return err
@@ -249,7 +232,7 @@ func (r *SQLiteRepository) deleteTodosByUserID(userID int64) error {
 	return nil
 }
 
-func (r *SQLiteRepository) deleteUserByID(id int64) error {
+func (r *SQLiteRepository) DeleteUserByID(id int64) error {
 	res, err := r.db.Exec("DELETE FROM users WHERE id = ?", id)
 	if err != nil {
 		return err
@@ -267,31 +250,42 @@ func (r *SQLiteRepository) deleteUserByID(id int64) error {
 	return nil
 }
 
-func (r *SQLiteRepository) updateTodo(id int64, todo doit.Todo, userID int64) (*doit.Todo, error) {
+func (r *SQLiteRepository) UpdateTodo(id int64, todo doit.Todo, userID int64) (*doit.Todo, error) {
 	if id == 0 {
 		return nil, errors.New("invalid updated ID")
 	}
 
-	res, err := r.db.Exec("UPDATE todos SET title = ?, description = ?, stateID = ?, priorityID = ?, colorID = ?, does_expire = ?, expiration_date = ?, userID = ? WHERE id = ? AND userID = ?",
-		todo.Title, todo.Description, todo.StateID, todo.PriorityID, todo.ColorID, todo.Expiration.DoesExpire, todo.Expiration.Date.Unix(), todo.UserID, todo.ID, userID)
+	err := inTx(r.db, func(tx *sql.Tx) error {
+		if err := checkTodoProject(tx, noRebind, todo.ProjectID, todo.UserID); err != nil {
+			return err
+		}
 
-	if err != nil {
-		return nil, err
-	}
+		res, err := tx.Exec("UPDATE todos SET title = ?, description = ?, stateID = ?, priorityID = ?, colorID = ?, does_expire = ?, expiration_date = ?, userID = ?, recurrence = ?, projectID = ?, assigneeID = ? WHERE id = ? AND userID = ?",
+			todo.Title, todo.Description, todo.StateID, todo.PriorityID, todo.ColorID, todo.Expiration.DoesExpire, todo.Expiration.Date.Unix(), todo.UserID, todo.Recurrence, nullID(todo.ProjectID), nullID(todo.AssigneeID), todo.ID, userID)
 
-	rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrUpdateFailed
+		}
+
+		return setTodoTags(tx, noRebind, todo.ID, todo.UserID, todo.TagIDs)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	if rowsAffected == 0 {
-		return nil, ErrUpdateFailed
-	}
-
-	return &todo, nil
+	return r.GetTodoByID(todo.ID)
 }
 
-func (r *SQLiteRepository) updateUser(id int64, user doit.User) (*doit.User, error) {
+func (r *SQLiteRepository) UpdateUser(id int64, user doit.User) (*doit.User, error) {
 	if id == 0 {
 		return nil, errors.New("invalid updated ID")
 	}
@@ -315,7 +309,7 @@ func (r *SQLiteRepository) updateUser(id int64, user doit.User) (*doit.User, err
 	return &user, nil
 }
 
-func (r *SQLiteRepository) insertTodoStates(s []*doit.TodoState) error {
+func (r *SQLiteRepository) InsertTodoStates(s []*doit.TodoState) error {
 	for i := range s {
 		row := r.db.QueryRow("SELECT * FROM todo_states WHERE state = ?", s[i].State)
 
@@ -340,7 +334,21 @@ func (r *SQLiteRepository) insertTodoStates(s []*doit.TodoState) error {
 	return nil
 }
 
-func (r *SQLiteRepository) insertTodoPriorities(s []*doit.TodoPriority) error {
+func (r *SQLiteRepository) LoadTodoStates(s []*doit.TodoState) error {
+	for i := range s {
+		row := r.db.QueryRow("SELECT id FROM todo_states WHERE state = ?", s[i].State)
+
+		if err := row.Scan(&s[i].ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotExists
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) InsertTodoPriorities(s []*doit.TodoPriority) error {
 	for i := range s {
 		row := r.db.QueryRow("SELECT * FROM todo_priority WHERE priority = ?", s[i].Priority)
 
@@ -365,7 +373,21 @@ func (r *SQLiteRepository) insertTodoPriorities(s []*doit.TodoPriority) error {
 	return nil
 }
 
-func (r *SQLiteRepository) insertTodoColors(s []*doit.Color) error {
+func (r *SQLiteRepository) LoadTodoPriorities(s []*doit.TodoPriority) error {
+	for i := range s {
+		row := r.db.QueryRow("SELECT id FROM todo_priority WHERE priority = ?", s[i].Priority)
+
+		if err := row.Scan(&s[i].ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotExists
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) InsertTodoColors(s []*doit.Color) error {
 	for i := range s {
 		row := r.db.QueryRow("SELECT * FROM todo_colors WHERE color = ?", s[i].Hex)
 
@@ -390,7 +412,21 @@ func (r *SQLiteRepository) insertTodoColors(s []*doit.Color) error {
 	return nil
 }
 
-func (r *SQLiteRepository) getInternal(key string) ([]byte, error) {
+func (r *SQLiteRepository) LoadTodoColors(s []*doit.Color) error {
+	for i := range s {
+		row := r.db.QueryRow("SELECT id FROM todo_colors WHERE color = ?", s[i].Hex)
+
+		if err := row.Scan(&s[i].ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrNotExists
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *SQLiteRepository) GetInternal(key string) ([]byte, error) {
 	row := r.db.QueryRow("SELECT data FROM internals WHERE key = ?", key)
 
 	var blob []byte
@@ -405,13 +441,15 @@ func (r *SQLiteRepository) getInternal(key string) ([]byte, error) {
 	return blob, nil
 }
 
-func (r *SQLiteRepository) addInternal(key string, data []byte) error {
+func (r *SQLiteRepository) AddInternal(key string, data []byte) error {
 	_, err := r.db.Exec("INSERT INTO internals(key, data) values(?, ?)", key, data)
 
 	if err != nil {
 		var sqliteErr sqlite3.Error
 		if errors.As(err, &sqliteErr) {
-			if errors.Is(sqliteErr.ExtendedCode, sqlite3.ErrConstraintUnique) {
+			// The key is the primary key, so sqlite reports that one
+			if errors.Is(sqliteErr.ExtendedCode, sqlite3.ErrConstraintUnique) ||
+				errors.Is(sqliteErr.ExtendedCode, sqlite3.ErrConstraintPrimaryKey) {
 				return ErrDuplicate
 			}
 		}
@@ -420,3 +458,37 @@ func (r *SQLiteRepository) addInternal(key string, data []byte) error {
 
 	return nil
 }
+
+// Return the todos of the user that match the filter, and the total number of
+// matching todos ignoring limit and offset
+func (r *SQLiteRepository) FilterTodos(userID int64, f TodoFilter) ([]doit.Todo, int64, error) {
+	where, args := f.where(userID)
+	order, err := f.orderAndLimit()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var total int64
+	row := r.db.QueryRow("SELECT COUNT(*) FROM todos "+where, args...)
+	if err := row.Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.Query("SELECT "+sqliteTodoColumns+" FROM todos "+where+" "+order, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var all []doit.Todo
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		all = append(all, *todo)
+	}
+
+	return all, total, nil
+}
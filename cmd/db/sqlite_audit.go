@@ -0,0 +1,68 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func scanAuditEvent(row rowScanner) (*doit.AuditEvent, error) {
+	var e doit.AuditEvent
+	var t int64
+	err := row.Scan(&e.ID, &e.Type, &e.ActorID, &e.ActorName, &e.TargetID, &e.TargetName, &e.IP, &e.UserAgent, &t, &e.Details)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExists
+		}
+		return nil, err
+	}
+
+	e.Time = time.Unix(t, 0)
+	return &e, nil
+}
+
+func (r *SQLiteRepository) CreateAuditEvent(e doit.AuditEvent) (*doit.AuditEvent, error) {
+	row := r.db.QueryRow("INSERT INTO audit_log(type, actorID, actorName, targetID, targetName, ip, userAgent, time, details) values(?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id",
+		e.Type, e.ActorID, e.ActorName, e.TargetID, e.TargetName, e.IP, e.UserAgent, e.Time.Unix(), e.Details)
+	err := row.Scan(&e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+// Return the events that match the filter, from the newest
+func (r *SQLiteRepository) AuditEvents(f AuditFilter) ([]doit.AuditEvent, error) {
+	where, args := f.where()
+	q := "SELECT id, type, actorID, actorName, targetID, targetName, ip, userAgent, time, details FROM audit_log WHERE " + where + " ORDER BY time DESC, id DESC"
+	if f.Limit > 0 || f.Offset > 0 {
+		limit := int64(f.Limit)
+		if limit == 0 {
+			limit = math.MaxInt64
+		}
+		q += " LIMIT ? OFFSET ?"
+		args = append(args, limit, f.Offset)
+	}
+
+	rows, err := r.db.Query(noRebind(q), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.AuditEvent
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *e)
+	}
+
+	return all, rows.Err()
+}
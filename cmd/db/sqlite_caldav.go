@@ -0,0 +1,17 @@
+package db
+
+import (
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func (r *SQLiteRepository) SetCalDAVObject(o doit.CalDAVObject) error {
+	return setCalDAVObject(r.db, noRebind, o)
+}
+
+func (r *SQLiteRepository) CalDAVObjects(todoIDs []int64) ([]doit.CalDAVObject, error) {
+	return calDAVObjects(r.db, noRebind, todoIDs)
+}
+
+func (r *SQLiteRepository) CalDAVObjectsByName(name string) ([]doit.CalDAVObject, error) {
+	return calDAVObjectsByName(r.db, noRebind, name)
+}
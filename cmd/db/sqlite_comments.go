@@ -0,0 +1,108 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Columns read by scanComment, with the FROM clause. The username of the
+// author is joined so clients don't need to look it up.
+const commentColumns = `comments.id, comments.todoID, comments.userID, users.username,
+  comments.body, comments.created, comments.edited
+  FROM comments JOIN users ON users.id = comments.userID`
+
+func scanComment(row rowScanner) (*doit.Comment, error) {
+	var c doit.Comment
+	var created, edited int64
+	err := row.Scan(&c.ID, &c.TodoID, &c.UserID, &c.Username, &c.Body, &created, &edited)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExists
+		}
+		return nil, err
+	}
+
+	c.Created = time.Unix(created, 0)
+	c.Edited = timeOrZero(edited)
+	return &c, nil
+}
+
+func (r *SQLiteRepository) CreateComment(c doit.Comment) (*doit.Comment, error) {
+	row := r.db.QueryRow("INSERT INTO comments(todoID, userID, body, created, edited) values(?, ?, ?, ?, ?) RETURNING id",
+		c.TodoID, c.UserID, c.Body, c.Created.Unix(), unixOrZero(c.Edited))
+	err := row.Scan(&c.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetCommentByID(c.ID, c.TodoID)
+}
+
+// The comments of the todo, from the oldest
+func (r *SQLiteRepository) AllComments(todoID int64) ([]doit.Comment, error) {
+	rows, err := r.db.Query("SELECT "+commentColumns+" WHERE comments.todoID = ? ORDER BY comments.created, comments.id", todoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.Comment
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *c)
+	}
+
+	return all, rows.Err()
+}
+
+// Get comment with id commentID only if todoID match
+func (r *SQLiteRepository) GetCommentByID(commentID int64, todoID int64) (*doit.Comment, error) {
+	row := r.db.QueryRow("SELECT "+commentColumns+" WHERE comments.id = ? AND comments.todoID = ?", commentID, todoID)
+	return scanComment(row)
+}
+
+// Update the body and the edited time of the comment with id commentID only if
+// todoID match
+func (r *SQLiteRepository) UpdateComment(commentID int64, todoID int64, c doit.Comment) (*doit.Comment, error) {
+	res, err := r.db.Exec("UPDATE comments SET body = ?, edited = ? WHERE id = ? AND todoID = ?", c.Body, unixOrZero(c.Edited), commentID, todoID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrUpdateFailed
+	}
+
+	return r.GetCommentByID(commentID, todoID)
+}
+
+// Delete comment with id commentID only if todoID match
+func (r *SQLiteRepository) DeleteCommentByID(commentID int64, todoID int64) error {
+	res, err := r.db.Exec("DELETE FROM comments WHERE id = ? AND todoID = ?", commentID, todoID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
@@ -0,0 +1,7 @@
+//go:build sqlite_fts5 || fts5
+
+package db
+
+// The full-text search on SQLite needs FTS5, that go-sqlite3 includes only
+// with the sqlite_fts5 build tag
+const sqliteFTS5 = true
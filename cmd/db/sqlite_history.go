@@ -0,0 +1,89 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Columns read by scanTodoEvent, with the FROM clause
+const todoEventColumns = `todo_events.id, todo_events.todoID, todo_events.userID,
+  users.username, todo_events.action, todo_events.time, todo_events.changes, todo_events.todo
+  FROM todo_events LEFT JOIN users ON users.id = todo_events.userID`
+
+func scanTodoEvent(row rowScanner) (*doit.TodoEvent, error) {
+	var e doit.TodoEvent
+	var userID sql.NullInt64
+	var username sql.NullString
+	var t int64
+	var changes, todo string
+	err := row.Scan(&e.ID, &e.TodoID, &userID, &username, &e.Action, &t, &changes, &todo)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExists
+		}
+		return nil, err
+	}
+
+	e.UserID = userID.Int64
+	e.Username = username.String
+	e.Time = time.Unix(t, 0)
+	if err := json.Unmarshal([]byte(changes), &e.Changes); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(todo), &e.Todo); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Add the event to the history of its todo
+func (r *SQLiteRepository) CreateTodoEvent(e doit.TodoEvent) (*doit.TodoEvent, error) {
+	changes, err := json.Marshal(e.Changes)
+	if err != nil {
+		return nil, err
+	}
+	todo, err := json.Marshal(e.Todo)
+	if err != nil {
+		return nil, err
+	}
+
+	row := r.db.QueryRow("INSERT INTO todo_events(todoID, userID, action, time, changes, todo) values(?, ?, ?, ?, ?, ?) RETURNING id",
+		e.TodoID, nullID(e.UserID), e.Action, e.Time.Unix(), string(changes), string(todo))
+	err = row.Scan(&e.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetTodoEventByID(e.ID, e.TodoID)
+}
+
+// The history of the todo, from the oldest event
+func (r *SQLiteRepository) TodoEvents(todoID int64) ([]doit.TodoEvent, error) {
+	rows, err := r.db.Query("SELECT "+todoEventColumns+" WHERE todo_events.todoID = ? ORDER BY todo_events.time, todo_events.id", todoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.TodoEvent
+	for rows.Next() {
+		e, err := scanTodoEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *e)
+	}
+
+	return all, rows.Err()
+}
+
+// Get event with id eventID only if todoID match
+func (r *SQLiteRepository) GetTodoEventByID(eventID int64, todoID int64) (*doit.TodoEvent, error) {
+	row := r.db.QueryRow("SELECT "+todoEventColumns+" WHERE todo_events.id = ? AND todo_events.todoID = ?", eventID, todoID)
+	return scanTodoEvent(row)
+}
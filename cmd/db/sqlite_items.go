@@ -0,0 +1,114 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func scanTodoItem(row rowScanner) (*doit.TodoItem, error) {
+	var item doit.TodoItem
+	err := row.Scan(&item.ID, &item.TodoID, &item.Text, &item.Done, &item.Position)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExists
+		}
+		return nil, err
+	}
+	return &item, nil
+}
+
+// Add the item at the end of the checklist of its todo
+func (r *SQLiteRepository) CreateTodoItem(item doit.TodoItem) (*doit.TodoItem, error) {
+	row := r.db.QueryRow("INSERT INTO todo_items(todoID, text, done, position) values(?, ?, ?, (SELECT COUNT(*) FROM todo_items WHERE todoID = ?)) RETURNING id, position",
+		item.TodoID, item.Text, item.Done, item.TodoID)
+
+	err := row.Scan(&item.ID, &item.Position)
+	if err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+func (r *SQLiteRepository) AllTodoItems(todoID int64) ([]doit.TodoItem, error) {
+	rows, err := r.db.Query("SELECT id, todoID, text, done, position FROM todo_items WHERE todoID = ? ORDER BY position, id", todoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.TodoItem
+	for rows.Next() {
+		item, err := scanTodoItem(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *item)
+	}
+
+	return all, nil
+}
+
+// Get item with id itemID only if todoID match
+func (r *SQLiteRepository) GetTodoItemByID(itemID int64, todoID int64) (*doit.TodoItem, error) {
+	row := r.db.QueryRow("SELECT id, todoID, text, done, position FROM todo_items WHERE id = ? AND todoID = ?", itemID, todoID)
+	return scanTodoItem(row)
+}
+
+// Update item with id itemID only if todoID match. If the position changes
+// the other items are shifted, the position is clamped to the checklist.
+func (r *SQLiteRepository) UpdateTodoItem(itemID int64, todoID int64, item doit.TodoItem) (*doit.TodoItem, error) {
+	err := inTx(r.db, func(tx *sql.Tx) error {
+		var old, count int64
+		row := tx.QueryRow("SELECT position, (SELECT COUNT(*) FROM todo_items WHERE todoID = ?) FROM todo_items WHERE id = ? AND todoID = ?", todoID, itemID, todoID)
+		if err := row.Scan(&old, &count); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUpdateFailed
+			}
+			return err
+		}
+
+		item.Position = max(0, min(item.Position, count-1))
+
+		var err error
+		if item.Position < old {
+			_, err = tx.Exec("UPDATE todo_items SET position = position + 1 WHERE todoID = ? AND position >= ? AND position < ?", todoID, item.Position, old)
+		} else if item.Position > old {
+			_, err = tx.Exec("UPDATE todo_items SET position = position - 1 WHERE todoID = ? AND position > ? AND position <= ?", todoID, old, item.Position)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE todo_items SET text = ?, done = ?, position = ? WHERE id = ?", item.Text, item.Done, item.Position, itemID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	item.ID = itemID
+	item.TodoID = todoID
+	return &item, nil
+}
+
+// Delete item with id itemID only if todoID match. The following items are
+// shifted back.
+func (r *SQLiteRepository) DeleteTodoItemByID(itemID int64, todoID int64) error {
+	return inTx(r.db, func(tx *sql.Tx) error {
+		var position int64
+		row := tx.QueryRow("DELETE FROM todo_items WHERE id = ? AND todoID = ? RETURNING position", itemID, todoID)
+		if err := row.Scan(&position); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrDeleteFailed
+			}
+			return err
+		}
+
+		_, err := tx.Exec("UPDATE todo_items SET position = position - 1 WHERE todoID = ? AND position > ?", todoID, position)
+		return err
+	})
+}
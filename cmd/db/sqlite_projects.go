@@ -0,0 +1,104 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func scanProject(row rowScanner) (*doit.Project, error) {
+	var p doit.Project
+	err := row.Scan(&p.ID, &p.UserID, &p.Name, &p.Archived)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExists
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *SQLiteRepository) CreateProject(p doit.Project) (*doit.Project, error) {
+	res, err := r.db.Exec("INSERT INTO projects(userID, name, archived) values(?, ?, ?)", p.UserID, p.Name, p.Archived)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	p.ID = id
+	return &p, nil
+}
+
+// Return the projects of the user and the ones shared with him
+func (r *SQLiteRepository) AllProjects(userID int64) ([]doit.Project, error) {
+	rows, err := r.db.Query(`SELECT id, userID, name, archived FROM projects
+    WHERE userID = ? OR id IN (SELECT projectID FROM project_shares WHERE userID = ?)
+    ORDER BY name, id`, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.Project
+	for rows.Next() {
+		p, err := scanProject(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *p)
+	}
+
+	return all, nil
+}
+
+func (r *SQLiteRepository) GetProjectByID(id int64) (*doit.Project, error) {
+	row := r.db.QueryRow("SELECT id, userID, name, archived FROM projects WHERE id = ?", id)
+	return scanProject(row)
+}
+
+// Update project with id projectID only if userID match
+func (r *SQLiteRepository) UpdateProject(projectID int64, userID int64, p doit.Project) (*doit.Project, error) {
+	res, err := r.db.Exec("UPDATE projects SET name = ?, archived = ? WHERE id = ? AND userID = ?", p.Name, p.Archived, projectID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrUpdateFailed
+	}
+
+	p.ID = projectID
+	p.UserID = userID
+	return &p, nil
+}
+
+// Delete project with id projectID only if userID match. Its todos are
+// moved to the inbox.
+func (r *SQLiteRepository) DeleteProjectByID(projectID int64, userID int64) error {
+	res, err := r.db.Exec("DELETE FROM projects WHERE id = ? AND userID = ?", projectID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
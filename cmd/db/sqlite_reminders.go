@@ -0,0 +1,31 @@
+package db
+
+import (
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func (r *SQLiteRepository) ExpiringTodos(from time.Time, to time.Time) ([]doit.Todo, error) {
+	return expiringTodos(r.db, noRebind, sqliteTodoColumns, from, to)
+}
+
+func (r *SQLiteRepository) ClaimReminder(reminder doit.Reminder) (bool, error) {
+	return claimReminder(r.db, noRebind, reminder)
+}
+
+func (r *SQLiteRepository) DeleteReminder(reminder doit.Reminder) error {
+	return deleteReminder(r.db, noRebind, reminder)
+}
+
+func (r *SQLiteRepository) LastDigest(userID int64) (time.Time, error) {
+	return lastDigest(r.db, noRebind, userID)
+}
+
+func (r *SQLiteRepository) ClaimDigest(userID int64, at time.Time, since time.Time) (bool, error) {
+	return claimDigest(r.db, noRebind, userID, at, since)
+}
+
+func (r *SQLiteRepository) SetLastDigest(userID int64, at time.Time) error {
+	return setLastDigest(r.db, noRebind, userID, at)
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Build an FTS query that matches all the words in q. Every word is quoted so
+// the FTS syntax can't be used (and can't produce syntax errors).
+func ftsQuery(q string) string {
+	words := strings.Fields(q)
+	for i := range words {
+		words[i] = `"` + strings.ReplaceAll(words[i], `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// Full-text search in the todos visible to the user. Results are ordered by
+// rank, limit 0 means no limit.
+func (r *SQLiteRepository) SearchTodos(userID int64, query string, limit int) ([]doit.TodoSearchResult, error) {
+	q := ftsQuery(query)
+	if q == "" {
+		return nil, nil
+	}
+
+	// bm25 is lower for better matches. Matches in the title weight more than
+	// the ones in the description.
+	visible, args := visibleTodos(userID)
+	sqlQuery := `SELECT ` + sqliteTodoColumns + `, -bm25(todos_fts, 2.0, 1.0) AS rank, snippet(todos_fts, -1, ?, ?, '...', 15)
+    FROM todos_fts JOIN todos ON todos.id = todos_fts.rowid
+    WHERE todos_fts MATCH ? AND ` + visible + `
+    ORDER BY rank DESC, todos.id`
+	args = append([]any{snippetStart, snippetStop, q}, args...)
+	if limit > 0 {
+		sqlQuery += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := r.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.TodoSearchResult
+	for rows.Next() {
+		var res doit.TodoSearchResult
+		todo, err := scanTodo(rows, &res.Rank, &res.Snippet)
+		if err != nil {
+			return nil, err
+		}
+
+		res.Todo = *todo
+		res.Snippet = htmlSnippet(res.Snippet)
+		all = append(all, res)
+	}
+
+	return all, rows.Err()
+}
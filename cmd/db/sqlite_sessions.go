@@ -0,0 +1,157 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSession(row rowScanner) (*doit.Session, error) {
+	var s doit.Session
+	var created, expire, lastSeen int64
+	err := row.Scan(&s.ID, &s.TokenHash, &s.UserID, &created, &expire, &lastSeen, &s.UserAgent, &s.IP)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExists
+		}
+		return nil, err
+	}
+
+	s.Created = time.Unix(created, 0)
+	s.Expire = time.Unix(expire, 0)
+	s.LastSeen = time.Unix(lastSeen, 0)
+	return &s, nil
+}
+
+func (r *SQLiteRepository) CreateSession(s doit.Session) (*doit.Session, error) {
+	res, err := r.db.Exec("INSERT INTO sessions(token_hash, userID, created, expire, last_seen, user_agent, ip) values(?, ?, ?, ?, ?, ?, ?)",
+		s.TokenHash, s.UserID, s.Created.Unix(), s.Expire.Unix(), s.LastSeen.Unix(), s.UserAgent, s.IP)
+
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) {
+			if errors.Is(sqliteErr.ExtendedCode, sqlite3.ErrConstraintUnique) {
+				return nil, ErrDuplicate
+			}
+		}
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	s.ID = id
+	return &s, nil
+}
+
+func (r *SQLiteRepository) GetSessionByTokenHash(hash string) (*doit.Session, error) {
+	row := r.db.QueryRow("SELECT * FROM sessions WHERE token_hash = ?", hash)
+	return scanSession(row)
+}
+
+func (r *SQLiteRepository) AllSessions(userID int64) ([]doit.Session, error) {
+	rows, err := r.db.Query("SELECT * FROM sessions WHERE userID = ? ORDER BY last_seen DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.Session
+	for rows.Next() {
+		s, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *s)
+	}
+
+	return all, nil
+}
+
+func (r *SQLiteRepository) UpdateSessionLastSeen(id int64, lastSeen time.Time) error {
+	res, err := r.db.Exec("UPDATE sessions SET last_seen = ? WHERE id = ?", lastSeen.Unix(), id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrUpdateFailed
+	}
+
+	return nil
+}
+
+// Delete session with id sessionID only if userID match
+func (r *SQLiteRepository) DeleteSessionByID(sessionID int64, userID int64) error {
+	res, err := r.db.Exec("DELETE FROM sessions WHERE id = ? AND userID = ?", sessionID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
+
+func (r *SQLiteRepository) DeleteSessionByTokenHash(hash string) error {
+	res, err := r.db.Exec("DELETE FROM sessions WHERE token_hash = ?", hash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
+
+// Delete all the sessions expired before now. Return the number of sessions
+// deleted.
+func (r *SQLiteRepository) DeleteExpiredSessions(now time.Time) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM sessions WHERE expire < ?", now.Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Delete all the sessions of the user but exceptID, 0 to delete them all.
+// Return the number of sessions deleted.
+func (r *SQLiteRepository) DeleteUserSessions(userID int64, exceptID int64) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM sessions WHERE userID = ? AND id != ?", userID, exceptID)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Return the permission granted to userID on the todo, directly or through
+// its project, or "" if the todo is not shared with him
+func (r *SQLiteRepository) TodoSharePermission(todoID int64, userID int64) (string, error) {
+	return todoSharePermission(r.db, noRebind, todoID, userID)
+}
+
+// Return the permission granted to userID on the project, or "" if the
+// project is not shared with him
+func (r *SQLiteRepository) ProjectSharePermission(projectID int64, userID int64) (string, error) {
+	return projectSharePermission(r.db, noRebind, projectID, userID)
+}
+
+// Share the todo with userID, or change the permission if already shared
+func (r *SQLiteRepository) ShareTodo(todoID int64, userID int64, permission string) error {
+	return share(r.db, noRebind, "todo_shares", "todoID", todoID, userID, permission)
+}
+
+func (r *SQLiteRepository) UnshareTodo(todoID int64, userID int64) error {
+	return unshare(r.db, noRebind, "todo_shares", "todoID", todoID, userID)
+}
+
+func (r *SQLiteRepository) TodoShares(todoID int64) ([]doit.Share, error) {
+	return allShares(r.db, noRebind, "todo_shares", "todoID", todoID)
+}
+
+// Share the project with userID, or change the permission if already shared
+func (r *SQLiteRepository) ShareProject(projectID int64, userID int64, permission string) error {
+	return share(r.db, noRebind, "project_shares", "projectID", projectID, userID, permission)
+}
+
+func (r *SQLiteRepository) UnshareProject(projectID int64, userID int64) error {
+	return unshare(r.db, noRebind, "project_shares", "projectID", projectID, userID)
+}
+
+func (r *SQLiteRepository) ProjectShares(projectID int64) ([]doit.Share, error) {
+	return allShares(r.db, noRebind, "project_shares", "projectID", projectID)
+}
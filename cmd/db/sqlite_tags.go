@@ -0,0 +1,114 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func scanTag(row rowScanner) (*doit.Tag, error) {
+	var tag doit.Tag
+	err := row.Scan(&tag.ID, &tag.UserID, &tag.Name, &tag.Color)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExists
+		}
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// Map the unique violation on the name of the tag to ErrDuplicate
+func sqliteTagError(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		if errors.Is(sqliteErr.ExtendedCode, sqlite3.ErrConstraintUnique) {
+			return ErrDuplicate
+		}
+	}
+	return err
+}
+
+func (r *SQLiteRepository) CreateTag(tag doit.Tag) (*doit.Tag, error) {
+	res, err := r.db.Exec("INSERT INTO tags(userID, name, color) values(?, ?, ?)", tag.UserID, tag.Name, tag.Color)
+	if err != nil {
+		return nil, sqliteTagError(err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	tag.ID = id
+	return &tag, nil
+}
+
+func (r *SQLiteRepository) AllTags(userID int64) ([]doit.Tag, error) {
+	rows, err := r.db.Query("SELECT id, userID, name, color FROM tags WHERE userID = ? ORDER BY name", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.Tag
+	for rows.Next() {
+		tag, err := scanTag(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *tag)
+	}
+
+	return all, nil
+}
+
+// Get tag with id tagID only if userID match
+func (r *SQLiteRepository) GetTagByID(tagID int64, userID int64) (*doit.Tag, error) {
+	row := r.db.QueryRow("SELECT id, userID, name, color FROM tags WHERE id = ? AND userID = ?", tagID, userID)
+	return scanTag(row)
+}
+
+// Update tag with id tagID only if userID match
+func (r *SQLiteRepository) UpdateTag(tagID int64, userID int64, tag doit.Tag) (*doit.Tag, error) {
+	res, err := r.db.Exec("UPDATE tags SET name = ?, color = ? WHERE id = ? AND userID = ?", tag.Name, tag.Color, tagID, userID)
+	if err != nil {
+		return nil, sqliteTagError(err)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrUpdateFailed
+	}
+
+	tag.ID = tagID
+	tag.UserID = userID
+	return &tag, nil
+}
+
+// Delete tag with id tagID only if userID match. The tag is removed from all
+// the todos.
+func (r *SQLiteRepository) DeleteTagByID(tagID int64, userID int64) error {
+	res, err := r.db.Exec("DELETE FROM tags WHERE id = ? AND userID = ?", tagID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
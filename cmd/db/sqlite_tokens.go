@@ -0,0 +1,130 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Zero time is stored as 0
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func timeOrZero(t int64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(t, 0)
+}
+
+func scanAPIToken(row rowScanner) (*doit.APIToken, error) {
+	var t doit.APIToken
+	var scopes string
+	var created, expire, lastUsed int64
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.TokenHash, &scopes, &created, &expire, &lastUsed)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExists
+		}
+		return nil, err
+	}
+
+	t.Scopes = strings.Fields(scopes)
+	t.Created = time.Unix(created, 0)
+	t.Expire = timeOrZero(expire)
+	t.LastUsed = timeOrZero(lastUsed)
+	return &t, nil
+}
+
+func (r *SQLiteRepository) CreateAPIToken(t doit.APIToken) (*doit.APIToken, error) {
+	res, err := r.db.Exec("INSERT INTO api_tokens(userID, name, token_hash, scopes, created, expire, last_used) values(?, ?, ?, ?, ?, ?, ?)",
+		t.UserID, t.Name, t.TokenHash, strings.Join(t.Scopes, " "), t.Created.Unix(), unixOrZero(t.Expire), unixOrZero(t.LastUsed))
+
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) {
+			if errors.Is(sqliteErr.ExtendedCode, sqlite3.ErrConstraintUnique) {
+				return nil, ErrDuplicate
+			}
+		}
+		return nil, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	t.ID = id
+	return &t, nil
+}
+
+func (r *SQLiteRepository) GetAPITokenByTokenHash(hash string) (*doit.APIToken, error) {
+	row := r.db.QueryRow("SELECT * FROM api_tokens WHERE token_hash = ?", hash)
+	return scanAPIToken(row)
+}
+
+func (r *SQLiteRepository) AllAPITokens(userID int64) ([]doit.APIToken, error) {
+	rows, err := r.db.Query("SELECT * FROM api_tokens WHERE userID = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *t)
+	}
+
+	return all, nil
+}
+
+func (r *SQLiteRepository) UpdateAPITokenLastUsed(id int64, lastUsed time.Time) error {
+	res, err := r.db.Exec("UPDATE api_tokens SET last_used = ? WHERE id = ?", lastUsed.Unix(), id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrUpdateFailed
+	}
+
+	return nil
+}
+
+// Delete token with id tokenID only if userID match
+func (r *SQLiteRepository) DeleteAPITokenByID(tokenID int64, userID int64) error {
+	res, err := r.db.Exec("DELETE FROM api_tokens WHERE id = ? AND userID = ?", tokenID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
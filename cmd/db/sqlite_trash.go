@@ -0,0 +1,77 @@
+package db
+
+import (
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Move the todo with id todoID to the trash only if userID match
+func (r *SQLiteRepository) TrashTodo(todoID int64, userID int64, at time.Time) error {
+	res, err := r.db.Exec("UPDATE todos SET deleted_at = ? WHERE id = ? AND userID = ? AND deleted_at IS NULL", at.Unix(), todoID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
+
+// Move the todo with id todoID out of the trash only if userID match
+func (r *SQLiteRepository) RestoreTodo(todoID int64, userID int64) error {
+	res, err := r.db.Exec("UPDATE todos SET deleted_at = NULL WHERE id = ? AND userID = ? AND deleted_at IS NOT NULL", todoID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrUpdateFailed
+	}
+
+	return nil
+}
+
+// The todos of the user in the trash, from the last deleted
+func (r *SQLiteRepository) TrashedTodos(userID int64) ([]doit.Todo, error) {
+	rows, err := r.db.Query("SELECT "+sqliteTodoColumns+" FROM todos WHERE userID = ? AND deleted_at IS NOT NULL ORDER BY deleted_at DESC, id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.Todo
+	for rows.Next() {
+		todo, err := scanTodo(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, *todo)
+	}
+
+	return all, rows.Err()
+}
+
+// Permanently delete the todos moved to the trash before the time, and return
+// how many were deleted
+func (r *SQLiteRepository) PurgeTrash(before time.Time) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM todos WHERE deleted_at < ?", before.Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
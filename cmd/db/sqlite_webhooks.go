@@ -0,0 +1,37 @@
+package db
+
+import (
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+func (r *SQLiteRepository) CreateWebhook(w doit.Webhook) (*doit.Webhook, error) {
+	return createWebhook(r.db, noRebind, w)
+}
+
+func (r *SQLiteRepository) GetWebhookByID(id int64) (*doit.Webhook, error) {
+	return getWebhookByID(r.db, noRebind, id)
+}
+
+func (r *SQLiteRepository) AllWebhooks(userID int64) ([]doit.Webhook, error) {
+	return allWebhooks(r.db, noRebind, userID)
+}
+
+func (r *SQLiteRepository) ActiveWebhooks(userID int64) ([]doit.Webhook, error) {
+	return activeWebhooks(r.db, noRebind, userID)
+}
+
+func (r *SQLiteRepository) UpdateWebhook(w doit.Webhook) (*doit.Webhook, error) {
+	return updateWebhook(r.db, noRebind, w)
+}
+
+func (r *SQLiteRepository) DeleteWebhookByID(id int64, userID int64) error {
+	return deleteWebhookByID(r.db, noRebind, id, userID)
+}
+
+func (r *SQLiteRepository) CreateWebhookDelivery(d doit.WebhookDelivery) (*doit.WebhookDelivery, error) {
+	return createWebhookDelivery(r.db, noRebind, d)
+}
+
+func (r *SQLiteRepository) WebhookDeliveries(webhookID int64) ([]doit.WebhookDelivery, error) {
+	return webhookDeliveries(r.db, noRebind, webhookID)
+}
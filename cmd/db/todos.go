@@ -0,0 +1,151 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+var (
+	ErrInvalidTag     = errors.New("tag does not exist")
+	ErrInvalidProject = errors.New("project does not exist")
+)
+
+// Columns read by scanTodo, followed by the tags column of the driver. They
+// are qualified so they can be used in joins. The progress is computed from
+// the checklist items of the todo.
+const todoBaseColumns = `todos.id, todos.title, todos.description, todos.stateID,
+  todos.priorityID, todos.colorID, todos.does_expire, todos.expiration_date,
+  todos.userID, todos.recurrence, todos.projectID, todos.assigneeID, todos.deleted_at,
+  (SELECT CASE WHEN COUNT(*) = 0 THEN 0 ELSE 100 * SUM(CASE WHEN done THEN 1 ELSE 0 END) / COUNT(*) END
+    FROM todo_items WHERE todo_items.todoID = todos.id)`
+
+// The tags of the todo as a comma separated list of IDs, NULL if there are
+// none
+const (
+	sqliteTodoColumns   = todoBaseColumns + `, (SELECT GROUP_CONCAT(tagID) FROM todo_tags WHERE todo_tags.todoID = todos.id)`
+	postgresTodoColumns = todoBaseColumns + `, (SELECT string_agg(tagID::text, ',') FROM todo_tags WHERE todo_tags.todoID = todos.id)`
+)
+
+// Scan a row selected with the todo columns of the driver. Extra destinations
+// are scanned after the columns of the todo.
+func scanTodo(row rowScanner, extra ...any) (*doit.Todo, error) {
+	var todo doit.Todo
+	var t int64
+	var projectID, assigneeID, deletedAt sql.NullInt64
+	var tags sql.NullString
+	dest := []any{&todo.ID, &todo.Title, &todo.Description, &todo.StateID, &todo.PriorityID, &todo.ColorID, &todo.Expiration.DoesExpire, &t, &todo.UserID, &todo.Recurrence, &projectID, &assigneeID, &deletedAt, &todo.Progress, &tags}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExists
+		}
+		return nil, err
+	}
+
+	if todo.Expiration.DoesExpire {
+		todo.Expiration.Date = time.Unix(t, 0)
+	}
+	todo.ProjectID = projectID.Int64
+	todo.AssigneeID = assigneeID.Int64
+	if deletedAt.Valid {
+		todo.DeletedAt = time.Unix(deletedAt.Int64, 0)
+	}
+
+	if tags.Valid {
+		for _, id := range strings.Split(tags.String, ",") {
+			tagID, err := strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			todo.TagIDs = append(todo.TagIDs, tagID)
+		}
+		slices.Sort(todo.TagIDs)
+	}
+	return &todo, nil
+}
+
+// Replace the tags of the todo. Nothing is done if tagIDs is nil, all the
+// tags are removed if it's empty. All the tags must belong to userID. The
+// queries are passed through bind so the placeholders of the driver are used.
+func setTodoTags(tx *sql.Tx, bind func(string) string, todoID int64, userID int64, tagIDs []int64) error {
+	if tagIDs == nil {
+		return nil
+	}
+
+	ids := slices.Clone(tagIDs)
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+
+	if len(ids) > 0 {
+		in, args := inClause("id", ids, []any{userID})
+		var n int
+		row := tx.QueryRow(bind("SELECT COUNT(*) FROM tags WHERE userID = ? AND "+in), args...)
+		if err := row.Scan(&n); err != nil {
+			return err
+		}
+		if n != len(ids) {
+			return ErrInvalidTag
+		}
+	}
+
+	_, err := tx.Exec(bind("DELETE FROM todo_tags WHERE todoID = ?"), todoID)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		_, err := tx.Exec(bind("INSERT INTO todo_tags(todoID, tagID) values(?, ?)"), todoID, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return an error if the project is not 0 (the inbox) and does not belong to
+// userID
+func checkTodoProject(tx *sql.Tx, bind func(string) string, projectID int64, userID int64) error {
+	if projectID == 0 {
+		return nil
+	}
+
+	var n int
+	row := tx.QueryRow(bind("SELECT COUNT(*) FROM projects WHERE id = ? AND userID = ?"), projectID, userID)
+	if err := row.Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidProject
+	}
+	return nil
+}
+
+// Convert an optional ID, where 0 means none, to a nullable value
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+// Used as bind by the drivers with ? placeholders
+func noRebind(query string) string {
+	return query
+}
+
+// Run f in a transaction, committed only if f returns nil
+func inTx(db *sql.DB, f func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := f(tx); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
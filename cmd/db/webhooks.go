@@ -0,0 +1,154 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Number of deliveries kept in the log of each webhook, the older are deleted
+const WEBHOOK_DELIVERIES_KEPT = 100
+
+const webhookColumns = "id, userID, url, secret, events, global, active, created"
+
+func scanWebhook(row rowScanner) (*doit.Webhook, error) {
+	var w doit.Webhook
+	var events string
+	var created int64
+	err := row.Scan(&w.ID, &w.UserID, &w.URL, &w.Secret, &events, &w.Global, &w.Active, &created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotExists
+		}
+		return nil, err
+	}
+
+	w.Events = strings.Fields(events)
+	w.Created = time.Unix(created, 0)
+	return &w, nil
+}
+
+func queryWebhooks(db *sql.DB, query string, args ...any) ([]doit.Webhook, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, *w)
+	}
+
+	return all, rows.Err()
+}
+
+func createWebhook(db *sql.DB, bind func(string) string, w doit.Webhook) (*doit.Webhook, error) {
+	row := db.QueryRow(bind("INSERT INTO webhooks(userID, url, secret, events, global, active, created) values(?, ?, ?, ?, ?, ?, ?) RETURNING id"),
+		w.UserID, w.URL, w.Secret, strings.Join(w.Events, " "), w.Global, w.Active, w.Created.Unix())
+	err := row.Scan(&w.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &w, nil
+}
+
+func getWebhookByID(db *sql.DB, bind func(string) string, id int64) (*doit.Webhook, error) {
+	return scanWebhook(db.QueryRow(bind("SELECT "+webhookColumns+" FROM webhooks WHERE id = ?"), id))
+}
+
+func allWebhooks(db *sql.DB, bind func(string) string, userID int64) ([]doit.Webhook, error) {
+	return queryWebhooks(db, bind("SELECT "+webhookColumns+" FROM webhooks WHERE userID = ? ORDER BY id"), userID)
+}
+
+// The owner of the webhook must still be active, and an admin for the global
+// ones
+func activeWebhooks(db *sql.DB, bind func(string) string, userID int64) ([]doit.Webhook, error) {
+	return queryWebhooks(db, bind(`SELECT `+webhookColumns+` FROM webhooks WHERE active AND (global OR userID = ?)
+  AND userID IN (SELECT id FROM users WHERE active AND (admin OR id = ?)) ORDER BY id`), userID, userID)
+}
+
+func updateWebhook(db *sql.DB, bind func(string) string, w doit.Webhook) (*doit.Webhook, error) {
+	res, err := db.Exec(bind("UPDATE webhooks SET url = ?, secret = ?, events = ?, global = ?, active = ? WHERE id = ? AND userID = ?"),
+		w.URL, w.Secret, strings.Join(w.Events, " "), w.Global, w.Active, w.ID, w.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrUpdateFailed
+	}
+
+	return getWebhookByID(db, bind, w.ID)
+}
+
+func deleteWebhookByID(db *sql.DB, bind func(string) string, id int64, userID int64) error {
+	res, err := db.Exec(bind("DELETE FROM webhooks WHERE id = ? AND userID = ?"), id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrDeleteFailed
+	}
+
+	return nil
+}
+
+func createWebhookDelivery(db *sql.DB, bind func(string) string, d doit.WebhookDelivery) (*doit.WebhookDelivery, error) {
+	err := inTx(db, func(tx *sql.Tx) error {
+		row := tx.QueryRow(bind("INSERT INTO webhook_deliveries(webhookID, payloadID, event, attempt, status_code, error, time, payload) values(?, ?, ?, ?, ?, ?, ?, ?) RETURNING id"),
+			d.WebhookID, d.PayloadID, d.Event, d.Attempt, d.StatusCode, d.Error, d.Time.Unix(), d.Payload)
+		if err := row.Scan(&d.ID); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(bind(`DELETE FROM webhook_deliveries WHERE webhookID = ? AND id NOT IN
+  (SELECT id FROM webhook_deliveries WHERE webhookID = ? ORDER BY id DESC LIMIT ?)`),
+			d.WebhookID, d.WebhookID, WEBHOOK_DELIVERIES_KEPT)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+func webhookDeliveries(db *sql.DB, bind func(string) string, webhookID int64) ([]doit.WebhookDelivery, error) {
+	rows, err := db.Query(bind("SELECT id, webhookID, payloadID, event, attempt, status_code, error, time, payload FROM webhook_deliveries WHERE webhookID = ? ORDER BY id DESC"), webhookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []doit.WebhookDelivery
+	for rows.Next() {
+		var d doit.WebhookDelivery
+		var t int64
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.PayloadID, &d.Event, &d.Attempt, &d.StatusCode, &d.Error, &t, &d.Payload)
+		if err != nil {
+			return nil, err
+		}
+		d.Time = time.Unix(t, 0)
+		all = append(all, d)
+	}
+
+	return all, rows.Err()
+}
@@ -0,0 +1,251 @@
+package doit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A todo as it's exported and imported. The state, the priority, the color,
+// the project and the tags are referenced by name, so the records can be read
+// and moved between users and instances.
+type TodoRecord struct {
+	Title       string
+	Description string
+	State       string
+	Priority    string
+	// Hex of the color
+	Color string
+	// In the RFC 3339 format, empty if the todo does not expire
+	Expiration string
+	Recurrence string
+	// Empty if the todo is in the inbox
+	Project string
+	Tags    []string
+}
+
+// A record that could not be imported. Rows are the positions of the records
+// starting from 1, not the lines of the file.
+type ImportError struct {
+	Row   int
+	Error string
+}
+
+type ImportResult struct {
+	// Number of todos imported, or that would be imported in a dry run
+	Imported int
+	DryRun   bool
+	Errors   []ImportError
+}
+
+// Formats of the export, the first is the default
+var ExportFormats = []string{"json", "csv", "todotxt", "markdown"}
+
+// Write the records in one of ExportFormats
+func WriteRecords(w io.Writer, format string, records []TodoRecord) error {
+	switch format {
+	case "json":
+		b, err := json.Marshal(records)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	case "csv":
+		return WriteCSV(w, records)
+	case "todotxt":
+		return WriteTodoTxt(w, records)
+	case "markdown":
+		return WriteMarkdown(w, records)
+	}
+	return fmt.Errorf("Format %q does not exist", format)
+}
+
+// Read the records in one of ExportFormats
+func ReadRecords(r io.Reader, format string) ([]TodoRecord, error) {
+	switch format {
+	case "json":
+		var records []TodoRecord
+		err := json.NewDecoder(r).Decode(&records)
+		return records, err
+	case "csv":
+		return ReadCSV(r)
+	case "todotxt":
+		return ReadTodoTxt(r)
+	case "markdown":
+		return ReadMarkdown(r)
+	}
+	return nil, fmt.Errorf("Format %q does not exist", format)
+}
+
+// Columns of the CSV export. The tags are joined by TagsSeparator.
+var CSVHeader = []string{"title", "description", "state", "priority", "color", "expiration", "recurrence", "project", "tags"}
+
+const TagsSeparator = ","
+
+// Return the record of the todo. The names of its project and tags are
+// resolved by the caller.
+func TodoToRecord(t *Todo, project string, tags []string) TodoRecord {
+	rec := TodoRecord{
+		Title:       t.Title,
+		Description: t.Description,
+		Recurrence:  t.Recurrence,
+		Project:     project,
+		Tags:        tags,
+	}
+	if rec.Tags == nil {
+		rec.Tags = []string{}
+	}
+	if t.Expiration.DoesExpire {
+		rec.Expiration = t.Expiration.Date.UTC().Format(time.RFC3339)
+	}
+	for _, s := range States {
+		if s.ID == t.StateID {
+			rec.State = s.State
+		}
+	}
+	for _, p := range Priorities {
+		if p.ID == t.PriorityID {
+			rec.Priority = p.Priority
+		}
+	}
+	for _, c := range Colors {
+		if c.ID == t.ColorID {
+			rec.Color = c.Hex
+		}
+	}
+	return rec
+}
+
+// Return the todo of the record, without project and tags that must be
+// resolved by the caller. An empty state, priority or color is replaced by
+// the first of its list, like the frontend does for new todos.
+func (rec *TodoRecord) ToTodo() (Todo, error) {
+	var t Todo
+	if strings.TrimSpace(rec.Title) == "" {
+		return t, errors.New("Title is empty or not present")
+	}
+	t.Title = rec.Title
+	t.Description = rec.Description
+	t.Recurrence = rec.Recurrence
+
+	t.StateID = States[0].ID
+	if rec.State != "" {
+		i := slices.IndexFunc(States, func(s *TodoState) bool { return strings.EqualFold(s.State, rec.State) })
+		if i < 0 {
+			return t, fmt.Errorf("State %q does not exist", rec.State)
+		}
+		t.StateID = States[i].ID
+	}
+
+	t.PriorityID = Priorities[0].ID
+	if rec.Priority != "" {
+		i := slices.IndexFunc(Priorities, func(p *TodoPriority) bool { return strings.EqualFold(p.Priority, rec.Priority) })
+		if i < 0 {
+			return t, fmt.Errorf("Priority %q does not exist", rec.Priority)
+		}
+		t.PriorityID = Priorities[i].ID
+	}
+
+	t.ColorID = Colors[0].ID
+	if rec.Color != "" {
+		i := slices.IndexFunc(Colors, func(c *Color) bool { return strings.EqualFold(c.Hex, rec.Color) })
+		if i < 0 {
+			return t, fmt.Errorf("Color %q does not exist", rec.Color)
+		}
+		t.ColorID = Colors[i].ID
+	}
+
+	if rec.Expiration != "" {
+		d, err := time.Parse(time.RFC3339, rec.Expiration)
+		if err != nil {
+			return t, fmt.Errorf("Expiration %q is not in the RFC 3339 format", rec.Expiration)
+		}
+		t.Expiration = Expiration{DoesExpire: true, Date: d}
+	}
+	return t, nil
+}
+
+// Write the records as CSV, with CSVHeader as the first line
+func WriteCSV(w io.Writer, records []TodoRecord) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(CSVHeader); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		err := cw.Write([]string{rec.Title, rec.Description, rec.State, rec.Priority, rec.Color, rec.Expiration, rec.Recurrence, rec.Project, strings.Join(rec.Tags, TagsSeparator)})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Read the records written by WriteCSV. The first line is the header: the
+// columns can be in any order and only the title is required.
+func ReadCSV(r io.Reader) ([]TodoRecord, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("The header is missing")
+		}
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if !slices.Contains(CSVHeader, h) {
+			return nil, fmt.Errorf("Unknown column %q", h)
+		}
+		if _, ok := columns[h]; ok {
+			return nil, fmt.Errorf("Column %q is repeated", h)
+		}
+		columns[h] = i
+	}
+	if _, ok := columns["title"]; !ok {
+		return nil, errors.New("Column \"title\" is missing")
+	}
+
+	var records []TodoRecord
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return row[i]
+			}
+			return ""
+		}
+		rec := TodoRecord{
+			Title:       field("title"),
+			Description: field("description"),
+			State:       field("state"),
+			Priority:    field("priority"),
+			Color:       field("color"),
+			Expiration:  field("expiration"),
+			Recurrence:  field("recurrence"),
+			Project:     field("project"),
+			Tags:        []string{},
+		}
+		for _, tag := range strings.Split(field("tags"), TagsSeparator) {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				rec.Tags = append(rec.Tags, tag)
+			}
+		}
+		records = append(records, rec)
+	}
+}
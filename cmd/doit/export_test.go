@@ -0,0 +1,144 @@
+package doit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestCSV(t *testing.T) {
+	records := []TodoRecord{
+		{Title: "Report", Description: "For the board, \"final\"\nversion", State: "done", Priority: "high", Color: "#d37676", Expiration: "2026-03-02T10:00:00Z", Project: "Work", Tags: []string{"urgent", "office"}},
+		{Title: "Milk", Tags: []string{}},
+	}
+
+	var b bytes.Buffer
+	assert.NilError(t, WriteCSV(&b, records))
+	assert.Assert(t, strings.HasPrefix(b.String(), "title,description,state,priority,color,expiration,recurrence,project,tags\n"))
+
+	read, err := ReadCSV(&b)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, read, records)
+
+	// Columns in any order, only the title is required
+	read, err = ReadCSV(strings.NewReader("Tags,Title\n\"a, b\",Bread\n"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, read, []TodoRecord{{Title: "Bread", Tags: []string{"a", "b"}}})
+
+	_, err = ReadCSV(strings.NewReader("description\nBread\n"))
+	assert.ErrorContains(t, err, "title")
+	_, err = ReadCSV(strings.NewReader("title,owner\nBread,me\n"))
+	assert.ErrorContains(t, err, "owner")
+	_, err = ReadCSV(strings.NewReader(""))
+	assert.ErrorContains(t, err, "header")
+}
+
+func TestRecordToTodo(t *testing.T) {
+	todo, err := (&TodoRecord{Title: "Report", Expiration: "2026-03-02T10:00:00Z"}).ToTodo()
+	assert.NilError(t, err)
+	assert.Equal(t, todo.Title, "Report")
+	assert.Assert(t, todo.Expiration.DoesExpire)
+	assert.Assert(t, todo.Expiration.Date.Equal(time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)))
+
+	invalid := []struct {
+		rec TodoRecord
+		msg string
+	}{
+		{TodoRecord{Title: " "}, "Title"},
+		{TodoRecord{Title: "a", State: "finished"}, "State"},
+		{TodoRecord{Title: "a", Priority: "urgent"}, "Priority"},
+		{TodoRecord{Title: "a", Color: "#000000"}, "Color"},
+		{TodoRecord{Title: "a", Expiration: "tomorrow"}, "Expiration"},
+	}
+	for _, c := range invalid {
+		_, err := c.rec.ToTodo()
+		assert.ErrorContains(t, err, c.msg)
+	}
+}
+
+func TestTodoTxt(t *testing.T) {
+	records := []TodoRecord{
+		{Title: "Report", Description: "For the board\nfinal version", State: "done", Priority: "max", Color: "#d37676", Expiration: "2026-03-02T10:00:00Z", Project: "Big work", Tags: []string{"urgent", "office"}},
+		{Title: "Milk", State: "in progress", Priority: "very low", Expiration: "2026-03-02T00:00:00Z", Recurrence: "FREQ=WEEKLY", Tags: []string{}},
+	}
+
+	var b bytes.Buffer
+	assert.NilError(t, WriteTodoTxt(&b, records))
+	assert.Equal(t, b.String(), "x (A) Report +Big%20work @urgent @office due:2026-03-02T10:00:00Z color:#d37676 description:For%20the%20board%0Afinal%20version\n"+
+		"(F) Milk due:2026-03-02 state:in-progress rrule:FREQ=WEEKLY\n")
+
+	read, err := ReadTodoTxt(&b)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, read, records)
+
+	// Dates and unknown keys of other apps
+	read, err = ReadTodoTxt(strings.NewReader("\nx 2026-01-02 2026-01-01 Call mom see:http://example.com\n(G) Bread\n"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, read, []TodoRecord{
+		{Title: "Call mom see:http://example.com", State: "done", Tags: []string{}},
+		{Title: "Bread", Priority: "(G)", Tags: []string{}},
+	})
+
+	_, err = ReadTodoTxt(strings.NewReader("Bread\nMilk +home +shop\n"))
+	assert.ErrorContains(t, err, "Line 2")
+
+	// Words of the title that look like the other fields are escaped
+	records = []TodoRecord{
+		{Title: "Email @bob about it", Tags: []string{}},
+		{Title: "x marks the spot", Tags: []string{}},
+		{Title: "2026-01-01 was a Thursday", Tags: []string{}},
+		{Title: "(A) is the highest", Priority: "low", Tags: []string{}},
+		{Title: "Meet due:tomorrow +1 #42", Tags: []string{}},
+		{Title: `\\server\share`, State: "done", Tags: []string{}},
+	}
+	b.Reset()
+	assert.NilError(t, WriteTodoTxt(&b, records))
+	assert.Equal(t, b.String(), `Email \@bob about it`+"\n"+`\x marks the spot`+"\n"+`\2026-01-01 was a Thursday`+"\n"+
+		`(E) \(A) is the highest`+"\n"+`Meet \due:tomorrow \+1 #42`+"\n"+`x \\\server\share`+"\n")
+	read, err = ReadTodoTxt(&b)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, read, records)
+}
+
+func TestMarkdown(t *testing.T) {
+	records := []TodoRecord{
+		{Title: "Report", Description: "For the board\n\n  final version", State: "done", Priority: "very high", Color: "#d37676", Project: "Work", Tags: []string{"urgent"}},
+		{Title: "Milk", State: "paused", Priority: "low", Tags: []string{}},
+		{Title: "Slides", Priority: "low", Project: "Work", Tags: []string{}},
+	}
+
+	var b bytes.Buffer
+	assert.NilError(t, WriteMarkdown(&b, records))
+	assert.Equal(t, b.String(), "- [ ] Milk priority:low state:paused\n\n## Work\n\n"+
+		"- [x] Report priority:very-high #urgent color:#d37676\n  For the board\n  \n    final version\n"+
+		"- [ ] Slides priority:low\n")
+
+	read, err := ReadMarkdown(&b)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, read, []TodoRecord{records[1], records[0], records[2]})
+
+	// Words of the title that look like the other fields and the spaces of
+	// the projects are escaped
+	records = []TodoRecord{
+		{Title: "Fix bug #42", Tags: []string{}},
+		{Title: "[x] is a checkbox priority:high", Project: "My  Proj", Tags: []string{}},
+		{Title: "Sell 50% @home", Project: " 100% sure\t", Tags: []string{}},
+	}
+	b.Reset()
+	assert.NilError(t, WriteMarkdown(&b, records))
+	assert.Equal(t, b.String(), `- [ ] Fix bug \#42`+"\n\n## My %20Proj\n\n"+`- [ ] \[x] is a checkbox \priority:high`+"\n\n"+
+		"## %20100%25 sure%09\n\n- [ ] Sell 50% @home\n")
+	read, err = ReadMarkdown(&b)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, read, records)
+
+	read, err = ReadMarkdown(strings.NewReader("# Shopping\n\nSome text\n\n* [X] Bread\n- Milk\n  semi skimmed\n"))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, read, []TodoRecord{
+		{Title: "Bread", State: "done", Tags: []string{}},
+		{Title: "Milk", Description: "semi skimmed", Tags: []string{}},
+	})
+}
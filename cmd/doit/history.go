@@ -0,0 +1,99 @@
+package doit
+
+import (
+	"slices"
+	"time"
+)
+
+// Actions recorded in the history of a todo
+const (
+	TodoEventCreate  = "create"
+	TodoEventUpdate  = "update"
+	TodoEventDelete  = "delete"
+	TodoEventRestore = "restore"
+)
+
+// Something that happened to a todo
+type TodoEvent struct {
+	ID     int64
+	TodoID int64
+	// Who did it, 0 if the user was deleted
+	UserID   int64
+	Username string
+	Action   string
+	Time     time.Time
+	Changes  []TodoChange
+	// The todo after the event, or before it for a delete. It's the revision
+	// a todo can be restored to.
+	Todo Todo
+}
+
+// A field of a todo that changed. Old is nil for a create and New is nil for
+// a delete.
+type TodoChange struct {
+	Field string
+	Old   any
+	New   any
+}
+
+// Return the fields that differ between old and new. Computed fields, like
+// the progress, are ignored. If old is nil all the fields of new are
+// returned, if new is nil all the fields of old.
+func DiffTodos(old *Todo, new *Todo) []TodoChange {
+	fields := func(t *Todo) []TodoChange {
+		if t == nil {
+			return nil
+		}
+		tags := t.TagIDs
+		if tags == nil {
+			tags = []int64{}
+		}
+		expiration := any(nil)
+		if t.Expiration.DoesExpire {
+			expiration = t.Expiration.Date.UTC()
+		}
+		return []TodoChange{
+			{Field: "Title", New: t.Title},
+			{Field: "Description", New: t.Description},
+			{Field: "StateID", New: t.StateID},
+			{Field: "PriorityID", New: t.PriorityID},
+			{Field: "ColorID", New: t.ColorID},
+			{Field: "Expiration", New: expiration},
+			{Field: "Recurrence", New: t.Recurrence},
+			{Field: "ProjectID", New: t.ProjectID},
+			{Field: "AssigneeID", New: t.AssigneeID},
+			{Field: "TagIDs", New: tags},
+		}
+	}
+
+	o, n := fields(old), fields(new)
+	var changes []TodoChange
+	switch {
+	case o == nil:
+		return n
+	case n == nil:
+		for _, c := range o {
+			changes = append(changes, TodoChange{Field: c.Field, Old: c.New})
+		}
+		return changes
+	}
+
+	for i := range o {
+		if !equalField(o[i].New, n[i].New) {
+			changes = append(changes, TodoChange{Field: o[i].Field, Old: o[i].New, New: n[i].New})
+		}
+	}
+	return changes
+}
+
+func equalField(a any, b any) bool {
+	switch a := a.(type) {
+	case []int64:
+		b, ok := b.([]int64)
+		return ok && slices.Equal(a, b)
+	case time.Time:
+		b, ok := b.(time.Time)
+		return ok && a.Equal(b)
+	}
+	return a == b
+}
@@ -0,0 +1,40 @@
+package doit
+
+import (
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestDiffTodos(t *testing.T) {
+	old := Todo{
+		ID:         1,
+		Title:      "Report",
+		StateID:    1,
+		Expiration: Expiration{DoesExpire: true, Date: date(2026, 3, 2)},
+		TagIDs:     []int64{1, 2},
+		Progress:   50,
+	}
+
+	new := old
+	new.Progress = 100
+	new.Expiration.Date = date(2026, 3, 2).Local()
+	assert.Equal(t, len(DiffTodos(&old, &new)), 0)
+
+	new.StateID = 3
+	new.Expiration.DoesExpire = false
+	new.TagIDs = []int64{2}
+	assert.DeepEqual(t, DiffTodos(&old, &new), []TodoChange{
+		{Field: "StateID", Old: int64(1), New: int64(3)},
+		{Field: "Expiration", Old: date(2026, 3, 2).UTC(), New: nil},
+		{Field: "TagIDs", Old: []int64{1, 2}, New: []int64{2}},
+	})
+
+	created := DiffTodos(nil, &old)
+	assert.Equal(t, len(created), 10)
+	assert.DeepEqual(t, created[0], TodoChange{Field: "Title", New: "Report"})
+
+	deleted := DiffTodos(&old, nil)
+	assert.Equal(t, len(deleted), 10)
+	assert.DeepEqual(t, deleted[0], TodoChange{Field: "Title", Old: "Report"})
+}
@@ -0,0 +1,282 @@
+package doit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format of the dates in UTC in iCalendar (RFC 5545)
+const ICalDateTime = "20060102T150405Z"
+
+// PRIORITY of the VTODO for each of Priorities, in the same order. In
+// iCalendar 1 is the highest and 9 the lowest.
+var icalPriorities = []int{9, 7, 5, 4, 2, 1}
+
+// STATUS of the VTODO for each of States, in the same order
+var icalStatuses = []string{"NEEDS-ACTION", "IN-PROCESS", "IN-PROCESS", "COMPLETED"}
+
+// Return the UID of the VTODO of the todo, stable across exports
+func TodoUID(id int64) string {
+	return fmt.Sprintf("doit-%d", id)
+}
+
+// Escape a TEXT value
+func icalEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// Write a content line, folded at 75 octets without splitting UTF-8
+// characters. Folded lines start with a space, that counts in the 75.
+func writeICalLine(w *bufio.Writer, name string, value string) {
+	line := name + ":" + value
+	max := 75
+	for len(line) > max {
+		i := max
+		for i > 0 && line[i]&0xC0 == 0x80 {
+			i--
+		}
+		w.WriteString(line[:i] + "\r\n ")
+		line = line[i:]
+		max = 74
+	}
+	w.WriteString(line + "\r\n")
+}
+
+func writeVTODO(w *bufio.Writer, t *Todo, uid string, stamp time.Time) {
+	writeICalLine(w, "BEGIN", "VTODO")
+	writeICalLine(w, "UID", icalEscape(uid))
+	writeICalLine(w, "DTSTAMP", stamp.UTC().Format(ICalDateTime))
+	writeICalLine(w, "SUMMARY", icalEscape(t.Title))
+	if t.Description != "" {
+		writeICalLine(w, "DESCRIPTION", icalEscape(t.Description))
+	}
+	if t.Expiration.DoesExpire {
+		writeICalLine(w, "DUE", t.Expiration.Date.UTC().Format(ICalDateTime))
+	}
+	for i, p := range Priorities {
+		if p.ID == t.PriorityID {
+			writeICalLine(w, "PRIORITY", fmt.Sprint(icalPriorities[i]))
+			break
+		}
+	}
+	for i, s := range States {
+		if s.ID == t.StateID {
+			writeICalLine(w, "STATUS", icalStatuses[i])
+			break
+		}
+	}
+	writeICalLine(w, "END", "VTODO")
+}
+
+func writeVCALENDAR(w io.Writer, name string, todos []Todo, uid func(t *Todo) string, stamp time.Time) error {
+	bw := bufio.NewWriter(w)
+	writeICalLine(bw, "BEGIN", "VCALENDAR")
+	writeICalLine(bw, "VERSION", "2.0")
+	writeICalLine(bw, "PRODID", "-//DOIT//DOIT//EN")
+	if name != "" {
+		writeICalLine(bw, "X-WR-CALNAME", icalEscape(name))
+	}
+	for i := range todos {
+		writeVTODO(bw, &todos[i], uid(&todos[i]), stamp)
+	}
+	writeICalLine(bw, "END", "VCALENDAR")
+	return bw.Flush()
+}
+
+// Write a VCALENDAR with a VTODO for each todo. stamp is the DTSTAMP of the
+// VTODOs, the time the calendar is generated.
+func WriteICalendar(w io.Writer, name string, todos []Todo, stamp time.Time) error {
+	return writeVCALENDAR(w, name, todos, func(t *Todo) string { return TodoUID(t.ID) }, stamp)
+}
+
+// Write a VCALENDAR with only the VTODO of the todo, as a CalDAV resource
+func WriteVTODO(w io.Writer, t *Todo, uid string, stamp time.Time) error {
+	return writeVCALENDAR(w, "", []Todo{*t}, func(*Todo) string { return uid }, stamp)
+}
+
+// The properties of a VTODO that DOIT knows about, the others are ignored
+type VTodo struct {
+	UID         string
+	Summary     string
+	Description string
+	// Zero if the VTODO has no DUE
+	Due time.Time
+	// From 1 (highest) to 9 (lowest), 0 if undefined
+	Priority int
+	// Empty if undefined
+	Status string
+}
+
+// A content line, with the name and the parameters in upper case
+type icalProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+func parseICalLine(line string) (icalProperty, error) {
+	p := icalProperty{params: make(map[string]string)}
+
+	// The value starts at the first colon not in a quoted parameter
+	quoted := false
+	colon := -1
+	for i := 0; i < len(line) && colon < 0; i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case ':':
+			if !quoted {
+				colon = i
+			}
+		}
+	}
+	if colon < 0 {
+		return p, fmt.Errorf("Line %q is not valid", line)
+	}
+	p.value = line[colon+1:]
+
+	parts := strings.Split(line[:colon], ";")
+	p.name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		k, v, _ := strings.Cut(param, "=")
+		p.params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+	}
+	return p, nil
+}
+
+func icalUnescape(s string) string {
+	r := strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+	return r.Replace(s)
+}
+
+// Parse a DATE or DATE-TIME value. Times without a timezone are local.
+func parseICalTime(p icalProperty) (time.Time, error) {
+	loc := time.Local
+	if tzid, ok := p.params["TZID"]; ok {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+
+	switch {
+	case p.params["VALUE"] == "DATE" || len(p.value) == len("20060102"):
+		return time.ParseInLocation("20060102", p.value, loc)
+	case strings.HasSuffix(p.value, "Z"):
+		return time.Parse(ICalDateTime, p.value)
+	default:
+		return time.ParseInLocation(strings.TrimSuffix(ICalDateTime, "Z"), p.value, loc)
+	}
+}
+
+// Parse the first VTODO of a VCALENDAR. Nested components, like alarms, are
+// ignored.
+func ParseVTODO(r io.Reader) (*VTodo, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unfold the lines
+	text := strings.ReplaceAll(string(b), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\n ", "")
+	text = strings.ReplaceAll(text, "\n\t", "")
+
+	var v *VTodo
+	var components []string
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" {
+			continue
+		}
+		p, err := parseICalLine(line)
+		if err != nil {
+			return nil, err
+		}
+
+		switch p.name {
+		case "BEGIN":
+			components = append(components, strings.ToUpper(p.value))
+			if v == nil && slices.Equal(components, []string{"VCALENDAR", "VTODO"}) {
+				v = &VTodo{}
+			}
+			continue
+		case "END":
+			if len(components) == 0 || components[len(components)-1] != strings.ToUpper(p.value) {
+				return nil, fmt.Errorf("Unexpected END:%s", p.value)
+			}
+			components = components[:len(components)-1]
+			continue
+		}
+		if v == nil || len(components) != 2 || components[1] != "VTODO" {
+			continue
+		}
+
+		switch p.name {
+		case "UID":
+			v.UID = icalUnescape(p.value)
+		case "SUMMARY":
+			v.Summary = icalUnescape(p.value)
+		case "DESCRIPTION":
+			v.Description = icalUnescape(p.value)
+		case "DUE":
+			v.Due, err = parseICalTime(p)
+			if err != nil {
+				return nil, fmt.Errorf("DUE %q is not valid", p.value)
+			}
+		case "PRIORITY":
+			v.Priority, err = strconv.Atoi(p.value)
+			if err != nil || v.Priority < 0 || v.Priority > 9 {
+				return nil, fmt.Errorf("PRIORITY %q is not valid", p.value)
+			}
+		case "STATUS":
+			v.Status = strings.ToUpper(p.value)
+		}
+	}
+
+	if len(components) != 0 {
+		return nil, fmt.Errorf("BEGIN:%s is not closed", components[len(components)-1])
+	}
+	if v == nil {
+		return nil, errors.New("The calendar has no VTODO")
+	}
+	return v, nil
+}
+
+// Set the fields of the todo from the VTODO. The priority and the state are
+// left unchanged if undefined, like the fields that are not in a VTODO.
+func (v *VTodo) Apply(t *Todo) {
+	t.Title = v.Summary
+	t.Description = v.Description
+	t.Expiration.DoesExpire = !v.Due.IsZero()
+	if t.Expiration.DoesExpire {
+		t.Expiration.Date = v.Due
+	}
+
+	// The highest priority that is not above the one of the VTODO, e.g. 3 is
+	// high
+	if v.Priority != 0 {
+		for i := range Priorities {
+			if icalPriorities[i] >= v.Priority {
+				t.PriorityID = Priorities[i].ID
+			}
+		}
+	}
+
+	switch v.Status {
+	case "NEEDS-ACTION":
+		t.StateID = StateToDo.ID
+	case "IN-PROCESS":
+		// Paused is IN-PROCESS too
+		if t.StateID != StateInProgress.ID && t.StateID != StatePaused.ID {
+			t.StateID = StateInProgress.ID
+		}
+	case "COMPLETED", "CANCELLED":
+		t.StateID = StateDone.ID
+	}
+}
@@ -0,0 +1,88 @@
+package doit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestWriteICalendar(t *testing.T) {
+	todos := []Todo{
+		{
+			ID:          7,
+			Title:       "Report; final, really",
+			Description: "First line\nsecond line",
+			StateID:     StateDone.ID,
+			PriorityID:  PriorityMax.ID,
+			Expiration:  Expiration{DoesExpire: true, Date: time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)},
+		},
+		{ID: 8, Title: strings.Repeat("è", 50)},
+	}
+
+	var b bytes.Buffer
+	stamp := time.Date(2026, 1, 1, 8, 30, 0, 0, time.UTC)
+	assert.NilError(t, WriteICalendar(&b, "Work", todos, stamp))
+
+	ical := b.String()
+	assert.Assert(t, strings.HasPrefix(ical, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"))
+	assert.Assert(t, strings.HasSuffix(ical, "END:VTODO\r\nEND:VCALENDAR\r\n"))
+	assert.Assert(t, strings.Contains(ical, "UID:doit-7\r\nDTSTAMP:20260101T083000Z\r\nSUMMARY:Report\\; final\\, really\r\nDESCRIPTION:First line\\nsecond line\r\nDUE:20260302T100000Z\r\n"))
+
+	// Long lines are folded without splitting characters
+	for _, line := range strings.Split(ical, "\r\n") {
+		assert.Assert(t, len(line) <= 75, line)
+	}
+	assert.Assert(t, strings.Contains(strings.ReplaceAll(ical, "\r\n ", ""), "SUMMARY:"+strings.Repeat("è", 50)+"\r\n"))
+}
+
+func TestParseVTODO(t *testing.T) {
+	ical := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VTIMEZONE\r\nTZID:Europe/Rome\r\nEND:VTIMEZONE\r\n" +
+		"BEGIN:VTODO\r\nUID:abc-123\r\nSUMMARY:Report\\, fina\r\n l\r\nDESCRIPTION:One\\nTwo\r\n" +
+		"DUE;TZID=\"Europe/Rome\":20260302T110000\r\nPRIORITY:3\r\nSTATUS:COMPLETED\r\n" +
+		"BEGIN:VALARM\r\nDESCRIPTION:Alarm\r\nEND:VALARM\r\nEND:VTODO\r\nEND:VCALENDAR\r\n"
+
+	v, err := ParseVTODO(strings.NewReader(ical))
+	assert.NilError(t, err)
+	assert.Equal(t, v.UID, "abc-123")
+	assert.Equal(t, v.Summary, "Report, final")
+	assert.Equal(t, v.Description, "One\nTwo")
+	assert.Assert(t, v.Due.Equal(time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)), v.Due)
+	assert.Equal(t, v.Priority, 3)
+	assert.Equal(t, v.Status, "COMPLETED")
+
+	v, err = ParseVTODO(strings.NewReader("BEGIN:VCALENDAR\nBEGIN:VTODO\nSUMMARY:Milk\nDUE;VALUE=DATE:20260302\nEND:VTODO\nEND:VCALENDAR\n"))
+	assert.NilError(t, err)
+	assert.Assert(t, v.Due.Equal(time.Date(2026, 3, 2, 0, 0, 0, 0, time.Local)))
+
+	_, err = ParseVTODO(strings.NewReader("BEGIN:VCALENDAR\nBEGIN:VEVENT\nEND:VEVENT\nEND:VCALENDAR\n"))
+	assert.ErrorContains(t, err, "no VTODO")
+	_, err = ParseVTODO(strings.NewReader("BEGIN:VCALENDAR\nBEGIN:VTODO\nEND:VCALENDAR\n"))
+	assert.ErrorContains(t, err, "END")
+	_, err = ParseVTODO(strings.NewReader("BEGIN:VCALENDAR\nBEGIN:VTODO\nPRIORITY:high\nEND:VTODO\nEND:VCALENDAR\n"))
+	assert.ErrorContains(t, err, "PRIORITY")
+}
+
+func TestVTodoRoundTrip(t *testing.T) {
+	todo := Todo{
+		ID:          7,
+		Title:       "Report; final, really",
+		Description: "First line\nsecond line",
+		Expiration:  Expiration{DoesExpire: true, Date: time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)},
+	}
+
+	var b bytes.Buffer
+	assert.NilError(t, WriteVTODO(&b, &todo, "a,b", time.Now()))
+	v, err := ParseVTODO(&b)
+	assert.NilError(t, err)
+	assert.Equal(t, v.UID, "a,b")
+
+	var parsed Todo
+	v.Apply(&parsed)
+	assert.Equal(t, parsed.Title, todo.Title)
+	assert.Equal(t, parsed.Description, todo.Description)
+	assert.Assert(t, parsed.Expiration.DoesExpire)
+	assert.Assert(t, parsed.Expiration.Date.Equal(todo.Expiration.Date))
+}
@@ -0,0 +1,185 @@
+package doit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+// Indentation of the lines of the description of a todo in Markdown
+const markdownIndent = "  "
+
+// A title can't start with a checkbox
+func markdownMarker(w string, first bool) bool {
+	return first && (w == "[" || w == "[x]" || w == "[X]")
+}
+
+// Escape like in URLs the percent signs and the spaces that would be lost in
+// a heading: the ones at the start or at the end, and all but the first of
+// the consecutive ones
+func escapeHeading(s string) string {
+	var b strings.Builder
+	var prev rune
+	for i, r := range s {
+		switch {
+		case r == '%':
+			b.WriteString("%25")
+		case r == ' ' && i > 0 && i < len(s)-1 && !unicode.IsSpace(prev):
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteString(url.PathEscape(string(r)))
+		default:
+			b.WriteRune(r)
+		}
+		prev = r
+	}
+	return b.String()
+}
+
+// Return the heading written by escapeHeading. Headings of other apps with
+// percent signs that are not escapes are kept as they are.
+func unescapeHeading(s string) string {
+	s = strings.TrimSpace(s)
+	if u, err := url.PathUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
+
+func writeMarkdownItem(w *bufio.Writer, rec *TodoRecord) {
+	box := "[ ]"
+	if strings.EqualFold(rec.State, StateDone.State) {
+		box = "[x]"
+	}
+	words := append([]string{"-", box}, titleWords(rec.Title, "#", markdownMarker)...)
+	if rec.Priority != "" {
+		words = append(words, "priority:"+hyphenate(rec.Priority))
+	}
+	words = append(words, recordWords(rec, "#")...)
+	w.WriteString(strings.Join(words, " ") + "\n")
+
+	if rec.Description != "" {
+		for _, line := range strings.Split(rec.Description, "\n") {
+			w.WriteString(markdownIndent + line + "\n")
+		}
+	}
+}
+
+// Write the records as a Markdown task list, with an item like
+// "- [x] Report priority:high #urgent" for each todo and its description in
+// the indented lines that follow. The todos in the inbox come first, then a
+// "## Project" section for each project, in the order of the records. The
+// other fields are written like in todo.txt. The percent signs and the spaces
+// that would be lost of the projects are escaped like in URLs.
+func WriteMarkdown(w io.Writer, records []TodoRecord) error {
+	var projects []string
+	byProject := make(map[string][]*TodoRecord)
+	for i := range records {
+		p := records[i].Project
+		if _, ok := byProject[p]; !ok && p != "" {
+			projects = append(projects, p)
+		}
+		byProject[p] = append(byProject[p], &records[i])
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, rec := range byProject[""] {
+		writeMarkdownItem(bw, rec)
+	}
+	for i, p := range projects {
+		if i > 0 || len(byProject[""]) > 0 {
+			bw.WriteString("\n")
+		}
+		fmt.Fprintf(bw, "## %s\n\n", escapeHeading(p))
+		for _, rec := range byProject[p] {
+			writeMarkdownItem(bw, rec)
+		}
+	}
+	return bw.Flush()
+}
+
+// Return the words of a list item and if it's checked. Items without a
+// checkbox are not done.
+func parseMarkdownItem(line string) ([]string, bool, bool) {
+	words := strings.Fields(line)
+	if len(words) == 0 || (words[0] != "-" && words[0] != "*") {
+		return nil, false, false
+	}
+	words = words[1:]
+
+	done := false
+	switch {
+	case len(words) > 1 && words[0] == "[" && words[1] == "]":
+		words = words[2:]
+	case len(words) > 0 && (words[0] == "[x]" || words[0] == "[X]"):
+		words = words[1:]
+		done = true
+	}
+	return words, done, true
+}
+
+// Read the records written by WriteMarkdown. The level 2 headings are the
+// projects and the lines indented after an item its description. Other lines
+// are ignored.
+func ReadMarkdown(r io.Reader) ([]TodoRecord, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxLineSize)
+
+	var records []TodoRecord
+	var description []string
+	project := ""
+	// Index of the record the indented lines are the description of
+	last := -1
+	endItem := func() {
+		if last >= 0 && len(description) > 0 {
+			records[last].Description = strings.Join(description, "\n")
+		}
+		last = -1
+		description = nil
+	}
+
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if last >= 0 && strings.HasPrefix(line, markdownIndent) {
+			description = append(description, strings.TrimPrefix(line, markdownIndent))
+			continue
+		}
+		endItem()
+
+		if strings.HasPrefix(line, "## ") {
+			project = unescapeHeading(line[len("## "):])
+			continue
+		}
+		words, done, ok := parseMarkdownItem(line)
+		if !ok {
+			continue
+		}
+
+		rec := TodoRecord{Project: project, Tags: []string{}}
+		var title []string
+		for _, w := range words {
+			if t, ok := unescapeTitleWord(w); ok {
+				title = append(title, t)
+				continue
+			}
+			ok, err := parseRecordWord(&rec, w, "#")
+			if err != nil {
+				return nil, fmt.Errorf("Line %d: %w", n, err)
+			}
+			if !ok {
+				title = append(title, w)
+			}
+		}
+		rec.Title = strings.Join(title, " ")
+		if done {
+			rec.State = StateDone.State
+		}
+		records = append(records, rec)
+		last = len(records) - 1
+	}
+	endItem()
+	return records, sc.Err()
+}
@@ -0,0 +1,230 @@
+package doit
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidRecurrence = errors.New("invalid recurrence rule")
+
+// Frequencies of a recurrence, as in RFC 5545
+const (
+	FreqDaily   = "DAILY"
+	FreqWeekly  = "WEEKLY"
+	FreqMonthly = "MONTHLY"
+	FreqYearly  = "YEARLY"
+)
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// Subset of the RFC 5545 RRULE supported for todos: FREQ, INTERVAL, BYDAY
+// (only weekly), UNTIL and COUNT. A rule like
+// FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;COUNT=10 is parsed with ParseRecurrence.
+type Recurrence struct {
+	Freq     string
+	Interval int
+	// Only with FreqWeekly, empty means the weekday of the due date
+	ByDay []time.Weekday
+	// Zero if there is no end date
+	Until time.Time
+	// Occurrences left, including the current one. 0 means no limit.
+	Count int
+}
+
+func ParseRecurrence(s string) (*Recurrence, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	r := Recurrence{Interval: 1}
+
+	for _, part := range strings.Split(s, ";") {
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			return nil, fmt.Errorf("%w: %q is not a KEY=VALUE pair", ErrInvalidRecurrence, part)
+		}
+
+		var err error
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.Freq = strings.ToUpper(value)
+			switch r.Freq {
+			case FreqDaily, FreqWeekly, FreqMonthly, FreqYearly:
+			default:
+				return nil, fmt.Errorf("%w: unknown FREQ %q", ErrInvalidRecurrence, value)
+			}
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(value)
+			if err != nil || r.Interval < 1 {
+				return nil, fmt.Errorf("%w: INTERVAL must be a positive number", ErrInvalidRecurrence)
+			}
+		case "BYDAY":
+			for _, d := range strings.Split(value, ",") {
+				wd, ok := weekdays[strings.ToUpper(d)]
+				if !ok {
+					return nil, fmt.Errorf("%w: unknown day %q in BYDAY", ErrInvalidRecurrence, d)
+				}
+				if !slices.Contains(r.ByDay, wd) {
+					r.ByDay = append(r.ByDay, wd)
+				}
+			}
+		case "UNTIL":
+			r.Until, err = parseUntil(value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: UNTIL is not a date", ErrInvalidRecurrence)
+			}
+		case "COUNT":
+			r.Count, err = strconv.Atoi(value)
+			if err != nil || r.Count < 1 {
+				return nil, fmt.Errorf("%w: COUNT must be a positive number", ErrInvalidRecurrence)
+			}
+		default:
+			return nil, fmt.Errorf("%w: %s is not supported", ErrInvalidRecurrence, key)
+		}
+	}
+
+	if r.Freq == "" {
+		return nil, fmt.Errorf("%w: FREQ is missing", ErrInvalidRecurrence)
+	}
+	if len(r.ByDay) > 0 && r.Freq != FreqWeekly {
+		return nil, fmt.Errorf("%w: BYDAY is supported only with FREQ=WEEKLY", ErrInvalidRecurrence)
+	}
+	if !r.Until.IsZero() && r.Count > 0 {
+		return nil, fmt.Errorf("%w: UNTIL and COUNT can't be used together", ErrInvalidRecurrence)
+	}
+
+	slices.SortFunc(r.ByDay, func(a, b time.Weekday) int {
+		return weekdayIndex(a) - weekdayIndex(b)
+	})
+	return &r, nil
+}
+
+// Check the recurrence rule of the todo and rewrite it in the canonical form.
+// Return the rule, or nil if the todo does not repeat.
+func (t *Todo) NormalizeRecurrence() (*Recurrence, error) {
+	if t.Recurrence == "" {
+		return nil, nil
+	}
+
+	rule, err := ParseRecurrence(t.Recurrence)
+	if err != nil {
+		return nil, err
+	}
+	if !t.Expiration.DoesExpire {
+		return nil, errors.New("A note that repeats must expire")
+	}
+
+	t.Recurrence = rule.String()
+	return rule, nil
+}
+
+// UNTIL can be a date or a UTC date-time
+func parseUntil(s string) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("20060102", s, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// The whole day is included
+	return t.AddDate(0, 0, 1).Add(-time.Second), nil
+}
+
+// Position of the weekday in a week starting on monday
+func weekdayIndex(d time.Weekday) int {
+	return (int(d) + 6) % 7
+}
+
+// Return the rule in the RRULE format, without the RRULE: prefix
+func (r *Recurrence) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, wd := range r.ByDay {
+			days[i] = strings.ToUpper(wd.String()[:2])
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Return the due date of the occurrence after the one due at due, and the rule
+// for the occurrences left after it. If there are no more occurrences false
+// is returned.
+func (r *Recurrence) Next(due time.Time) (time.Time, *Recurrence, bool) {
+	if r.Count == 1 {
+		return time.Time{}, nil, false
+	}
+
+	var next time.Time
+	switch r.Freq {
+	case FreqDaily:
+		next = due.AddDate(0, 0, r.Interval)
+	case FreqWeekly:
+		next = r.nextWeekly(due)
+	case FreqMonthly:
+		next = nextSameDay(due, func(k int) time.Time { return due.AddDate(0, k*r.Interval, 1-due.Day()) })
+	case FreqYearly:
+		next = nextSameDay(due, func(k int) time.Time { return due.AddDate(k*r.Interval, 0, 1-due.Day()) })
+	}
+
+	if next.IsZero() || (!r.Until.IsZero() && next.After(r.Until)) {
+		return time.Time{}, nil, false
+	}
+
+	rest := *r
+	if rest.Count > 0 {
+		rest.Count--
+	}
+	return next, &rest, true
+}
+
+func (r *Recurrence) nextWeekly(due time.Time) time.Time {
+	if len(r.ByDay) == 0 {
+		return due.AddDate(0, 0, 7*r.Interval)
+	}
+
+	for i := 1; i <= 7*r.Interval+7; i++ {
+		d := due.AddDate(0, 0, i)
+		// Weeks start on monday
+		weeks := (weekdayIndex(due.Weekday()) + i) / 7
+		if weeks%r.Interval == 0 && slices.Contains(r.ByDay, d.Weekday()) {
+			return d
+		}
+	}
+	return time.Time{}
+}
+
+// Occurrences on days that don't exist in a month (e.g. the 31st) are
+// skipped, as in RFC 5545. first(k) returns the first day of the month of
+// the k-th following occurrence.
+func nextSameDay(due time.Time, first func(k int) time.Time) time.Time {
+	// Every day exists at least once every 8 years (29th of february)
+	for k := 1; k <= 8*12; k++ {
+		f := first(k)
+		d := f.AddDate(0, 0, due.Day()-1)
+		if d.Month() == f.Month() {
+			return d
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,79 @@
+package doit
+
+import (
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 9, 0, 0, 0, time.Local)
+}
+
+func TestParseRecurrence(t *testing.T) {
+	r, err := ParseRecurrence("RRULE:freq=weekly;BYDAY=TH,MO;INTERVAL=2;COUNT=3")
+	assert.NilError(t, err)
+	assert.Equal(t, r.String(), "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH;COUNT=3")
+
+	for _, s := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=MONTHLY;BYDAY=MO",
+		"FREQ=DAILY;COUNT=2;UNTIL=20260101",
+		"FREQ=DAILY;BYMONTH=1",
+	} {
+		_, err := ParseRecurrence(s)
+		assert.ErrorIs(t, err, ErrInvalidRecurrence, s)
+	}
+}
+
+func TestRecurrenceNext(t *testing.T) {
+	tests := []struct {
+		rule string
+		due  time.Time
+		next time.Time
+	}{
+		{"FREQ=DAILY;INTERVAL=3", date(2026, 1, 30), date(2026, 2, 2)},
+		{"FREQ=WEEKLY", date(2026, 1, 1), date(2026, 1, 8)},
+		// 2026-01-05 is a monday
+		{"FREQ=WEEKLY;BYDAY=MO,FR", date(2026, 1, 5), date(2026, 1, 9)},
+		{"FREQ=WEEKLY;BYDAY=MO,FR", date(2026, 1, 9), date(2026, 1, 12)},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR", date(2026, 1, 9), date(2026, 1, 19)},
+		{"FREQ=MONTHLY", date(2026, 1, 15), date(2026, 2, 15)},
+		// February has no 31st
+		{"FREQ=MONTHLY", date(2026, 1, 31), date(2026, 3, 31)},
+		{"FREQ=YEARLY", date(2028, 2, 29), date(2032, 2, 29)},
+	}
+
+	for _, tt := range tests {
+		r, err := ParseRecurrence(tt.rule)
+		assert.NilError(t, err)
+
+		next, _, ok := r.Next(tt.due)
+		assert.Assert(t, ok, tt.rule)
+		assert.Equal(t, next, tt.next, tt.rule)
+	}
+}
+
+func TestRecurrenceEnd(t *testing.T) {
+	r, err := ParseRecurrence("FREQ=DAILY;COUNT=2")
+	assert.NilError(t, err)
+
+	next, rest, ok := r.Next(date(2026, 1, 1))
+	assert.Assert(t, ok)
+	assert.Equal(t, rest.Count, 1)
+
+	_, _, ok = rest.Next(next)
+	assert.Assert(t, !ok)
+
+	r, err = ParseRecurrence("FREQ=DAILY;UNTIL=20260102")
+	assert.NilError(t, err)
+
+	next, _, ok = r.Next(date(2026, 1, 1))
+	assert.Assert(t, ok)
+	_, _, ok = r.Next(next)
+	assert.Assert(t, !ok)
+}
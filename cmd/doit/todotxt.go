@@ -0,0 +1,247 @@
+package doit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Letter of the todo.txt priority for each of Priorities, in the same order.
+// In todo.txt (A) is the highest.
+var todoTxtPriorities = []string{"(F)", "(E)", "(D)", "(C)", "(B)", "(A)"}
+
+// Maximum length of a line of todo.txt or Markdown
+const maxLineSize = 1 << 20
+
+// Prefix of the words of the title that would be read as another field
+const titleEscape = `\`
+
+// Spaces can't be in the words of todo.txt, so the states and the priorities
+// are written with hyphens
+func hyphenate(s string) string {
+	return strings.ReplaceAll(s, " ", "-")
+}
+
+func unhyphenate(s string) string {
+	return strings.ReplaceAll(s, "-", " ")
+}
+
+// Return the due date of the expiration, without the time if it's midnight in
+// UTC
+func dueWord(expiration string) string {
+	d, err := time.Parse(time.RFC3339, expiration)
+	if err == nil && d.Location() == time.UTC && d.Equal(d.Truncate(24*time.Hour)) {
+		return d.Format(time.DateOnly)
+	}
+	return expiration
+}
+
+// Return the words of the fields of the record that are not in the title,
+// the project, the priority and the description excluded. The tags are
+// written with the prefix.
+func recordWords(rec *TodoRecord, tagPrefix string) []string {
+	var words []string
+	for _, tag := range rec.Tags {
+		words = append(words, tagPrefix+url.PathEscape(tag))
+	}
+	if rec.Expiration != "" {
+		words = append(words, "due:"+dueWord(rec.Expiration))
+	}
+	// Todo is the default and done is written as a checkbox
+	if rec.State != "" && !strings.EqualFold(rec.State, StateToDo.State) && !strings.EqualFold(rec.State, StateDone.State) {
+		words = append(words, "state:"+hyphenate(rec.State))
+	}
+	if rec.Color != "" {
+		words = append(words, "color:"+rec.Color)
+	}
+	if rec.Recurrence != "" {
+		words = append(words, "rrule:"+rec.Recurrence)
+	}
+	return words
+}
+
+// Words of the title that would be read as another field, or as the start of
+// the line if first, are escaped with a backslash, like the ones that start
+// with it. Marker reports the words that are special only in the format.
+func titleWords(title string, tagPrefix string, marker func(word string, first bool) bool) []string {
+	words := strings.Fields(title)
+	for i, w := range words {
+		ok, err := parseRecordWord(&TodoRecord{}, w, tagPrefix)
+		if ok || err != nil || strings.HasPrefix(w, titleEscape) || marker(w, i == 0) {
+			words[i] = titleEscape + w
+		}
+	}
+	return words
+}
+
+// Return the word of the title without the escape, if it's escaped
+func unescapeTitleWord(word string) (string, bool) {
+	return strings.CutPrefix(word, titleEscape)
+}
+
+// Set the field of the record of a word written by recordWords, or by the
+// formats for the priority and the description. Return false if the word is
+// part of the title.
+func parseRecordWord(rec *TodoRecord, word string, tagPrefix string) (bool, error) {
+	if len(word) > len(tagPrefix) && strings.HasPrefix(word, tagPrefix) {
+		tag, err := url.PathUnescape(word[len(tagPrefix):])
+		if err != nil {
+			return false, fmt.Errorf("Tag %q is not valid", word)
+		}
+		rec.Tags = append(rec.Tags, tag)
+		return true, nil
+	}
+
+	key, value, ok := strings.Cut(word, ":")
+	if !ok || value == "" {
+		return false, nil
+	}
+	switch key {
+	case "due":
+		if d, err := time.Parse(time.DateOnly, value); err == nil {
+			value = d.Format(time.RFC3339)
+		}
+		rec.Expiration = value
+	case "state":
+		rec.State = unhyphenate(value)
+	case "priority":
+		rec.Priority = unhyphenate(value)
+	case "color":
+		rec.Color = value
+	case "rrule":
+		rec.Recurrence = value
+	case "description":
+		d, err := url.PathUnescape(value)
+		if err != nil {
+			return false, fmt.Errorf("Description %q is not valid", value)
+		}
+		rec.Description = d
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+func isTodoTxtPriority(w string) bool {
+	return len(w) == 3 && w[0] == '(' && w[1] >= 'A' && w[1] <= 'Z' && w[2] == ')'
+}
+
+func isTodoTxtDate(w string) bool {
+	_, err := time.Parse(time.DateOnly, w)
+	return err == nil
+}
+
+// Projects can be anywhere, the other markers only at the start of the line
+func todoTxtMarker(w string, first bool) bool {
+	if len(w) > 1 && w[0] == '+' {
+		return true
+	}
+	return first && (w == "x" || isTodoTxtDate(w) || isTodoTxtPriority(w))
+}
+
+// Write the records in the todo.txt format (https://github.com/todotxt/todo.txt),
+// one per line:
+//
+//	x (A) Title +Project @tag due:2026-03-02 state:paused color:#d37676 rrule:FREQ=WEEKLY description:More%20text
+//
+// Done todos start with x and the priorities go from (A), max, to (F), very
+// low. Projects, tags and descriptions are escaped like in URLs, and the words
+// of the title that look like the other fields with a backslash.
+func WriteTodoTxt(w io.Writer, records []TodoRecord) error {
+	bw := bufio.NewWriter(w)
+	for i := range records {
+		rec := &records[i]
+		var words []string
+		if strings.EqualFold(rec.State, StateDone.State) {
+			words = append(words, "x")
+		}
+		for j, p := range Priorities {
+			if strings.EqualFold(p.Priority, rec.Priority) {
+				words = append(words, todoTxtPriorities[j])
+			}
+		}
+		words = append(words, titleWords(rec.Title, "@", todoTxtMarker)...)
+		if rec.Project != "" {
+			words = append(words, "+"+url.PathEscape(rec.Project))
+		}
+		words = append(words, recordWords(rec, "@")...)
+		if rec.Description != "" {
+			words = append(words, "description:"+url.PathEscape(rec.Description))
+		}
+		bw.WriteString(strings.Join(words, " ") + "\n")
+	}
+	return bw.Flush()
+}
+
+// Read the records written by WriteTodoTxt. Completion and creation dates are
+// ignored, like the words with unknown keys, that are part of the title.
+func ReadTodoTxt(r io.Reader) ([]TodoRecord, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxLineSize)
+
+	var records []TodoRecord
+	for n := 1; sc.Scan(); n++ {
+		words := strings.Fields(sc.Text())
+		if len(words) == 0 {
+			continue
+		}
+
+		rec := TodoRecord{Tags: []string{}}
+		done := words[0] == "x"
+		if done {
+			words = words[1:]
+		}
+		for len(words) > 0 {
+			if isTodoTxtDate(words[0]) {
+				words = words[1:]
+				continue
+			}
+			w := words[0]
+			if !isTodoTxtPriority(w) {
+				break
+			}
+			// An unknown priority is reported when the record is imported
+			rec.Priority = w
+			if i := slices.Index(todoTxtPriorities, w); i >= 0 {
+				rec.Priority = Priorities[i].Priority
+			}
+			words = words[1:]
+		}
+
+		var title []string
+		for _, w := range words {
+			if t, ok := unescapeTitleWord(w); ok {
+				title = append(title, t)
+				continue
+			}
+			if len(w) > 1 && w[0] == '+' {
+				if rec.Project != "" {
+					return nil, fmt.Errorf("Line %d has more than one project", n)
+				}
+				p, err := url.PathUnescape(w[1:])
+				if err != nil {
+					return nil, fmt.Errorf("Line %d: project %q is not valid", n, w)
+				}
+				rec.Project = p
+				continue
+			}
+			ok, err := parseRecordWord(&rec, w, "@")
+			if err != nil {
+				return nil, fmt.Errorf("Line %d: %w", n, err)
+			}
+			if !ok {
+				title = append(title, w)
+			}
+		}
+		rec.Title = strings.Join(title, " ")
+		if done {
+			rec.State = StateDone.State
+		}
+		records = append(records, rec)
+	}
+	return records, sc.Err()
+}
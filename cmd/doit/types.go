@@ -65,6 +65,23 @@ type Todo struct {
 	ColorID     int64
 	Expiration  Expiration
 	UserID      int64
+	// Recurrence rule in the RRULE format (see ParseRecurrence), empty if the
+	// todo does not repeat. A todo that repeats must expire.
+	Recurrence string
+	// 0 if the todo is in the inbox, not in a project
+	ProjectID int64
+	// User the todo is assigned to, 0 if not assigned. It's different from
+	// UserID, the owner.
+	AssigneeID int64
+	// When the todo was moved to the trash, zero if it's not in the trash.
+	// It's ignored when saving the todo.
+	DeletedAt time.Time
+	// Percentage of the checklist items that are done, 0 if there are no
+	// items. It's computed by the DB and ignored when saving the todo.
+	Progress int64
+	// IDs of the tags of the todo. When saving, nil leaves the tags unchanged
+	// and an empty slice removes them all.
+	TagIDs []int64
 }
 
 type TodoResponse struct {
@@ -75,6 +92,104 @@ type TodoResponse struct {
 	Priority       TodoPriority
 	Color          Color
 	ExpirationDate Expiration
+	Recurrence     string
+	ProjectID      int64
+	AssigneeID     int64
+	Progress       int64
+	TagIDs         []int64
+}
+
+// Permissions that can be granted when sharing a todo or a project. The owner
+// can do everything, while a user with edit can't delete nor share.
+const (
+	PermissionRead = "read"
+	PermissionEdit = "edit"
+)
+
+// A user a todo or a project is shared with
+type Share struct {
+	UserID     int64
+	Username   string
+	Permission string
+}
+
+// A list of todos of a user
+type Project struct {
+	ID     int64
+	UserID int64
+	Name   string
+	// Todos of archived projects are hidden from the list of todos
+	Archived bool
+}
+
+// This is used during JSON unmarshaling to check if values are present
+type ProjectUnmarshaling struct {
+	Name     *string
+	Archived *bool
+}
+
+// Label defined by a user to group todos
+type Tag struct {
+	ID     int64
+	UserID int64
+	Name   string
+	// Hex of the color, empty if the tag has no color
+	Color string
+}
+
+// This is used during JSON unmarshaling to check if values are present
+type TagUnmarshaling struct {
+	Name  *string
+	Color *string
+}
+
+// A step of the checklist of a todo
+type TodoItem struct {
+	ID     int64
+	TodoID int64
+	Text   string
+	Done   bool
+	// Items of a todo are ordered by position, starting from 0
+	Position int64
+}
+
+// This is used during JSON unmarshaling to check if values are present
+type TodoItemUnmarshaling struct {
+	Text     *string
+	Done     *bool
+	Position *int64
+}
+
+// How a todo created by a CalDAV client is known to it. Todos without one use
+// TodoUID and TodoResourceName.
+type CalDAVObject struct {
+	TodoID int64
+	// UID of the VTODO sent by the client
+	UID string
+	// Name of the resource in its calendar collection, e.g. "abc.ics"
+	Name string
+}
+
+// A reminder sent by email for a todo, Before its due date. It's sent again
+// if the due date changes.
+type Reminder struct {
+	TodoID int64
+	Before time.Duration
+	Due    time.Time
+	SentAt time.Time
+}
+
+// A message about a todo, written by a user that can see it
+type Comment struct {
+	ID     int64
+	TodoID int64
+	// The author
+	UserID   int64
+	Username string
+	Body     string
+	Created  time.Time
+	// Zero if the comment was never edited
+	Edited time.Time
 }
 
 type User struct {
@@ -127,6 +242,11 @@ func TodoToResponse(n *Todo) *TodoResponse {
 		ID:          n.ID,
 		Title:       n.Title,
 		Description: n.Description,
+		Recurrence:  n.Recurrence,
+		ProjectID:   n.ProjectID,
+		AssigneeID:  n.AssigneeID,
+		Progress:    n.Progress,
+		TagIDs:      n.TagIDs,
 	}
 }
 
@@ -166,3 +286,160 @@ func UserUnmarshalingToUser(n *UserUnmarshaling) *User {
 
 	return &u
 }
+
+// Types of the events in the audit log
+const (
+	AuditLogin          = "login"
+	AuditLoginFailed    = "login_failed"
+	AuditLogout         = "logout"
+	AuditPasswordChange = "password_change"
+	AuditAdminGrant     = "admin_grant"
+	AuditAdminRevoke    = "admin_revoke"
+	AuditUserCreate     = "user_create"
+	AuditUserActivate   = "user_activate"
+	AuditUserDeactivate = "user_deactivate"
+	AuditUserDelete     = "user_delete"
+)
+
+// A security relevant event. Usernames are saved with the IDs, so the event
+// is still readable after the users are deleted.
+type AuditEvent struct {
+	ID   int64
+	Type string
+	// Who did it, 0 if not authenticated (e.g. a failed login)
+	ActorID   int64
+	ActorName string
+	// The user the event is about, 0 if it does not exist
+	TargetID   int64
+	TargetName string
+	IP         string
+	UserAgent  string
+	Time       time.Time
+	// Free text, e.g. the reason of a failed login
+	Details string
+}
+
+type Session struct {
+	ID int64
+	// Only the hash of the token is stored, the token is known only by the
+	// client
+	TokenHash string
+	UserID    int64
+	Created   time.Time
+	Expire    time.Time
+	LastSeen  time.Time
+	UserAgent string
+	IP        string
+}
+
+type SessionResponse struct {
+	ID        int64
+	Created   time.Time
+	Expire    time.Time
+	LastSeen  time.Time
+	UserAgent string
+	IP        string
+	// True if this is the session used for the request
+	Current bool
+}
+
+func SessionToResponse(s *Session) *SessionResponse {
+	return &SessionResponse{
+		ID:        s.ID,
+		Created:   s.Created,
+		Expire:    s.Expire,
+		LastSeen:  s.LastSeen,
+		UserAgent: s.UserAgent,
+		IP:        s.IP,
+	}
+}
+
+// Scopes that can be granted to an API token
+const (
+	ScopeTodosRead  = "todos:read"
+	ScopeTodosWrite = "todos:write"
+	ScopeUsersAdmin = "users:admin"
+)
+
+var Scopes = []string{ScopeTodosRead, ScopeTodosWrite, ScopeUsersAdmin}
+
+type APIToken struct {
+	ID     int64
+	UserID int64
+	Name   string
+	// Only the hash of the token is stored, the token is shown to the user
+	// only when created
+	TokenHash string
+	Scopes    []string
+	Created   time.Time
+	// Zero if the token never expires
+	Expire time.Time
+	// Zero if the token was never used
+	LastUsed time.Time
+}
+
+func (t *APIToken) IsExpired() bool {
+	return !t.Expire.IsZero() && t.Expire.Before(time.Now())
+}
+
+func (t *APIToken) HasScope(scope string) bool {
+	for i := range t.Scopes {
+		if t.Scopes[i] == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type APITokenResponse struct {
+	ID       int64
+	Name     string
+	Scopes   []string
+	Created  time.Time
+	Expire   time.Time
+	LastUsed time.Time
+	// Present only in the response to the creation of the token
+	Token string `json:",omitempty"`
+}
+
+func APITokenToResponse(t *APIToken) *APITokenResponse {
+	return &APITokenResponse{
+		ID:       t.ID,
+		Name:     t.Name,
+		Scopes:   t.Scopes,
+		Created:  t.Created,
+		Expire:   t.Expire,
+		LastUsed: t.LastUsed,
+	}
+}
+
+type TodoSearchResult struct {
+	Todo Todo
+	// The higher the better
+	Rank float64
+	// Part of the todo that matches the query as HTML, with the matches
+	// between <b> and </b>
+	Snippet string
+}
+
+// Body of all the error responses of the API
+type APIError struct {
+	// The status text in snake case, e.g. not_found
+	Code    string
+	Message string
+	// The fields of the request that are not valid, if any
+	Details []FieldError `json:",omitempty"`
+	// Also in the X-Request-ID header and in the logs
+	RequestID string
+}
+
+// A field of a request that is not valid. The field is the one of the body,
+// or the name of the query or path parameter.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e *FieldError) Error() string {
+	return e.Message
+}
@@ -0,0 +1,103 @@
+package doit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Events a webhook can subscribe to
+const (
+	WebhookNoteCreated      = "note.created"
+	WebhookNoteUpdated      = "note.updated"
+	WebhookNoteStateChanged = "note.state_changed"
+	WebhookNoteDeleted      = "note.deleted"
+	WebhookNoteRestored     = "note.restored"
+	WebhookUserCreated      = "user.created"
+	WebhookUserUpdated      = "user.updated"
+	WebhookUserDeleted      = "user.deleted"
+)
+
+var WebhookEvents = []string{WebhookNoteCreated, WebhookNoteUpdated, WebhookNoteStateChanged, WebhookNoteDeleted, WebhookNoteRestored, WebhookUserCreated, WebhookUserUpdated, WebhookUserDeleted}
+
+// Event sent by the test endpoint, whatever the events of the webhook
+const WebhookPing = "ping"
+
+// Header with the HMAC of the payload, see SignWebhookPayload
+const WebhookSignatureHeader = "X-DOIT-Signature"
+
+type Webhook struct {
+	ID     int64
+	UserID int64
+	URL    string
+	// Key of the HMAC of the payloads
+	Secret string
+	// Empty means all the events
+	Events []string
+	// A global webhook receives the events of all the users and the user
+	// events. Only admins can create them.
+	Global  bool
+	Active  bool
+	Created time.Time
+}
+
+// This is used during JSON unmarshaling to check if values are present
+type WebhookUnmarshaling struct {
+	URL    *string
+	Secret *string
+	Events *[]string
+	Global *bool
+	Active *bool
+}
+
+// Return if the webhook subscribed to the event
+func (w *Webhook) Wants(event string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
+}
+
+// Return if the event is about users, that only global webhooks receive
+func IsUserEvent(event string) bool {
+	return strings.HasPrefix(event, "user.")
+}
+
+// The body POSTed to a webhook
+type WebhookPayload struct {
+	// Same for all the attempts to deliver the payload
+	ID    string
+	Event string
+	Time  time.Time
+	// Who caused the event, 0 if unknown
+	ActorID int64
+	// The note after the event, or before it if deleted
+	Note    *Todo         `json:",omitempty"`
+	Changes []TodoChange  `json:",omitempty"`
+	User    *UserResponse `json:",omitempty"`
+}
+
+// An attempt to deliver a payload to a webhook
+type WebhookDelivery struct {
+	ID        int64
+	WebhookID int64
+	// ID of the payload
+	PayloadID string
+	Event     string
+	// From 1
+	Attempt int
+	// 0 if there was no response
+	StatusCode int
+	// Empty if the delivery succeeded
+	Error   string
+	Time    time.Time
+	Payload string
+}
+
+// Return the signature of the body, as "sha256=" followed by the hex of its
+// HMAC-SHA256 with the secret
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
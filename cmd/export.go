@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/db"
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+var formatsUsage = strings.Join(doit.ExportFormats, ", ")
+
+var exportUsage = `Usage: doit export [-format FORMAT] <username>
+
+Write the todos of the user to the standard output. FORMAT is one of
+` + formatsUsage + ` (default ` + doit.ExportFormats[0] + `).`
+
+var importUsage = `Usage: doit import [-format FORMAT] [-dry-run] <username> [file]
+
+Import todos for the user from the file, or from the standard input. FORMAT
+is one of ` + formatsUsage + ` (default ` + doit.ExportFormats[0] + `). With
+-dry-run the todos are only checked.`
+
+// Open the database and return the user with the username
+func openUser(username string) (db.Repository, *doit.User, error) {
+	repo, err := db.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := repo.GetUserByUsername(username)
+	if err != nil {
+		repo.Close()
+		if errors.Is(err, db.ErrNotExists) {
+			return nil, nil, fmt.Errorf("User %q does not exist", username)
+		}
+		return nil, nil, err
+	}
+	return repo, user, nil
+}
+
+// Parse the flags of a subcommand, with the usage as the error
+func parseFlags(fs *flag.FlagSet, usage string, args []string) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return errors.New(usage)
+	}
+	return nil
+}
+
+// Handle the "doit export" subcommand
+func exportCmd(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", doit.ExportFormats[0], "")
+	if err := parseFlags(fs, exportUsage, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || !slices.Contains(doit.ExportFormats, *format) {
+		return errors.New(exportUsage)
+	}
+
+	repo, user, err := openUser(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	records, err := db.ExportRecords(repo, user.ID)
+	if err != nil {
+		return err
+	}
+	return doit.WriteRecords(os.Stdout, *format, records)
+}
+
+// Handle the "doit import" subcommand
+func importCmd(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", doit.ExportFormats[0], "")
+	dryRun := fs.Bool("dry-run", false, "")
+	if err := parseFlags(fs, importUsage, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 || !slices.Contains(doit.ExportFormats, *format) {
+		return errors.New(importUsage)
+	}
+
+	var in io.Reader = os.Stdin
+	if fs.NArg() == 2 {
+		f, err := os.Open(fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	records, err := doit.ReadRecords(in, *format)
+	if err != nil {
+		return fmt.Errorf("Could not read todos: %w", err)
+	}
+
+	repo, user, err := openUser(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	result, created, err := db.ImportRecords(repo, user.ID, records, *dryRun)
+	if err != nil {
+		return err
+	}
+	// The todos are already created, so the history is best effort
+	now := time.Now()
+	for i := range created {
+		_, err := repo.CreateTodoEvent(doit.TodoEvent{
+			TodoID:  created[i].ID,
+			UserID:  user.ID,
+			Action:  doit.TodoEventCreate,
+			Time:    now,
+			Changes: doit.DiffTodos(nil, &created[i]),
+			Todo:    created[i],
+		})
+		if err != nil {
+			slog.With("err", err, "id", created[i].ID).Error("Recording todo history")
+		}
+	}
+	if len(result.Errors) > 0 {
+		for _, e := range result.Errors {
+			fmt.Fprintf(os.Stderr, "Todo %d: %s\n", e.Row, e.Error)
+		}
+		return fmt.Errorf("Nothing imported, %d todos are not valid", len(result.Errors))
+	}
+	if *dryRun {
+		fmt.Printf("%d todos would be imported\n", result.Imported)
+	} else {
+		fmt.Printf("Imported %d todos\n", result.Imported)
+	}
+	return nil
+}
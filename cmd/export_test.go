@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/samuelemusiani/doit/cmd/config"
+	"github.com/samuelemusiani/doit/cmd/db"
+	"github.com/samuelemusiani/doit/cmd/doit"
+	"gotest.tools/v3/assert"
+)
+
+// Run the subcommand with the standard output in the file
+func runToFile(t *testing.T, path string, cmd func([]string) error, args ...string) {
+	f, err := os.Create(path)
+	assert.NilError(t, err)
+	defer f.Close()
+
+	stdout := os.Stdout
+	os.Stdout = f
+	defer func() { os.Stdout = stdout }()
+
+	assert.NilError(t, cmd(args))
+}
+
+func TestExportImport(t *testing.T) {
+	dir := t.TempDir()
+	conf := config.GetConfig()
+	conf.Databse.Driver = "sqlite"
+	conf.Databse.Path = filepath.Join(dir, "doit.db")
+
+	r, err := db.Init()
+	assert.NilError(t, err)
+	user, err := r.GetUserByUsername(conf.Users.First_User.Username)
+	assert.NilError(t, err)
+	_, err = r.CreateTodo(doit.Todo{
+		Title:      "Buy milk",
+		UserID:     user.ID,
+		StateID:    doit.States[1].ID,
+		PriorityID: doit.Priorities[2].ID,
+		ColorID:    doit.Colors[3].ID,
+	})
+	assert.NilError(t, err)
+	assert.NilError(t, r.Close())
+
+	// The commands run in a new process, where only Open sets the IDs
+	for _, s := range doit.States {
+		s.ID = 0
+	}
+	for _, p := range doit.Priorities {
+		p.ID = 0
+	}
+	for _, c := range doit.Colors {
+		c.ID = 0
+	}
+
+	for _, format := range doit.ExportFormats {
+		path := filepath.Join(dir, "todos."+format)
+		runToFile(t, path, exportCmd, "-format", format, user.Username)
+	}
+	for _, format := range doit.ExportFormats {
+		path := filepath.Join(dir, "todos."+format)
+		runToFile(t, filepath.Join(dir, "import.log"), importCmd, "-format", format, user.Username, path)
+	}
+
+	r, err = db.Open()
+	assert.NilError(t, err)
+	defer r.Close()
+	todos, err := r.AllTodos(user.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(todos), 1+len(doit.ExportFormats))
+	for _, todo := range todos {
+		assert.Equal(t, todo.Title, "Buy milk")
+		assert.Equal(t, todo.StateID, doit.States[1].ID)
+		assert.Equal(t, todo.PriorityID, doit.Priorities[2].ID)
+		assert.Equal(t, todo.ColorID, doit.Colors[3].ID)
+	}
+}
@@ -0,0 +1,34 @@
+package http_server
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Record an event in the audit log. actor and target can be nil if unknown.
+// The action is already done, so errors are only logged.
+func (srv *Server) recordAudit(r *http.Request, eventType string, actor *doit.User, target *doit.User, details string) {
+	e := doit.AuditEvent{
+		Type:      eventType,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Time:      time.Now(),
+		Details:   details,
+	}
+	if actor != nil {
+		e.ActorID = actor.ID
+		e.ActorName = actor.Username
+	}
+	if target != nil {
+		e.TargetID = target.ID
+		e.TargetName = target.Username
+	}
+
+	_, err := srv.repo.CreateAuditEvent(e)
+	if err != nil {
+		slog.With("err", err, "type", eventType).Error("Recording audit event")
+	}
+}
@@ -0,0 +1,115 @@
+package http_server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/db"
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Prefix of every API token, so they are easy to recognize
+const API_TOKEN_PREFIX = "doit_"
+
+type authKey struct{}
+
+// Who is doing the request. It's set by authMiddleware in the context of every
+// authenticated request.
+type auth struct {
+	userID int64
+	// Set only if the request is authenticated with the session cookie
+	sessionID int64
+	// Set only if the request is authenticated with an API token
+	token *doit.APIToken
+}
+
+func withAuth(r *http.Request, a auth) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), authKey{}, a))
+}
+
+// Return the auth of the request and true if the request is authenticated,
+// false otherwise
+func getAuth(r *http.Request) (auth, bool) {
+	a, ok := r.Context().Value(authKey{}).(auth)
+	return a, ok
+}
+
+// Return the scope an API token needs for the request and true, or false if
+// the request can't be done with an API token at all (e.g. managing sessions
+// or other tokens).
+func requiredScope(r *http.Request) (string, bool) {
+	p := r.URL.Path
+	switch {
+	case p == "/api/notes" || strings.HasPrefix(p, "/api/notes/"),
+		p == "/api/tags" || strings.HasPrefix(p, "/api/tags/"),
+		p == "/api/projects" || strings.HasPrefix(p, "/api/projects/"),
+		p == "/api/trash" || strings.HasPrefix(p, "/api/trash/"),
+		p == "/api/webhooks" || strings.HasPrefix(p, "/api/webhooks/"),
+		p == "/api/export", p == "/api/import", p == "/api/events", p == CALENDAR_FEED_PATH:
+		if r.Method == http.MethodGet {
+			return doit.ScopeTodosRead, true
+		}
+		return doit.ScopeTodosWrite, true
+	case strings.HasPrefix(p, DAV_PREFIX):
+		switch r.Method {
+		case http.MethodGet, "PROPFIND", "REPORT":
+			return doit.ScopeTodosRead, true
+		}
+		return doit.ScopeTodosWrite, true
+	case p == "/api/users" || strings.HasPrefix(p, "/api/users/"),
+		strings.HasPrefix(p, "/api/admin/"):
+		return doit.ScopeUsersAdmin, true
+	}
+	return "", false
+}
+
+// Return the token in the Authorization header and true if present, false
+// otherwise
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	token, found := strings.CutPrefix(h, "Bearer ")
+	if !found || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+func newAPIToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return API_TOKEN_PREFIX + hex.EncodeToString(b), nil
+}
+
+// Return the API token and true if is present, false otherwise
+func (srv *Server) getAPIToken(token string) (*doit.APIToken, bool) {
+	t, err := srv.repo.GetAPITokenByTokenHash(hashToken(token))
+	if err != nil {
+		if !errors.Is(err, db.ErrNotExists) {
+			slog.With("err", err).Error("Getting API token from DB")
+		}
+		return nil, false
+	}
+	return t, true
+}
+
+// Record that the token was just used
+func (srv *Server) touchAPIToken(t *doit.APIToken) {
+	now := time.Now()
+	if now.Sub(t.LastUsed) < SESSIONS_LAST_SEEN_RESOLUTION {
+		return
+	}
+
+	err := srv.repo.UpdateAPITokenLastUsed(t.ID, now)
+	if err != nil {
+		slog.With("err", err, "id", t.ID).Error("Updating API token last used")
+	}
+}
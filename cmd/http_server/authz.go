@@ -0,0 +1,126 @@
+package http_server
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/samuelemusiani/doit/cmd/db"
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// What a user can do on a note or a project. Every level includes the ones
+// before it.
+type access int
+
+const (
+	accessNone access = iota
+	accessRead
+	// Only the state of the note can be changed, as the assignee does
+	accessState
+	accessEdit
+	accessOwner
+)
+
+func permissionAccess(permission string) access {
+	switch permission {
+	case doit.PermissionRead:
+		return accessRead
+	case doit.PermissionEdit:
+		return accessEdit
+	}
+	return accessNone
+}
+
+// Access needed for the method: GET needs read, everything else edit.
+// Deleting and sharing need owner, so they are checked explicitly.
+func methodAccess(method string) access {
+	if method == http.MethodGet {
+		return accessRead
+	}
+	return accessEdit
+}
+
+func (srv *Server) todoAccess(note *doit.Todo, userID int64) (access, error) {
+	if note.UserID == userID {
+		return accessOwner, nil
+	}
+	p, err := srv.repo.TodoSharePermission(note.ID, userID)
+	if err != nil {
+		return accessNone, err
+	}
+	a := permissionAccess(p)
+	if note.AssigneeID == userID && a < accessState {
+		a = accessState
+	}
+	return a, nil
+}
+
+func (srv *Server) projectAccess(p *doit.Project, userID int64) (access, error) {
+	if p.UserID == userID {
+		return accessOwner, nil
+	}
+	perm, err := srv.repo.ProjectSharePermission(p.ID, userID)
+	if err != nil {
+		return accessNone, err
+	}
+	return permissionAccess(perm), nil
+}
+
+// Return the note and the access of the user on it if he has at least the
+// needed access, otherwise write the error and return false. A note the user
+// can't see at all does not exist for him, so 404 is returned instead of 403.
+// Notes in the trash can be reached only through the trash.
+func (srv *Server) authorizeTodo(w http.ResponseWriter, noteID int64, userID int64, need access) (*doit.Todo, access, bool) {
+	note, err := srv.repo.GetTodoByID(noteID)
+	if err == nil && !note.DeletedAt.IsZero() {
+		err = db.ErrNotExists
+	}
+	if err == nil {
+		var got access
+		got, err = srv.todoAccess(note, userID)
+		if err == nil {
+			return note, got, checkAccess(w, got, need, "Note does not exist")
+		}
+	}
+
+	if errors.Is(err, db.ErrNotExists) {
+		jsonError(w, "Note does not exist", http.StatusNotFound)
+	} else {
+		slog.With("err", err, "id", noteID).Error("Getting note")
+		jsonError(w, "Could not get note", http.StatusInternalServerError)
+	}
+	return nil, accessNone, false
+}
+
+// Same as authorizeTodo, but for projects
+func (srv *Server) authorizeProject(w http.ResponseWriter, projectID int64, userID int64, need access) (*doit.Project, bool) {
+	p, err := srv.repo.GetProjectByID(projectID)
+	if err == nil {
+		var got access
+		got, err = srv.projectAccess(p, userID)
+		if err == nil {
+			return p, checkAccess(w, got, need, "Project does not exist")
+		}
+	}
+
+	if errors.Is(err, db.ErrNotExists) {
+		jsonError(w, "Project does not exist", http.StatusNotFound)
+	} else {
+		slog.With("err", err, "id", projectID).Error("Getting project from DB")
+		jsonError(w, "", http.StatusInternalServerError)
+	}
+	return nil, false
+}
+
+func checkAccess(w http.ResponseWriter, got access, need access, notFound string) bool {
+	switch {
+	case got == accessNone:
+		jsonError(w, notFound, http.StatusNotFound)
+		return false
+	case got < need:
+		jsonError(w, "Permission denied", http.StatusForbidden)
+		return false
+	}
+	return true
+}
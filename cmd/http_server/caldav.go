@@ -0,0 +1,753 @@
+package http_server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/db"
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// The CalDAV server. Paths are relative to the authenticated user:
+//
+//	/api/dav/                             root
+//	/api/dav/principal/                   principal of the user
+//	/api/dav/calendars/                   calendar home
+//	/api/dav/calendars/inbox/             notes without a project
+//	/api/dav/calendars/{projectID}/       notes of a project
+//	/api/dav/calendars/{collection}/{name} a note, as a VTODO
+const (
+	DAV_PREFIX     = "/api/dav/"
+	DAV_PRINCIPAL  = DAV_PREFIX + "principal/"
+	DAV_CALENDARS  = DAV_PREFIX + "calendars/"
+	DAV_INBOX      = "inbox"
+	DAV_MAX_OBJECT = 1 << 20
+)
+
+const (
+	nsDAV         = "DAV:"
+	nsCalDAV      = "urn:ietf:params:xml:ns:caldav"
+	nsCalServer   = "http://calendarserver.org/ns/"
+	davContentICS = "text/calendar; charset=utf-8"
+)
+
+// Prefixes used for the namespaces in the responses
+var davPrefixes = map[string]string{nsDAV: "d", nsCalDAV: "c", nsCalServer: "cs"}
+
+var (
+	propResourceType     = xml.Name{Space: nsDAV, Local: "resourcetype"}
+	propDisplayName      = xml.Name{Space: nsDAV, Local: "displayname"}
+	propPrincipal        = xml.Name{Space: nsDAV, Local: "current-user-principal"}
+	propPrincipalURL     = xml.Name{Space: nsDAV, Local: "principal-URL"}
+	propPrivileges       = xml.Name{Space: nsDAV, Local: "current-user-privilege-set"}
+	propETag             = xml.Name{Space: nsDAV, Local: "getetag"}
+	propContentType      = xml.Name{Space: nsDAV, Local: "getcontenttype"}
+	propCalendarHome     = xml.Name{Space: nsCalDAV, Local: "calendar-home-set"}
+	propComponentSet     = xml.Name{Space: nsCalDAV, Local: "supported-calendar-component-set"}
+	propCalendarData     = xml.Name{Space: nsCalDAV, Local: "calendar-data"}
+	propCTag             = xml.Name{Space: nsCalServer, Local: "getctag"}
+	reportCalendarQuery  = xml.Name{Space: nsCalDAV, Local: "calendar-query"}
+	reportCalendarMulti  = xml.Name{Space: nsCalDAV, Local: "calendar-multiget"}
+	defaultResourceRegex = regexp.MustCompile(`^doit-(\d+)\.ics$`)
+)
+
+// Return the API token in the Basic credentials of a CalDAV request and true
+// if present, false otherwise. CalDAV clients only support Basic, so the token
+// is used as password and the username is ignored.
+func davToken(r *http.Request) (string, bool) {
+	if !strings.HasPrefix(r.URL.Path, DAV_PREFIX) {
+		return "", false
+	}
+	_, token, ok := r.BasicAuth()
+	return token, ok && token != ""
+}
+
+// Name of the resource of a note never seen by a client
+func todoResourceName(id int64) string {
+	return doit.TodoUID(id) + ".ics"
+}
+
+// A calendar collection
+type davCollection struct {
+	name        string
+	displayName string
+	// 0 for the inbox
+	projectID int64
+	// The user can create notes in it
+	owned bool
+	// The user can change the notes in it
+	writable bool
+}
+
+func (c *davCollection) href() string {
+	return DAV_CALENDARS + c.name + "/"
+}
+
+// A note as a resource of a collection
+type davObject struct {
+	note doit.Todo
+	uid  string
+	name string
+}
+
+func (o *davObject) ics() []byte {
+	var b bytes.Buffer
+	doit.WriteVTODO(&b, &o.note, o.uid, time.Now())
+	return b.Bytes()
+}
+
+// The ETag changes only if the VTODO changes, so it's computed without the
+// DTSTAMP
+func (o *davObject) etag() string {
+	var b bytes.Buffer
+	doit.WriteVTODO(&b, &o.note, o.uid, time.Time{})
+	h := sha256.Sum256(b.Bytes())
+	return `"` + hex.EncodeToString(h[:16]) + `"`
+}
+
+// Return the collections of the user: the inbox and the projects he can see
+// that are not archived
+func (srv *Server) davCollections(userID int64) ([]davCollection, error) {
+	collections := []davCollection{{name: DAV_INBOX, displayName: "Inbox", owned: true, writable: true}}
+
+	projects, err := srv.repo.AllProjects(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range projects {
+		if projects[i].Archived {
+			continue
+		}
+		got, err := srv.projectAccess(&projects[i], userID)
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, projectCollection(&projects[i], got))
+	}
+	return collections, nil
+}
+
+func projectCollection(p *doit.Project, got access) davCollection {
+	return davCollection{
+		name:        strconv.FormatInt(p.ID, 10),
+		displayName: p.Name,
+		projectID:   p.ID,
+		owned:       got == accessOwner,
+		writable:    got >= accessEdit,
+	}
+}
+
+// Return the collection with the name, or nil if the user can't see it
+func (srv *Server) getDavCollection(userID int64, name string) (*davCollection, error) {
+	if name == DAV_INBOX {
+		return &davCollection{name: DAV_INBOX, displayName: "Inbox", owned: true, writable: true}, nil
+	}
+
+	id, err := strconv.ParseInt(name, 10, 64)
+	if err != nil || strconv.FormatInt(id, 10) != name {
+		return nil, nil
+	}
+	p, err := srv.repo.GetProjectByID(id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotExists) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	got, err := srv.projectAccess(p, userID)
+	if err != nil || got == accessNone {
+		return nil, err
+	}
+	c := projectCollection(p, got)
+	return &c, nil
+}
+
+// Return the notes of the collection visible to the user
+func (srv *Server) davObjects(userID int64, c *davCollection) ([]davObject, error) {
+	filter := db.TodoFilter{IncludeArchived: true}
+	if c.projectID == 0 {
+		filter.Inbox = true
+	} else {
+		filter.ProjectIDs = []int64{c.projectID}
+	}
+	notes, _, err := srv.repo.FilterTodos(userID, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(notes))
+	for i := range notes {
+		ids[i] = notes[i].ID
+	}
+	known, err := srv.repo.CalDAVObjects(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]davObject, len(notes))
+	for i := range notes {
+		objects[i] = davObject{note: notes[i], uid: doit.TodoUID(notes[i].ID), name: todoResourceName(notes[i].ID)}
+		for _, o := range known {
+			if o.TodoID == notes[i].ID {
+				objects[i].uid = o.UID
+				objects[i].name = o.Name
+			}
+		}
+	}
+	return objects, nil
+}
+
+// Return the note with the resource name in the collection and the access of
+// the user on it, or nil if there is none
+func (srv *Server) getDavObject(userID int64, c *davCollection, name string) (*davObject, access, error) {
+	candidates, err := srv.repo.CalDAVObjectsByName(name)
+	if err != nil {
+		return nil, accessNone, err
+	}
+	if m := defaultResourceRegex.FindStringSubmatch(name); m != nil {
+		id, _ := strconv.ParseInt(m[1], 10, 64)
+		candidates = append(candidates, doit.CalDAVObject{TodoID: id, UID: doit.TodoUID(id), Name: name})
+	}
+
+	for _, o := range candidates {
+		note, err := srv.repo.GetTodoByID(o.TodoID)
+		if errors.Is(err, db.ErrNotExists) {
+			continue
+		}
+		if err != nil {
+			return nil, accessNone, err
+		}
+		if !note.DeletedAt.IsZero() || note.ProjectID != c.projectID {
+			continue
+		}
+
+		// A note known by a client has only its own name
+		known, err := srv.repo.CalDAVObjects([]int64{note.ID})
+		if err != nil {
+			return nil, accessNone, err
+		}
+		if len(known) > 0 && known[0].Name != name {
+			continue
+		}
+
+		got, err := srv.todoAccess(note, userID)
+		if err != nil {
+			return nil, accessNone, err
+		}
+		if got == accessNone {
+			continue
+		}
+		return &davObject{note: *note, uid: o.UID, name: name}, got, nil
+	}
+	return nil, accessNone, nil
+}
+
+// Props of a resource, as inner XML
+type davProps map[xml.Name]string
+
+type davResponse struct {
+	href string
+	// Props of the resource, nil if it does not exist
+	props davProps
+}
+
+type davPropfind struct {
+	XMLName xml.Name     `xml:"DAV: propfind"`
+	AllProp *struct{}    `xml:"DAV: allprop"`
+	Prop    *davPropList `xml:"DAV: prop"`
+}
+
+type davPropList struct {
+	Names []davAny `xml:",any"`
+}
+
+type davAny struct {
+	XMLName xml.Name
+}
+
+// A calendar-query or calendar-multiget report
+type davReport struct {
+	XMLName xml.Name
+	Prop    *davPropList `xml:"DAV: prop"`
+	Hrefs   []string     `xml:"DAV: href"`
+	Filter  *struct {
+		Comp davCompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	} `xml:"urn:ietf:params:xml:ns:caldav filter"`
+}
+
+type davCompFilter struct {
+	Name  string          `xml:"name,attr"`
+	Comps []davCompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+}
+
+// Return the requested props, nil for all of them
+func (l *davPropList) names() []xml.Name {
+	if l == nil {
+		return nil
+	}
+	names := make([]xml.Name, len(l.Names))
+	for i := range l.Names {
+		names[i] = l.Names[i].XMLName
+	}
+	return names
+}
+
+func davElement(name xml.Name, inner string) string {
+	prefix, ok := davPrefixes[name.Space]
+	if !ok {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(name.Space))
+		if inner == "" {
+			return fmt.Sprintf(`<x:%s xmlns:x="%s"/>`, name.Local, b.String())
+		}
+		return fmt.Sprintf(`<x:%s xmlns:x="%s">%s</x:%s>`, name.Local, b.String(), inner, name.Local)
+	}
+	if inner == "" {
+		return fmt.Sprintf("<%s:%s/>", prefix, name.Local)
+	}
+	return fmt.Sprintf("<%s:%s>%s</%s:%s>", prefix, name.Local, inner, prefix, name.Local)
+}
+
+func davEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func davHref(href string) string {
+	return "<d:href>" + davEscape(href) + "</d:href>"
+}
+
+// Write the multistatus with the requested props of each resource. The ones a
+// resource does not have are reported as not found.
+func writeMultistatus(w http.ResponseWriter, responses []davResponse, requested []xml.Name) {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav" xmlns:cs="http://calendarserver.org/ns/">`)
+	for _, resp := range responses {
+		b.WriteString("<d:response>" + davHref(resp.href))
+		if resp.props == nil {
+			b.WriteString("<d:status>HTTP/1.1 404 Not Found</d:status></d:response>")
+			continue
+		}
+
+		var found, missing []string
+		if requested == nil {
+			for name, inner := range resp.props {
+				if name != propCalendarData {
+					found = append(found, davElement(name, inner))
+				}
+			}
+			sort.Strings(found)
+		}
+		for _, name := range requested {
+			if inner, ok := resp.props[name]; ok {
+				found = append(found, davElement(name, inner))
+			} else {
+				missing = append(missing, davElement(name, ""))
+			}
+		}
+		if len(found) > 0 {
+			b.WriteString("<d:propstat><d:prop>" + strings.Join(found, "") + "</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat>")
+		}
+		if len(missing) > 0 {
+			b.WriteString("<d:propstat><d:prop>" + strings.Join(missing, "") + "</d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat>")
+		}
+		b.WriteString("</d:response>")
+	}
+	b.WriteString("</d:multistatus>\n")
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(b.String()))
+}
+
+// Props that tell a client where to find the calendars of the user
+func davPrincipalProps(user *doit.User) davProps {
+	return davProps{
+		propPrincipal:    davHref(DAV_PRINCIPAL),
+		propPrincipalURL: davHref(DAV_PRINCIPAL),
+		propCalendarHome: davHref(DAV_CALENDARS),
+		propDisplayName:  davEscape(user.Username),
+	}
+}
+
+func davCollectionProps(c *davCollection, objects []davObject) davProps {
+	// The CTag changes when any of the notes changes
+	etags := make([]string, len(objects))
+	for i := range objects {
+		etags[i] = objects[i].name + objects[i].etag()
+	}
+	sort.Strings(etags)
+	h := sha256.Sum256([]byte(strings.Join(etags, "\n")))
+
+	privileges := "<d:privilege><d:read/></d:privilege>"
+	if c.writable {
+		privileges += "<d:privilege><d:write/></d:privilege>"
+	}
+
+	return davProps{
+		propResourceType: "<d:collection/><c:calendar/>",
+		propDisplayName:  davEscape(c.displayName),
+		propComponentSet: `<c:comp name="VTODO"/>`,
+		propCTag:         hex.EncodeToString(h[:16]),
+		propPrivileges:   privileges,
+	}
+}
+
+func davObjectProps(c *davCollection, o *davObject) davResponse {
+	return davResponse{
+		href: c.href() + url.PathEscape(o.name),
+		props: davProps{
+			propResourceType: "",
+			propETag:         davEscape(o.etag()),
+			propContentType:  davContentICS + "; component=VTODO",
+			propCalendarData: davEscape(string(o.ics())),
+		},
+	}
+}
+
+// Set the error and return true if the If-Match and If-None-Match headers
+// don't match the ETag of the resource, "" if it does not exist
+func davPreconditionFailed(w http.ResponseWriter, r *http.Request, etag string) bool {
+	ifMatch := r.Header.Get("If-Match")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	failed := (ifMatch != "" && (etag == "" || (ifMatch != "*" && ifMatch != etag))) ||
+		(ifNoneMatch != "" && etag != "" && (ifNoneMatch == "*" || ifNoneMatch == etag))
+	if failed {
+		jsonError(w, "Precondition failed", http.StatusPreconditionFailed)
+	}
+	return failed
+}
+
+// Redirect the clients that look for the CalDAV server (RFC 6764)
+func wellKnownCalDAVHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, DAV_PREFIX, http.StatusMovedPermanently)
+}
+
+func (srv *Server) davHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS PROPFIND REPORT GET PUT DELETE")
+		w.Header().Set("DAV", "1, 3, calendar-access")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	user, err := srv.userFromRequest(r)
+	if err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			slog.Error("At this stage request should be authenticated")
+			jsonError(w, "", http.StatusUnauthorized)
+			return
+		}
+		slog.With("err", err).Error("Getting user of CalDAV request")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(DAV_PREFIX, "/")), "/")
+	var segments []string
+	if path != "" {
+		segments = strings.Split(path, "/")
+	}
+
+	switch {
+	case len(segments) == 0 || (len(segments) == 1 && segments[0] == "principal"):
+		davPrincipalHandler(w, r, user, segments)
+	case segments[0] != "calendars" || len(segments) > 3:
+		jsonError(w, "Not found", http.StatusNotFound)
+	case len(segments) == 1:
+		srv.davHomeHandler(w, r, user)
+	default:
+		c, err := srv.getDavCollection(user.ID, segments[1])
+		if err != nil {
+			slog.With("err", err).Error("Getting CalDAV collection")
+			jsonError(w, "", http.StatusInternalServerError)
+			return
+		}
+		if c == nil {
+			jsonError(w, "Calendar not found", http.StatusNotFound)
+			return
+		}
+		if len(segments) == 2 {
+			srv.davCollectionHandler(w, r, user, c)
+		} else {
+			srv.davObjectHandler(w, r, user, c, segments[2])
+		}
+	}
+}
+
+// Parse the body of a PROPFIND and return the requested props, nil for all.
+// On error it's written and false is returned.
+func davRequestedProps(w http.ResponseWriter, r *http.Request) ([]xml.Name, bool) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, DAV_MAX_OBJECT))
+	if err != nil {
+		jsonError(w, "", http.StatusBadRequest)
+		return nil, false
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, true
+	}
+
+	var pf davPropfind
+	if err := xml.Unmarshal(body, &pf); err != nil {
+		jsonError(w, "Body is not a valid propfind", http.StatusBadRequest)
+		return nil, false
+	}
+	if pf.AllProp != nil {
+		return nil, true
+	}
+	return pf.Prop.names(), true
+}
+
+func davPrincipalHandler(w http.ResponseWriter, r *http.Request, user *doit.User, segments []string) {
+	if r.Method != "PROPFIND" {
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	requested, ok := davRequestedProps(w, r)
+	if !ok {
+		return
+	}
+
+	href, resourceType := DAV_PREFIX, "<d:collection/>"
+	if len(segments) == 1 {
+		href, resourceType = DAV_PRINCIPAL, "<d:principal/>"
+	}
+	props := davPrincipalProps(user)
+	props[propResourceType] = resourceType
+	writeMultistatus(w, []davResponse{{href: href, props: props}}, requested)
+}
+
+func (srv *Server) davHomeHandler(w http.ResponseWriter, r *http.Request, user *doit.User) {
+	if r.Method != "PROPFIND" {
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	requested, ok := davRequestedProps(w, r)
+	if !ok {
+		return
+	}
+
+	props := davPrincipalProps(user)
+	props[propResourceType] = "<d:collection/>"
+	responses := []davResponse{{href: DAV_CALENDARS, props: props}}
+
+	if r.Header.Get("Depth") != "0" {
+		collections, err := srv.davCollections(user.ID)
+		if err != nil {
+			slog.With("err", err).Error("Getting CalDAV collections")
+			jsonError(w, "", http.StatusInternalServerError)
+			return
+		}
+		for i := range collections {
+			objects, err := srv.davObjects(user.ID, &collections[i])
+			if err != nil {
+				slog.With("err", err).Error("Getting CalDAV objects")
+				jsonError(w, "", http.StatusInternalServerError)
+				return
+			}
+			responses = append(responses, davResponse{href: collections[i].href(), props: davCollectionProps(&collections[i], objects)})
+		}
+	}
+	writeMultistatus(w, responses, requested)
+}
+
+func (srv *Server) davCollectionHandler(w http.ResponseWriter, r *http.Request, user *doit.User, c *davCollection) {
+	if r.Method != "PROPFIND" && r.Method != "REPORT" {
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	objects, err := srv.davObjects(user.ID, c)
+	if err != nil {
+		slog.With("err", err).Error("Getting CalDAV objects")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == "REPORT" {
+		davReportHandler(w, r, user, c, objects)
+		return
+	}
+
+	requested, ok := davRequestedProps(w, r)
+	if !ok {
+		return
+	}
+	responses := []davResponse{{href: c.href(), props: davCollectionProps(c, objects)}}
+	if r.Header.Get("Depth") != "0" {
+		for i := range objects {
+			responses = append(responses, davObjectProps(c, &objects[i]))
+		}
+	}
+	writeMultistatus(w, responses, requested)
+}
+
+// Only calendar-query, without filters except for the component, and
+// calendar-multiget are supported
+func davReportHandler(w http.ResponseWriter, r *http.Request, user *doit.User, c *davCollection, objects []davObject) {
+	var report davReport
+	if err := xml.NewDecoder(io.LimitReader(r.Body, DAV_MAX_OBJECT)).Decode(&report); err != nil {
+		jsonError(w, "Body is not a valid report", http.StatusBadRequest)
+		return
+	}
+	requested := report.Prop.names()
+
+	var responses []davResponse
+	switch report.XMLName {
+	case reportCalendarQuery:
+		// Only VTODOs are in the calendars
+		if report.Filter != nil {
+			for _, comp := range report.Filter.Comp.Comps {
+				if !strings.EqualFold(comp.Name, "VTODO") {
+					objects = nil
+				}
+			}
+		}
+		for i := range objects {
+			responses = append(responses, davObjectProps(c, &objects[i]))
+		}
+	case reportCalendarMulti:
+		for _, href := range report.Hrefs {
+			name := ""
+			if u, err := url.Parse(href); err == nil {
+				name, _ = strings.CutPrefix(u.Path, c.href())
+			}
+			i := slices.IndexFunc(objects, func(o davObject) bool { return o.name == name })
+			if i < 0 {
+				responses = append(responses, davResponse{href: href})
+			} else {
+				responses = append(responses, davObjectProps(c, &objects[i]))
+			}
+		}
+	default:
+		jsonError(w, "Report not supported", http.StatusForbidden)
+		return
+	}
+	writeMultistatus(w, responses, requested)
+}
+
+func (srv *Server) davObjectHandler(w http.ResponseWriter, r *http.Request, user *doit.User, c *davCollection, name string) {
+	o, got, err := srv.getDavObject(user.ID, c, name)
+	if err != nil {
+		slog.With("err", err).Error("Getting CalDAV object")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	etag := ""
+	if o != nil {
+		etag = o.etag()
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if o == nil {
+			jsonError(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", davContentICS)
+		w.Header().Set("ETag", etag)
+		w.Write(o.ics())
+	case http.MethodPut:
+		if davPreconditionFailed(w, r, etag) {
+			return
+		}
+		v, err := doit.ParseVTODO(http.MaxBytesReader(w, r.Body, DAV_MAX_OBJECT))
+		if err != nil {
+			jsonError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if o == nil {
+			srv.davCreateObject(w, user, c, name, v)
+		} else {
+			srv.davUpdateObject(w, user, o, got, v)
+		}
+	case http.MethodDelete:
+		if o == nil {
+			jsonError(w, "Note not found", http.StatusNotFound)
+			return
+		}
+		if davPreconditionFailed(w, r, etag) {
+			return
+		}
+		if got < accessOwner {
+			jsonError(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+		err := srv.repo.TrashTodo(o.note.ID, o.note.UserID, time.Now())
+		if err != nil {
+			slog.With("err", err, "id", o.note.ID).Error("Deleting note from CalDAV")
+			jsonError(w, "", http.StatusInternalServerError)
+			return
+		}
+		srv.recordTodoEvent(user.ID, doit.TodoEventDelete, &o.note, nil)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Notes can be created only in the inbox and in the projects of the user. The
+// new note is known to the client by the name and the UID it chose.
+func (srv *Server) davCreateObject(w http.ResponseWriter, user *doit.User, c *davCollection, name string, v *doit.VTodo) {
+	if !c.owned {
+		jsonError(w, "Permission denied", http.StatusForbidden)
+		return
+	}
+
+	note := doit.Todo{
+		StateID:    doit.States[0].ID,
+		PriorityID: doit.Priorities[0].ID,
+		ColorID:    doit.Colors[0].ID,
+		ProjectID:  c.projectID,
+		TagIDs:     []int64{},
+	}
+	v.Apply(&note)
+	created, ok := srv.createNote(w, note, user.ID)
+	if !ok {
+		return
+	}
+
+	uid := v.UID
+	if uid == "" {
+		uid = doit.TodoUID(created.ID)
+	}
+	err := srv.repo.SetCalDAVObject(doit.CalDAVObject{TodoID: created.ID, UID: uid, Name: name})
+	if err != nil {
+		slog.With("err", err, "id", created.ID).Error("Saving CalDAV object")
+	}
+	// The stored VTODO is not the one sent, so no ETag is returned
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Only the fields of the note that are in a VTODO are changed
+func (srv *Server) davUpdateObject(w http.ResponseWriter, user *doit.User, o *davObject, got access, v *doit.VTodo) {
+	if got < accessState {
+		jsonError(w, "Permission denied", http.StatusForbidden)
+		return
+	}
+
+	note := o.note
+	v.Apply(&note)
+	if _, ok := srv.updateNote(w, &o.note, note, got, user.ID); !ok {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
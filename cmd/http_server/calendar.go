@@ -0,0 +1,60 @@
+package http_server
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/db"
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Path of the iCalendar feed. Calendar clients can only subscribe to a URL,
+// so here the API token can also be passed in the token query parameter.
+const CALENDAR_FEED_PATH = "/api/calendar.ics"
+
+// Return the token in the query of the calendar feed and true if present,
+// false otherwise
+func feedToken(r *http.Request) (string, bool) {
+	if r.URL.Path != CALENDAR_FEED_PATH {
+		return "", false
+	}
+	token := r.URL.Query().Get("token")
+	return token, token != ""
+}
+
+// Return the notes visible to the user that expire as VTODOs
+func (srv *Server) calendarHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	notes, _, err := srv.repo.FilterTodos(a.userID, db.TodoFilter{})
+	if err != nil {
+		slog.With("err", err).Error("Getting notes for the calendar")
+		jsonError(w, "Could not get notes", http.StatusInternalServerError)
+		return
+	}
+
+	var due []doit.Todo
+	for _, n := range notes {
+		if n.Expiration.DoesExpire {
+			due = append(due, n)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	err = doit.WriteICalendar(w, "DOIT", due, time.Now())
+	if err != nil {
+		slog.With("err", err).Error("Writing calendar")
+	}
+}
@@ -0,0 +1,101 @@
+package http_server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Header with the ID of the request, set on all the responses
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+// IDs of requests coming from a proxy are kept if they are safe to log
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Write the error as a doit.APIError. An empty message is replaced by the
+// status text. The error is logged with the ID of the request, so the response
+// can be found in the logs.
+func jsonError(w http.ResponseWriter, message string, code int, details ...doit.FieldError) {
+	id := w.Header().Get(REQUEST_ID_HEADER)
+	if id == "" {
+		id = newRequestID()
+		w.Header().Set(REQUEST_ID_HEADER, id)
+	}
+	if message == "" {
+		message = http.StatusText(code)
+	}
+
+	l := slog.With("requestID", id, "code", code, "message", message)
+	if code >= http.StatusInternalServerError {
+		l.Error("Error response")
+	} else {
+		l.Debug("Error response")
+	}
+
+	b, err := json.Marshal(doit.APIError{
+		Code:      strings.ReplaceAll(strings.ToLower(http.StatusText(code)), " ", "_"),
+		Message:   message,
+		Details:   details,
+		RequestID: id,
+	})
+	if err != nil {
+		slog.With("err", err).Error("Marshaling error response")
+		b = []byte("{}")
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Content-Disposition")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+// Write a 400 with the fields of the request that are not valid
+func validationError(w http.ResponseWriter, details ...doit.FieldError) {
+	message := "Request is not valid"
+	if len(details) == 1 {
+		message = details[0].Message
+	}
+	jsonError(w, message, http.StatusBadRequest, details...)
+}
+
+// Return a doit.FieldError, for badRequest
+func fieldError(field string, format string, a ...any) error {
+	return &doit.FieldError{Field: field, Message: fmt.Sprintf(format, a...)}
+}
+
+// Write a 400 with the error, and its field if it's a doit.FieldError
+func badRequest(w http.ResponseWriter, err error) {
+	var fe *doit.FieldError
+	if errors.As(err, &fe) {
+		validationError(w, *fe)
+		return
+	}
+	jsonError(w, err.Error(), http.StatusBadRequest)
+}
+
+// Used for the API paths that don't exist, instead of the UI
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	jsonError(w, "Not found", http.StatusNotFound)
+}
+
+// Used by the router when the path exists, but not for the method
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,290 @@
+package http_server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/db"
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Events kept to be sent again to the clients that reconnect
+const EVENTS_KEPT = 256
+
+// Events that can wait to be sent to a client. A slower client is
+// disconnected and gets them again when it reconnects.
+const EVENTS_CLIENT_BUFFER = 64
+
+// Event sent when the events after the Last-Event-ID of a client are not kept
+// anymore, so it has to reload the notes
+const EVENT_RESET = "reset"
+
+// How often a comment is sent on the streams, so proxies don't close them
+// when there are no events. A variable so tests don't wait.
+var eventsHeartbeat = 30 * time.Second
+
+// A change of a note, sent to the users that could see it when it happened
+type streamEvent struct {
+	id    int64
+	event string
+	note  doit.Todo
+	users []int64
+}
+
+// In process pub/sub of the changes of the notes, for the event streams
+type eventBroker struct {
+	mu      sync.Mutex
+	lastID  int64
+	recent  []streamEvent
+	clients map[chan streamEvent]struct{}
+	closed  bool
+	// To find who can see the notes
+	repo db.Repository
+}
+
+func newEventBroker(r db.Repository) *eventBroker {
+	// IDs start from the time, so the ones of a previous run of the server are
+	// lower and its clients are told to reload
+	return &eventBroker{
+		lastID:  time.Now().UnixMicro(),
+		clients: make(map[chan streamEvent]struct{}),
+		repo:    r,
+	}
+}
+
+func (b *eventBroker) publish(event string, note *doit.Todo) {
+	users, err := b.noteAudience(note)
+	if err != nil {
+		slog.With("err", err, "id", note.ID).Error("Getting users of note event")
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+
+	b.lastID++
+	e := streamEvent{id: b.lastID, event: event, note: *note, users: users}
+	b.recent = append(b.recent, e)
+	if len(b.recent) > EVENTS_KEPT {
+		b.recent = slices.Delete(b.recent, 0, len(b.recent)-EVENTS_KEPT)
+	}
+
+	for c := range b.clients {
+		select {
+		case c <- e:
+		default:
+			delete(b.clients, c)
+			close(c)
+		}
+	}
+}
+
+// Subscribe a client to the events after lastID, 0 for only the new ones.
+// Return the channel of the new events, closed when the client has to
+// disconnect, and the events already published after lastID. If they are not
+// kept anymore the ID of the last event is returned, to reset the client.
+func (b *eventBroker) subscribe(lastID int64) (chan streamEvent, []streamEvent, int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c := make(chan streamEvent, EVENTS_CLIENT_BUFFER)
+	if b.closed {
+		close(c)
+		return c, nil, 0
+	}
+	b.clients[c] = struct{}{}
+
+	if lastID == 0 || lastID == b.lastID {
+		return c, nil, 0
+	}
+	if lastID > b.lastID || len(b.recent) == 0 || b.recent[0].id > lastID+1 {
+		return c, nil, b.lastID
+	}
+	i := slices.IndexFunc(b.recent, func(e streamEvent) bool { return e.id > lastID })
+	return c, slices.Clone(b.recent[i:]), 0
+}
+
+func (b *eventBroker) unsubscribe(c chan streamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.clients[c]; ok {
+		delete(b.clients, c)
+		close(c)
+	}
+}
+
+// Return the users that can see the note: the owner, the assignee and the
+// ones it or its project is shared with
+func (b *eventBroker) noteAudience(note *doit.Todo) ([]int64, error) {
+	users := []int64{note.UserID}
+	if note.AssigneeID != 0 {
+		users = append(users, note.AssigneeID)
+	}
+
+	shares, err := b.repo.TodoShares(note.ID)
+	if err != nil {
+		return nil, err
+	}
+	if note.ProjectID != 0 {
+		projectShares, err := b.repo.ProjectShares(note.ProjectID)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, projectShares...)
+	}
+	for _, s := range shares {
+		users = append(users, s.UserID)
+	}
+	return users, nil
+}
+
+// Disconnect all the clients and stop publishing
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for c := range b.clients {
+		delete(b.clients, c)
+		close(c)
+	}
+}
+
+// Stream with Server-Sent Events the changes of the notes the user can see.
+// Clients that reconnect with Last-Event-ID get the events they missed. The
+// stream is closed when its session or API token is not valid anymore.
+func (srv *Server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	var lastID int64
+	if s := r.Header.Get("Last-Event-ID"); s != "" {
+		var err error
+		lastID, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || lastID < 0 {
+			validationError(w, doit.FieldError{Field: "Last-Event-ID", Message: "Last-Event-ID is not valid"})
+			return
+		}
+	}
+
+	// The stream is not limited by the write timeout of the server
+	rc := http.NewResponseController(w)
+	err := rc.SetWriteDeadline(time.Time{})
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		slog.With("err", err).Error("Removing write deadline of event stream")
+	}
+
+	c, missed, resetID := srv.events.subscribe(lastID)
+	defer srv.events.unsubscribe(c)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Disable the buffering of nginx
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if resetID != 0 {
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: {}\n\n", resetID, EVENT_RESET)
+	}
+	for i := 0; i < len(missed) && err == nil; i++ {
+		err = writeStreamEvent(w, &missed[i], a.userID)
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for err == nil {
+		err = rc.Flush()
+		if err != nil {
+			break
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-c:
+			if !ok {
+				return
+			}
+			if !slices.Contains(e.users, a.userID) {
+				continue
+			}
+			if !srv.streamAuthValid(r, a) {
+				return
+			}
+			err = writeStreamEvent(w, &e, a.userID)
+		case <-heartbeat.C:
+			if !srv.streamAuthValid(r, a) {
+				return
+			}
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		}
+	}
+	slog.With("err", err).Debug("Writing event stream")
+}
+
+// The session or the API token of a stream can be revoked, or the user
+// deactivated, after it started. Return false if the stream has to be closed.
+func (srv *Server) streamAuthValid(r *http.Request, a auth) bool {
+	var userID int64
+	if a.token != nil {
+		t, err := srv.repo.GetAPITokenByTokenHash(a.token.TokenHash)
+		if err != nil || t.IsExpired() {
+			slog.With("err", err, "tokenID", a.token.ID).Debug("Closing event stream, API token not valid")
+			return false
+		}
+		userID = t.UserID
+	} else {
+		c, err := r.Cookie(SESSION_COOCKIE_NAME)
+		if err != nil {
+			return false
+		}
+		s, ok := srv.getSession(c.Value)
+		if !ok || s.isExpired() || s.id != a.sessionID {
+			slog.With("sessionID", a.sessionID).Debug("Closing event stream, session not valid")
+			return false
+		}
+		userID = s.userID
+	}
+
+	user, err := srv.repo.GetUserByID(userID)
+	if err != nil || !user.Active {
+		slog.With("err", err, "userID", userID).Debug("Closing event stream, user not active")
+		return false
+	}
+	return true
+}
+
+// Write the event if the user could see its note
+func writeStreamEvent(w http.ResponseWriter, e *streamEvent, userID int64) error {
+	if !slices.Contains(e.users, userID) {
+		return nil
+	}
+
+	b, err := json.Marshal(e.note)
+	if err != nil {
+		slog.With("err", err, "id", e.note.ID).Error("Marshaling note of event")
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.id, e.event, b)
+	return err
+}
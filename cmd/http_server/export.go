@@ -0,0 +1,158 @@
+package http_server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/samuelemusiani/doit/cmd/db"
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Maximum size of the body of an import
+const IMPORT_MAX_SIZE = 10 << 20
+
+// Content type and file extension of each of doit.ExportFormats
+var exportTypes = map[string]struct {
+	contentType string
+	extension   string
+}{
+	"json":     {"application/json", "json"},
+	"csv":      {"text/csv", "csv"},
+	"todotxt":  {"text/plain; charset=utf-8", "txt"},
+	"markdown": {"text/markdown; charset=utf-8", "md"},
+}
+
+// Return the format of the request, the default if not specified
+func exportFormat(r *http.Request) (string, bool) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		return doit.ExportFormats[0], true
+	}
+	return format, slices.Contains(doit.ExportFormats, format)
+}
+
+func (srv *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	format, ok := exportFormat(r)
+	if !ok {
+		validationError(w, doit.FieldError{Field: "format", Message: "Format must be one of " + strings.Join(doit.ExportFormats, ", ")})
+		return
+	}
+
+	records, err := db.ExportRecords(srv.repo, a.userID)
+	if err != nil {
+		slog.With("err", err).Error("Getting notes to export")
+		jsonError(w, "Could not export notes", http.StatusInternalServerError)
+		return
+	}
+
+	t := exportTypes[format]
+	w.Header().Set("Content-Type", t.contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="doit.`+t.extension+`"`)
+	err = doit.WriteRecords(w, format, records)
+	if err != nil {
+		slog.With("err", err).Error("Writing exported notes")
+	}
+}
+
+// Import the notes in the format of the export. If a record is not valid
+// nothing is imported and the errors of all the records are returned. With
+// dry_run the records are only checked.
+func (srv *Server) importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS POST")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	format, ok := exportFormat(r)
+	if !ok {
+		validationError(w, doit.FieldError{Field: "format", Message: "Format must be one of " + strings.Join(doit.ExportFormats, ", ")})
+		return
+	}
+
+	dryRun := false
+	if s := r.URL.Query().Get("dry_run"); s != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(s)
+		if err != nil {
+			validationError(w, doit.FieldError{Field: "dry_run", Message: "dry_run must be a boolean"})
+			return
+		}
+	}
+
+	body := http.MaxBytesReader(w, r.Body, IMPORT_MAX_SIZE)
+	records, err := doit.ReadRecords(body, format)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			jsonError(w, "Body is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if errors.Is(err, io.EOF) {
+			jsonError(w, "Body is empty", http.StatusBadRequest)
+			return
+		}
+		jsonError(w, "Could not read notes: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, created, err := db.ImportRecords(srv.repo, a.userID, records, dryRun)
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidTag) || errors.Is(err, db.ErrInvalidProject) {
+			jsonError(w, "Tags or projects changed during the import", http.StatusConflict)
+			return
+		}
+		slog.With("err", err).Error("Importing notes")
+		jsonError(w, "Could not import notes", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range created {
+		srv.recordTodoEvent(a.userID, doit.TodoEventCreate, nil, &created[i])
+	}
+
+	status := http.StatusOK
+	switch {
+	case len(result.Errors) > 0:
+		status = http.StatusBadRequest
+	case !dryRun && result.Imported > 0:
+		status = http.StatusCreated
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling import result")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
@@ -8,8 +8,11 @@ import (
 	"io/fs"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"path"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -20,25 +23,28 @@ import (
 
 const SESSION_COOCKIE_NAME = "ST"
 
-func staticHandler(w http.ResponseWriter, r *http.Request) {
+// Header with the total number of items when a list is paginated
+const TOTAL_COUNT_HEADER = "X-Total-Count"
+
+func (srv *Server) staticHandler(w http.ResponseWriter, r *http.Request) {
 	p := r.URL.Path[1:]
 	if p == "" || p == "static" || p == "static/" {
 		p = "index.html"
 	}
 
-	f, err := fs.ReadFile(ui_fs, p)
+	f, err := fs.ReadFile(srv.ui, p)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			// If the file does not exists it could be a route that the SPA router
 			// would catch. We serve the index.html instead
 
-			f, err = fs.ReadFile(ui_fs, "index.html")
+			f, err = fs.ReadFile(srv.ui, "index.html")
 			if err != nil {
 				if errors.Is(err, fs.ErrNotExist) {
-					http.Error(w, "", http.StatusNotFound)
+					jsonError(w, "", http.StatusNotFound)
 				} else {
 					slog.With("err", err).Error("Reading index.html")
-					http.Error(w, "", http.StatusInternalServerError)
+					jsonError(w, "", http.StatusInternalServerError)
 				}
 				return
 			}
@@ -47,7 +53,7 @@ func staticHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		slog.With("path", p, "err", err).Error("Reading file")
-		http.Error(w, "", http.StatusInternalServerError)
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
 
@@ -66,7 +72,7 @@ func rootAPIHandler(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
-func notesHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) notesHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodOptions {
 		w.Header().Set("Allow", "GET OPTIONS POST")
 		w.WriteHeader(http.StatusOK)
@@ -74,35 +80,154 @@ func notesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c, err := r.Cookie(SESSION_COOCKIE_NAME)
-	if err != nil {
-		slog.With("err", err).Error("At this stage cookie should be present")
-		http.Error(w, "", http.StatusUnauthorized)
-		return
-	}
-	s, b := getSession(c.Value)
-	if !b || s.isExpired() {
-		slog.With("err", err).Error("At this stage cookie should valid")
-		http.Error(w, "", http.StatusUnauthorized)
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
 		return
 	}
 
 	switch r.Method {
 	case http.MethodGet:
-		notesHandlerGET(w, r, s.userID)
+		srv.notesHandlerGET(w, r, a.userID)
 	case http.MethodPost:
-		notesHandlerPOST(w, r, s.userID)
+		srv.notesHandlerPOST(w, r, a.userID)
 	default:
 	}
 
 	return
 }
 
-func notesHandlerGET(w http.ResponseWriter, r *http.Request, userID int64) {
-	notes, err := db.AllTodos(userID)
+// Parse a comma separated list of IDs
+func parseIDs(s string) ([]int64, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(s, ",")
+	ids := make([]int64, len(parts))
+	for i := range parts {
+		id, err := strconv.ParseInt(strings.TrimSpace(parts[i]), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// Build the filter for the notes from the query parameters of the request
+func parseTodoFilter(q url.Values) (db.TodoFilter, error) {
+	var f db.TodoFilter
+	var err error
+
+	f.StateIDs, err = parseIDs(q.Get("state"))
+	if err != nil {
+		return f, fieldError("state", "state is not valid: %v", err)
+	}
+	f.PriorityIDs, err = parseIDs(q.Get("priority"))
+	if err != nil {
+		return f, fieldError("priority", "priority is not valid: %v", err)
+	}
+	f.ColorIDs, err = parseIDs(q.Get("color"))
+	if err != nil {
+		return f, fieldError("color", "color is not valid: %v", err)
+	}
+	f.TagIDs, err = parseIDs(q.Get("tag"))
+	if err != nil {
+		return f, fieldError("tag", "tag is not valid: %v", err)
+	}
+
+	// e.g. project=inbox,3
+	if s := q.Get("project"); s != "" {
+		var ids []string
+		for _, p := range strings.Split(s, ",") {
+			if strings.TrimSpace(p) == "inbox" {
+				f.Inbox = true
+			} else {
+				ids = append(ids, p)
+			}
+		}
+		f.ProjectIDs, err = parseIDs(strings.Join(ids, ","))
+		if err != nil {
+			return f, fieldError("project", "project is not valid: %v", err)
+		}
+	}
+	// assigned=me lists the notes assigned to the user
+	switch q.Get("assigned") {
+	case "":
+	case "me":
+		f.AssignedToMe = true
+	default:
+		return f, fieldError("assigned", "assigned is not valid")
+	}
+	if s := q.Get("archived"); s != "" {
+		f.IncludeArchived, err = strconv.ParseBool(s)
+		if err != nil {
+			return f, fieldError("archived", "archived is not valid: %v", err)
+		}
+	}
+
+	if s := q.Get("expires_before"); s != "" {
+		f.ExpiresBefore, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return f, fieldError("expires_before", "expires_before is not valid: %v", err)
+		}
+	}
+	if s := q.Get("expires_after"); s != "" {
+		f.ExpiresAfter, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return f, fieldError("expires_after", "expires_after is not valid: %v", err)
+		}
+	}
+
+	f.Query = q.Get("q")
+
+	// e.g. sort=priority,-expiration
+	if s := q.Get("sort"); s != "" {
+		for _, field := range strings.Split(s, ",") {
+			field = strings.TrimSpace(field)
+			desc := strings.HasPrefix(field, "-")
+			f.Sort = append(f.Sort, db.TodoSort{Field: strings.TrimPrefix(field, "-"), Desc: desc})
+		}
+	}
+
+	if s := q.Get("limit"); s != "" {
+		f.Limit, err = strconv.Atoi(s)
+		if err != nil || f.Limit < 0 {
+			return f, fieldError("limit", "limit is not valid")
+		}
+	}
+	if s := q.Get("offset"); s != "" {
+		f.Offset, err = strconv.Atoi(s)
+		if err != nil || f.Offset < 0 {
+			return f, fieldError("offset", "offset is not valid")
+		}
+	}
+
+	return f, nil
+}
+
+func (srv *Server) notesHandlerGET(w http.ResponseWriter, r *http.Request, userID int64) {
+	filter, err := parseTodoFilter(r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	srv.writeNotes(w, userID, filter)
+}
+
+// Write the notes of the user that match the filter, with the total count
+func (srv *Server) writeNotes(w http.ResponseWriter, userID int64, filter db.TodoFilter) {
+	notes, total, err := srv.repo.FilterTodos(userID, filter)
 	if err != nil {
+		if errors.Is(err, db.ErrInvalidFilter) {
+			validationError(w, doit.FieldError{Field: "sort", Message: err.Error()})
+			return
+		}
 		slog.With("err", err).Error("While getting notes from DB")
-		http.Error(w, "Could not get notes", http.StatusInternalServerError)
+		jsonError(w, "Could not get notes", http.StatusInternalServerError)
 		return
 	}
 
@@ -113,56 +238,140 @@ func notesHandlerGET(w http.ResponseWriter, r *http.Request, userID int64) {
 		response, err = json.Marshal(notes)
 		if err != nil {
 			slog.With("err", err).Error("While parsing notes for json")
-			http.Error(w, "Could not get notes", http.StatusInternalServerError)
+			jsonError(w, "Could not get notes", http.StatusInternalServerError)
 			return
 		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(TOTAL_COUNT_HEADER, strconv.FormatInt(total, 10))
 	w.WriteHeader(http.StatusOK)
 	w.Write(response)
 }
 
-func notesHandlerPOST(w http.ResponseWriter, r *http.Request, userID int64) {
+func (srv *Server) notesHandlerPOST(w http.ResponseWriter, r *http.Request, userID int64) {
 	decoder := json.NewDecoder(r.Body)
 	var note doit.Todo
 	err := decoder.Decode(&note)
 	if err != nil {
-		slog.With("err", err).Error("Decoding request body")
-		// Should we return another type of error?
-		http.Error(w, "Something went wrong", http.StatusBadRequest)
+		jsonError(w, "Could not unmarshal body", http.StatusBadRequest)
+		return
+	}
+
+	noteCreated, ok := srv.createNote(w, note, userID)
+	if !ok {
+		return
+	}
+
+	jnote, err := json.Marshal(doit.TodoToResponse(noteCreated))
+	if err != nil {
+		slog.With("note", note, "err", err).Error("Could not parse note to json")
+		jsonError(w, "Todo was added but we could not send the note back", http.StatusInternalServerError)
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(jnote)
+	return
+}
+
+// Create the note of the user and record it in its history. If the note is
+// not valid the error is written and false is returned.
+func (srv *Server) createNote(w http.ResponseWriter, note doit.Todo, userID int64) (*doit.Todo, bool) {
 	// Basic check, we could improve it in the future
 	if note.Title == "" {
-		http.Error(w, "Title is empty or not present", http.StatusBadRequest)
-		return
+		validationError(w, doit.FieldError{Field: "Title", Message: "Title is empty or not present"})
+		return nil, false
+	}
+
+	if _, err := note.NormalizeRecurrence(); err != nil {
+		validationError(w, doit.FieldError{Field: "Recurrence", Message: err.Error()})
+		return nil, false
+	}
+
+	if note.AssigneeID != 0 && !srv.checkAssignee(w, note.AssigneeID) {
+		return nil, false
 	}
 
 	slog.With("note", note).Debug("Adding note to db")
 	note.UserID = userID
-	noteCreated, err := db.CreateTodo(note)
+	noteCreated, err := srv.repo.CreateTodo(note)
 	if err != nil {
+		if errors.Is(err, db.ErrInvalidTag) {
+			jsonError(w, "Tags are not valid", http.StatusBadRequest)
+			return nil, false
+		}
+		if errors.Is(err, db.ErrInvalidProject) {
+			jsonError(w, "Project is not valid", http.StatusBadRequest)
+			return nil, false
+		}
 		slog.With("note", note, "err", err).Error("Adding note to db")
-		http.Error(w, "Something went wrong", http.StatusInternalServerError)
+		jsonError(w, "Could not add note", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	srv.recordTodoEvent(userID, doit.TodoEventCreate, nil, noteCreated)
+	return noteCreated, true
+}
+
+// Maximum number of results returned by a search
+const SEARCH_MAX_RESULTS = 50
+
+func (srv *Server) notesSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS")
+		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	jnote, err := json.Marshal(doit.TodoToResponse(noteCreated))
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	q := r.URL.Query().Get("q")
+	if strings.TrimSpace(q) == "" {
+		validationError(w, doit.FieldError{Field: "q", Message: "Query is empty or not present"})
+		return
+	}
+
+	limit := SEARCH_MAX_RESULTS
+	if s := r.URL.Query().Get("limit"); s != "" {
+		l, err := strconv.Atoi(s)
+		if err != nil || l <= 0 {
+			validationError(w, doit.FieldError{Field: "limit", Message: "limit is not valid"})
+			return
+		}
+		limit = min(l, SEARCH_MAX_RESULTS)
+	}
+
+	results, err := srv.repo.SearchTodos(a.userID, q, limit)
 	if err != nil {
-		slog.With("note", note, "err", err).Error("Could not parse note to json")
-		http.Error(w, "Todo was added but we could not send the note back", http.StatusInternalServerError)
+		slog.With("err", err, "q", q).Error("Searching notes in DB")
+		jsonError(w, "Could not search notes", http.StatusInternalServerError)
 		return
 	}
 
+	var response []byte
+	if len(results) == 0 {
+		response = []byte("[]")
+	} else {
+		response, err = json.Marshal(results)
+		if err != nil {
+			slog.With("err", err).Error("While parsing search results for json")
+			jsonError(w, "Could not search notes", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	w.Write(jnote)
-	return
+	w.Write(response)
 }
 
-func singleTodoHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) singleTodoHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodOptions {
 		w.Header().Set("Allow", "GET OPTIONS PUT DELETE")
 		w.WriteHeader(http.StatusOK)
@@ -173,67 +382,56 @@ func singleTodoHandler(w http.ResponseWriter, r *http.Request) {
 	id_string, ok := mux.Vars(r)["id"]
 	if !ok {
 		slog.With("vars", mux.Vars(r)).Error("Could not get id from router vars in singleTodoHandler")
-		http.Error(w, "", http.StatusInternalServerError)
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
 
 	id, err := strconv.ParseInt(id_string, 10, 64)
 	if err != nil {
-		slog.With("err", err).Error("Parsing int")
-		http.Error(w, "Id is not valid", http.StatusBadRequest)
+		validationError(w, doit.FieldError{Field: "id", Message: "Id is not valid"})
 		return
 	}
 
-	c, err := r.Cookie(SESSION_COOCKIE_NAME)
-	if err != nil {
-		slog.With("err", err).Error("At this stage cookie should be present")
-		http.Error(w, "", http.StatusInternalServerError)
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
 		return
 	}
-	s, b := getSession(c.Value)
-	if !b || s.isExpired() {
-		slog.With("err", err).Error("At this stage cookie should valid")
-		http.Error(w, "", http.StatusUnauthorized)
+
+	var need access
+	switch r.Method {
+	case http.MethodPut:
+		need = accessState
+	case http.MethodDelete:
+		need = accessOwner
+	default:
+		need = methodAccess(r.Method)
+	}
+	note, got, ok := srv.authorizeTodo(w, id, a.userID, need)
+	if !ok {
 		return
 	}
 
 	switch r.Method {
 	case http.MethodGet:
-		singleTodoHandlerGET(w, r, id, s.userID)
+		singleTodoHandlerGET(w, r, note)
 	case http.MethodDelete:
-		singleTodoHandlerDELETE(w, r, id, s.userID)
+		srv.singleTodoHandlerDELETE(w, r, note)
 	case http.MethodPut:
-		singleTodoHandlerPUT(w, r, id, s.userID)
+		srv.singleTodoHandlerPUT(w, r, note, got, a.userID)
 	default:
 		slog.With("method", r.Method).Error("Method not valid. How did we get here?")
-		http.Error(w, "Bad method", http.StatusMethodNotAllowed)
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 	return
 }
 
-func singleTodoHandlerGET(w http.ResponseWriter, r *http.Request, noteID int64, userId int64) {
-	note, err := db.GetTodoByID(noteID)
-	if err != nil {
-		slog.With("err", err, "id", noteID).Error("Getting notes")
-		if errors.Is(err, db.ErrNotExists) {
-			w.WriteHeader(http.StatusNotFound)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
-		w.Write([]byte("Could not get note"))
-		return
-	}
-
-	if note.UserID != userId {
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte("Could not get note"))
-		return
-	}
-
+func singleTodoHandlerGET(w http.ResponseWriter, r *http.Request, note *doit.Todo) {
 	jnote, err := json.Marshal(note)
 	if err != nil {
 		slog.With("err", err).Error("While parsing note for json")
-		http.Error(w, "Could not get note", http.StatusInternalServerError)
+		jsonError(w, "Could not get note", http.StatusInternalServerError)
 		return
 	}
 
@@ -242,55 +440,202 @@ func singleTodoHandlerGET(w http.ResponseWriter, r *http.Request, noteID int64,
 	w.Write(jnote)
 }
 
-func singleTodoHandlerPUT(w http.ResponseWriter, r *http.Request, noteID int64, userID int64) {
+// actorID is the user doing the update
+func (srv *Server) singleTodoHandlerPUT(w http.ResponseWriter, r *http.Request, old *doit.Todo, got access, actorID int64) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		slog.With("err", err).Error("Reading body")
-		http.Error(w, "", http.StatusInternalServerError)
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
 
 	var note doit.Todo
 	err = json.Unmarshal(body, &note)
 	if err != nil {
-		http.Error(w, "Could not unmarshal body", http.StatusBadRequest)
+		jsonError(w, "Could not unmarshal body", http.StatusBadRequest)
 		return
 	}
 
-	note.UserID = userID
-
-	newTodo, err := db.UpdateTodo(noteID, note, userID)
-	if err != nil {
-		slog.With("err", err).Error("Updating note")
-		http.Error(w, "Could not update note", http.StatusBadRequest)
+	newTodo, ok := srv.updateNote(w, old, note, got, actorID)
+	if !ok {
 		return
 	}
 
 	b, err := json.Marshal(*newTodo)
 	if err != nil {
 		slog.With("err", err).Error("Marshaling note update")
-		w.Write([]byte("Todo updated, but can't be returned"))
+		jsonError(w, "Note was updated but we could not send it back", http.StatusInternalServerError)
 		return
 	}
 
 	w.Write(b)
 }
 
-func singleTodoHandlerDELETE(w http.ResponseWriter, r *http.Request, noteID int64, userID int64) {
-	err := db.DeleteTodoByID(noteID, userID)
+// Update old with note and record the change in its history. The note keeps
+// its owner, even when it's updated by a user it's shared with. Only the
+// owner can assign the note, and the assignee can change only the state. If
+// the update is not valid the error is written and false is returned.
+func (srv *Server) updateNote(w http.ResponseWriter, old *doit.Todo, note doit.Todo, got access, actorID int64) (*doit.Todo, bool) {
+	if got < accessEdit {
+		state := note.StateID
+		note = *old
+		note.StateID = state
+	}
+	note.ID = old.ID
+	note.UserID = old.UserID
+
+	if note.AssigneeID != old.AssigneeID {
+		if got < accessOwner {
+			jsonError(w, "Only the owner can assign the note", http.StatusForbidden)
+			return nil, false
+		}
+		if !srv.checkAssignee(w, note.AssigneeID) {
+			return nil, false
+		}
+	}
+
+	rule, err := note.NormalizeRecurrence()
+	if err != nil {
+		validationError(w, doit.FieldError{Field: "Recurrence", Message: err.Error()})
+		return nil, false
+	}
+
+	// When an occurrence is done the rule moves to the next one, so the done
+	// note does not repeat anymore
+	completed := false
+	if rule != nil && note.StateID == doit.StateDone.ID && old.StateID != doit.StateDone.ID {
+		completed = true
+		note.Recurrence = ""
+	}
+
+	newTodo, err := srv.repo.UpdateTodo(note.ID, note, note.UserID)
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidTag) {
+			jsonError(w, "Tags are not valid", http.StatusBadRequest)
+			return nil, false
+		}
+		if errors.Is(err, db.ErrInvalidProject) {
+			jsonError(w, "Project is not valid", http.StatusBadRequest)
+			return nil, false
+		}
+		slog.With("err", err).Error("Updating note")
+		jsonError(w, "Could not update note", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	srv.recordTodoEvent(actorID, doit.TodoEventUpdate, old, newTodo)
+	if completed {
+		srv.createNextOccurrence(newTodo, rule, actorID)
+	}
+	return newTodo, true
+}
+
+// Return true if a note can be assigned to the user, that is if it's 0 (not
+// assigned) or an active user, otherwise write the error and return false
+func (srv *Server) checkAssignee(w http.ResponseWriter, userID int64) bool {
+	if userID == 0 {
+		return true
+	}
+
+	user, err := srv.repo.GetUserByID(userID)
+	if err != nil && !errors.Is(err, db.ErrNotExists) {
+		slog.With("err", err, "id", userID).Error("Getting assignee from DB")
+		jsonError(w, "", http.StatusInternalServerError)
+		return false
+	}
+	if err != nil || !user.Active {
+		validationError(w, doit.FieldError{Field: "AssigneeID", Message: "Assignee is not an active user"})
+		return false
+	}
+	return true
+}
+
+// Create the occurrence that follows done, if any. The new note has the same
+// fields and tags, and the checklist is copied with all the items not done.
+func (srv *Server) createNextOccurrence(done *doit.Todo, rule *doit.Recurrence, actorID int64) {
+	due, rest, ok := rule.Next(done.Expiration.Date)
+	if !ok {
+		return
+	}
+
+	next := *done
+	next.ID = 0
+	next.StateID = doit.StateToDo.ID
+	next.Expiration.Date = due
+	next.Recurrence = rest.String()
+	if next.TagIDs == nil {
+		next.TagIDs = []int64{}
+	}
+
+	created, err := srv.repo.CreateTodo(next)
+	if err != nil {
+		slog.With("err", err, "id", done.ID).Error("Creating next occurrence of note")
+		return
+	}
+	srv.recordTodoEvent(actorID, doit.TodoEventCreate, nil, created)
+
+	items, err := srv.repo.AllTodoItems(done.ID)
+	if err != nil {
+		slog.With("err", err, "id", done.ID).Error("Getting items of done occurrence")
+		return
+	}
+	for _, item := range items {
+		item.TodoID = created.ID
+		item.Done = false
+		if _, err := srv.repo.CreateTodoItem(item); err != nil {
+			slog.With("err", err, "id", created.ID).Error("Copying item to next occurrence")
+			return
+		}
+	}
+}
+
+// The note is moved to the trash, it's permanently deleted later
+func (srv *Server) singleTodoHandlerDELETE(w http.ResponseWriter, r *http.Request, note *doit.Todo) {
+	err := srv.repo.TrashTodo(note.ID, note.UserID, time.Now())
 	if err != nil {
 		if errors.Is(err, db.ErrDeleteFailed) {
-			w.WriteHeader(http.StatusNotFound)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
+			jsonError(w, "Note does not exist", http.StatusNotFound)
+			return
 		}
-		w.Write([]byte("Error deleting note"))
+		slog.With("err", err, "id", note.ID).Error("Moving note to the trash")
+		jsonError(w, "Could not delete note", http.StatusInternalServerError)
 		return
 	}
+	srv.recordTodoEvent(note.UserID, doit.TodoEventDelete, note, nil)
 	return
 }
 
-func loginHandler(w http.ResponseWriter, r *http.Request) {
+// Record the change of a note in its history. old is nil for a create and new
+// is nil for a delete. The change is already done, so errors are only logged.
+func (srv *Server) recordTodoEvent(actorID int64, action string, old *doit.Todo, new *doit.Todo) {
+	changes := doit.DiffTodos(old, new)
+	if len(changes) == 0 {
+		return
+	}
+
+	todo := new
+	if todo == nil {
+		todo = old
+	}
+	_, err := srv.repo.CreateTodoEvent(doit.TodoEvent{
+		TodoID:  todo.ID,
+		UserID:  actorID,
+		Action:  action,
+		Time:    time.Now(),
+		Changes: changes,
+		Todo:    *todo,
+	})
+	if err != nil {
+		slog.With("err", err, "id", todo.ID).Error("Recording note history")
+	}
+
+	srv.sendNoteWebhooks(actorID, action, todo, changes)
+	if event, ok := noteEventName(action); ok {
+		srv.events.publish(event, todo)
+	}
+}
+
+func (srv *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodOptions {
 		w.Header().Set("Allow", "GET OPTIONS POST DELETE")
 		w.WriteHeader(http.StatusOK)
@@ -300,57 +645,59 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
-		loginHandlerGET(w, r)
+		srv.loginHandlerGET(w, r)
 	case http.MethodPost:
-		loginHandlerPOST(w, r)
+		srv.loginHandlerPOST(w, r)
 	case http.MethodDelete:
-		loginHandlerDELETE(w, r)
+		srv.loginHandlerDELETE(w, r)
 	}
 	return
 }
 
-func loginHandlerGET(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) loginHandlerGET(w http.ResponseWriter, r *http.Request) {
 	c, err := r.Cookie(SESSION_COOCKIE_NAME)
 	if err != nil {
 		if errors.Is(err, http.ErrNoCookie) {
-			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			jsonError(w, "Not authenticated", http.StatusUnauthorized)
 			return
 		}
 		slog.With("err", err).Error("While getting cookies")
-		http.Error(w, "", http.StatusInternalServerError)
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
-	s, ok := getSession(c.Value)
+	s, ok := srv.getSession(c.Value)
 	if !ok {
-		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		jsonError(w, "Not authenticated", http.StatusUnauthorized)
 		return
 	}
 
-	user, err := db.GetUserByID(s.userID)
+	user, err := srv.repo.GetUserByID(s.userID)
 	if err != nil {
-		http.Error(w, "Could not get user", http.StatusInternalServerError)
+		slog.With("err", err, "id", s.userID).Error("Getting user of session")
+		jsonError(w, "Could not get user", http.StatusInternalServerError)
 		return
 	}
 
 	userResp := doit.UserToResponse(user)
 	b, err := json.Marshal(*userResp)
 	if err != nil {
-		http.Error(w, "Could not marshal user response", http.StatusInternalServerError)
+		slog.With("err", err).Error("Marshaling user")
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
 	w.Write(b)
 	return
 }
 
-func loginHandlerPOST(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) loginHandlerPOST(w http.ResponseWriter, r *http.Request) {
 	c, err := r.Cookie(SESSION_COOCKIE_NAME)
 	if !errors.Is(err, http.ErrNoCookie) {
 		if err != nil {
 			slog.With("err", err).Error("While getting cookies")
 		} else {
-			s, p := getSession(c.Value)
+			s, p := srv.getSession(c.Value)
 			if p && !s.isExpired() {
-				deleteSession(c.Value)
+				srv.deleteSession(c.Value)
 			}
 		}
 	}
@@ -358,7 +705,7 @@ func loginHandlerPOST(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		slog.With("err", err).Error("Reading body")
-		http.Error(w, "Reading body", http.StatusInternalServerError)
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
 
@@ -370,45 +717,59 @@ func loginHandlerPOST(w http.ResponseWriter, r *http.Request) {
 	var u UserPasswd
 	err = json.Unmarshal(body, &u)
 	if err != nil {
-		slog.With("err", err, "body", body).Error("Unmarshaling body")
-		http.Error(w, "Reading body", http.StatusBadRequest)
+		jsonError(w, "Could not unmarshal body", http.StatusBadRequest)
 		return
 	}
 
-	if len(u.Username) == 0 || len(u.Password) == 0 {
-		http.Error(w, "Username or password are empty", http.StatusBadRequest)
+	var missing []doit.FieldError
+	if len(u.Username) == 0 {
+		missing = append(missing, doit.FieldError{Field: "Username", Message: "Username is empty or not present"})
+	}
+	if len(u.Password) == 0 {
+		missing = append(missing, doit.FieldError{Field: "Password", Message: "Password is empty or not present"})
+	}
+	if len(missing) > 0 {
+		validationError(w, missing...)
 		return
 	}
 
-	user, err := db.GetUserByUsername(u.Username)
+	user, err := srv.repo.GetUserByUsername(u.Username)
 	if err != nil {
 		if errors.Is(err, db.ErrNotExists) {
-			http.Error(w, "User does not exists or password is not correct", http.StatusNotFound)
+			srv.recordAudit(r, doit.AuditLoginFailed, nil, &doit.User{Username: u.Username}, "unknown user")
+			jsonError(w, "User does not exist or password is not correct", http.StatusUnauthorized)
 			return
 		}
-		http.Error(w, "", http.StatusInternalServerError)
 		slog.With("err", err, "username", u.Username).Error("During user lookup on db")
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(u.Password))
 	if err != nil {
 		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			http.Error(w, "User does not exists or password is not correct", http.StatusNotFound)
+			srv.recordAudit(r, doit.AuditLoginFailed, nil, user, "wrong password")
+			jsonError(w, "User does not exist or password is not correct", http.StatusUnauthorized)
 			return
 		}
 		slog.With("err", err, "user", user).Error("Comparing hash with password hashed")
-		http.Error(w, "", http.StatusInternalServerError)
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
 
 	if !user.Active {
-		http.Error(w, "Username and password are correct, but user in not active", http.StatusForbidden)
+		srv.recordAudit(r, doit.AuditLoginFailed, nil, user, "user not active")
+		jsonError(w, "Username and password are correct, but user is not active", http.StatusForbidden)
 		return
 	}
 
 	expire := time.Now().Add(2 * 24 * time.Hour)
-	sToken := newSession(session{userID: user.ID, expire: expire})
+	sToken, err := srv.newSession(user.ID, expire, r)
+	if err != nil {
+		slog.With("err", err, "user", u.Username).Error("Creating new session")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
 	http.SetCookie(w, &http.Cookie{
 		Name:  SESSION_COOCKIE_NAME,
 		Value: sToken,
@@ -420,61 +781,68 @@ func loginHandlerPOST(w http.ResponseWriter, r *http.Request) {
 		SameSite: http.SameSiteStrictMode,
 	})
 
+	srv.recordAudit(r, doit.AuditLogin, user, user, "")
 	slog.With("user", u.Username).Info("Logged in")
 	w.Write([]byte(fmt.Sprintf("Logged in as user %s with id %d", user.Username, user.ID)))
 	return
 }
 
-func loginHandlerDELETE(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) loginHandlerDELETE(w http.ResponseWriter, r *http.Request) {
 	c, err := r.Cookie(SESSION_COOCKIE_NAME)
 	if err != nil {
 		w.WriteHeader(http.StatusResetContent)
 		return
 	}
-	deleteSession(c.Value)
+	if s, ok := srv.getSession(c.Value); ok {
+		user, err := srv.repo.GetUserByID(s.userID)
+		if err == nil {
+			srv.recordAudit(r, doit.AuditLogout, user, user, "")
+		}
+	}
+	srv.deleteSession(c.Value)
 	w.WriteHeader(http.StatusResetContent)
 	return
 }
 
-func usersHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) usersHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodOptions {
 		w.Header().Set("Allow", "GET POST OPTIONS")
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	isAdmin, err := isAdminFromRequest(r)
+	isAdmin, err := srv.isAdminFromRequest(r)
 	if errors.Is(err, ErrInteral) {
 		slog.With("err", err).Error("Checking if user is admin")
-		http.Error(w, "", http.StatusInternalServerError)
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	} else if errors.Is(err, ErrUnauthorized) {
 		// This should never happen
 		slog.Error("Passing middleware of authentication, but not authenticated")
-		http.Error(w, "Not authenticated", http.StatusUnauthorized)
+		jsonError(w, "Not authenticated", http.StatusUnauthorized)
 		return
 	}
 
 	if !isAdmin {
-		http.Error(w, "Not authenticated", http.StatusForbidden)
+		jsonError(w, "Not an admin", http.StatusForbidden)
 		return
 	}
 
 	switch r.Method {
 	case http.MethodGet:
-		usersHandlerGET(w, r)
+		srv.usersHandlerGET(w, r)
 	case http.MethodPost:
-		usersHandlerPOST(w, r)
+		srv.usersHandlerPOST(w, r)
 	}
 
 	return
 }
 
-func usersHandlerGET(w http.ResponseWriter, r *http.Request) {
-	users, err := db.AllUsers()
+func (srv *Server) usersHandlerGET(w http.ResponseWriter, r *http.Request) {
+	users, err := srv.repo.AllUsers()
 	if err != nil {
 		slog.With("err", err).Error("Gettin users from DB")
-		http.Error(w, "", http.StatusInternalServerError)
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
 
@@ -490,7 +858,7 @@ func usersHandlerGET(w http.ResponseWriter, r *http.Request) {
 		res, err = json.Marshal(usersResponse)
 		if err != nil {
 			slog.With("err", err).Error("Marshaling users for response")
-			http.Error(w, "", http.StatusInternalServerError)
+			jsonError(w, "", http.StatusInternalServerError)
 			return
 		}
 	}
@@ -499,11 +867,11 @@ func usersHandlerGET(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
-func usersHandlerPOST(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) usersHandlerPOST(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		slog.With("err", err).Error("Could not read body of a request")
-		http.Error(w, "", http.StatusInternalServerError)
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
 
@@ -511,12 +879,22 @@ func usersHandlerPOST(w http.ResponseWriter, r *http.Request) {
 	err = json.Unmarshal(body, &u_user)
 	if err != nil {
 		slog.With("err", err).Error("Unmarshaling body")
-		http.Error(w, "Could not unmarshal body", http.StatusBadRequest)
+		jsonError(w, "Could not unmarshal body", http.StatusBadRequest)
 		return
 	}
 
-	if u_user.Username == nil || u_user.Password == nil || u_user.Email == nil {
-		http.Error(w, "Username, password or email are not present", http.StatusBadRequest)
+	var missing []doit.FieldError
+	if u_user.Username == nil {
+		missing = append(missing, doit.FieldError{Field: "Username", Message: "Username is not present"})
+	}
+	if u_user.Password == nil {
+		missing = append(missing, doit.FieldError{Field: "Password", Message: "Password is not present"})
+	}
+	if u_user.Email == nil {
+		missing = append(missing, doit.FieldError{Field: "Email", Message: "Email is not present"})
+	}
+	if len(missing) > 0 {
+		validationError(w, missing...)
 		return
 	}
 
@@ -526,39 +904,45 @@ func usersHandlerPOST(w http.ResponseWriter, r *http.Request) {
 	h, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
 	if err != nil {
 		if errors.Is(err, bcrypt.ErrPasswordTooLong) {
-			http.Error(w, "Password too long (> 72 bytes)", http.StatusBadRequest)
+			jsonError(w, "Password too long (> 72 bytes)", http.StatusBadRequest)
 		} else {
 			slog.With("err", err).Error("Generating hash from password")
-			http.Error(w, "", http.StatusInternalServerError)
+			jsonError(w, "", http.StatusInternalServerError)
 		}
 		return
 	}
 
 	user.Password = string(h)
 
-	new_user, err := db.CreateUser(*user)
+	new_user, err := srv.repo.CreateUser(*user)
 	if err != nil {
 		if errors.Is(err, db.ErrDuplicate) {
-			http.Error(w, "User already present", http.StatusBadRequest)
+			jsonError(w, "User already present", http.StatusConflict)
 		} else {
 			slog.With("err", err).Error("Inserting new user into db")
-			http.Error(w, "", http.StatusInternalServerError)
+			jsonError(w, "", http.StatusInternalServerError)
 		}
 		return
 	}
 
+	author, err := srv.userFromRequest(r)
+	if err == nil {
+		srv.recordAudit(r, doit.AuditUserCreate, author, new_user, "")
+	}
+	srv.sendUserWebhooks(doit.WebhookUserCreated, author, new_user)
+
 	user_res := doit.UserToResponse(new_user)
 	res, err := json.Marshal(user_res)
 	if err != nil {
-		slog.With("err", err).Error("Marshaling update user")
-		w.Write([]byte("User created, but can't be returned"))
+		slog.With("err", err).Error("Marshaling new user")
+		jsonError(w, "User was added but we could not send it back", http.StatusInternalServerError)
 		return
 	}
 
 	w.Write(res)
 }
 
-func singleUserHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) singleUserHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodOptions {
 		w.Header().Set("Allow", "GET OPTIONS PUT DELETE")
 		w.WriteHeader(http.StatusOK)
@@ -568,64 +952,58 @@ func singleUserHandler(w http.ResponseWriter, r *http.Request) {
 	id_string, ok := mux.Vars(r)["id"]
 	if !ok {
 		slog.With("vars", mux.Vars(r)).Error("Could not get id from router vars in singleTodoHandler")
-		http.Error(w, "", http.StatusInternalServerError)
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
 
 	id, err := strconv.ParseInt(id_string, 10, 64)
 	if err != nil {
-		slog.With("err", err).Error("Parsing int")
-		http.Error(w, "Id is not valid", http.StatusBadRequest)
+		validationError(w, doit.FieldError{Field: "id", Message: "Id is not valid"})
 		return
 	}
 
-	c, err := r.Cookie(SESSION_COOCKIE_NAME)
-	if err != nil {
-		slog.With("err", err).Error("At this stage cookie should be present")
-		http.Error(w, "", http.StatusInternalServerError)
-		return
-	}
-	s, b := getSession(c.Value)
-	if !b || s.isExpired() {
-		slog.With("err", err).Error("At this stage cookie should valid")
-		http.Error(w, "", http.StatusUnauthorized)
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
 		return
 	}
 
-	user, err := db.GetUserByID(s.userID)
+	user, err := srv.repo.GetUserByID(a.userID)
 	if err != nil {
-		http.Error(w, "", http.StatusInternalServerError)
+		slog.With("err", err, "id", a.userID).Error("Getting user from DB")
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
 
 	if !user.Admin && user.ID != id {
-		http.Error(w, "Your not an admin or this is not your account", http.StatusForbidden)
+		jsonError(w, "You are not an admin and this is not your account", http.StatusForbidden)
 		return
 	}
 
 	switch r.Method {
 	case http.MethodGet:
-		singleUserHandlerGET(w, r, id, user)
+		srv.singleUserHandlerGET(w, r, id, user)
 	case http.MethodPut:
-		singleUserHandlerPUT(w, r, id, user)
+		srv.singleUserHandlerPUT(w, r, id, user)
 	case http.MethodDelete:
-		singleUserHandlerDELETE(w, r, id, user)
+		srv.singleUserHandlerDELETE(w, r, id, user)
 	default:
 		slog.With("method", r.Method).Error("Method not valid. How did we get here?")
-		http.Error(w, "Bad method", http.StatusMethodNotAllowed)
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 	return
 }
 
-func singleUserHandlerGET(w http.ResponseWriter, r *http.Request, userID int64, author *doit.User) {
-	author, err := db.GetUserByID(userID)
+func (srv *Server) singleUserHandlerGET(w http.ResponseWriter, r *http.Request, userID int64, author *doit.User) {
+	author, err := srv.repo.GetUserByID(userID)
 	if err != nil {
 		if errors.Is(err, db.ErrNotExists) {
-			http.Error(w, "User does not exists", http.StatusNotFound)
+			jsonError(w, "User does not exist", http.StatusNotFound)
 			return
 		}
 		slog.With("err", err, "userId", userID).Error("Getting user in db")
-		http.Error(w, "", http.StatusInternalServerError)
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
 
@@ -633,39 +1011,44 @@ func singleUserHandlerGET(w http.ResponseWriter, r *http.Request, userID int64,
 	res, err := json.Marshal(userResponse)
 	if err != nil {
 		slog.With("err", err, "user", userResponse).Error("Marshaling user to JSON")
-		http.Error(w, "", http.StatusInternalServerError)
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
 
 	w.Write(res)
 }
 
-func singleUserHandlerPUT(w http.ResponseWriter, r *http.Request, userID int64, author *doit.User) {
+func (srv *Server) singleUserHandlerPUT(w http.ResponseWriter, r *http.Request, userID int64, author *doit.User) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		slog.With("err", err).Error("Reading body")
-		http.Error(w, "", http.StatusInternalServerError)
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
 
-	originalUser, err := db.GetUserByID(userID)
+	originalUser, err := srv.repo.GetUserByID(userID)
 	if err != nil {
+		if errors.Is(err, db.ErrNotExists) {
+			jsonError(w, "User does not exist", http.StatusNotFound)
+			return
+		}
 		slog.With("err", err, "userId", userID).Error("Getting user from db")
-		http.Error(w, "", http.StatusInternalServerError)
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
+	before := *originalUser
 
 	var updateRequested doit.UserUnmarshaling
 	err = json.Unmarshal(body, &updateRequested)
 	if err != nil {
 		slog.With("err", err).Error("Unmarshaling body")
-		http.Error(w, "Could not unmarshal body", http.StatusBadRequest)
+		jsonError(w, "Could not unmarshal body", http.StatusBadRequest)
 		return
 	}
 
 	if updateRequested.Username != nil &&
 		*updateRequested.Username != originalUser.Username {
-		http.Error(w, "Username is not updatable", http.StatusBadRequest)
+		validationError(w, doit.FieldError{Field: "Username", Message: "Username is not updatable"})
 		return
 	}
 
@@ -686,7 +1069,7 @@ func singleUserHandlerPUT(w http.ResponseWriter, r *http.Request, userID int64,
 			slog.With("author", *author, "updateUser", updateRequested).Info("Admin modification")
 			originalUser.Admin = *updateRequested.Admin
 		} else {
-			http.Error(w, "Not an admin, cannot become one", http.StatusForbidden)
+			jsonError(w, "Not an admin, cannot become one", http.StatusForbidden)
 			return
 		}
 	}
@@ -699,10 +1082,10 @@ func singleUserHandlerPUT(w http.ResponseWriter, r *http.Request, userID int64,
 		h, err := bcrypt.GenerateFromPassword([]byte(*updateRequested.Password), bcrypt.DefaultCost)
 		if err != nil {
 			if errors.Is(err, bcrypt.ErrPasswordTooLong) {
-				http.Error(w, "Password too long (> 72 bytes)", http.StatusBadRequest)
+				jsonError(w, "Password too long (> 72 bytes)", http.StatusBadRequest)
 			} else {
 				slog.With("err", err).Error("Generating hash from password")
-				http.Error(w, "", http.StatusInternalServerError)
+				jsonError(w, "", http.StatusInternalServerError)
 			}
 			return
 		}
@@ -710,43 +1093,94 @@ func singleUserHandlerPUT(w http.ResponseWriter, r *http.Request, userID int64,
 		originalUser.Password = string(h)
 	}
 
-	updatedUser, err := db.UpdateUser(userID, *originalUser)
+	updatedUser, err := srv.repo.UpdateUser(userID, *originalUser)
 	if err != nil {
 		slog.With("err", err).Error("Updating user")
-		http.Error(w, "", http.StatusInternalServerError)
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
 
+	if updateRequested.Password != nil {
+		srv.recordAudit(r, doit.AuditPasswordChange, author, updatedUser, "")
+	}
+	if updatedUser.Admin != before.Admin {
+		if updatedUser.Admin {
+			srv.recordAudit(r, doit.AuditAdminGrant, author, updatedUser, "")
+		} else {
+			srv.recordAudit(r, doit.AuditAdminRevoke, author, updatedUser, "")
+		}
+	}
+	if updatedUser.Active != before.Active {
+		if updatedUser.Active {
+			srv.recordAudit(r, doit.AuditUserActivate, author, updatedUser, "")
+		} else {
+			srv.recordAudit(r, doit.AuditUserDeactivate, author, updatedUser, "")
+		}
+	}
+
+	srv.sendUserWebhooks(doit.WebhookUserUpdated, author, updatedUser)
+
+	// The logins of the user are revoked when he is deactivated or the
+	// password changes, but the one of a user that changes his own password
+	if updateRequested.Password != nil || !updatedUser.Active {
+		var keep int64
+		if a, ok := getAuth(r); ok && a.userID == userID && updatedUser.Active {
+			keep = a.sessionID
+		}
+		n, err := srv.repo.DeleteUserSessions(userID, keep)
+		if err != nil {
+			slog.With("err", err, "userId", userID).Error("Deleting sessions of user")
+			jsonError(w, "User was updated but we could not revoke its sessions", http.StatusInternalServerError)
+			return
+		}
+		slog.With("n", n, "userId", userID).Debug("Deleted sessions of user")
+	}
+
 	updateResponse := doit.UserToResponse(updatedUser)
 
 	res, err := json.Marshal(updateResponse)
 	if err != nil {
 		slog.With("err", err).Error("Marshaling update user")
-		w.Write([]byte("User updated, but can't be returned"))
+		jsonError(w, "User was updated but we could not send it back", http.StatusInternalServerError)
 		return
 	}
 
 	w.Write(res)
 }
 
-func singleUserHandlerDELETE(w http.ResponseWriter, r *http.Request, userID int64, author *doit.User) {
-	err := db.DeleteTodosByUserID(userID)
+func (srv *Server) singleUserHandlerDELETE(w http.ResponseWriter, r *http.Request, userID int64, author *doit.User) {
+	// Read before the delete, for the audit log
+	target, err := srv.repo.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotExists) {
+			jsonError(w, "User does not exist", http.StatusNotFound)
+			return
+		}
+		slog.With("err", err, "userID", userID).Error("Getting user from DB")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	err = srv.repo.DeleteTodosByUserID(userID)
 	if err != nil && !errors.Is(err, db.ErrDeleteFailed) {
-		http.Error(w, "", http.StatusInternalServerError)
+		slog.With("err", err, "userID", userID).Error("Deleting todos of user from DB")
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
 
-	err = db.DeleteUserByID(userID)
+	err = srv.repo.DeleteUserByID(userID)
 	if err != nil {
 		if errors.Is(err, db.ErrDeleteFailed) {
-			http.Error(w, "", http.StatusNotFound)
+			jsonError(w, "User does not exist", http.StatusNotFound)
 			return
 		}
 		slog.With("err", err, "userID", userID).Error("Deleting user from DB")
-		http.Error(w, "", http.StatusInternalServerError)
+		jsonError(w, "", http.StatusInternalServerError)
 		return
 	}
 
+	srv.recordAudit(r, doit.AuditUserDelete, author, target, "")
+	srv.sendUserWebhooks(doit.WebhookUserDeleted, author, target)
 	w.Write([]byte("User deleted successfuly"))
 }
 
@@ -760,7 +1194,7 @@ func noteStatesHandler(w http.ResponseWriter, r *http.Request) {
 	b, err := json.Marshal(doit.States)
 	if err != nil {
 		slog.With("err", err).Error("Marshaling notes states")
-		http.Error(w, "Could not get states", http.StatusInternalServerError)
+		jsonError(w, "Could not get states", http.StatusInternalServerError)
 		return
 	}
 
@@ -777,7 +1211,7 @@ func notePrioritiesHandler(w http.ResponseWriter, r *http.Request) {
 	b, err := json.Marshal(doit.Priorities)
 	if err != nil {
 		slog.With("err", err).Error("Marshaling notes priorities")
-		http.Error(w, "Could not get states", http.StatusInternalServerError)
+		jsonError(w, "Could not get states", http.StatusInternalServerError)
 		return
 	}
 
@@ -794,9 +1228,1737 @@ func noteColorsHandler(w http.ResponseWriter, r *http.Request) {
 	b, err := json.Marshal(doit.Colors)
 	if err != nil {
 		slog.With("err", err).Error("Marshaling notes colors")
-		http.Error(w, "Could not get states", http.StatusInternalServerError)
+		jsonError(w, "Could not get states", http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(b)
+}
+
+func (srv *Server) sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
 		return
 	}
 
+	sessions, err := srv.repo.AllSessions(a.userID)
+	if err != nil {
+		slog.With("err", err).Error("Getting sessions from DB")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	sessionsResponse := make([]doit.SessionResponse, len(sessions))
+	for i := range sessions {
+		sessionsResponse[i] = *doit.SessionToResponse(&sessions[i])
+		sessionsResponse[i].Current = sessions[i].ID == a.sessionID
+	}
+
+	res, err := json.Marshal(sessionsResponse)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling sessions for response")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+func (srv *Server) singleSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS DELETE")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id_string, ok := mux.Vars(r)["id"]
+	if !ok {
+		slog.With("vars", mux.Vars(r)).Error("Could not get id from router vars in singleSessionHandler")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.ParseInt(id_string, 10, 64)
+	if err != nil {
+		validationError(w, doit.FieldError{Field: "id", Message: "Id is not valid"})
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	// A user can only revoke his own sessions
+	err = srv.repo.DeleteSessionByID(id, a.userID)
+	if err != nil {
+		if errors.Is(err, db.ErrDeleteFailed) {
+			jsonError(w, "Session does not exist", http.StatusNotFound)
+			return
+		}
+		slog.With("err", err, "id", id).Error("Deleting session from DB")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (srv *Server) tokensHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS POST")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		srv.tokensHandlerGET(w, r, a.userID)
+	case http.MethodPost:
+		srv.tokensHandlerPOST(w, r, a.userID)
+	default:
+		slog.With("method", r.Method).Error("Method not valid. How did we get here?")
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (srv *Server) tokensHandlerGET(w http.ResponseWriter, r *http.Request, userID int64) {
+	tokens, err := srv.repo.AllAPITokens(userID)
+	if err != nil {
+		slog.With("err", err).Error("Getting API tokens from DB")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	tokensResponse := make([]doit.APITokenResponse, len(tokens))
+	for i := range tokens {
+		tokensResponse[i] = *doit.APITokenToResponse(&tokens[i])
+	}
+
+	res, err := json.Marshal(tokensResponse)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling API tokens for response")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
+
+func (srv *Server) tokensHandlerPOST(w http.ResponseWriter, r *http.Request, userID int64) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		slog.With("err", err).Error("Reading body")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	type TokenRequest struct {
+		Name   string
+		Scopes []string
+		// Optional, if not present the token never expires
+		Expire time.Time
+	}
+
+	var req TokenRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		jsonError(w, "Could not unmarshal body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" {
+		validationError(w, doit.FieldError{Field: "Name", Message: "Name is empty or not present"})
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		validationError(w, doit.FieldError{Field: "Scopes", Message: "At least one scope is needed"})
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if !sliceContains(doit.Scopes, scope) {
+			validationError(w, doit.FieldError{Field: "Scopes", Message: fmt.Sprintf("Scope %q does not exist", scope)})
+			return
+		}
+	}
+
+	if !req.Expire.IsZero() && req.Expire.Before(time.Now()) {
+		validationError(w, doit.FieldError{Field: "Expire", Message: "Expire is in the past"})
+		return
+	}
+
+	user, err := srv.repo.GetUserByID(userID)
+	if err != nil {
+		slog.With("err", err, "userID", userID).Error("Getting user from db")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	if sliceContains(req.Scopes, doit.ScopeUsersAdmin) && !user.Admin {
+		jsonError(w, "Not an admin, cannot create a token with scope "+doit.ScopeUsersAdmin, http.StatusForbidden)
+		return
+	}
+
+	plain, err := newAPIToken()
+	if err != nil {
+		slog.With("err", err).Error("Generating API token")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := srv.repo.CreateAPIToken(doit.APIToken{
+		UserID:    userID,
+		Name:      req.Name,
+		TokenHash: hashToken(plain),
+		Scopes:    req.Scopes,
+		Created:   time.Now(),
+		Expire:    req.Expire,
+	})
+	if err != nil {
+		slog.With("err", err).Error("Inserting API token into db")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	// This is the only time the token is sent to the user
+	tokenResponse := doit.APITokenToResponse(token)
+	tokenResponse.Token = plain
+
+	res, err := json.Marshal(tokenResponse)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling API token for response")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(res)
+}
+
+func (srv *Server) singleTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS DELETE")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id_string, ok := mux.Vars(r)["id"]
+	if !ok {
+		slog.With("vars", mux.Vars(r)).Error("Could not get id from router vars in singleTokenHandler")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.ParseInt(id_string, 10, 64)
+	if err != nil {
+		validationError(w, doit.FieldError{Field: "id", Message: "Id is not valid"})
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	// A user can only revoke his own tokens
+	err = srv.repo.DeleteAPITokenByID(id, a.userID)
+	if err != nil {
+		if errors.Is(err, db.ErrDeleteFailed) {
+			jsonError(w, "Token does not exist", http.StatusNotFound)
+			return
+		}
+		slog.With("err", err, "id", id).Error("Deleting API token from DB")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Return the ID in the router var name and true, or write the error and
+// return false
+func varID(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
+	id_string, ok := mux.Vars(r)[name]
+	if !ok {
+		slog.With("vars", mux.Vars(r), "name", name).Error("Could not get id from router vars")
+		jsonError(w, "", http.StatusInternalServerError)
+		return 0, false
+	}
+
+	id, err := strconv.ParseInt(id_string, 10, 64)
+	if err != nil {
+		validationError(w, doit.FieldError{Field: name, Message: "Id is not valid"})
+		return 0, false
+	}
+	return id, true
+}
+
+func (srv *Server) todoItemsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS POST")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	noteID, ok := varID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	if _, _, ok := srv.authorizeTodo(w, noteID, a.userID, methodAccess(r.Method)); !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		srv.todoItemsHandlerGET(w, r, noteID)
+	case http.MethodPost:
+		srv.todoItemsHandlerPOST(w, r, noteID)
+	default:
+		slog.With("method", r.Method).Error("Method not valid. How did we get here?")
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (srv *Server) todoItemsHandlerGET(w http.ResponseWriter, r *http.Request, noteID int64) {
+	items, err := srv.repo.AllTodoItems(noteID)
+	if err != nil {
+		slog.With("err", err, "noteID", noteID).Error("Getting items from DB")
+		jsonError(w, "Could not get items", http.StatusInternalServerError)
+		return
+	}
+
+	var response []byte
+	if len(items) == 0 {
+		response = []byte("[]")
+	} else {
+		response, err = json.Marshal(items)
+		if err != nil {
+			slog.With("err", err).Error("While parsing items for json")
+			jsonError(w, "Could not get items", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func (srv *Server) todoItemsHandlerPOST(w http.ResponseWriter, r *http.Request, noteID int64) {
+	var item doit.TodoItem
+	err := json.NewDecoder(r.Body).Decode(&item)
+	if err != nil {
+		jsonError(w, "Could not unmarshal body", http.StatusBadRequest)
+		return
+	}
+
+	if item.Text == "" {
+		validationError(w, doit.FieldError{Field: "Text", Message: "Text is empty or not present"})
+		return
+	}
+
+	item.TodoID = noteID
+	created, err := srv.repo.CreateTodoItem(item)
+	if err != nil {
+		slog.With("err", err, "noteID", noteID).Error("Adding item to DB")
+		jsonError(w, "Could not add item", http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(created)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling item")
+		jsonError(w, "Item was added but we could not send it back", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+}
+
+func (srv *Server) singleTodoItemHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS PUT DELETE")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	noteID, ok := varID(w, r, "id")
+	if !ok {
+		return
+	}
+	itemID, ok := varID(w, r, "itemID")
+	if !ok {
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	if _, _, ok := srv.authorizeTodo(w, noteID, a.userID, methodAccess(r.Method)); !ok {
+		return
+	}
+
+	item, err := srv.repo.GetTodoItemByID(itemID, noteID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotExists) {
+			jsonError(w, "Item does not exist", http.StatusNotFound)
+			return
+		}
+		slog.With("err", err, "id", itemID).Error("Getting item from DB")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		b, err := json.Marshal(item)
+		if err != nil {
+			slog.With("err", err).Error("Marshaling item")
+			jsonError(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	case http.MethodPut:
+		srv.singleTodoItemHandlerPUT(w, r, item)
+	case http.MethodDelete:
+		err := srv.repo.DeleteTodoItemByID(itemID, noteID)
+		if err != nil && !errors.Is(err, db.ErrDeleteFailed) {
+			slog.With("err", err, "id", itemID).Error("Deleting item from DB")
+			jsonError(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		slog.With("method", r.Method).Error("Method not valid. How did we get here?")
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Only the fields present in the body are changed
+func (srv *Server) singleTodoItemHandlerPUT(w http.ResponseWriter, r *http.Request, item *doit.TodoItem) {
+	var u doit.TodoItemUnmarshaling
+	err := json.NewDecoder(r.Body).Decode(&u)
+	if err != nil {
+		jsonError(w, "Could not unmarshal body", http.StatusBadRequest)
+		return
+	}
+
+	if u.Text != nil {
+		if *u.Text == "" {
+			validationError(w, doit.FieldError{Field: "Text", Message: "Text can't be empty"})
+			return
+		}
+		item.Text = *u.Text
+	}
+	if u.Done != nil {
+		item.Done = *u.Done
+	}
+	if u.Position != nil {
+		item.Position = *u.Position
+	}
+
+	updated, err := srv.repo.UpdateTodoItem(item.ID, item.TodoID, *item)
+	if err != nil {
+		if errors.Is(err, db.ErrUpdateFailed) {
+			jsonError(w, "Item does not exist", http.StatusNotFound)
+			return
+		}
+		slog.With("err", err, "id", item.ID).Error("Updating item in DB")
+		jsonError(w, "Could not update item", http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(updated)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling item update")
+		jsonError(w, "Item was updated but we could not send it back", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+var colorRegexp = regexp.MustCompile("^#[0-9a-fA-F]{6}$")
+
+// Check the fields of a tag, return a doit.FieldError if not valid
+func validateTag(tag *doit.Tag) error {
+	if strings.TrimSpace(tag.Name) == "" {
+		return fieldError("Name", "Name is empty or not present")
+	}
+	if tag.Color != "" && !colorRegexp.MatchString(tag.Color) {
+		return fieldError("Color", "Color must be empty or in the #rrggbb format")
+	}
+	return nil
+}
+
+func (srv *Server) tagsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS POST")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		srv.tagsHandlerGET(w, r, a.userID)
+	case http.MethodPost:
+		srv.tagsHandlerPOST(w, r, a.userID)
+	default:
+		slog.With("method", r.Method).Error("Method not valid. How did we get here?")
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (srv *Server) tagsHandlerGET(w http.ResponseWriter, r *http.Request, userID int64) {
+	tags, err := srv.repo.AllTags(userID)
+	if err != nil {
+		slog.With("err", err).Error("Getting tags from DB")
+		jsonError(w, "Could not get tags", http.StatusInternalServerError)
+		return
+	}
+
+	var response []byte
+	if len(tags) == 0 {
+		response = []byte("[]")
+	} else {
+		response, err = json.Marshal(tags)
+		if err != nil {
+			slog.With("err", err).Error("While parsing tags for json")
+			jsonError(w, "Could not get tags", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func (srv *Server) tagsHandlerPOST(w http.ResponseWriter, r *http.Request, userID int64) {
+	var tag doit.Tag
+	err := json.NewDecoder(r.Body).Decode(&tag)
+	if err != nil {
+		jsonError(w, "Could not unmarshal body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validateTag(&tag); err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	tag.UserID = userID
+	created, err := srv.repo.CreateTag(tag)
+	if err != nil {
+		if errors.Is(err, db.ErrDuplicate) {
+			jsonError(w, "Tag already present", http.StatusConflict)
+			return
+		}
+		slog.With("err", err).Error("Adding tag to DB")
+		jsonError(w, "Could not add tag", http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(created)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling tag")
+		jsonError(w, "Tag was added but we could not send it back", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+}
+
+func (srv *Server) singleTagHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS PUT DELETE")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	id, ok := varID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	// A user can only see and change his own tags
+	tag, err := srv.repo.GetTagByID(id, a.userID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotExists) {
+			jsonError(w, "Tag does not exist", http.StatusNotFound)
+			return
+		}
+		slog.With("err", err, "id", id).Error("Getting tag from DB")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		b, err := json.Marshal(tag)
+		if err != nil {
+			slog.With("err", err).Error("Marshaling tag")
+			jsonError(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	case http.MethodPut:
+		srv.singleTagHandlerPUT(w, r, tag)
+	case http.MethodDelete:
+		err := srv.repo.DeleteTagByID(id, a.userID)
+		if err != nil && !errors.Is(err, db.ErrDeleteFailed) {
+			slog.With("err", err, "id", id).Error("Deleting tag from DB")
+			jsonError(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		slog.With("method", r.Method).Error("Method not valid. How did we get here?")
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Only the fields present in the body are changed
+func (srv *Server) singleTagHandlerPUT(w http.ResponseWriter, r *http.Request, tag *doit.Tag) {
+	var u doit.TagUnmarshaling
+	err := json.NewDecoder(r.Body).Decode(&u)
+	if err != nil {
+		jsonError(w, "Could not unmarshal body", http.StatusBadRequest)
+		return
+	}
+
+	if u.Name != nil {
+		tag.Name = *u.Name
+	}
+	if u.Color != nil {
+		tag.Color = *u.Color
+	}
+
+	if err := validateTag(tag); err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	updated, err := srv.repo.UpdateTag(tag.ID, tag.UserID, *tag)
+	if err != nil {
+		if errors.Is(err, db.ErrDuplicate) {
+			jsonError(w, "Tag already present", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, db.ErrUpdateFailed) {
+			jsonError(w, "Tag does not exist", http.StatusNotFound)
+			return
+		}
+		slog.With("err", err, "id", tag.ID).Error("Updating tag in DB")
+		jsonError(w, "Could not update tag", http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(updated)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling tag update")
+		jsonError(w, "Tag was updated but we could not send it back", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func (srv *Server) projectsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS POST")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		srv.projectsHandlerGET(w, r, a.userID)
+	case http.MethodPost:
+		srv.projectsHandlerPOST(w, r, a.userID)
+	default:
+		slog.With("method", r.Method).Error("Method not valid. How did we get here?")
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (srv *Server) projectsHandlerGET(w http.ResponseWriter, r *http.Request, userID int64) {
+	projects, err := srv.repo.AllProjects(userID)
+	if err != nil {
+		slog.With("err", err).Error("Getting projects from DB")
+		jsonError(w, "Could not get projects", http.StatusInternalServerError)
+		return
+	}
+
+	var response []byte
+	if len(projects) == 0 {
+		response = []byte("[]")
+	} else {
+		response, err = json.Marshal(projects)
+		if err != nil {
+			slog.With("err", err).Error("While parsing projects for json")
+			jsonError(w, "Could not get projects", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func (srv *Server) projectsHandlerPOST(w http.ResponseWriter, r *http.Request, userID int64) {
+	var p doit.Project
+	err := json.NewDecoder(r.Body).Decode(&p)
+	if err != nil {
+		jsonError(w, "Could not unmarshal body", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(p.Name) == "" {
+		validationError(w, doit.FieldError{Field: "Name", Message: "Name is empty or not present"})
+		return
+	}
+
+	p.UserID = userID
+	created, err := srv.repo.CreateProject(p)
+	if err != nil {
+		slog.With("err", err).Error("Adding project to DB")
+		jsonError(w, "Could not add project", http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(created)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling project")
+		jsonError(w, "Project was added but we could not send it back", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+}
+
+// Return the project of the request if the user has the needed access on it,
+// otherwise write the error and return false
+func (srv *Server) requestProject(w http.ResponseWriter, r *http.Request, need access) (*doit.Project, bool) {
+	id, ok := varID(w, r, "id")
+	if !ok {
+		return nil, false
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return srv.authorizeProject(w, id, a.userID, need)
+}
+
+func (srv *Server) singleProjectHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS PUT DELETE")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Only the owner can change or delete a project, an edit permission is
+	// about its notes
+	need := accessRead
+	if r.Method != http.MethodGet {
+		need = accessOwner
+	}
+	p, ok := srv.requestProject(w, r, need)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		b, err := json.Marshal(p)
+		if err != nil {
+			slog.With("err", err).Error("Marshaling project")
+			jsonError(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	case http.MethodPut:
+		srv.singleProjectHandlerPUT(w, r, p)
+	case http.MethodDelete:
+		srv.singleProjectHandlerDELETE(w, r, p)
+	default:
+		slog.With("method", r.Method).Error("Method not valid. How did we get here?")
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Only the fields present in the body are changed
+func (srv *Server) singleProjectHandlerPUT(w http.ResponseWriter, r *http.Request, p *doit.Project) {
+	var u doit.ProjectUnmarshaling
+	err := json.NewDecoder(r.Body).Decode(&u)
+	if err != nil {
+		jsonError(w, "Could not unmarshal body", http.StatusBadRequest)
+		return
+	}
+
+	if u.Name != nil {
+		if strings.TrimSpace(*u.Name) == "" {
+			validationError(w, doit.FieldError{Field: "Name", Message: "Name can't be empty"})
+			return
+		}
+		p.Name = *u.Name
+	}
+	if u.Archived != nil {
+		p.Archived = *u.Archived
+	}
+
+	updated, err := srv.repo.UpdateProject(p.ID, p.UserID, *p)
+	if err != nil {
+		if errors.Is(err, db.ErrUpdateFailed) {
+			jsonError(w, "Project does not exist", http.StatusNotFound)
+			return
+		}
+		slog.With("err", err, "id", p.ID).Error("Updating project in DB")
+		jsonError(w, "Could not update project", http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(updated)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling project update")
+		jsonError(w, "Project was updated but we could not send it back", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// The notes of the project are moved to the inbox, unless notes=delete is in
+// the query and they are moved to the trash
+func (srv *Server) singleProjectHandlerDELETE(w http.ResponseWriter, r *http.Request, p *doit.Project) {
+	var deleteNotes bool
+	switch r.URL.Query().Get("notes") {
+	case "", "inbox":
+	case "delete":
+		deleteNotes = true
+	default:
+		validationError(w, doit.FieldError{Field: "notes", Message: "notes must be inbox or delete"})
+		return
+	}
+
+	// Needed for the history of the notes
+	notes, _, err := srv.repo.FilterTodos(p.UserID, db.TodoFilter{ProjectIDs: []int64{p.ID}, IncludeArchived: true})
+	if err != nil {
+		slog.With("err", err, "id", p.ID).Error("Getting notes of project from DB")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	// Deleted notes go to the trash, so they are not deleted with the project
+	if deleteNotes {
+		for i := range notes {
+			err := srv.repo.TrashTodo(notes[i].ID, p.UserID, time.Now())
+			if err != nil && !errors.Is(err, db.ErrDeleteFailed) {
+				slog.With("err", err, "id", notes[i].ID).Error("Moving note to trash")
+				jsonError(w, "", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	err = srv.repo.DeleteProjectByID(p.ID, p.UserID)
+	if err != nil && !errors.Is(err, db.ErrDeleteFailed) {
+		slog.With("err", err, "id", p.ID).Error("Deleting project from DB")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range notes {
+		if deleteNotes {
+			srv.recordTodoEvent(p.UserID, doit.TodoEventDelete, &notes[i], nil)
+		} else {
+			moved := notes[i]
+			moved.ProjectID = 0
+			srv.recordTodoEvent(p.UserID, doit.TodoEventUpdate, &notes[i], &moved)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// The notes of a project, including the ones of an archived project. The same
+// query parameters of the notes can be used.
+func (srv *Server) projectNotesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	p, ok := srv.requestProject(w, r, accessRead)
+	if !ok {
+		return
+	}
+
+	filter, err := parseTodoFilter(r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	filter.ProjectIDs = []int64{p.ID}
+	filter.Inbox = false
+	filter.IncludeArchived = true
+
+	srv.writeNotes(w, p.UserID, filter)
+}
+
+func writeShares(w http.ResponseWriter, shares []doit.Share, err error) {
+	if err != nil {
+		slog.With("err", err).Error("Getting shares from DB")
+		jsonError(w, "Could not get shares", http.StatusInternalServerError)
+		return
+	}
+
+	var response []byte
+	if len(shares) == 0 {
+		response = []byte("[]")
+	} else {
+		response, err = json.Marshal(shares)
+		if err != nil {
+			slog.With("err", err).Error("While parsing shares for json")
+			jsonError(w, "Could not get shares", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// Read the user to share with from the body and call share with his ID and
+// the permission
+func (srv *Server) addShare(w http.ResponseWriter, r *http.Request, ownerID int64, share func(userID int64, permission string) error) {
+	var body struct {
+		Username   string
+		Permission string
+	}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		jsonError(w, "Could not unmarshal body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := srv.repo.GetUserByUsername(body.Username)
+	if err != nil {
+		if errors.Is(err, db.ErrNotExists) {
+			validationError(w, doit.FieldError{Field: "Username", Message: "User does not exist"})
+			return
+		}
+		slog.With("err", err).Error("Getting user from DB")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+	if user.ID == ownerID {
+		validationError(w, doit.FieldError{Field: "Username", Message: "Can't share with the owner"})
+		return
+	}
+
+	err = share(user.ID, body.Permission)
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidPermission) {
+			validationError(w, doit.FieldError{Field: "Permission", Message: "Permission must be read or edit"})
+			return
+		}
+		slog.With("err", err).Error("Sharing")
+		jsonError(w, "Could not share", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func removeShare(w http.ResponseWriter, r *http.Request, unshare func(userID int64) error) {
+	userID, ok := varID(w, r, "userID")
+	if !ok {
+		return
+	}
+
+	err := unshare(userID)
+	if err != nil {
+		if errors.Is(err, db.ErrDeleteFailed) {
+			jsonError(w, "Not shared with the user", http.StatusNotFound)
+			return
+		}
+		slog.With("err", err).Error("Removing share")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Who the note is shared with. Everyone who can see the note can see the list,
+// only the owner can change it.
+func (srv *Server) noteSharesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS POST")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	noteID, ok := varID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	need := accessRead
+	if r.Method != http.MethodGet {
+		need = accessOwner
+	}
+	note, _, ok := srv.authorizeTodo(w, noteID, a.userID, need)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		shares, err := srv.repo.TodoShares(note.ID)
+		writeShares(w, shares, err)
+	case http.MethodPost:
+		srv.addShare(w, r, note.UserID, func(userID int64, permission string) error {
+			return srv.repo.ShareTodo(note.ID, userID, permission)
+		})
+	default:
+		slog.With("method", r.Method).Error("Method not valid. How did we get here?")
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (srv *Server) singleNoteShareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS DELETE")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	noteID, ok := varID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	note, _, ok := srv.authorizeTodo(w, noteID, a.userID, accessOwner)
+	if !ok {
+		return
+	}
+
+	removeShare(w, r, func(userID int64) error {
+		return srv.repo.UnshareTodo(note.ID, userID)
+	})
+}
+
+// Sharing a project shares all its notes, with the same permission
+func (srv *Server) projectSharesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS POST")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	need := accessRead
+	if r.Method != http.MethodGet {
+		need = accessOwner
+	}
+	p, ok := srv.requestProject(w, r, need)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		shares, err := srv.repo.ProjectShares(p.ID)
+		writeShares(w, shares, err)
+	case http.MethodPost:
+		srv.addShare(w, r, p.UserID, func(userID int64, permission string) error {
+			return srv.repo.ShareProject(p.ID, userID, permission)
+		})
+	default:
+		slog.With("method", r.Method).Error("Method not valid. How did we get here?")
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (srv *Server) singleProjectShareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS DELETE")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	p, ok := srv.requestProject(w, r, accessOwner)
+	if !ok {
+		return
+	}
+
+	removeShare(w, r, func(userID int64) error {
+		return srv.repo.UnshareProject(p.ID, userID)
+	})
+}
+
+// Comments can be read and written by everyone who can see the note
+func (srv *Server) commentsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS POST")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	noteID, ok := varID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	if _, _, ok := srv.authorizeTodo(w, noteID, a.userID, accessRead); !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		srv.commentsHandlerGET(w, r, noteID)
+	case http.MethodPost:
+		srv.commentsHandlerPOST(w, r, noteID, a.userID)
+	default:
+		slog.With("method", r.Method).Error("Method not valid. How did we get here?")
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (srv *Server) commentsHandlerGET(w http.ResponseWriter, r *http.Request, noteID int64) {
+	comments, err := srv.repo.AllComments(noteID)
+	if err != nil {
+		slog.With("err", err, "noteID", noteID).Error("Getting comments from DB")
+		jsonError(w, "Could not get comments", http.StatusInternalServerError)
+		return
+	}
+
+	var response []byte
+	if len(comments) == 0 {
+		response = []byte("[]")
+	} else {
+		response, err = json.Marshal(comments)
+		if err != nil {
+			slog.With("err", err).Error("While parsing comments for json")
+			jsonError(w, "Could not get comments", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func (srv *Server) commentsHandlerPOST(w http.ResponseWriter, r *http.Request, noteID int64, userID int64) {
+	var c doit.Comment
+	err := json.NewDecoder(r.Body).Decode(&c)
+	if err != nil {
+		jsonError(w, "Could not unmarshal body", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(c.Body) == "" {
+		validationError(w, doit.FieldError{Field: "Body", Message: "Body is empty or not present"})
+		return
+	}
+
+	comment := doit.Comment{
+		TodoID:  noteID,
+		UserID:  userID,
+		Body:    c.Body,
+		Created: time.Now(),
+	}
+	created, err := srv.repo.CreateComment(comment)
+	if err != nil {
+		slog.With("err", err, "noteID", noteID).Error("Adding comment to DB")
+		jsonError(w, "Could not add comment", http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(created)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling comment")
+		jsonError(w, "Comment was added but we could not send it back", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+}
+
+// Only the author or an admin can change or delete a comment
+func (srv *Server) singleCommentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS PUT DELETE")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	noteID, ok := varID(w, r, "id")
+	if !ok {
+		return
+	}
+	commentID, ok := varID(w, r, "commentID")
+	if !ok {
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	if _, _, ok := srv.authorizeTodo(w, noteID, a.userID, accessRead); !ok {
+		return
+	}
+
+	comment, err := srv.repo.GetCommentByID(commentID, noteID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotExists) {
+			jsonError(w, "Comment does not exist", http.StatusNotFound)
+			return
+		}
+		slog.With("err", err, "id", commentID).Error("Getting comment from DB")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method != http.MethodGet && comment.UserID != a.userID {
+		isAdmin, err := srv.isAdminFromRequest(r)
+		if err != nil {
+			slog.With("err", err).Error("Checking if user is admin")
+			jsonError(w, "", http.StatusInternalServerError)
+			return
+		}
+		if !isAdmin {
+			jsonError(w, "Only the author can change the comment", http.StatusForbidden)
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		b, err := json.Marshal(comment)
+		if err != nil {
+			slog.With("err", err).Error("Marshaling comment")
+			jsonError(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	case http.MethodPut:
+		srv.singleCommentHandlerPUT(w, r, comment)
+	case http.MethodDelete:
+		err := srv.repo.DeleteCommentByID(commentID, noteID)
+		if err != nil && !errors.Is(err, db.ErrDeleteFailed) {
+			slog.With("err", err, "id", commentID).Error("Deleting comment from DB")
+			jsonError(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		slog.With("method", r.Method).Error("Method not valid. How did we get here?")
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (srv *Server) singleCommentHandlerPUT(w http.ResponseWriter, r *http.Request, comment *doit.Comment) {
+	var c doit.Comment
+	err := json.NewDecoder(r.Body).Decode(&c)
+	if err != nil {
+		jsonError(w, "Could not unmarshal body", http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(c.Body) == "" {
+		validationError(w, doit.FieldError{Field: "Body", Message: "Body is empty or not present"})
+		return
+	}
+
+	comment.Body = c.Body
+	comment.Edited = time.Now()
+	updated, err := srv.repo.UpdateComment(comment.ID, comment.TodoID, *comment)
+	if err != nil {
+		if errors.Is(err, db.ErrUpdateFailed) {
+			jsonError(w, "Comment does not exist", http.StatusNotFound)
+			return
+		}
+		slog.With("err", err, "id", comment.ID).Error("Updating comment in DB")
+		jsonError(w, "Could not update comment", http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(updated)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling comment update")
+		jsonError(w, "Comment was updated but we could not send it back", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// The history of a note, from the oldest event
+func (srv *Server) noteHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	noteID, ok := varID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	if _, _, ok := srv.authorizeTodo(w, noteID, a.userID, accessRead); !ok {
+		return
+	}
+
+	events, err := srv.repo.TodoEvents(noteID)
+	if err != nil {
+		slog.With("err", err, "noteID", noteID).Error("Getting history from DB")
+		jsonError(w, "Could not get history", http.StatusInternalServerError)
+		return
+	}
+
+	var response []byte
+	if len(events) == 0 {
+		response = []byte("[]")
+	} else {
+		response, err = json.Marshal(events)
+		if err != nil {
+			slog.With("err", err).Error("While parsing history for json")
+			jsonError(w, "Could not get history", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// Restore the note to the revision of an event of its history. Tags and
+// projects deleted in the meantime are not restored, and only the owner can
+// change the assignee.
+func (srv *Server) restoreNoteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS POST")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	noteID, ok := varID(w, r, "id")
+	if !ok {
+		return
+	}
+	eventID, ok := varID(w, r, "eventID")
+	if !ok {
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	note, got, ok := srv.authorizeTodo(w, noteID, a.userID, accessEdit)
+	if !ok {
+		return
+	}
+
+	event, err := srv.repo.GetTodoEventByID(eventID, noteID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotExists) {
+			jsonError(w, "Event does not exist", http.StatusNotFound)
+			return
+		}
+		slog.With("err", err, "id", eventID).Error("Getting event from DB")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	revision := event.Todo
+	revision.ID = note.ID
+	revision.UserID = note.UserID
+	if got < accessOwner {
+		revision.AssigneeID = note.AssigneeID
+	}
+
+	tags := []int64{}
+	for _, id := range revision.TagIDs {
+		_, err := srv.repo.GetTagByID(id, note.UserID)
+		if err == nil {
+			tags = append(tags, id)
+		} else if !errors.Is(err, db.ErrNotExists) {
+			slog.With("err", err, "id", id).Error("Getting tag from DB")
+			jsonError(w, "", http.StatusInternalServerError)
+			return
+		}
+	}
+	revision.TagIDs = tags
+
+	if revision.ProjectID != 0 {
+		p, err := srv.repo.GetProjectByID(revision.ProjectID)
+		if errors.Is(err, db.ErrNotExists) || (err == nil && p.UserID != note.UserID) {
+			revision.ProjectID = 0
+		} else if err != nil {
+			slog.With("err", err, "id", revision.ProjectID).Error("Getting project from DB")
+			jsonError(w, "", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	restored, err := srv.repo.UpdateTodo(note.ID, revision, note.UserID)
+	if err != nil {
+		slog.With("err", err, "id", note.ID).Error("Restoring note")
+		jsonError(w, "Could not restore note", http.StatusInternalServerError)
+		return
+	}
+	srv.recordTodoEvent(a.userID, doit.TodoEventRestore, note, restored)
+
+	b, err := json.Marshal(restored)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling restored note")
+		jsonError(w, "Note was restored but we could not send it back", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// The audit log, only for admins. It can be filtered by user (as actor or
+// target), event type and time range.
+func (srv *Server) adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	isAdmin, err := srv.isAdminFromRequest(r)
+	if err != nil {
+		slog.With("err", err).Error("Checking if user is admin")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+	if !isAdmin {
+		jsonError(w, "Not an admin", http.StatusForbidden)
+		return
+	}
+
+	f, err := parseAuditFilter(r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	events, err := srv.repo.AuditEvents(f)
+	if err != nil {
+		slog.With("err", err).Error("Getting audit log from DB")
+		jsonError(w, "Could not get audit log", http.StatusInternalServerError)
+		return
+	}
+
+	var response []byte
+	if len(events) == 0 {
+		response = []byte("[]")
+	} else {
+		response, err = json.Marshal(events)
+		if err != nil {
+			slog.With("err", err).Error("While parsing audit log for json")
+			jsonError(w, "Could not get audit log", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+func parseAuditFilter(q url.Values) (db.AuditFilter, error) {
+	var f db.AuditFilter
+	var err error
+
+	if s := q.Get("user"); s != "" {
+		f.UserID, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return f, fieldError("user", "user is not valid")
+		}
+	}
+	// e.g. type=login,login_failed
+	if s := q.Get("type"); s != "" {
+		for _, t := range strings.Split(s, ",") {
+			f.Types = append(f.Types, strings.TrimSpace(t))
+		}
+	}
+
+	if s := q.Get("after"); s != "" {
+		f.After, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return f, fieldError("after", "after is not valid: %v", err)
+		}
+	}
+	if s := q.Get("before"); s != "" {
+		f.Before, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			return f, fieldError("before", "before is not valid: %v", err)
+		}
+	}
+
+	if s := q.Get("limit"); s != "" {
+		f.Limit, err = strconv.Atoi(s)
+		if err != nil || f.Limit < 0 {
+			return f, fieldError("limit", "limit is not valid")
+		}
+	}
+	if s := q.Get("offset"); s != "" {
+		f.Offset, err = strconv.Atoi(s)
+		if err != nil || f.Offset < 0 {
+			return f, fieldError("offset", "offset is not valid")
+		}
+	}
+
+	return f, nil
+}
+
+// The notes of the user in the trash, from the last deleted
+func (srv *Server) trashHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	notes, err := srv.repo.TrashedTodos(a.userID)
+	if err != nil {
+		slog.With("err", err).Error("Getting trash from DB")
+		jsonError(w, "Could not get trash", http.StatusInternalServerError)
+		return
+	}
+
+	var response []byte
+	if len(notes) == 0 {
+		response = []byte("[]")
+	} else {
+		response, err = json.Marshal(notes)
+		if err != nil {
+			slog.With("err", err).Error("While parsing trash for json")
+			jsonError(w, "Could not get trash", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// Return the note of the request if it's in the trash of the user, otherwise
+// write the error and return false. Only the owner can see his trash.
+func (srv *Server) requestTrashedNote(w http.ResponseWriter, r *http.Request) (*doit.Todo, bool) {
+	id, ok := varID(w, r, "id")
+	if !ok {
+		return nil, false
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	note, err := srv.repo.GetTodoByID(id)
+	if err != nil && !errors.Is(err, db.ErrNotExists) {
+		slog.With("err", err, "id", id).Error("Getting note")
+		jsonError(w, "", http.StatusInternalServerError)
+		return nil, false
+	}
+	if err != nil || note.UserID != a.userID || note.DeletedAt.IsZero() {
+		jsonError(w, "Note is not in the trash", http.StatusNotFound)
+		return nil, false
+	}
+	return note, true
+}
+
+// Permanently delete a note in the trash
+func (srv *Server) singleTrashHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS DELETE")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	note, ok := srv.requestTrashedNote(w, r)
+	if !ok {
+		return
+	}
+
+	err := srv.repo.DeleteTodoByID(note.ID, note.UserID)
+	if err != nil && !errors.Is(err, db.ErrDeleteFailed) {
+		slog.With("err", err, "id", note.ID).Error("Deleting note from DB")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Move a note out of the trash
+func (srv *Server) restoreTrashHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS POST")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	note, ok := srv.requestTrashedNote(w, r)
+	if !ok {
+		return
+	}
+
+	err := srv.repo.RestoreTodo(note.ID, note.UserID)
+	if err != nil {
+		if errors.Is(err, db.ErrUpdateFailed) {
+			jsonError(w, "Note is not in the trash", http.StatusNotFound)
+			return
+		}
+		slog.With("err", err, "id", note.ID).Error("Restoring note from trash")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	restored, err := srv.repo.GetTodoByID(note.ID)
+	if err != nil {
+		slog.With("err", err, "id", note.ID).Error("Getting restored note")
+		jsonError(w, "Note was restored but we could not send it back", http.StatusInternalServerError)
+		return
+	}
+	srv.recordTodoEvent(note.UserID, doit.TodoEventRestore, nil, restored)
+
+	b, err := json.Marshal(restored)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling restored note")
+		jsonError(w, "Note was restored but we could not send it back", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.Write(b)
 }
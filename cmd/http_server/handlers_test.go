@@ -1,10 +1,25 @@
 package http_server
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/samuelemusiani/doit/cmd/config"
+	"github.com/samuelemusiani/doit/cmd/db"
+	"github.com/samuelemusiani/doit/cmd/doit"
+	"golang.org/x/crypto/bcrypt"
 	"gotest.tools/v3/assert"
 )
 
@@ -29,8 +44,1744 @@ func TestRootAPIHandler(t *testing.T) {
 }
 
 func TestNotesHandlerOPTIONS(t *testing.T) {
-	rr, err := request("OPTIONS", "/api/notes", notesHandler)
+	srv := setupServer(t)
+	rr, err := request("OPTIONS", "/api/notes", srv.notesHandler)
 	assert.NilError(t, err)
 	assert.Equal(t, rr.Code, http.StatusOK)
 	assert.Equal(t, rr.Result().Header.Get("Allow"), "GET OPTIONS POST")
 }
+
+// Initialize the http server with an in-memory database
+func setupServer(t *testing.T) *Server {
+	conf := config.GetConfig()
+	conf.Databse.Path = ":memory:"
+	r, err := db.Init()
+	assert.NilError(t, err)
+
+	return New(fstest.MapFS{"index.html": {Data: []byte("DOIT")}}, r)
+}
+
+func createUser(t *testing.T, r db.Repository, username string, password string, admin bool) *doit.User {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	assert.NilError(t, err)
+
+	user, err := r.CreateUser(doit.User{
+		Username: username,
+		Email:    username + "@mail.com",
+		Admin:    admin,
+		Active:   true,
+		Password: string(h),
+	})
+	assert.NilError(t, err)
+	return user
+}
+
+// Perform a request against the whole router, with middlewares
+func (srv *Server) serve(method string, endpoint string, body string, c *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, endpoint, strings.NewReader(body))
+	if c != nil {
+		req.AddCookie(c)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	return rr
+}
+
+func (srv *Server) login(t *testing.T, username string, password string) *http.Cookie {
+	rr := srv.serve("POST", "/api/login", `{"Username":"`+username+`","Password":"`+password+`"}`, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == SESSION_COOCKIE_NAME {
+			return c
+		}
+	}
+	t.Fatalf("Session cookie not set after login")
+	return nil
+}
+
+func TestNotesNotAuthenticated(t *testing.T) {
+	srv := setupServer(t)
+	defer srv.repo.Close()
+
+	rr := srv.serve("GET", "/api/notes", "", nil)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+}
+
+func TestCreateAndGetNote(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	c := srv.login(t, "alice", "password")
+
+	rr := srv.serve("POST", "/api/notes", `{"Title":"Buy milk","StateID":1,"PriorityID":1,"ColorID":1}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+
+	var created doit.TodoResponse
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.Equal(t, created.Title, "Buy milk")
+
+	rr = srv.serve("GET", "/api/notes/"+strconv.FormatInt(created.ID, 10), "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	var note doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &note))
+	assert.Equal(t, note.ID, created.ID)
+	assert.Equal(t, note.Title, "Buy milk")
+}
+
+func TestTwoServers(t *testing.T) {
+	srv := setupServer(t)
+	defer srv.repo.Close()
+	other := setupServer(t)
+	defer other.repo.Close()
+
+	createUser(t, srv.repo, "alice", "password", false)
+	c := srv.login(t, "alice", "password")
+
+	rr := other.serve("POST", "/api/login", `{"Username":"alice","Password":"password"}`, nil)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+	rr = other.serve("GET", "/api/notes", "", c)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Buy milk","StateID":1,"PriorityID":1,"ColorID":1}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	assert.Equal(t, len(srv.events.recent), 1)
+	assert.Equal(t, len(other.events.recent), 0)
+}
+
+func TestGetNoteOfAnotherUser(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	alice := createUser(t, r, "alice", "password", false)
+	createUser(t, r, "bob", "password", false)
+
+	note, err := r.CreateTodo(doit.Todo{Title: "Secret", StateID: 1, PriorityID: 1, ColorID: 1, UserID: alice.ID})
+	assert.NilError(t, err)
+
+	c := srv.login(t, "bob", "password")
+	rr := srv.serve("GET", "/api/notes/"+strconv.FormatInt(note.ID, 10), "", c)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+}
+
+func TestUsersOnlyForAdmins(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	createUser(t, r, "root", "password", true)
+
+	rr := srv.serve("GET", "/api/users", "", srv.login(t, "alice", "password"))
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+
+	rr = srv.serve("GET", "/api/users", "", srv.login(t, "root", "password"))
+	assert.Equal(t, rr.Code, http.StatusOK)
+}
+
+func TestRevokeSession(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	laptop := srv.login(t, "alice", "password")
+	phone := srv.login(t, "alice", "password")
+
+	rr := srv.serve("GET", "/api/sessions", "", laptop)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	var sessions []doit.SessionResponse
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &sessions))
+	assert.Equal(t, len(sessions), 2)
+
+	var other int64
+	for _, s := range sessions {
+		if !s.Current {
+			other = s.ID
+		}
+	}
+	assert.Check(t, other != 0)
+
+	rr = srv.serve("DELETE", "/api/sessions/"+strconv.FormatInt(other, 10), "", laptop)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+
+	rr = srv.serve("GET", "/api/notes", "", phone)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+
+	rr = srv.serve("GET", "/api/notes", "", laptop)
+	assert.Equal(t, rr.Code, http.StatusOK)
+}
+
+func TestRevokeSessionsOfUser(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "dave", "password", true)
+	alice := createUser(t, r, "alice", "password", false)
+	cDave := srv.login(t, "dave", "password")
+	laptop := srv.login(t, "alice", "password")
+	phone := srv.login(t, "alice", "password")
+	aliceURL := "/api/users/" + strconv.FormatInt(alice.ID, 10)
+
+	// Changing the password logs out the other sessions
+	rr := srv.serve("PUT", aliceURL, `{"Password":"changed"}`, laptop)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	rr = srv.serve("GET", "/api/notes", "", phone)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+	rr = srv.serve("GET", "/api/notes", "", laptop)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	// Even after being activated again, the user has to login again
+	rr = srv.serve("PUT", aliceURL, `{"Active":false}`, cDave)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	sessions, err := r.AllSessions(alice.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(sessions), 0)
+	rr = srv.serve("PUT", aliceURL, `{"Active":true}`, cDave)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	rr = srv.serve("GET", "/api/notes", "", laptop)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+	srv.login(t, "alice", "changed")
+
+	// An admin changing the password logs out all the sessions of the user
+	rr = srv.serve("PUT", aliceURL, `{"Password":"again"}`, cDave)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	sessions, err = r.AllSessions(alice.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(sessions), 0)
+	rr = srv.serve("GET", "/api/notes", "", cDave)
+	assert.Equal(t, rr.Code, http.StatusOK)
+}
+
+func TestRevokeSessionOfAnotherUser(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	createUser(t, r, "bob", "password", false)
+	alice := srv.login(t, "alice", "password")
+	bob := srv.login(t, "bob", "password")
+
+	rr := srv.serve("GET", "/api/sessions", "", bob)
+	var sessions []doit.SessionResponse
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &sessions))
+	assert.Equal(t, len(sessions), 1)
+
+	rr = srv.serve("DELETE", "/api/sessions/"+strconv.FormatInt(sessions[0].ID, 10), "", alice)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+}
+
+func (srv *Server) createToken(t *testing.T, c *http.Cookie, body string) doit.APITokenResponse {
+	rr := srv.serve("POST", "/api/tokens", body, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+
+	var token doit.APITokenResponse
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &token))
+	assert.Check(t, strings.HasPrefix(token.Token, API_TOKEN_PREFIX))
+	return token
+}
+
+// Perform a request authenticated with an API token
+func (srv *Server) serveWithToken(method string, endpoint string, body string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, endpoint, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	c := srv.login(t, "alice", "password")
+
+	read := srv.createToken(t, c, `{"Name":"cron","Scopes":["todos:read"]}`)
+
+	rr := srv.serveWithToken("GET", "/api/notes", "", read.Token)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	rr = srv.serveWithToken("POST", "/api/notes", `{"Title":"From cron","StateID":1,"PriorityID":1,"ColorID":1}`, read.Token)
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+
+	// Tokens can't be used to manage other tokens
+	rr = srv.serveWithToken("GET", "/api/tokens", "", read.Token)
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+
+	write := srv.createToken(t, c, `{"Name":"script","Scopes":["todos:read","todos:write"]}`)
+	rr = srv.serveWithToken("POST", "/api/notes", `{"Title":"From script","StateID":1,"PriorityID":1,"ColorID":1}`, write.Token)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+
+	rr = srv.serve("GET", "/api/tokens", "", c)
+	var tokens []doit.APITokenResponse
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &tokens))
+	assert.Equal(t, len(tokens), 2)
+	for _, token := range tokens {
+		assert.Equal(t, token.Token, "")
+	}
+}
+
+func TestAPITokenInvalid(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	c := srv.login(t, "alice", "password")
+
+	rr := srv.serveWithToken("GET", "/api/notes", "", API_TOKEN_PREFIX+"notvalid")
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+
+	rr = srv.serve("POST", "/api/tokens", `{"Name":"admin","Scopes":["users:admin"]}`, c)
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+
+	rr = srv.serve("POST", "/api/tokens", `{"Name":"unknown","Scopes":["notes:everything"]}`, c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+
+	token := srv.createToken(t, c, `{"Name":"cron","Scopes":["todos:read"]}`)
+	rr = srv.serve("DELETE", "/api/tokens/"+strconv.FormatInt(token.ID, 10), "", c)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+
+	rr = srv.serveWithToken("GET", "/api/notes", "", token.Token)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+}
+
+func TestDeactivatedUser(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "dave", "password", true)
+	alice := createUser(t, r, "alice", "password", true)
+	cDave := srv.login(t, "dave", "password")
+	c := srv.login(t, "alice", "password")
+	token := srv.createToken(t, c, `{"Name":"admin","Scopes":["todos:read","users:admin"]}`)
+
+	rr := srv.serveWithToken("GET", "/api/users", "", token.Token)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	rr = srv.serve("PUT", "/api/users/"+strconv.FormatInt(alice.ID, 10), `{"Active":false}`, cDave)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	// Nor the tokens nor the sessions of the user can be used anymore
+	rr = srv.serveWithToken("GET", "/api/notes", "", token.Token)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+	rr = srv.serveWithToken("GET", "/api/users", "", token.Token)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+	rr = srv.serve("GET", "/api/notes", "", c)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+}
+
+func TestAPITokenExpired(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	alice := createUser(t, r, "alice", "password", false)
+
+	_, err := r.CreateAPIToken(doit.APIToken{
+		UserID:    alice.ID,
+		Name:      "old",
+		TokenHash: hashToken(API_TOKEN_PREFIX + "expired"),
+		Scopes:    []string{doit.ScopeTodosRead},
+		Created:   time.Now().Add(-2 * time.Hour),
+		Expire:    time.Now().Add(-time.Hour),
+	})
+	assert.NilError(t, err)
+
+	rr := srv.serveWithToken("GET", "/api/notes", "", API_TOKEN_PREFIX+"expired")
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+}
+
+func TestNotesFilter(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	alice := createUser(t, r, "alice", "password", false)
+	c := srv.login(t, "alice", "password")
+
+	for i := range 5 {
+		_, err := r.CreateTodo(doit.Todo{Title: "Note " + strconv.Itoa(i), StateID: int64(i%2 + 1), PriorityID: 1, ColorID: 1, UserID: alice.ID})
+		assert.NilError(t, err)
+	}
+
+	rr := srv.serve("GET", "/api/notes?state=1&sort=-id&limit=2", "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, rr.Header().Get(TOTAL_COUNT_HEADER), "3")
+
+	var notes []doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &notes))
+	assert.Equal(t, len(notes), 2)
+	assert.Equal(t, notes[0].Title, "Note 4")
+	assert.Equal(t, notes[1].Title, "Note 2")
+
+	rr = srv.serve("GET", "/api/notes?sort=owner", "", c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+
+	rr = srv.serve("GET", "/api/notes?expires_before=tomorrow", "", c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+
+	rr = srv.serve("GET", "/api/notes?limit=-1", "", c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+}
+
+func TestNotesSearch(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	alice := createUser(t, r, "alice", "password", false)
+	c := srv.login(t, "alice", "password")
+
+	_, err := r.CreateTodo(doit.Todo{Title: "Buy milk", StateID: 1, PriorityID: 1, ColorID: 1, UserID: alice.ID})
+	assert.NilError(t, err)
+	_, err = r.CreateTodo(doit.Todo{Title: "Call mom", StateID: 1, PriorityID: 1, ColorID: 1, UserID: alice.ID})
+	assert.NilError(t, err)
+
+	rr := srv.serve("GET", "/api/notes/search?q=milk", "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	var results []doit.TodoSearchResult
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &results))
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].Todo.Title, "Buy milk")
+	assert.Equal(t, results[0].Snippet, "Buy <b>milk</b>")
+
+	rr = srv.serve("GET", "/api/notes/search?q=", "", c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+}
+
+func TestTodoItems(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	alice := createUser(t, r, "alice", "password", false)
+	createUser(t, r, "bob", "password", false)
+	c := srv.login(t, "alice", "password")
+
+	note, err := r.CreateTodo(doit.Todo{Title: "Trip", StateID: 1, PriorityID: 1, ColorID: 1, UserID: alice.ID})
+	assert.NilError(t, err)
+	base := "/api/notes/" + strconv.FormatInt(note.ID, 10)
+
+	var items []doit.TodoItem
+	for _, text := range []string{"Tickets", "Hotel"} {
+		rr := srv.serve("POST", base+"/items", `{"Text":"`+text+`"}`, c)
+		assert.Equal(t, rr.Code, http.StatusCreated)
+
+		var item doit.TodoItem
+		assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &item))
+		items = append(items, item)
+	}
+
+	rr := srv.serve("POST", base+"/items", `{"Text":""}`, c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+
+	itemURL := base + "/items/" + strconv.FormatInt(items[1].ID, 10)
+	rr = srv.serve("PUT", itemURL, `{"Done":true,"Position":0}`, c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	var updated doit.TodoItem
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &updated))
+	assert.Equal(t, updated.Text, "Hotel")
+	assert.Equal(t, updated.Done, true)
+	assert.Equal(t, updated.Position, int64(0))
+
+	rr = srv.serve("GET", base, "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	var got doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+	assert.Equal(t, got.Progress, int64(50))
+
+	// Bob can't see the items of alice
+	cBob := srv.login(t, "bob", "password")
+	rr = srv.serve("GET", base+"/items", "", cBob)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+	rr = srv.serve("DELETE", itemURL, "", cBob)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+
+	rr = srv.serve("DELETE", itemURL, "", c)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+
+	rr = srv.serve("GET", base+"/items", "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	var left []doit.TodoItem
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &left))
+	assert.Equal(t, len(left), 1)
+	assert.Equal(t, left[0].Text, "Tickets")
+	assert.Equal(t, left[0].Position, int64(0))
+}
+
+func TestTags(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	createUser(t, r, "bob", "password", false)
+	c := srv.login(t, "alice", "password")
+
+	rr := srv.serve("POST", "/api/tags", `{"Name":"work","Color":"#aabbcc"}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var tag doit.Tag
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &tag))
+	tagID := strconv.FormatInt(tag.ID, 10)
+
+	rr = srv.serve("POST", "/api/tags", `{"Name":"home","Color":"red"}`, c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+	rr = srv.serve("POST", "/api/tags", `{"Name":"work"}`, c)
+	assert.Equal(t, rr.Code, http.StatusConflict)
+
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Report","StateID":1,"PriorityID":1,"ColorID":1,"TagIDs":[`+tagID+`]}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Groceries","StateID":1,"PriorityID":1,"ColorID":1}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+
+	rr = srv.serve("GET", "/api/notes?tag="+tagID, "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	var notes []doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &notes))
+	assert.Equal(t, len(notes), 1)
+	assert.Equal(t, notes[0].Title, "Report")
+	assert.DeepEqual(t, notes[0].TagIDs, []int64{tag.ID})
+
+	rr = srv.serve("PUT", "/api/tags/"+tagID, `{"Name":"office"}`, c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &tag))
+	assert.Equal(t, tag.Name, "office")
+	assert.Equal(t, tag.Color, "#aabbcc")
+
+	// Bob can't see nor use the tags of alice
+	cBob := srv.login(t, "bob", "password")
+	rr = srv.serve("GET", "/api/tags/"+tagID, "", cBob)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Mine","StateID":1,"PriorityID":1,"ColorID":1,"TagIDs":[`+tagID+`]}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+
+	rr = srv.serve("DELETE", "/api/tags/"+tagID, "", c)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	rr = srv.serve("GET", "/api/tags", "", c)
+	assert.Equal(t, rr.Body.String(), "[]")
+}
+
+func TestRecurringNote(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	c := srv.login(t, "alice", "password")
+
+	rr := srv.serve("POST", "/api/notes", `{"Title":"Trash","StateID":1,"PriorityID":1,"ColorID":1,"Recurrence":"FREQ=WEEKLY"}`, c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Trash","StateID":1,"PriorityID":1,"ColorID":1,"Recurrence":"FREQ=HOURLY","Expiration":{"DoesExpire":true,"Date":"2026-01-05T09:00:00Z"}}`, c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Trash","StateID":1,"PriorityID":1,"ColorID":1,"Recurrence":"freq=weekly;count=2","Expiration":{"DoesExpire":true,"Date":"2026-01-05T09:00:00Z"}}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var created doit.TodoResponse
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	assert.Equal(t, created.Recurrence, "FREQ=WEEKLY;COUNT=2")
+	noteURL := "/api/notes/" + strconv.FormatInt(created.ID, 10)
+
+	rr = srv.serve("POST", noteURL+"/items", `{"Text":"Paper"}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+
+	done := func(url string) {
+		rr := srv.serve("GET", url, "", c)
+		assert.Equal(t, rr.Code, http.StatusOK)
+		var note doit.Todo
+		assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &note))
+
+		note.StateID = doit.StateDone.ID
+		b, err := json.Marshal(note)
+		assert.NilError(t, err)
+		rr = srv.serve("PUT", url, string(b), c)
+		assert.Equal(t, rr.Code, http.StatusOK)
+	}
+
+	notes := func() []doit.Todo {
+		rr := srv.serve("GET", "/api/notes", "", c)
+		assert.Equal(t, rr.Code, http.StatusOK)
+		var notes []doit.Todo
+		assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &notes))
+		return notes
+	}
+
+	done(noteURL)
+	all := notes()
+	assert.Equal(t, len(all), 2)
+	assert.Equal(t, all[0].Recurrence, "")
+	next := all[1]
+	assert.Equal(t, next.StateID, doit.StateToDo.ID)
+	assert.Equal(t, next.Recurrence, "FREQ=WEEKLY;COUNT=1")
+	assert.Assert(t, next.Expiration.Date.Equal(time.Date(2026, 1, 12, 9, 0, 0, 0, time.UTC)))
+
+	items, err := r.AllTodoItems(next.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, len(items), 1)
+	assert.Equal(t, items[0].Text, "Paper")
+
+	// That was the last occurrence
+	done("/api/notes/" + strconv.FormatInt(next.ID, 10))
+	assert.Equal(t, len(notes()), 2)
+}
+
+func TestProjects(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	createUser(t, r, "bob", "password", false)
+	c := srv.login(t, "alice", "password")
+
+	rr := srv.serve("POST", "/api/projects", `{"Name":"Work"}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var project doit.Project
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &project))
+	projectURL := "/api/projects/" + strconv.FormatInt(project.ID, 10)
+	projectID := strconv.FormatInt(project.ID, 10)
+
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Report","StateID":1,"PriorityID":1,"ColorID":1,"ProjectID":`+projectID+`}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Groceries","StateID":1,"PriorityID":1,"ColorID":1}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+
+	titles := func(url string) []string {
+		rr := srv.serve("GET", url, "", c)
+		assert.Equal(t, rr.Code, http.StatusOK)
+		var notes []doit.Todo
+		assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &notes))
+		var titles []string
+		for i := range notes {
+			titles = append(titles, notes[i].Title)
+		}
+		return titles
+	}
+
+	assert.DeepEqual(t, titles(projectURL+"/notes"), []string{"Report"})
+	assert.DeepEqual(t, titles("/api/notes?project=inbox"), []string{"Groceries"})
+
+	rr = srv.serve("PUT", projectURL, `{"Archived":true}`, c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.DeepEqual(t, titles("/api/notes"), []string{"Groceries"})
+	assert.DeepEqual(t, titles(projectURL+"/notes"), []string{"Report"})
+
+	// Bob can't see the project nor add notes to it
+	cBob := srv.login(t, "bob", "password")
+	rr = srv.serve("GET", projectURL+"/notes", "", cBob)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Mine","StateID":1,"PriorityID":1,"ColorID":1,"ProjectID":`+projectID+`}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+
+	rr = srv.serve("DELETE", projectURL+"?notes=everything", "", c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+	rr = srv.serve("DELETE", projectURL, "", c)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	assert.DeepEqual(t, titles("/api/notes?project=inbox"), []string{"Report", "Groceries"})
+}
+
+func TestSharing(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	bob := createUser(t, r, "bob", "password", false)
+	createUser(t, r, "carol", "password", false)
+	c := srv.login(t, "alice", "password")
+	cBob := srv.login(t, "bob", "password")
+	cCarol := srv.login(t, "carol", "password")
+
+	rr := srv.serve("POST", "/api/notes", `{"Title":"Shared","StateID":1,"PriorityID":1,"ColorID":1}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var note doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &note))
+	noteURL := "/api/notes/" + strconv.FormatInt(note.ID, 10)
+
+	rr = srv.serve("POST", noteURL+"/shares", `{"Username":"bob","Permission":"read"}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+	rr = srv.serve("POST", noteURL+"/shares", `{"Username":"alice","Permission":"read"}`, c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+	rr = srv.serve("POST", noteURL+"/shares", `{"Username":"bob","Permission":"owner"}`, c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+	rr = srv.serve("POST", noteURL+"/shares", `{"Username":"bob","Permission":"read"}`, c)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+
+	// Bob sees the note, but can't change it
+	rr = srv.serve("GET", "/api/notes", "", cBob)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	var notes []doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &notes))
+	assert.Equal(t, len(notes), 1)
+	assert.Equal(t, notes[0].Title, "Shared")
+	rr = srv.serve("PUT", noteURL, `{"Title":"Changed","StateID":1,"PriorityID":1,"ColorID":1}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+	rr = srv.serve("GET", noteURL, "", cCarol)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+
+	// With edit he can change it, but still can't delete it nor share it
+	rr = srv.serve("POST", noteURL+"/shares", `{"Username":"bob","Permission":"edit"}`, c)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	rr = srv.serve("PUT", noteURL, `{"Title":"Changed","StateID":1,"PriorityID":1,"ColorID":1}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &note))
+	assert.Equal(t, note.Title, "Changed")
+	assert.Equal(t, note.UserID, notes[0].UserID)
+	rr = srv.serve("DELETE", noteURL, "", cBob)
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+	rr = srv.serve("POST", noteURL+"/shares", `{"Username":"carol","Permission":"read"}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+
+	rr = srv.serve("GET", noteURL+"/shares", "", cBob)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	var shares []doit.Share
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &shares))
+	assert.DeepEqual(t, shares, []doit.Share{{UserID: bob.ID, Username: "bob", Permission: "edit"}})
+
+	bobID := strconv.FormatInt(bob.ID, 10)
+	rr = srv.serve("DELETE", noteURL+"/shares/"+bobID, "", c)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	rr = srv.serve("GET", noteURL, "", cBob)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+
+	// Sharing a project shares its notes
+	rr = srv.serve("POST", "/api/projects", `{"Name":"Work"}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var project doit.Project
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &project))
+	projectURL := "/api/projects/" + strconv.FormatInt(project.ID, 10)
+	rr = srv.serve("PUT", noteURL, `{"Title":"Changed","StateID":1,"PriorityID":1,"ColorID":1,"ProjectID":`+strconv.FormatInt(project.ID, 10)+`}`, c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	rr = srv.serve("POST", projectURL+"/shares", `{"Username":"bob","Permission":"read"}`, c)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	rr = srv.serve("GET", projectURL+"/notes", "", cBob)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &notes))
+	assert.Equal(t, len(notes), 1)
+	rr = srv.serve("GET", noteURL, "", cBob)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	rr = srv.serve("PUT", projectURL, `{"Archived":true}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+}
+
+func TestAssignNote(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	bob := createUser(t, r, "bob", "password", false)
+	carol := createUser(t, r, "carol", "password", false)
+	carol.Active = false
+	_, err := r.UpdateUser(carol.ID, *carol)
+	assert.NilError(t, err)
+	c := srv.login(t, "alice", "password")
+	cBob := srv.login(t, "bob", "password")
+
+	bobID := strconv.FormatInt(bob.ID, 10)
+	rr := srv.serve("POST", "/api/notes", `{"Title":"Report","StateID":1,"PriorityID":1,"ColorID":1,"AssigneeID":`+strconv.FormatInt(carol.ID, 10)+`}`, c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Report","StateID":1,"PriorityID":1,"ColorID":1,"AssigneeID":`+bobID+`}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var note doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &note))
+	noteURL := "/api/notes/" + strconv.FormatInt(note.ID, 10)
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Groceries","StateID":1,"PriorityID":1,"ColorID":1}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+
+	rr = srv.serve("GET", "/api/notes?assigned=me", "", cBob)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	var notes []doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &notes))
+	assert.Equal(t, len(notes), 1)
+	assert.Equal(t, notes[0].Title, "Report")
+	rr = srv.serve("GET", "/api/notes?assigned=you", "", cBob)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+
+	// The assignee changes only the state
+	rr = srv.serve("PUT", noteURL, `{"Title":"Changed","StateID":2,"PriorityID":1,"ColorID":1,"AssigneeID":`+bobID+`}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &note))
+	assert.Equal(t, note.Title, "Report")
+	assert.Equal(t, note.StateID, int64(2))
+
+	rr = srv.serve("PUT", noteURL, `{"Title":"Report","StateID":2,"PriorityID":1,"ColorID":1}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &note))
+	assert.Equal(t, note.AssigneeID, bob.ID)
+
+	rr = srv.serve("DELETE", noteURL, "", cBob)
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+	rr = srv.serve("POST", noteURL+"/items", `{"Text":"step"}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+
+	// The owner can unassign the note
+	rr = srv.serve("PUT", noteURL, `{"Title":"Report","StateID":2,"PriorityID":1,"ColorID":1,"AssigneeID":0}`, c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	rr = srv.serve("GET", noteURL, "", cBob)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+}
+
+func TestComments(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	createUser(t, r, "bob", "password", false)
+	createUser(t, r, "dave", "password", true)
+	c := srv.login(t, "alice", "password")
+	cBob := srv.login(t, "bob", "password")
+	cAdmin := srv.login(t, "dave", "password")
+
+	rr := srv.serve("POST", "/api/notes", `{"Title":"Report","StateID":1,"PriorityID":1,"ColorID":1}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var note doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &note))
+	noteURL := "/api/notes/" + strconv.FormatInt(note.ID, 10)
+
+	rr = srv.serve("POST", noteURL+"/comments", `{"Body":"Hi"}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+	rr = srv.serve("POST", noteURL+"/shares", `{"Username":"bob","Permission":"read"}`, c)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	rr = srv.serve("POST", noteURL+"/shares", `{"Username":"dave","Permission":"read"}`, c)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+
+	rr = srv.serve("POST", noteURL+"/comments", `{"Body":" "}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+	rr = srv.serve("POST", noteURL+"/comments", `{"Body":"Is it done?"}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var comment doit.Comment
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &comment))
+	assert.Equal(t, comment.Username, "bob")
+	commentURL := noteURL + "/comments/" + strconv.FormatInt(comment.ID, 10)
+
+	rr = srv.serve("GET", noteURL+"/comments", "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	var comments []doit.Comment
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &comments))
+	assert.Equal(t, len(comments), 1)
+	assert.Equal(t, comments[0].Body, "Is it done?")
+
+	// Only the author or an admin can change the comment
+	rr = srv.serve("PUT", commentURL, `{"Body":"No"}`, c)
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+	rr = srv.serve("PUT", commentURL, `{"Body":"Is it done yet?"}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &comment))
+	assert.Equal(t, comment.Body, "Is it done yet?")
+	assert.Assert(t, !comment.Edited.IsZero())
+
+	rr = srv.serve("DELETE", commentURL, "", c)
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+	rr = srv.serve("DELETE", commentURL, "", cAdmin)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	rr = srv.serve("GET", commentURL, "", c)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+}
+
+func TestNoteHistory(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	createUser(t, r, "bob", "password", false)
+	c := srv.login(t, "alice", "password")
+	cBob := srv.login(t, "bob", "password")
+
+	rr := srv.serve("POST", "/api/notes", `{"Title":"Report","StateID":1,"PriorityID":1,"ColorID":1}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var note doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &note))
+	noteURL := "/api/notes/" + strconv.FormatInt(note.ID, 10)
+
+	rr = srv.serve("POST", noteURL+"/shares", `{"Username":"bob","Permission":"edit"}`, c)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	rr = srv.serve("PUT", noteURL, `{"Title":"Final report","StateID":2,"PriorityID":1,"ColorID":1}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	// Nothing changes, so nothing is recorded
+	rr = srv.serve("PUT", noteURL, `{"Title":"Final report","StateID":2,"PriorityID":1,"ColorID":1}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	history := func() []doit.TodoEvent {
+		rr := srv.serve("GET", noteURL+"/history", "", c)
+		assert.Equal(t, rr.Code, http.StatusOK)
+		var events []doit.TodoEvent
+		assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &events))
+		return events
+	}
+	events := history()
+	assert.Equal(t, len(events), 2)
+	assert.Equal(t, events[0].Action, doit.TodoEventCreate)
+	assert.Equal(t, events[0].Username, "alice")
+	assert.Equal(t, events[1].Action, doit.TodoEventUpdate)
+	assert.Equal(t, events[1].Username, "bob")
+	assert.DeepEqual(t, events[1].Changes, []doit.TodoChange{
+		{Field: "Title", Old: "Report", New: "Final report"},
+		{Field: "StateID", Old: float64(1), New: float64(2)},
+	})
+
+	// Restore the first revision
+	restoreURL := noteURL + "/history/" + strconv.FormatInt(events[0].ID, 10) + "/restore"
+	rr = srv.serve("POST", restoreURL, "", cBob)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &note))
+	assert.Equal(t, note.Title, "Report")
+	assert.Equal(t, note.StateID, int64(1))
+
+	events = history()
+	assert.Equal(t, len(events), 3)
+	assert.Equal(t, events[2].Action, doit.TodoEventRestore)
+
+	rr = srv.serve("POST", noteURL+"/history/999/restore", "", c)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+}
+
+func TestAuditLog(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	bob := createUser(t, r, "bob", "password", false)
+	createUser(t, r, "dave", "password", true)
+
+	rr := srv.serve("POST", "/api/login", `{"Username":"alice","Password":"wrong"}`, nil)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+	c := srv.login(t, "alice", "password")
+	cAdmin := srv.login(t, "dave", "password")
+
+	bobURL := "/api/users/" + strconv.FormatInt(bob.ID, 10)
+	rr = srv.serve("PUT", bobURL, `{"Admin":true,"Active":false}`, cAdmin)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	rr = srv.serve("DELETE", bobURL, "", cAdmin)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	rr = srv.serve("GET", "/api/admin/audit", "", c)
+	assert.Equal(t, rr.Code, http.StatusForbidden)
+
+	types := func(query string) []string {
+		rr := srv.serve("GET", "/api/admin/audit"+query, "", cAdmin)
+		assert.Equal(t, rr.Code, http.StatusOK)
+		var events []doit.AuditEvent
+		assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &events))
+		var types []string
+		for i := range events {
+			types = append(types, events[i].Type)
+		}
+		return types
+	}
+	assert.DeepEqual(t, types("?type=login,login_failed"), []string{"login", "login", "login_failed"})
+	assert.DeepEqual(t, types("?user="+strconv.FormatInt(bob.ID, 10)), []string{"user_delete", "user_deactivate", "admin_grant"})
+
+	rr = srv.serve("GET", "/api/admin/audit?type=login_failed", "", cAdmin)
+	var events []doit.AuditEvent
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &events))
+	assert.Equal(t, events[0].TargetName, "alice")
+	assert.Equal(t, events[0].Details, "wrong password")
+
+	rr = srv.serve("GET", "/api/admin/audit?after=yesterday", "", cAdmin)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+}
+
+func TestTrash(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	createUser(t, r, "bob", "password", false)
+	c := srv.login(t, "alice", "password")
+	cBob := srv.login(t, "bob", "password")
+
+	rr := srv.serve("POST", "/api/notes", `{"Title":"Report","StateID":1,"PriorityID":1,"ColorID":1}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var note doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &note))
+	id := strconv.FormatInt(note.ID, 10)
+
+	rr = srv.serve("DELETE", "/api/notes/"+id, "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	rr = srv.serve("GET", "/api/notes/"+id, "", c)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+	rr = srv.serve("GET", "/api/notes", "", c)
+	assert.Equal(t, rr.Body.String(), "[]")
+
+	rr = srv.serve("GET", "/api/trash", "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	var notes []doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &notes))
+	assert.Equal(t, len(notes), 1)
+	assert.Assert(t, !notes[0].DeletedAt.IsZero())
+	rr = srv.serve("GET", "/api/trash", "", cBob)
+	assert.Equal(t, rr.Body.String(), "[]")
+
+	rr = srv.serve("POST", "/api/trash/"+id+"/restore", "", cBob)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+	rr = srv.serve("POST", "/api/trash/"+id+"/restore", "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	rr = srv.serve("GET", "/api/notes/"+id, "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	// Deleting from the trash is permanent
+	rr = srv.serve("DELETE", "/api/trash/"+id, "", c)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+	rr = srv.serve("DELETE", "/api/notes/"+id, "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	rr = srv.serve("DELETE", "/api/trash/"+id, "", c)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	rr = srv.serve("GET", "/api/trash", "", c)
+	assert.Equal(t, rr.Body.String(), "[]")
+}
+
+func TestExportImport(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	createUser(t, r, "bob", "password", false)
+	c := srv.login(t, "alice", "password")
+	cBob := srv.login(t, "bob", "password")
+
+	rr := srv.serve("POST", "/api/tags", `{"Name":"urgent"}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var tag doit.Tag
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &tag))
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Report","StateID":4,"PriorityID":5,"ColorID":2,"TagIDs":[`+strconv.FormatInt(tag.ID, 10)+`]}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+
+	rr = srv.serve("GET", "/api/export", "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, rr.Body.String(), `[{"Title":"Report","Description":"","State":"done","Priority":"very high","Color":"#d37676","Expiration":"","Recurrence":"","Project":"","Tags":["urgent"]}]`)
+	rr = srv.serve("GET", "/api/export?format=csv", "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, rr.Body.String(), "title,description,state,priority,color,expiration,recurrence,project,tags\nReport,,done,very high,#d37676,,,,urgent\n")
+	csv := rr.Body.String()
+	rr = srv.serve("GET", "/api/export?format=xml", "", c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+
+	// Nothing is imported if a row is not valid
+	rr = srv.serve("POST", "/api/import?format=csv", csv+"Milk,,bought,,,,,,\n,,,,,,,,\n", c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+	var result doit.ImportResult
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+	assert.DeepEqual(t, result.Errors, []doit.ImportError{
+		{Row: 2, Error: `State "bought" does not exist`},
+		{Row: 3, Error: "Title is empty or not present"},
+	})
+	// Tags are resolved among the ones of the user
+	rr = srv.serve("POST", "/api/import?format=csv", csv, cBob)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+	rr = srv.serve("GET", "/api/notes", "", c)
+	var notes []doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &notes))
+	assert.Equal(t, len(notes), 1)
+
+	rr = srv.serve("POST", "/api/import?dry_run=true", `[{"Title":"Milk"}]`, c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, rr.Body.String(), `{"Imported":1,"DryRun":true,"Errors":[]}`)
+
+	rr = srv.serve("POST", "/api/import?format=csv", csv, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	assert.Equal(t, rr.Body.String(), `{"Imported":1,"DryRun":false,"Errors":[]}`)
+	rr = srv.serve("GET", "/api/notes", "", c)
+	notes = nil
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &notes))
+	assert.Equal(t, len(notes), 2)
+	assert.DeepEqual(t, notes[1].TagIDs, []int64{tag.ID})
+	assert.Equal(t, notes[1].PriorityID, notes[0].PriorityID)
+
+	rr = srv.serve("GET", "/api/export?format=todotxt", "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, rr.Header().Get("Content-Disposition"), `attachment; filename="doit.txt"`)
+	assert.Equal(t, rr.Body.String(), "x (B) Report @urgent color:#d37676\nx (B) Report @urgent color:#d37676\n")
+	rr = srv.serve("POST", "/api/import?format=markdown", "## Nowhere\n\n- [ ] Milk\n", c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+	assert.Assert(t, strings.Contains(rr.Body.String(), `Project \"Nowhere\" does not exist`))
+	rr = srv.serve("POST", "/api/import?format=markdown", "- [ ] Milk #urgent\n  semi skimmed\n", c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	rr = srv.serve("GET", "/api/export?format=markdown", "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Assert(t, strings.HasSuffix(rr.Body.String(), "- [ ] Milk priority:very-low #urgent color:#b0c5a4\n  semi skimmed\n"), rr.Body.String())
+}
+
+func TestCalendarFeed(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	c := srv.login(t, "alice", "password")
+
+	rr := srv.serve("POST", "/api/notes", `{"Title":"Report","StateID":4,"PriorityID":6,"ColorID":1,"Expiration":{"DoesExpire":true,"Date":"2026-03-02T10:00:00Z"}}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var note doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &note))
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Someday","StateID":1,"PriorityID":1,"ColorID":1}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+
+	rr = srv.serve("GET", "/api/calendar.ics", "", nil)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+
+	token := srv.createToken(t, c, `{"Name":"calendar","Scopes":["todos:read"]}`)
+	rr = srv.serve("GET", "/api/calendar.ics?token="+token.Token, "", nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, rr.Header().Get("Content-Type"), "text/calendar; charset=utf-8")
+	ical := rr.Body.String()
+	assert.Assert(t, strings.Contains(ical, "UID:doit-"+strconv.FormatInt(note.ID, 10)+"\r\n"))
+	assert.Assert(t, strings.Contains(ical, "SUMMARY:Report\r\nDUE:20260302T100000Z\r\nPRIORITY:1\r\nSTATUS:COMPLETED\r\n"))
+	// Only the notes that expire are in the feed
+	assert.Equal(t, strings.Count(ical, "BEGIN:VTODO"), 1)
+
+	// The token in the query is accepted only for the feed
+	rr = srv.serve("GET", "/api/notes?token="+token.Token, "", nil)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+	rr = srv.serve("GET", "/api/calendar.ics?token="+API_TOKEN_PREFIX+"notvalid", "", nil)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+}
+
+// Perform a CalDAV request authenticated with an API token as password
+func (srv *Server) serveDAV(method string, endpoint string, body string, token string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, endpoint, strings.NewReader(body))
+	req.SetBasicAuth("anyone", token)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCalDAV(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	createUser(t, r, "bob", "password", false)
+	c := srv.login(t, "alice", "password")
+	cBob := srv.login(t, "bob", "password")
+	token := srv.createToken(t, c, `{"Name":"phone","Scopes":["todos:read","todos:write"]}`).Token
+	bobToken := srv.createToken(t, cBob, `{"Name":"phone","Scopes":["todos:read","todos:write"]}`).Token
+
+	rr := srv.serve("GET", "/.well-known/caldav", "", nil)
+	assert.Equal(t, rr.Code, http.StatusMovedPermanently)
+	assert.Equal(t, rr.Header().Get("Location"), DAV_PREFIX)
+	rr = srv.serve("PROPFIND", DAV_PREFIX, "", nil)
+	assert.Equal(t, rr.Code, http.StatusUnauthorized)
+	assert.Equal(t, rr.Header().Get("WWW-Authenticate"), `Basic realm="DOIT"`)
+
+	rr = srv.serveDAV("PROPFIND", DAV_PREFIX, `<d:propfind xmlns:d="DAV:"><d:prop><d:current-user-principal/><d:owner/></d:prop></d:propfind>`, token, map[string]string{"Depth": "0"})
+	assert.Equal(t, rr.Code, http.StatusMultiStatus)
+	assert.Assert(t, strings.Contains(rr.Body.String(), "<d:current-user-principal><d:href>/api/dav/principal/</d:href></d:current-user-principal>"))
+	assert.Assert(t, strings.Contains(rr.Body.String(), "<d:owner/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status>"))
+
+	rr = srv.serve("POST", "/api/projects", `{"Name":"Work"}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	rr = srv.serveDAV("PROPFIND", DAV_CALENDARS, "", token, map[string]string{"Depth": "1"})
+	assert.Equal(t, rr.Code, http.StatusMultiStatus)
+	assert.Assert(t, strings.Contains(rr.Body.String(), "<d:href>/api/dav/calendars/inbox/</d:href>"))
+	assert.Assert(t, strings.Contains(rr.Body.String(), "<d:displayname>Work</d:displayname>"))
+
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Report","StateID":1,"PriorityID":1,"ColorID":1}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var note doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &note))
+	object := DAV_CALENDARS + "inbox/doit-" + strconv.FormatInt(note.ID, 10) + ".ics"
+
+	rr = srv.serveDAV("PROPFIND", DAV_CALENDARS+"inbox/", "", token, map[string]string{"Depth": "1"})
+	assert.Equal(t, rr.Code, http.StatusMultiStatus)
+	assert.Assert(t, strings.Contains(rr.Body.String(), "<d:href>"+object+"</d:href>"))
+
+	rr = srv.serveDAV("GET", object, "", token, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Assert(t, strings.Contains(rr.Body.String(), "SUMMARY:Report\r\n"))
+	etag := rr.Header().Get("ETag")
+	assert.Assert(t, etag != "")
+	rr = srv.serveDAV("GET", object, "", bobToken, nil)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+
+	vtodo := "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nUID:doit-1\r\nSUMMARY:Final report\r\nSTATUS:COMPLETED\r\nEND:VTODO\r\nEND:VCALENDAR\r\n"
+	rr = srv.serveDAV("PUT", object, vtodo, token, map[string]string{"If-Match": `"old"`})
+	assert.Equal(t, rr.Code, http.StatusPreconditionFailed)
+	rr = srv.serveDAV("PUT", object, vtodo, token, map[string]string{"If-Match": etag})
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	rr = srv.serve("GET", "/api/notes/"+strconv.FormatInt(note.ID, 10), "", c)
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &note))
+	assert.Equal(t, note.Title, "Final report")
+	assert.Equal(t, note.StateID, doit.StateDone.ID)
+
+	// New notes keep the name and the UID chosen by the client
+	vtodo = "BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nUID:abc\r\nSUMMARY:Milk\r\nEND:VTODO\r\nEND:VCALENDAR\r\n"
+	rr = srv.serveDAV("PUT", DAV_CALENDARS+"inbox/abc.ics", vtodo, token, map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	rr = srv.serveDAV("PUT", DAV_CALENDARS+"inbox/abc.ics", vtodo, token, map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, rr.Code, http.StatusPreconditionFailed)
+	rr = srv.serveDAV("GET", DAV_CALENDARS+"inbox/abc.ics", "", token, nil)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Assert(t, strings.Contains(rr.Body.String(), "UID:abc\r\n"))
+
+	query := `<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav"><d:prop><d:getetag/><c:calendar-data/></d:prop>` +
+		`<c:filter><c:comp-filter name="VCALENDAR"><c:comp-filter name="%s"/></c:comp-filter></c:filter></c:calendar-query>`
+	rr = srv.serveDAV("REPORT", DAV_CALENDARS+"inbox/", strings.Replace(query, "%s", "VTODO", 1), token, nil)
+	assert.Equal(t, rr.Code, http.StatusMultiStatus)
+	assert.Equal(t, strings.Count(rr.Body.String(), "<d:response>"), 2)
+	assert.Assert(t, strings.Contains(rr.Body.String(), "SUMMARY:Milk"))
+	rr = srv.serveDAV("REPORT", DAV_CALENDARS+"inbox/", strings.Replace(query, "%s", "VEVENT", 1), token, nil)
+	assert.Equal(t, strings.Count(rr.Body.String(), "<d:response>"), 0)
+
+	multiget := `<c:calendar-multiget xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav"><d:prop><d:getetag/></d:prop>` +
+		`<d:href>/api/dav/calendars/inbox/abc.ics</d:href><d:href>/api/dav/calendars/inbox/missing.ics</d:href></c:calendar-multiget>`
+	rr = srv.serveDAV("REPORT", DAV_CALENDARS+"inbox/", multiget, token, nil)
+	assert.Equal(t, rr.Code, http.StatusMultiStatus)
+	assert.Assert(t, strings.Contains(rr.Body.String(), "<d:href>/api/dav/calendars/inbox/missing.ics</d:href><d:status>HTTP/1.1 404 Not Found</d:status>"))
+	assert.Equal(t, strings.Count(rr.Body.String(), "<d:getetag>"), 1)
+
+	rr = srv.serveDAV("DELETE", DAV_CALENDARS+"inbox/abc.ics", "", token, nil)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	rr = srv.serveDAV("GET", DAV_CALENDARS+"inbox/abc.ics", "", token, nil)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+	rr = srv.serve("GET", "/api/trash", "", c)
+	assert.Assert(t, strings.Contains(rr.Body.String(), `"Title":"Milk"`))
+}
+
+// Start an SMTP server that only accepts the messages, and return its port
+// and the messages it received
+func smtpSink(t *testing.T) (int, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			// Lines of the messages end with \n
+			c := textproto.NewConn(conn)
+			c.PrintfLine("220 sink")
+			for {
+				line, err := c.ReadLine()
+				if err != nil {
+					break
+				}
+				switch strings.ToUpper(strings.Fields(line + " ")[0]) {
+				case "DATA":
+					c.PrintfLine("354 go on")
+					b, _ := c.ReadDotBytes()
+					messages <- string(b)
+					c.PrintfLine("250 ok")
+				case "QUIT":
+					c.PrintfLine("221 bye")
+				default:
+					c.PrintfLine("250 ok")
+				}
+			}
+			c.Close()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).Port, messages
+}
+
+func TestReminders(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	alice := createUser(t, r, "alice", "password", false)
+	createUser(t, r, "bob", "password", false)
+	c := srv.login(t, "alice", "password")
+	cBob := srv.login(t, "bob", "password")
+
+	now := time.Date(2030, 3, 2, 9, 0, 0, 0, time.Local)
+	due := func(d time.Duration) string {
+		return `"Expiration":{"DoesExpire":true,"Date":"` + now.Add(d).Format(time.RFC3339) + `"}`
+	}
+	rr := srv.serve("POST", "/api/notes", `{"Title":"Report","StateID":1,"PriorityID":1,"ColorID":1,`+due(30*time.Minute)+`}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Slides","StateID":1,"PriorityID":1,"ColorID":1,`+due(20*time.Hour)+`}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Taxes","StateID":2,"PriorityID":1,"ColorID":1,`+due(-time.Hour)+`}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Done","StateID":4,"PriorityID":1,"ColorID":1,`+due(10*time.Minute)+`}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Holidays","StateID":1,"PriorityID":1,"ColorID":1,`+due(72*time.Hour)+`}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	// Not in the digest
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Paid","StateID":4,"PriorityID":1,"ColorID":1,`+due(-2*time.Hour)+`}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Trashed","StateID":1,"PriorityID":1,"ColorID":1,`+due(-2*time.Hour)+`}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var trashed doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &trashed))
+	rr = srv.serve("DELETE", "/api/notes/"+strconv.FormatInt(trashed.ID, 10), "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	// What is not sent is tried again at the next check
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	l.Close()
+	conf := config.SMTP{Host: "127.0.0.1", Port: l.Addr().(*net.TCPAddr).Port, From: "doit@mail.com", Reminders: []string{"1h", "24h"}, Digest_Hour: 8}
+	rm, err := newReminders(r, conf)
+	assert.NilError(t, err)
+	rm.send(now)
+
+	port, messages := smtpSink(t)
+	conf.Port = port
+	rm, err = newReminders(r, conf)
+	assert.NilError(t, err)
+	rm.send(now)
+
+	assert.Equal(t, len(messages), 2)
+	msg := <-messages
+	assert.Assert(t, strings.Contains(msg, "To: <"+alice.Email+">\n"), msg)
+	assert.Assert(t, strings.Contains(msg, "Subject: Reminder: 2 notes expire soon\n"), msg)
+	assert.Assert(t, strings.Contains(msg, "- Report, on "), msg)
+	assert.Assert(t, strings.Contains(msg, "- Slides, on "), msg)
+	assert.Assert(t, !strings.Contains(msg, "Done"), msg)
+	msg = <-messages
+	assert.Assert(t, strings.Contains(msg, "Subject: Digest: 1 notes are expired\n"), msg)
+	assert.Assert(t, strings.Contains(msg, "- Taxes, on "), msg)
+	assert.Assert(t, !strings.Contains(msg, "Paid") && !strings.Contains(msg, "Trashed"), msg)
+
+	// What was sent is remembered across restarts
+	rm, err = newReminders(r, conf)
+	assert.NilError(t, err)
+	rm.send(now.Add(time.Minute))
+	assert.Equal(t, len(messages), 0)
+
+	// The next reminder before the due date, and the digest of the next day
+	// after the hour
+	rm.send(now.Add(19*time.Hour + 30*time.Minute))
+	assert.Equal(t, len(messages), 1)
+	msg = <-messages
+	assert.Assert(t, strings.Contains(msg, `Subject: Reminder: "Slides" expires on `), msg)
+	rm.send(now.Add(24 * time.Hour))
+	assert.Equal(t, len(messages), 1)
+	msg = <-messages
+	assert.Assert(t, strings.Contains(msg, "Subject: Digest: 3 notes are expired\n"), msg)
+
+	_, err = newReminders(r, config.SMTP{From: "doit@mail.com", Reminders: []string{"1 day"}})
+	assert.ErrorContains(t, err, "1 day")
+}
+
+func TestWebhooks(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+	webhookBackoff = time.Millisecond
+	defer func() { webhookBackoff = 10 * time.Second }()
+
+	createUser(t, r, "alice", "password", false)
+	createUser(t, r, "bob", "password", false)
+	createUser(t, r, "dave", "password", true)
+	c := srv.login(t, "alice", "password")
+	cBob := srv.login(t, "bob", "password")
+	cDave := srv.login(t, "dave", "password")
+
+	// The receiver fails the first two requests to /flaky
+	type received struct {
+		path      string
+		signature string
+		body      []byte
+	}
+	requests := make(chan received, 10)
+	flaky := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{r.URL.Path, r.Header.Get(doit.WebhookSignatureHeader), body}
+		if r.URL.Path == "/flaky" {
+			if flaky++; flaky <= 2 {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}
+	}))
+	defer receiver.Close()
+	next := func() (received, doit.WebhookPayload) {
+		select {
+		case req := <-requests:
+			var p doit.WebhookPayload
+			assert.NilError(t, json.Unmarshal(req.body, &p))
+			return req, p
+		case <-time.After(5 * time.Second):
+			t.Fatal("Webhook not delivered")
+		}
+		return received{}, doit.WebhookPayload{}
+	}
+
+	// Webhooks can't reach the addresses that are not public, by default
+	rr := srv.serve("POST", "/api/webhooks", `{"URL":"`+receiver.URL+`/notes"}`, c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+	rr = srv.serve("POST", "/api/webhooks", `{"URL":"http://169.254.169.254/latest"}`, c)
+	assert.Equal(t, rr.Code, http.StatusBadRequest)
+	localURL := strings.Replace(receiver.URL, "127.0.0.1", "localhost", 1)
+	rr = srv.serve("POST", "/api/webhooks", `{"URL":"`+localURL+`/notes"}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var local doit.Webhook
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &local))
+	localHookURL := "/api/webhooks/" + strconv.FormatInt(local.ID, 10)
+	rr = srv.serve("POST", localHookURL+"/test", "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	var refused doit.WebhookDelivery
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &refused))
+	assert.Equal(t, refused.StatusCode, 0)
+	assert.Assert(t, strings.Contains(refused.Error, "address is not public"), refused.Error)
+	rr = srv.serve("DELETE", localHookURL, "", c)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	assert.Equal(t, len(requests), 0)
+
+	config.GetConfig().Webhooks.Allow_Private = true
+	defer func() { config.GetConfig().Webhooks.Allow_Private = false }()
+
+	rr = srv.serve("POST", "/api/webhooks", `{"URL":"`+receiver.URL+`/notes","Secret":"s3cret","Events":["note.created","note.state_changed"]}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var hook doit.Webhook
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &hook))
+	assert.Assert(t, hook.Active)
+	hookURL := "/api/webhooks/" + strconv.FormatInt(hook.ID, 10)
+
+	invalid := []struct {
+		body string
+		code int
+	}{
+		{`{"URL":"ftp://example.com"}`, http.StatusBadRequest},
+		{`{"URL":"` + receiver.URL + `","Events":["note.exploded"]}`, http.StatusBadRequest},
+		{`{"URL":"` + receiver.URL + `","Events":["user.created"]}`, http.StatusBadRequest},
+		{`{"URL":"` + receiver.URL + `","Global":true}`, http.StatusForbidden},
+	}
+	for _, i := range invalid {
+		rr = srv.serve("POST", "/api/webhooks", i.body, c)
+		assert.Equal(t, rr.Code, i.code, i.body)
+	}
+	rr = srv.serve("GET", hookURL, "", cBob)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+
+	// Only the events of the owner are delivered
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Holidays","StateID":1,"PriorityID":1,"ColorID":1}`, cBob)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Report","StateID":1,"PriorityID":1,"ColorID":1}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var note doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &note))
+	noteURL := "/api/notes/" + strconv.FormatInt(note.ID, 10)
+
+	req, p := next()
+	assert.Equal(t, req.path, "/notes")
+	assert.Equal(t, req.signature, doit.SignWebhookPayload("s3cret", req.body))
+	assert.Equal(t, p.Event, doit.WebhookNoteCreated)
+	assert.Equal(t, p.Note.Title, "Report")
+
+	rr = srv.serve("PUT", noteURL, `{"Title":"Report","StateID":2,"PriorityID":1,"ColorID":1}`, c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	_, p = next()
+	assert.Equal(t, p.Event, doit.WebhookNoteStateChanged)
+	assert.DeepEqual(t, p.Changes, []doit.TodoChange{{Field: "StateID", Old: float64(1), New: float64(2)}})
+
+	// Failed deliveries are retried
+	rr = srv.serve("POST", "/api/webhooks", `{"URL":"`+receiver.URL+`/flaky","Events":["note.deleted"]}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var flakyHook doit.Webhook
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &flakyHook))
+	assert.Assert(t, flakyHook.Secret != "")
+	rr = srv.serve("DELETE", noteURL, "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	for range 3 {
+		req, p = next()
+		assert.Equal(t, req.path, "/flaky")
+		assert.Equal(t, p.Event, doit.WebhookNoteDeleted)
+	}
+
+	var deliveries []doit.WebhookDelivery
+	deliveriesURL := "/api/webhooks/" + strconv.FormatInt(flakyHook.ID, 10) + "/deliveries"
+	for range 100 {
+		rr = srv.serve("GET", deliveriesURL, "", c)
+		assert.Equal(t, rr.Code, http.StatusOK)
+		assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &deliveries))
+		if len(deliveries) == 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, len(deliveries), 3)
+	assert.Equal(t, deliveries[0].Attempt, 3)
+	assert.Equal(t, deliveries[0].StatusCode, http.StatusOK)
+	assert.Equal(t, deliveries[0].Error, "")
+	assert.Equal(t, deliveries[2].StatusCode, http.StatusInternalServerError)
+	assert.Equal(t, deliveries[0].PayloadID, deliveries[2].PayloadID)
+
+	// The test event is sent even if the webhook is not active
+	rr = srv.serve("PUT", hookURL, `{"Active":false}`, c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	rr = srv.serve("POST", hookURL+"/test", "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	var d doit.WebhookDelivery
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &d))
+	assert.Equal(t, d.Event, doit.WebhookPing)
+	assert.Equal(t, d.StatusCode, http.StatusOK)
+	_, p = next()
+	assert.Equal(t, p.Event, doit.WebhookPing)
+
+	// Admins receive the user events with a global webhook
+	rr = srv.serve("POST", "/api/webhooks", `{"URL":"`+receiver.URL+`/users","Events":["user.created"],"Global":true}`, cDave)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	rr = srv.serve("POST", "/api/users", `{"Username":"erin","Password":"password","Email":"erin@mail.com"}`, cDave)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	req, p = next()
+	assert.Equal(t, req.path, "/users")
+	assert.Equal(t, p.Event, doit.WebhookUserCreated)
+	assert.Equal(t, p.User.Username, "erin")
+
+	rr = srv.serve("DELETE", hookURL, "", c)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	rr = srv.serve("GET", hookURL, "", c)
+	assert.Equal(t, rr.Code, http.StatusNotFound)
+}
+
+func TestEvents(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+	eventsHeartbeat = 10 * time.Millisecond
+	defer func() { eventsHeartbeat = 30 * time.Second }()
+
+	createUser(t, r, "alice", "password", false)
+	createUser(t, r, "bob", "password", false)
+	c := srv.login(t, "alice", "password")
+	cBob := srv.login(t, "bob", "password")
+
+	// Cleanups run in reverse order, so the streams are closed before the
+	// server even if the test fails
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	// Open a stream and return the blocks of lines it sends
+	stream := func(cookie *http.Cookie, lastID string) (*http.Response, chan string) {
+		req, err := http.NewRequest("GET", ts.URL+"/api/events", nil)
+		assert.NilError(t, err)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.NilError(t, err)
+		t.Cleanup(func() { res.Body.Close() })
+
+		blocks := make(chan string, 100)
+		go func() {
+			defer close(blocks)
+			s := bufio.NewScanner(res.Body)
+			var block []string
+			for s.Scan() {
+				if s.Text() != "" {
+					block = append(block, s.Text())
+					continue
+				}
+				blocks <- strings.Join(block, "\n")
+				block = nil
+			}
+		}()
+		return res, blocks
+	}
+	type event struct {
+		id    string
+		event string
+		note  doit.Todo
+	}
+	heartbeats := 0
+	next := func(blocks chan string, heartbeat bool) event {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case b := <-blocks:
+				if b == ": heartbeat" {
+					heartbeats++
+					if heartbeat {
+						return event{}
+					}
+					continue
+				}
+				var e event
+				for _, line := range strings.Split(b, "\n") {
+					k, v, _ := strings.Cut(line, ": ")
+					switch k {
+					case "id":
+						e.id = v
+					case "event":
+						e.event = v
+					case "data":
+						assert.NilError(t, json.Unmarshal([]byte(v), &e.note))
+					}
+				}
+				return e
+			case <-timeout:
+				t.Fatal("Event not received")
+			}
+		}
+	}
+
+	res, _ := stream(nil, "")
+	assert.Equal(t, res.StatusCode, http.StatusUnauthorized)
+	res.Body.Close()
+
+	res, blocks := stream(cBob, "")
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+	assert.Equal(t, res.Header.Get("Content-Type"), "text/event-stream")
+
+	// Bob only gets the events of the notes shared with him
+	rr := srv.serve("POST", "/api/notes", `{"Title":"Private","StateID":1,"PriorityID":1,"ColorID":1}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	rr = srv.serve("POST", "/api/notes", `{"Title":"Shared","StateID":1,"PriorityID":1,"ColorID":1}`, c)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	var note doit.Todo
+	assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &note))
+	noteURL := "/api/notes/" + strconv.FormatInt(note.ID, 10)
+	rr = srv.serve("POST", noteURL+"/shares", `{"Username":"bob","Permission":"read"}`, c)
+	assert.Equal(t, rr.Code, http.StatusNoContent)
+	rr = srv.serve("PUT", noteURL, `{"Title":"Changed","StateID":1,"PriorityID":1,"ColorID":1}`, c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+
+	e := next(blocks, false)
+	assert.Equal(t, e.event, doit.WebhookNoteUpdated)
+	assert.Equal(t, e.note.ID, note.ID)
+	assert.Equal(t, e.note.Title, "Changed")
+	next(blocks, true)
+	assert.Assert(t, heartbeats > 0)
+	res.Body.Close()
+
+	// After reconnecting the missed events are sent again
+	rr = srv.serve("DELETE", noteURL, "", c)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	res, blocks = stream(cBob, e.id)
+	e = next(blocks, false)
+	assert.Equal(t, e.event, doit.WebhookNoteDeleted)
+	assert.Equal(t, e.note.ID, note.ID)
+	res.Body.Close()
+
+	// Unless they are too old
+	res, blocks = stream(cBob, "1")
+	e = next(blocks, false)
+	assert.Equal(t, e.event, EVENT_RESET)
+	assert.Assert(t, e.id != "1" && e.id != "")
+	res.Body.Close()
+
+	// Imported notes are sent too
+	res, blocks = stream(cBob, "")
+	rr = srv.serve("POST", "/api/import", `[{"Title":"Imported"}]`, cBob)
+	assert.Equal(t, rr.Code, http.StatusCreated)
+	e = next(blocks, false)
+	assert.Equal(t, e.event, doit.WebhookNoteCreated)
+	assert.Equal(t, e.note.Title, "Imported")
+	res.Body.Close()
+
+	res, _ = stream(cBob, "last")
+	assert.Equal(t, res.StatusCode, http.StatusBadRequest)
+	res.Body.Close()
+
+	// The stream is closed when its session is revoked
+	res, blocks = stream(cBob, "")
+	next(blocks, true)
+	bob, err := r.GetUserByUsername("bob")
+	assert.NilError(t, err)
+	_, err = r.DeleteUserSessions(bob.ID, 0)
+	assert.NilError(t, err)
+	timeout := time.After(5 * time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-blocks:
+			closed = !ok
+		case <-timeout:
+			t.Fatal("Stream not closed")
+		}
+	}
+	res.Body.Close()
+}
+
+func TestErrors(t *testing.T) {
+	srv := setupServer(t)
+	r := srv.repo
+	defer r.Close()
+
+	createUser(t, r, "alice", "password", false)
+	c := srv.login(t, "alice", "password")
+
+	apiError := func(rr *httptest.ResponseRecorder, code int) doit.APIError {
+		assert.Equal(t, rr.Code, code)
+		assert.Equal(t, rr.Header().Get("Content-Type"), "application/json")
+		var e doit.APIError
+		assert.NilError(t, json.Unmarshal(rr.Body.Bytes(), &e))
+		assert.Equal(t, e.RequestID, rr.Header().Get(REQUEST_ID_HEADER))
+		assert.Assert(t, e.RequestID != "")
+		assert.Assert(t, e.Message != "")
+		return e
+	}
+
+	e := apiError(srv.serve("GET", "/api/notes", "", nil), http.StatusUnauthorized)
+	assert.Equal(t, e.Code, "unauthorized")
+	e = apiError(srv.serve("GET", "/api/notes/42", "", c), http.StatusNotFound)
+	assert.Equal(t, e.Code, "not_found")
+	e = apiError(srv.serve("PATCH", "/api/notes", "", c), http.StatusMethodNotAllowed)
+	assert.Equal(t, e.Code, "method_not_allowed")
+	for _, method := range []string{"GET", "POST", "PUT", "DELETE"} {
+		e = apiError(srv.serve(method, "/api/nothing", "", c), http.StatusNotFound)
+		assert.Equal(t, e.Code, "not_found")
+	}
+	e = apiError(srv.serve("DELETE", "/api/notes", "", c), http.StatusMethodNotAllowed)
+	assert.Equal(t, e.Code, "method_not_allowed")
+	e = apiError(srv.serve("POST", "/api/login", `{"Username":"alice","Password":"wrong"}`, nil), http.StatusUnauthorized)
+	assert.Equal(t, e.Message, "User does not exist or password is not correct")
+
+	// Fields that are not valid are in the details
+	e = apiError(srv.serve("POST", "/api/notes", `{"StateID":1,"PriorityID":1,"ColorID":1}`, c), http.StatusBadRequest)
+	assert.Equal(t, e.Code, "bad_request")
+	assert.DeepEqual(t, e.Details, []doit.FieldError{{Field: "Title", Message: "Title is empty or not present"}})
+	e = apiError(srv.serve("POST", "/api/login", `{}`, nil), http.StatusBadRequest)
+	assert.Equal(t, len(e.Details), 2)
+	assert.Equal(t, e.Details[0].Field, "Username")
+	assert.Equal(t, e.Details[1].Field, "Password")
+	e = apiError(srv.serve("GET", "/api/notes?sort=priority&limit=-1", "", c), http.StatusBadRequest)
+	assert.DeepEqual(t, e.Details, []doit.FieldError{{Field: "limit", Message: "limit is not valid"}})
+
+	// The ID of a proxy is kept, if it's safe
+	req := httptest.NewRequest("GET", "/api/notes/42", nil)
+	req.AddCookie(c)
+	req.Header.Set(REQUEST_ID_HEADER, "proxy-123")
+	rr := httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	e = apiError(rr, http.StatusNotFound)
+	assert.Equal(t, e.RequestID, "proxy-123")
+
+	req.Header.Set(REQUEST_ID_HEADER, "bad id\n")
+	rr = httptest.NewRecorder()
+	srv.ServeHTTP(rr, req)
+	e = apiError(rr, http.StatusNotFound)
+	assert.Assert(t, e.RequestID != "bad id\n")
+
+	// Errors are logged with the ID of the request
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	e = apiError(srv.serve("GET", "/api/notes/42", "", c), http.StatusNotFound)
+	assert.Assert(t, strings.Contains(logs.String(), "requestID="+e.RequestID+" code=404"), logs.String())
+}
@@ -5,16 +5,35 @@ import (
 	"log/slog"
 	"net/http"
 	"strings"
+
+	"github.com/samuelemusiani/doit/cmd/db"
 )
 
+// Give an ID to the request, so the error responses can be found in the logs
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(REQUEST_ID_HEADER)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
 func logginMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		slog.With("method", r.Method, "URL", r.URL, "client", r.RemoteAddr, "agent", r.UserAgent()).Debug("")
+		u := r.URL.String()
+		// Don't log the API token of the calendar feed
+		if _, ok := feedToken(r); ok {
+			u = r.URL.Path
+		}
+		slog.With("method", r.Method, "URL", u, "client", r.RemoteAddr, "agent", r.UserAgent(), "requestID", w.Header().Get(REQUEST_ID_HEADER)).Debug("")
 		next.ServeHTTP(w, r)
 	})
 }
 
-func authMiddleware(next http.Handler) http.Handler {
+func (srv *Server) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		// I don't like this :(
@@ -34,23 +53,83 @@ func authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Scripts and CLI clients use API tokens instead of the session cookie
+		token, ok := bearerToken(r)
+		if !ok {
+			token, ok = feedToken(r)
+		}
+		if !ok {
+			token, ok = davToken(r)
+		}
+		if ok {
+			t, ok := srv.getAPIToken(token)
+			if !ok || t.IsExpired() {
+				slog.Debug("Not authenticated, API token not valid")
+				notAuthenticated(w, r)
+				return
+			}
+
+			scope, ok := requiredScope(r)
+			if !ok || !t.HasScope(scope) {
+				slog.With("tokenID", t.ID, "scope", scope).Debug("API token lacks scope")
+				jsonError(w, "API token does not have the required scope", http.StatusForbidden)
+				return
+			}
+			if !srv.checkActiveUser(w, r, t.UserID) {
+				return
+			}
+			srv.touchAPIToken(t)
+
+			next.ServeHTTP(w, withAuth(r, auth{userID: t.UserID, token: t}))
+			return
+		}
+
 		c, err := r.Cookie(SESSION_COOCKIE_NAME)
 		if err != nil {
 			if !errors.Is(err, http.ErrNoCookie) {
 				slog.With("err", err).Error("Getting cookie")
 			}
 			slog.With("err", err).Debug("Not authenticated")
-			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			notAuthenticated(w, r)
 			return
 		}
 
-		s, ok := getSession(c.Value)
+		s, ok := srv.getSession(c.Value)
 		if !ok || s.isExpired() {
 			slog.With("err", err).Debug("Not authenticated")
-			http.Error(w, "Not authenticated", http.StatusUnauthorized)
+			notAuthenticated(w, r)
+			return
+		}
+		if !srv.checkActiveUser(w, r, s.userID) {
 			return
 		}
+		srv.touchSession(s)
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, withAuth(r, auth{userID: s.userID, sessionID: s.id}))
 	})
 }
+
+// Sessions and tokens of users that are deactivated can't be used anymore.
+// Return false, with the response already written, if the user is not active.
+func (srv *Server) checkActiveUser(w http.ResponseWriter, r *http.Request, userID int64) bool {
+	user, err := srv.repo.GetUserByID(userID)
+	if err != nil && !errors.Is(err, db.ErrNotExists) {
+		slog.With("err", err, "id", userID).Error("Getting user from DB")
+		jsonError(w, "", http.StatusInternalServerError)
+		return false
+	}
+	if err != nil || !user.Active {
+		slog.With("userID", userID).Debug("Not authenticated, user not active")
+		notAuthenticated(w, r)
+		return false
+	}
+	return true
+}
+
+// CalDAV clients ask for the credentials only if the server asks for Basic
+func notAuthenticated(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, DAV_PREFIX) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="DOIT"`)
+	}
+	jsonError(w, "Not authenticated", http.StatusUnauthorized)
+}
@@ -0,0 +1,311 @@
+package http_server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/config"
+	"github.com/samuelemusiani/doit/cmd/db"
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// How often the reminders and the digests to send are checked
+const REMINDERS_INTERVAL = time.Minute
+
+// Maximum time to send an email
+const SMTP_TIMEOUT = 30 * time.Second
+
+// How the due dates are written in the emails, in the time zone of the server
+const REMINDER_TIME_FORMAT = "Mon 2 Jan 2006 15:04 MST"
+
+type reminders struct {
+	repo db.Repository
+	smtp config.SMTP
+	// How long before the due date the reminders are sent, from the shortest
+	before []time.Duration
+}
+
+func newReminders(r db.Repository, conf config.SMTP) (*reminders, error) {
+	rm := reminders{repo: r, smtp: conf}
+	for _, s := range conf.Reminders {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("Reminder %q is not a positive duration", s)
+		}
+		rm.before = append(rm.before, d)
+	}
+	slices.Sort(rm.before)
+	if conf.Digest_Hour > 23 {
+		return nil, fmt.Errorf("Digest hour %d is not valid", conf.Digest_Hour)
+	}
+	if conf.From == "" {
+		return nil, errors.New("The sender of the emails is not set")
+	}
+	return &rm, nil
+}
+
+// Periodically send the reminders and the digests that are due, until ctx is
+// done
+func (rm *reminders) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		rm.send(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (rm *reminders) send(now time.Time) {
+	err := rm.sendReminders(now)
+	if err != nil {
+		slog.With("err", err).Error("Sending reminders")
+	}
+	err = rm.sendDigests(now)
+	if err != nil {
+		slog.With("err", err).Error("Sending digests")
+	}
+}
+
+// Group the todos by owner, in the order of the first todo of each one
+func todosByUser(todos []doit.Todo) ([]int64, map[int64][]doit.Todo) {
+	var users []int64
+	byUser := make(map[int64][]doit.Todo)
+	for _, t := range todos {
+		if _, ok := byUser[t.UserID]; !ok {
+			users = append(users, t.UserID)
+		}
+		byUser[t.UserID] = append(byUser[t.UserID], t)
+	}
+	return users, byUser
+}
+
+// Send to each user a reminder of his todos that expire within one of the
+// durations before the due date. Only the shortest is sent, so after a
+// downtime a user is not reminded of the same todo more times.
+func (rm *reminders) sendReminders(now time.Time) error {
+	if len(rm.before) == 0 {
+		return nil
+	}
+
+	// Expiration dates are in seconds, so the end is rounded up
+	todos, err := rm.repo.ExpiringTodos(now, now.Add(rm.before[len(rm.before)-1]+time.Second))
+	if err != nil {
+		return err
+	}
+
+	var due []doit.Todo
+	beforeOf := make(map[int64]time.Duration)
+	for _, t := range todos {
+		left := t.Expiration.Date.Sub(now)
+		i := slices.IndexFunc(rm.before, func(d time.Duration) bool { return d >= left })
+		if i < 0 {
+			continue
+		}
+		due = append(due, t)
+		beforeOf[t.ID] = rm.before[i]
+	}
+
+	users, byUser := todosByUser(due)
+	for _, userID := range users {
+		// The reminders are claimed before sending them, so with more servers
+		// only one sends them
+		var todos []doit.Todo
+		var claimed []doit.Reminder
+		for _, t := range byUser[userID] {
+			r := doit.Reminder{TodoID: t.ID, Before: beforeOf[t.ID], Due: t.Expiration.Date, SentAt: now}
+			ok, err := rm.repo.ClaimReminder(r)
+			if err != nil {
+				return err
+			}
+			if ok {
+				todos = append(todos, t)
+				claimed = append(claimed, r)
+			}
+		}
+		if len(todos) == 0 {
+			continue
+		}
+
+		subject := fmt.Sprintf("Reminder: %d notes expire soon", len(todos))
+		if len(todos) == 1 {
+			subject = fmt.Sprintf("Reminder: %q expires on %s", todos[0].Title, todos[0].Expiration.Date.Local().Format(REMINDER_TIME_FORMAT))
+		}
+		if rm.mailUser(userID, subject, "These notes expire soon:", todos, now) {
+			continue
+		}
+
+		// Not sent, so they are tried again at the next check
+		for _, r := range claimed {
+			if err := rm.repo.DeleteReminder(r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Send to each user a digest of his expired todos, once a day after the hour
+// of the config
+func (rm *reminders) sendDigests(now time.Time) error {
+	if rm.smtp.Digest_Hour < 0 || now.Hour() < rm.smtp.Digest_Hour {
+		return nil
+	}
+
+	// The done ones and the ones in the trash are not included
+	todos, err := rm.repo.ExpiringTodos(time.Time{}, now)
+	if err != nil {
+		return err
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	users, byUser := todosByUser(todos)
+	for _, userID := range users {
+		last, err := rm.repo.LastDigest(userID)
+		if err != nil {
+			return err
+		}
+		// Claimed before sending it, like the reminders
+		ok, err := rm.repo.ClaimDigest(userID, now, today)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		todos := byUser[userID]
+		subject := fmt.Sprintf("Digest: %d notes are expired", len(todos))
+		if rm.mailUser(userID, subject, "These notes are expired:", todos, now) {
+			continue
+		}
+		if err := rm.repo.SetLastDigest(userID, last); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Send an email with the list of the todos to the user, and return if it was
+// sent. Users that are not active or without an email are skipped.
+func (rm *reminders) mailUser(userID int64, subject string, intro string, todos []doit.Todo, now time.Time) bool {
+	user, err := rm.repo.GetUserByID(userID)
+	if err != nil {
+		slog.With("err", err, "id", userID).Error("Getting user to remind")
+		return false
+	}
+	if !user.Active || user.Email == "" {
+		return false
+	}
+
+	var body strings.Builder
+	body.WriteString(intro + "\n\n")
+	for _, t := range todos {
+		fmt.Fprintf(&body, "- %s, on %s\n", t.Title, t.Expiration.Date.Local().Format(REMINDER_TIME_FORMAT))
+	}
+
+	msg, err := mailMessage(rm.smtp.From, user.Email, subject, body.String(), now)
+	if err == nil {
+		err = sendMail(&rm.smtp, user.Email, msg)
+	}
+	if err != nil {
+		slog.With("err", err, "user", user.Username).Error("Sending email")
+		return false
+	}
+	return true
+}
+
+// Return a plain text email
+func mailMessage(from string, to string, subject string, body string, date time.Time) ([]byte, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", (&mail.Address{Name: "DOIT", Address: from}).String())
+	fmt.Fprintf(&b, "To: %s\r\n", (&mail.Address{Address: to}).String())
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&b)
+	_, err := qp.Write([]byte(body))
+	if err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return []byte(b.String()), nil
+}
+
+// Send the message with the SMTP server of the config. STARTTLS is used if
+// the server supports it and TLS is not used from the start.
+func sendMail(conf *config.SMTP, to string, msg []byte) error {
+	addr := net.JoinHostPort(conf.Host, strconv.Itoa(conf.Port))
+	tlsConf := &tls.Config{ServerName: conf.Host}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: SMTP_TIMEOUT}
+	if conf.Tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConf)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(SMTP_TIMEOUT))
+
+	c, err := smtp.NewClient(conn, conf.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && !conf.Tls {
+		if err := c.StartTLS(tlsConf); err != nil {
+			return err
+		}
+	}
+	if conf.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", conf.Username, conf.Password, conf.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(conf.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
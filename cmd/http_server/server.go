@@ -13,10 +13,9 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/samuelemusiani/doit/cmd/config"
+	"github.com/samuelemusiani/doit/cmd/db"
 )
 
-var router *mux.Router = nil
-
 var NO_AUTH_PATHS = [...]string{
 	"/",
 	"/api",
@@ -26,47 +25,123 @@ var NO_AUTH_PATHS = [...]string{
 	"/api/options/colors",
 }
 
-var ui_fs fs.FS
+// The http server with the state used by its handlers, so more than one can
+// run in the same process
+type Server struct {
+	// Storage used by all the handlers
+	repo   db.Repository
+	events *eventBroker
+	router *mux.Router
+	ui     fs.FS
+}
 
-func Init(fs fs.FS) {
+func New(fs fs.FS, r db.Repository) *Server {
 	slog.Debug("Init http server")
 
-	ui_fs = fs
-
-	router = mux.NewRouter()
-	router.HandleFunc("/api", rootAPIHandler).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/notes", notesHandler).Methods("GET", "OPTIONS", "POST")
-	router.HandleFunc("/api/notes/{id}", singleTodoHandler).Methods("GET", "OPTIONS", "PUT", "DELETE")
-	router.HandleFunc("/api/login", loginHandler).Methods("GET", "OPTIONS", "POST", "DELETE")
-	router.HandleFunc("/api/users", usersHandler).Methods("GET", "POST", "OPTIONS")
-	router.HandleFunc("/api/users/{id}", singleUserHandler).Methods("GET", "OPTIONS", "PUT", "DELETE")
-
-	router.HandleFunc("/api/options/states", noteStatesHandler).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/options/priorities", notePrioritiesHandler).Methods("GET", "OPTIONS")
-	router.HandleFunc("/api/options/colors", noteColorsHandler).Methods("GET", "OPTIONS")
+	srv := &Server{
+		repo:   r,
+		events: newEventBroker(r),
+		ui:     fs,
+	}
 
-	router.PathPrefix("/").HandlerFunc(staticHandler)
+	srv.router = mux.NewRouter()
+	srv.router.HandleFunc("/api", rootAPIHandler).Methods("GET", "OPTIONS")
+	srv.router.HandleFunc("/api/notes", srv.notesHandler).Methods("GET", "OPTIONS", "POST")
+	srv.router.HandleFunc("/api/notes/search", srv.notesSearchHandler).Methods("GET", "OPTIONS")
+	srv.router.HandleFunc("/api/notes/{id}", srv.singleTodoHandler).Methods("GET", "OPTIONS", "PUT", "DELETE")
+	srv.router.HandleFunc("/api/notes/{id}/items", srv.todoItemsHandler).Methods("GET", "OPTIONS", "POST")
+	srv.router.HandleFunc("/api/notes/{id}/items/{itemID}", srv.singleTodoItemHandler).Methods("GET", "OPTIONS", "PUT", "DELETE")
+	srv.router.HandleFunc("/api/notes/{id}/history", srv.noteHistoryHandler).Methods("GET", "OPTIONS")
+	srv.router.HandleFunc("/api/notes/{id}/history/{eventID}/restore", srv.restoreNoteHandler).Methods("OPTIONS", "POST")
+	srv.router.HandleFunc("/api/notes/{id}/comments", srv.commentsHandler).Methods("GET", "OPTIONS", "POST")
+	srv.router.HandleFunc("/api/notes/{id}/comments/{commentID}", srv.singleCommentHandler).Methods("GET", "OPTIONS", "PUT", "DELETE")
+	srv.router.HandleFunc("/api/notes/{id}/shares", srv.noteSharesHandler).Methods("GET", "OPTIONS", "POST")
+	srv.router.HandleFunc("/api/notes/{id}/shares/{userID}", srv.singleNoteShareHandler).Methods("OPTIONS", "DELETE")
+	srv.router.HandleFunc("/api/trash", srv.trashHandler).Methods("GET", "OPTIONS")
+	srv.router.HandleFunc("/api/trash/{id}", srv.singleTrashHandler).Methods("OPTIONS", "DELETE")
+	srv.router.HandleFunc("/api/trash/{id}/restore", srv.restoreTrashHandler).Methods("OPTIONS", "POST")
+	srv.router.HandleFunc("/api/projects", srv.projectsHandler).Methods("GET", "OPTIONS", "POST")
+	srv.router.HandleFunc("/api/projects/{id}", srv.singleProjectHandler).Methods("GET", "OPTIONS", "PUT", "DELETE")
+	srv.router.HandleFunc("/api/projects/{id}/notes", srv.projectNotesHandler).Methods("GET", "OPTIONS")
+	srv.router.HandleFunc("/api/projects/{id}/shares", srv.projectSharesHandler).Methods("GET", "OPTIONS", "POST")
+	srv.router.HandleFunc("/api/projects/{id}/shares/{userID}", srv.singleProjectShareHandler).Methods("OPTIONS", "DELETE")
+	srv.router.HandleFunc("/api/tags", srv.tagsHandler).Methods("GET", "OPTIONS", "POST")
+	srv.router.HandleFunc("/api/tags/{id}", srv.singleTagHandler).Methods("GET", "OPTIONS", "PUT", "DELETE")
+	srv.router.HandleFunc(CALENDAR_FEED_PATH, srv.calendarHandler).Methods("GET", "OPTIONS")
+	srv.router.PathPrefix(DAV_PREFIX).HandlerFunc(srv.davHandler)
+	srv.router.HandleFunc("/.well-known/caldav", wellKnownCalDAVHandler)
+	srv.router.HandleFunc("/api/export", srv.exportHandler).Methods("GET", "OPTIONS")
+	srv.router.HandleFunc("/api/import", srv.importHandler).Methods("OPTIONS", "POST")
+	srv.router.HandleFunc("/api/webhooks", srv.webhooksHandler).Methods("GET", "OPTIONS", "POST")
+	srv.router.HandleFunc("/api/webhooks/{id}", srv.singleWebhookHandler).Methods("GET", "OPTIONS", "PUT", "DELETE")
+	srv.router.HandleFunc("/api/webhooks/{id}/deliveries", srv.webhookDeliveriesHandler).Methods("GET", "OPTIONS")
+	srv.router.HandleFunc("/api/webhooks/{id}/test", srv.webhookTestHandler).Methods("OPTIONS", "POST")
+	srv.router.HandleFunc("/api/events", srv.eventsHandler).Methods("GET", "OPTIONS")
+	srv.router.HandleFunc("/api/admin/audit", srv.adminAuditHandler).Methods("GET", "OPTIONS")
+	srv.router.HandleFunc("/api/login", srv.loginHandler).Methods("GET", "OPTIONS", "POST", "DELETE")
+	srv.router.HandleFunc("/api/users", srv.usersHandler).Methods("GET", "POST", "OPTIONS")
+	srv.router.HandleFunc("/api/users/{id}", srv.singleUserHandler).Methods("GET", "OPTIONS", "PUT", "DELETE")
+	srv.router.HandleFunc("/api/sessions", srv.sessionsHandler).Methods("GET", "OPTIONS")
+	srv.router.HandleFunc("/api/sessions/{id}", srv.singleSessionHandler).Methods("OPTIONS", "DELETE")
+	srv.router.HandleFunc("/api/tokens", srv.tokensHandler).Methods("GET", "OPTIONS", "POST")
+	srv.router.HandleFunc("/api/tokens/{id}", srv.singleTokenHandler).Methods("OPTIONS", "DELETE")
+
+	srv.router.HandleFunc("/api/options/states", noteStatesHandler).Methods("GET", "OPTIONS")
+	srv.router.HandleFunc("/api/options/priorities", notePrioritiesHandler).Methods("GET", "OPTIONS")
+	srv.router.HandleFunc("/api/options/colors", noteColorsHandler).Methods("GET", "OPTIONS")
+
+	// Only if no route has the path with another method, that is a 405. The
+	// matcher must be the first, the router clears the error on a match.
+	srv.router.MatcherFunc(func(r *http.Request, m *mux.RouteMatch) bool {
+		return m.MatchErr == nil
+	}).PathPrefix("/api/").HandlerFunc(notFoundHandler)
+	srv.router.PathPrefix("/").Methods("GET", "HEAD").HandlerFunc(srv.staticHandler)
+
+	srv.router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+
+	srv.router.Use(requestIDMiddleware)
+	srv.router.Use(logginMiddleware)
+	srv.router.Use(srv.authMiddleware)
+
+	return srv
+}
 
-	router.Use(logginMiddleware)
-	router.Use(authMiddleware)
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.router.ServeHTTP(w, r)
 }
 
-func ListenAndServe() error {
+func (srv *Server) ListenAndServe() error {
 	config := config.GetConfig()
 	addr := config.Server.Listen
 
-	srv := &http.Server{
-		Handler:      router,
+	hs := &http.Server{
+		Handler:      srv,
 		Addr:         addr,
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
 	}
+	// Event streams never end by themselves
+	hs.RegisterOnShutdown(srv.events.close)
+
+	sweepCtx, stopSweep := context.WithCancel(context.Background())
+	defer stopSweep()
+	go srv.sweepSessions(sweepCtx, SESSIONS_SWEEP_INTERVAL)
+	if days := config.Trash.Retention_Days; days > 0 {
+		go srv.purgeTrash(sweepCtx, TRASH_PURGE_INTERVAL, time.Duration(days)*24*time.Hour)
+	}
+	if config.SMTP.Host != "" {
+		rm, err := newReminders(srv.repo, config.SMTP)
+		if err != nil {
+			return err
+		}
+		go rm.run(sweepCtx, REMINDERS_INTERVAL)
+	}
 
 	errc := make(chan error, 1)
 
 	go func() {
 		slog.With("addr", addr).Info("Listening and serving")
-		err := srv.ListenAndServe()
+		err := hs.ListenAndServe()
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errc <- err
 		}
@@ -92,7 +167,7 @@ func ListenAndServe() error {
 	defer cancel()
 	// Doesn't block if no connections, but will otherwise wait
 	// until the timeout deadline.
-	srv.Shutdown(ctx)
+	hs.Shutdown(ctx)
 	slog.Info("Shutting http server")
 
 	return nil
@@ -1,47 +1,129 @@
 package http_server
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"log/slog"
-	"sync"
+	"net"
+	"net/http"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/samuelemusiani/doit/cmd/db"
+	"github.com/samuelemusiani/doit/cmd/doit"
 )
 
+// How often expired sessions are removed from the DB
+const SESSIONS_SWEEP_INTERVAL = time.Hour
+
+// Last seen is updated at most once in this interval, so we don't write on
+// the DB for every request
+const SESSIONS_LAST_SEEN_RESOLUTION = time.Minute
+
 type session struct {
-	userID int64
-	expire time.Time
+	id       int64
+	userID   int64
+	expire   time.Time
+	lastSeen time.Time
 }
 
-var activeSessions sync.Map
-
 func (s session) isExpired() bool {
 	return s.expire.Before(time.Now())
 }
 
-func newSession(s session) string {
-	t := uuid.NewString()
+// Only the hash of a token is saved in the DB
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
 
-	activeSessions.Store(t, s)
-	slog.With("token", t, "session", s).Debug("New session")
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Create a new session for the user and return the token for the client
+func (srv *Server) newSession(userID int64, expire time.Time, r *http.Request) (string, error) {
+	t := uuid.NewString()
+	now := time.Now()
 
-	activeSessions.Range(func(key, value any) bool {
-		slog.With("key", key, "value", value).Debug("")
-		return true
+	s, err := srv.repo.CreateSession(doit.Session{
+		TokenHash: hashToken(t),
+		UserID:    userID,
+		Created:   now,
+		Expire:    expire,
+		LastSeen:  now,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
 	})
+	if err != nil {
+		return "", err
+	}
 
-	return t
+	slog.With("id", s.ID, "userID", userID).Debug("New session")
+	return t, nil
 }
 
 // Return the session and true if is present, false otherwise
-func getSession(token string) (session, bool) {
-	s, present := activeSessions.Load(token)
-	if !present {
-		s = session{}
+func (srv *Server) getSession(token string) (session, bool) {
+	s, err := srv.repo.GetSessionByTokenHash(hashToken(token))
+	if err != nil {
+		if !errors.Is(err, db.ErrNotExists) {
+			slog.With("err", err).Error("Getting session from DB")
+		}
+		return session{}, false
+	}
+
+	return session{
+		id:       s.ID,
+		userID:   s.UserID,
+		expire:   s.Expire,
+		lastSeen: s.LastSeen,
+	}, true
+}
+
+// Record that the session was just used
+func (srv *Server) touchSession(s session) {
+	now := time.Now()
+	if now.Sub(s.lastSeen) < SESSIONS_LAST_SEEN_RESOLUTION {
+		return
+	}
+
+	err := srv.repo.UpdateSessionLastSeen(s.id, now)
+	if err != nil {
+		slog.With("err", err, "id", s.id).Error("Updating session last seen")
 	}
-	return s.(session), present
 }
 
-func deleteSession(token string) {
-	activeSessions.Delete(token)
+func (srv *Server) deleteSession(token string) {
+	err := srv.repo.DeleteSessionByTokenHash(hashToken(token))
+	if err != nil && !errors.Is(err, db.ErrDeleteFailed) {
+		slog.With("err", err).Error("Deleting session from DB")
+	}
+}
+
+// Periodically remove expired sessions from the DB until ctx is done
+func (srv *Server) sweepSessions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := srv.repo.DeleteExpiredSessions(time.Now())
+		if err != nil {
+			slog.With("err", err).Error("Deleting expired sessions")
+		} else if n > 0 {
+			slog.With("n", n).Debug("Deleted expired sessions")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
 }
@@ -0,0 +1,32 @@
+package http_server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// How often the todos older than the retention are removed from the trash
+const TRASH_PURGE_INTERVAL = time.Hour
+
+// Periodically remove from the trash the todos deleted more than retention
+// ago, until ctx is done
+func (srv *Server) purgeTrash(ctx context.Context, interval time.Duration, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := srv.repo.PurgeTrash(time.Now().Add(-retention))
+		if err != nil {
+			slog.With("err", err).Error("Purging trash")
+		} else if n > 0 {
+			slog.With("n", n).Info("Purged todos from trash")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"errors"
 	"net/http"
 
-	"github.com/samuelemusiani/doit/cmd/db"
+	"github.com/samuelemusiani/doit/cmd/doit"
 )
 
 var (
@@ -21,20 +21,25 @@ func sliceContains(s []string, e string) bool {
 	return false
 }
 
-func isAdminFromRequest(r *http.Request) (bool, error) {
-	c, err := r.Cookie(SESSION_COOCKIE_NAME)
-	if err != nil {
-		return false, errors.Join(ErrInteral, err)
+// Return the user doing the request
+func (srv *Server) userFromRequest(r *http.Request) (*doit.User, error) {
+	a, ok := getAuth(r)
+	if !ok {
+		return nil, ErrUnauthorized
 	}
 
-	s, ok := getSession(c.Value)
-	if !ok || s.isExpired() {
-		return false, ErrUnauthorized
+	user, err := srv.repo.GetUserByID(a.userID)
+	if err != nil {
+		return nil, errors.Join(ErrInteral, err)
 	}
 
-	user, err := db.GetUserByID(s.userID)
+	return user, nil
+}
+
+func (srv *Server) isAdminFromRequest(r *http.Request) (bool, error) {
+	user, err := srv.userFromRequest(r)
 	if err != nil {
-		return false, errors.Join(ErrInteral, err)
+		return false, err
 	}
 
 	return user.Admin, nil
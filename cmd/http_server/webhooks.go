@@ -0,0 +1,556 @@
+package http_server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/config"
+	"github.com/samuelemusiani/doit/cmd/db"
+	"github.com/samuelemusiani/doit/cmd/doit"
+)
+
+// Attempts to deliver a payload before giving up
+const WEBHOOK_MAX_ATTEMPTS = 5
+
+// Maximum time to wait for the response of a webhook
+const WEBHOOK_TIMEOUT = 10 * time.Second
+
+// Wait before the second attempt, doubled at each next one. A variable so
+// tests don't wait.
+var webhookBackoff = 10 * time.Second
+
+// Redirects are not followed, a webhook must answer with a 2xx. The address
+// is checked when connecting, after the name is resolved, and proxies are not
+// used because they would hide it.
+var webhookClient = &http.Client{
+	Timeout: WEBHOOK_TIMEOUT,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: WEBHOOK_TIMEOUT, Control: checkWebhookAddress}).DialContext,
+		TLSHandshakeTimeout: WEBHOOK_TIMEOUT,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+var errWebhookAddress = errors.New("address is not public")
+
+// Blocks that are not public, besides the ones of netip.Addr methods
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// Return true if the address can be reached from the internet, so users can't
+// use webhooks to scan the network of the server
+func isPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	return !slices.ContainsFunc(reservedPrefixes, func(p netip.Prefix) bool { return p.Contains(ip) })
+}
+
+func allowPrivateWebhooks() bool {
+	return config.GetConfig().Webhooks.Allow_Private
+}
+
+// Control of the dialer of the webhooks, that refuses to connect to the
+// addresses that are not public
+func checkWebhookAddress(network string, address string, c syscall.RawConn) error {
+	if allowPrivateWebhooks() {
+		return nil
+	}
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !isPublicAddr(ap.Addr()) {
+		return errWebhookAddress
+	}
+	return nil
+}
+
+func newPayloadID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Deliver the event to the active webhooks of the owner that want it and to
+// the global ones, in the background. User events are delivered only to the
+// global webhooks.
+func (srv *Server) sendWebhooks(ownerID int64, p doit.WebhookPayload) {
+	if doit.IsUserEvent(p.Event) {
+		ownerID = 0
+	}
+	hooks, err := srv.repo.ActiveWebhooks(ownerID)
+	if err != nil {
+		slog.With("err", err, "event", p.Event).Error("Getting webhooks from DB")
+		return
+	}
+
+	p.Time = time.Now()
+	for _, h := range hooks {
+		if !h.Wants(p.Event) {
+			continue
+		}
+		p.ID = newPayloadID()
+		body, err := json.Marshal(p)
+		if err != nil {
+			slog.With("err", err, "event", p.Event).Error("Marshaling webhook payload")
+			return
+		}
+		go srv.deliverWebhook(h, p, body)
+	}
+}
+
+// Deliver the payload, retrying with exponential backoff until it succeeds
+// or WEBHOOK_MAX_ATTEMPTS fail
+func (srv *Server) deliverWebhook(h doit.Webhook, p doit.WebhookPayload, body []byte) {
+	wait := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		d := srv.attemptWebhook(&h, &p, body, attempt)
+		if d.Error == "" || attempt == WEBHOOK_MAX_ATTEMPTS {
+			return
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// POST the payload once and record the delivery in the log of the webhook
+func (srv *Server) attemptWebhook(h *doit.Webhook, p *doit.WebhookPayload, body []byte, attempt int) *doit.WebhookDelivery {
+	d := doit.WebhookDelivery{
+		WebhookID: h.ID,
+		PayloadID: p.ID,
+		Event:     p.Event,
+		Attempt:   attempt,
+		Time:      time.Now(),
+		Payload:   string(body),
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err == nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "DOIT-Webhook")
+		req.Header.Set("X-DOIT-Event", p.Event)
+		req.Header.Set("X-DOIT-Delivery", p.ID)
+		req.Header.Set(doit.WebhookSignatureHeader, doit.SignWebhookPayload(h.Secret, body))
+
+		var res *http.Response
+		res, err = webhookClient.Do(req)
+		if err == nil {
+			res.Body.Close()
+			d.StatusCode = res.StatusCode
+			if res.StatusCode < 200 || res.StatusCode > 299 {
+				err = errors.New(res.Status)
+			}
+		}
+	}
+	if err != nil {
+		d.Error = err.Error()
+	}
+
+	created, err := srv.repo.CreateWebhookDelivery(d)
+	if err != nil {
+		slog.With("err", err, "id", h.ID).Error("Recording webhook delivery")
+		return &d
+	}
+	return created
+}
+
+// Return the event of a change of a note with the action of its history
+func noteEventName(action string) (string, bool) {
+	switch action {
+	case doit.TodoEventCreate:
+		return doit.WebhookNoteCreated, true
+	case doit.TodoEventUpdate:
+		return doit.WebhookNoteUpdated, true
+	case doit.TodoEventDelete:
+		return doit.WebhookNoteDeleted, true
+	case doit.TodoEventRestore:
+		return doit.WebhookNoteRestored, true
+	}
+	return "", false
+}
+
+// Deliver the webhooks of a change of a note, recorded in its history
+func (srv *Server) sendNoteWebhooks(actorID int64, action string, note *doit.Todo, changes []doit.TodoChange) {
+	event, ok := noteEventName(action)
+	if !ok {
+		return
+	}
+	p := doit.WebhookPayload{Event: event, ActorID: actorID, Note: note, Changes: changes}
+	srv.sendWebhooks(note.UserID, p)
+
+	stateChanged := slices.ContainsFunc(changes, func(c doit.TodoChange) bool { return c.Field == "StateID" })
+	if action == doit.TodoEventUpdate && stateChanged {
+		p.Event = doit.WebhookNoteStateChanged
+		srv.sendWebhooks(note.UserID, p)
+	}
+}
+
+// Deliver the webhooks of a change of a user. actor can be nil if unknown.
+func (srv *Server) sendUserWebhooks(event string, actor *doit.User, user *doit.User) {
+	p := doit.WebhookPayload{Event: event, User: doit.UserToResponse(user)}
+	if actor != nil {
+		p.ActorID = actor.ID
+	}
+	srv.sendWebhooks(0, p)
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Check the fields of a webhook, return a doit.FieldError if not valid
+func validateWebhook(h *doit.Webhook) error {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fieldError("URL", "URL must be an absolute http or https URL")
+	}
+	// Names are checked when the webhook is sent, as they can change
+	ip, err := netip.ParseAddr(u.Hostname())
+	if err == nil && !isPublicAddr(ip) && !allowPrivateWebhooks() {
+		return fieldError("URL", "URL must be a public address")
+	}
+	if h.Secret == "" {
+		return fieldError("Secret", "Secret is empty")
+	}
+	for _, e := range h.Events {
+		if !sliceContains(doit.WebhookEvents, e) {
+			return fieldError("Events", "Event %q does not exist", e)
+		}
+		if doit.IsUserEvent(e) && !h.Global {
+			return fieldError("Events", "Only global webhooks receive %q", e)
+		}
+	}
+	return nil
+}
+
+// Set the fields present in u. Only admins can make a webhook global, and
+// with a token only if it has the admin scope.
+func (srv *Server) applyWebhookUnmarshaling(w http.ResponseWriter, r *http.Request, h *doit.Webhook, u *doit.WebhookUnmarshaling) bool {
+	if u.URL != nil {
+		h.URL = *u.URL
+	}
+	if u.Secret != nil {
+		h.Secret = *u.Secret
+	}
+	if u.Events != nil {
+		h.Events = *u.Events
+		if h.Events == nil {
+			h.Events = []string{}
+		}
+	}
+	if u.Active != nil {
+		h.Active = *u.Active
+	}
+	if u.Global != nil && *u.Global && !h.Global {
+		user, err := srv.userFromRequest(r)
+		if err != nil {
+			slog.With("err", err).Error("Getting user from request")
+			jsonError(w, "", http.StatusInternalServerError)
+			return false
+		}
+		a, _ := getAuth(r)
+		if !user.Admin || (a.token != nil && !a.token.HasScope(doit.ScopeUsersAdmin)) {
+			jsonError(w, "Not an admin, cannot create a global webhook", http.StatusForbidden)
+			return false
+		}
+	}
+	if u.Global != nil {
+		h.Global = *u.Global
+	}
+
+	if err := validateWebhook(h); err != nil {
+		badRequest(w, err)
+		return false
+	}
+	return true
+}
+
+func (srv *Server) webhooksHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS POST")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		srv.webhooksHandlerGET(w, r, a.userID)
+	case http.MethodPost:
+		srv.webhooksHandlerPOST(w, r, a.userID)
+	default:
+		slog.With("method", r.Method).Error("Method not valid. How did we get here?")
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (srv *Server) webhooksHandlerGET(w http.ResponseWriter, r *http.Request, userID int64) {
+	hooks, err := srv.repo.AllWebhooks(userID)
+	if err != nil {
+		slog.With("err", err).Error("Getting webhooks from DB")
+		jsonError(w, "Could not get webhooks", http.StatusInternalServerError)
+		return
+	}
+
+	var response []byte
+	if len(hooks) == 0 {
+		response = []byte("[]")
+	} else {
+		response, err = json.Marshal(hooks)
+		if err != nil {
+			slog.With("err", err).Error("Marshaling webhooks")
+			jsonError(w, "Could not get webhooks", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// The secret is generated if not present and the webhook is active if not
+// specified
+func (srv *Server) webhooksHandlerPOST(w http.ResponseWriter, r *http.Request, userID int64) {
+	var u doit.WebhookUnmarshaling
+	err := json.NewDecoder(r.Body).Decode(&u)
+	if err != nil {
+		jsonError(w, "Could not unmarshal body", http.StatusBadRequest)
+		return
+	}
+
+	h := doit.Webhook{UserID: userID, Events: []string{}, Active: true, Created: time.Now()}
+	if u.Secret == nil || *u.Secret == "" {
+		h.Secret, err = newWebhookSecret()
+		if err != nil {
+			slog.With("err", err).Error("Generating webhook secret")
+			jsonError(w, "", http.StatusInternalServerError)
+			return
+		}
+		u.Secret = nil
+	}
+	if !srv.applyWebhookUnmarshaling(w, r, &h, &u) {
+		return
+	}
+
+	created, err := srv.repo.CreateWebhook(h)
+	if err != nil {
+		slog.With("err", err).Error("Adding webhook to DB")
+		jsonError(w, "Could not add webhook", http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(created)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling webhook")
+		jsonError(w, "Webhook was added but we could not send it back", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(b)
+}
+
+// Return the webhook with the id in the path if it's of the user. Otherwise
+// an error is written and false returned.
+func (srv *Server) ownWebhook(w http.ResponseWriter, r *http.Request) (*doit.Webhook, bool) {
+	id, ok := varID(w, r, "id")
+	if !ok {
+		return nil, false
+	}
+
+	a, ok := getAuth(r)
+	if !ok {
+		slog.Error("At this stage request should be authenticated")
+		jsonError(w, "", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	h, err := srv.repo.GetWebhookByID(id)
+	if err != nil && !errors.Is(err, db.ErrNotExists) {
+		slog.With("err", err, "id", id).Error("Getting webhook from DB")
+		jsonError(w, "", http.StatusInternalServerError)
+		return nil, false
+	}
+	// A user can only see and change his own webhooks
+	if err != nil || h.UserID != a.userID {
+		jsonError(w, "Webhook does not exist", http.StatusNotFound)
+		return nil, false
+	}
+	return h, true
+}
+
+func (srv *Server) singleWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS PUT DELETE")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	h, ok := srv.ownWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		b, err := json.Marshal(h)
+		if err != nil {
+			slog.With("err", err).Error("Marshaling webhook")
+			jsonError(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	case http.MethodPut:
+		srv.singleWebhookHandlerPUT(w, r, h)
+	case http.MethodDelete:
+		err := srv.repo.DeleteWebhookByID(h.ID, h.UserID)
+		if err != nil && !errors.Is(err, db.ErrDeleteFailed) {
+			slog.With("err", err, "id", h.ID).Error("Deleting webhook from DB")
+			jsonError(w, "", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		slog.With("method", r.Method).Error("Method not valid. How did we get here?")
+		jsonError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Only the fields present in the body are changed
+func (srv *Server) singleWebhookHandlerPUT(w http.ResponseWriter, r *http.Request, h *doit.Webhook) {
+	var u doit.WebhookUnmarshaling
+	err := json.NewDecoder(r.Body).Decode(&u)
+	if err != nil {
+		jsonError(w, "Could not unmarshal body", http.StatusBadRequest)
+		return
+	}
+
+	if !srv.applyWebhookUnmarshaling(w, r, h, &u) {
+		return
+	}
+
+	updated, err := srv.repo.UpdateWebhook(*h)
+	if err != nil {
+		if errors.Is(err, db.ErrUpdateFailed) {
+			jsonError(w, "Webhook does not exist", http.StatusNotFound)
+			return
+		}
+		slog.With("err", err, "id", h.ID).Error("Updating webhook in DB")
+		jsonError(w, "Could not update webhook", http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(updated)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling webhook update")
+		jsonError(w, "Webhook was updated but we could not send it back", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// The log of the deliveries of the webhook, from the newest
+func (srv *Server) webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET OPTIONS")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	h, ok := srv.ownWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := srv.repo.WebhookDeliveries(h.ID)
+	if err != nil {
+		slog.With("err", err, "id", h.ID).Error("Getting webhook deliveries from DB")
+		jsonError(w, "Could not get deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	var response []byte
+	if len(deliveries) == 0 {
+		response = []byte("[]")
+	} else {
+		response, err = json.Marshal(deliveries)
+		if err != nil {
+			slog.With("err", err).Error("Marshaling webhook deliveries")
+			jsonError(w, "Could not get deliveries", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(response)
+}
+
+// Send a ping event to the webhook, even if it's not active, and return the
+// delivery. It's attempted only once.
+func (srv *Server) webhookTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS POST")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	h, ok := srv.ownWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	p := doit.WebhookPayload{ID: newPayloadID(), Event: doit.WebhookPing, Time: time.Now(), ActorID: h.UserID}
+	body, err := json.Marshal(p)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling webhook payload")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	d := srv.attemptWebhook(h, &p, body, 1)
+	b, err := json.Marshal(d)
+	if err != nil {
+		slog.With("err", err).Error("Marshaling webhook delivery")
+		jsonError(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"embed"
+	"fmt"
 	"io/fs"
 	"log/slog"
 	"os"
@@ -39,9 +40,28 @@ func main() {
 	}
 	slog.SetLogLoggerLevel(logLevl)
 
-	err = db.Init()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			err = migrateCmd(os.Args[2:])
+		case "export":
+			err = exportCmd(os.Args[2:])
+		case "import":
+			err = importCmd(os.Args[2:])
+		default:
+			err = fmt.Errorf("Unknown command %q", os.Args[1])
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	repo, err := db.Init()
 	if err != nil {
-		slog.With("path", conf.Databse.Path, "err", err).Error("Initializing database")
+		slog.With("driver", conf.Databse.Driver, "path", conf.Databse.Path, "err", err).Error("Initializing database")
 		os.Exit(1)
 	}
 
@@ -51,14 +71,14 @@ func main() {
 		os.Exit(1)
 	}
 
-	http_server.Init(front_fs)
-	err = http_server.ListenAndServe()
+	srv := http_server.New(front_fs, repo)
+	err = srv.ListenAndServe()
 	if err != nil {
 		slog.With("err", err).Error("Listening and serving")
 		os.Exit(1)
 	}
 
-	err = db.Close()
+	err = repo.Close()
 	if err != nil {
 		slog.With("err", err).Error("Closing database")
 	}
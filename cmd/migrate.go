@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/samuelemusiani/doit/cmd/db"
+)
+
+const migrateUsage = `Usage: doit migrate <command>
+
+Commands:
+  status  show the applied and pending migrations
+  up      apply all the pending migrations
+  down    revert the last applied migration`
+
+// Handle the "doit migrate" subcommand
+func migrateCmd(args []string) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "status":
+		status, err := db.MigrationsStatus()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, s := range status {
+			if s.Applied {
+				fmt.Fprintf(w, "%d\t%s\tapplied\t%s\n", s.Version, s.Name, s.AppliedAt.Format(time.DateTime))
+			} else {
+				fmt.Fprintf(w, "%d\t%s\tpending\t-\n", s.Version, s.Name)
+			}
+		}
+		return w.Flush()
+	case "up":
+		n, err := db.MigrateUp()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migrations\n", n)
+		return nil
+	case "down":
+		v, err := db.MigrateDown()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Reverted migration %d\n", v)
+		return nil
+	default:
+		return errors.New(migrateUsage)
+	}
+}
@@ -2,13 +2,14 @@ module github.com/samuelemusiani/doit
 
 go 1.22.5
 
-require github.com/pelletier/go-toml/v2 v2.2.2
-
 require (
-	github.com/google/go-cmp v0.5.9 // indirect
-	github.com/google/uuid v1.6.0 // indirect
-	github.com/gorilla/mux v1.8.1 // indirect
-	github.com/mattn/go-sqlite3 v1.14.22 // indirect
-	golang.org/x/crypto v0.25.0 // indirect
-	gotest.tools/v3 v3.5.1 // indirect
+	github.com/google/uuid v1.6.0
+	github.com/gorilla/mux v1.8.1
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/pelletier/go-toml/v2 v2.2.2
+	golang.org/x/crypto v0.25.0
+	gotest.tools/v3 v3.5.1
 )
+
+require github.com/google/go-cmp v0.5.9 // indirect
@@ -2,13 +2,23 @@ import { COLORS_URL, LOGIN_URL, NOTES_URL, PRIORITIES_URL, STATES_URL, USERS_URL
 import type { TodoColor, TodoPriority, TodoState, Todo } from '@/types'
 import type { User } from '@/types'
 
+// Errors of the API are JSON with the Code, the Message and the RequestID
+async function errorMessage(res: Response): Promise<string> {
+  const text = await res.text()
+  try {
+    return JSON.parse(text).Message ?? text
+  } catch {
+    return text
+  }
+}
+
 export async function getCurrentUser(): Promise<User> {
   return fetch(LOGIN_URL, {
     credentials: 'include'
   })
     .then(async (res) => {
       if (!res.ok) {
-        throw new Error(await res.text())
+        throw new Error(await errorMessage(res))
       }
       return (await res.json()) as User
     })
@@ -126,11 +136,10 @@ export async function login(user: string, password: string): Promise<any> {
     credentials: 'include'
   })
     .then(async (res) => {
-      let t = await res.text()
       if (!res.ok) {
-        throw new Error(t)
+        throw new Error(await errorMessage(res))
       }
-      return t
+      return res.text()
     })
     .catch((err) => {
       throw new Error(`Could not login: ${err}`)
@@ -202,7 +211,7 @@ export async function addUser(user: User): Promise<User> {
   })
     .then(async (res) => {
       if (!res.ok) {
-        throw new Error(await res.text())
+        throw new Error(await errorMessage(res))
       }
 
       return await res.json()